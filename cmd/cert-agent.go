@@ -0,0 +1,166 @@
+// cmd/cert-agent.go
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certagent"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certissuer"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/configuration"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/mtls"
+	pb "github.com/jdfalk/ubuntu-autoinstall-webhook/pkg/proto"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+var (
+	agentServerAddr    string
+	agentAPIKey        string
+	agentAPIKeyFile    string
+	agentCAFile        string
+	agentInsecure      bool
+	agentCertPath      string
+	agentKeyPath       string
+	agentRenewBefore   time.Duration
+	agentCheckInterval time.Duration
+	agentRetryInterval time.Duration
+	agentReloadCommand string
+	agentOnce          bool
+	agentClientCert    bool
+)
+
+var certAgentCmd = &cobra.Command{
+	Use:   "cert-agent",
+	Short: "Keeps a host certificate renewed through the cert-issuer",
+	Long: `Watches a certificate and key pair on an installed host and renews the
+certificate through the cert-issuer's CertAdmin gRPC API once it is inside its
+renewal window. The renewed certificate replaces the old one atomically and
+the reload command is run so services pick it up.
+Example usage:
+  cert-agent --server ca.example.com:9443 --ca-file /etc/ssl/autoinstall/ca.crt \
+    --api-key-file /etc/autoinstall/api-key \
+    --cert /etc/ssl/autoinstall/host.crt --key /etc/ssl/autoinstall/host.key \
+    --reload-command "systemctl reload nginx"`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if agentAPIKey == "" && agentAPIKeyFile != "" {
+			data, err := os.ReadFile(agentAPIKeyFile)
+			if err != nil {
+				return fmt.Errorf("failed to read API key file: %w", err)
+			}
+			agentAPIKey = strings.TrimSpace(string(data))
+		}
+		if agentAPIKey == "" && !agentClientCert {
+			return fmt.Errorf("API key is required, provide one with --api-key or --api-key-file, or authenticate with --client-cert")
+		}
+		if agentClientCert && agentInsecure {
+			return fmt.Errorf("--client-cert needs TLS and cannot be combined with --insecure")
+		}
+
+		var clientCert configuration.GRPCAuthConfig
+		if agentClientCert {
+			clientCert.CertFile, clientCert.KeyFile = agentCertPath, agentKeyPath
+		}
+		conn, err := dialCertAdmin(agentServerAddr, agentCAFile, agentInsecure, clientCert)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		agent := certagent.New(certagent.Config{
+			CertPath:      agentCertPath,
+			KeyPath:       agentKeyPath,
+			RenewBefore:   agentRenewBefore,
+			CheckInterval: agentCheckInterval,
+			RetryInterval: agentRetryInterval,
+			ReloadCommand: agentReloadCommand,
+		}, &grpcRenewer{client: pb.NewCertAdminClient(conn), apiKey: agentAPIKey})
+
+		// --once suits systemd timers and cron: check, renew if due, exit
+		if agentOnce {
+			renewed, err := agent.CheckOnce(cmd.Context())
+			if err != nil {
+				return err
+			}
+			if !renewed {
+				fmt.Printf("Certificate %s is not due for renewal\n", agentCertPath)
+			}
+			return nil
+		}
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		fmt.Printf("Watching certificate %s\n", agentCertPath)
+		return agent.Run(ctx)
+	},
+}
+
+// dialCertAdmin connects to a CertAdmin server. TLS connections verify the
+// server against caFile, or the system roots if it is empty, and present
+// the certificate in clientCert if it names one. The certificate is reread
+// when it changes, so a renewed certificate is used for the next connection.
+func dialCertAdmin(addr, caFile string, plaintext bool, clientCert configuration.GRPCAuthConfig) (*grpc.ClientConn, error) {
+	creds := insecure.NewCredentials()
+	if !plaintext {
+		clientCert.ClientCAFile = caFile
+		tlsConfig, err := mtls.ClientTLSConfig(clientCert, "")
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to server: %w", err)
+	}
+	return conn, nil
+}
+
+// grpcRenewer renews certificates through the CertAdmin API.
+type grpcRenewer struct {
+	client pb.CertAdminClient
+	apiKey string
+}
+
+func (r *grpcRenewer) RenewCertificate(ctx context.Context, certPEM []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	if r.apiKey != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+r.apiKey)
+	}
+
+	res, err := r.client.RenewCertificate(ctx, &pb.RenewCertificateRequest{CertificatePem: string(certPEM)})
+	if err != nil {
+		return nil, err
+	}
+	return []byte(res.GetCertificatePem()), nil
+}
+
+func init() {
+	rootCmd.AddCommand(certAgentCmd)
+
+	certAgentCmd.Flags().StringVar(&agentServerAddr, "server", "localhost:9443", "CertAdmin gRPC server address")
+	certAgentCmd.Flags().StringVar(&agentAPIKey, "api-key", "", "API key for the CertAdmin server")
+	certAgentCmd.Flags().StringVar(&agentAPIKeyFile, "api-key-file", "", "File containing the API key")
+	certAgentCmd.Flags().StringVar(&agentCAFile, "ca-file", "", "CA bundle to verify the server with (default: system roots)")
+	certAgentCmd.Flags().BoolVar(&agentInsecure, "insecure", false, "Connect without TLS")
+	certAgentCmd.Flags().StringVar(&agentCertPath, "cert", "", "Certificate file to keep renewed")
+	certAgentCmd.Flags().StringVar(&agentKeyPath, "key", "", "Private key of the certificate")
+	certAgentCmd.Flags().DurationVar(&agentRenewBefore, "renew-before", certissuer.RenewBeforeExpiry, "Renew this long before the certificate expires")
+	certAgentCmd.Flags().DurationVar(&agentCheckInterval, "interval", certagent.DefaultCheckInterval, "How often to check the certificate")
+	certAgentCmd.Flags().DurationVar(&agentRetryInterval, "retry-interval", certagent.DefaultRetryInterval, "How soon to retry a failed renewal")
+	certAgentCmd.Flags().StringVar(&agentReloadCommand, "reload-command", "", "Shell command to run after the certificate is renewed, e.g. \"systemctl reload nginx\"")
+	certAgentCmd.Flags().BoolVar(&agentOnce, "once", false, "Check once, renew if due and exit")
+	certAgentCmd.Flags().BoolVar(&agentClientCert, "client-cert", false, "Present the watched certificate as a TLS client certificate; with a mapped principal no API key is needed")
+	certAgentCmd.MarkFlagRequired("cert")
+	certAgentCmd.MarkFlagRequired("key")
+}
@@ -3,22 +3,33 @@ package cmd
 
 import (
 	"context"
+	"crypto"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/acme"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/apikeys"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/auth"
 	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certadmin"
 	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certissuer"
-	pb "github.com/jdfalk/ubuntu-autoinstall-webhook/pkg/proto"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/configuration"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/database"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/mtls"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
 )
@@ -29,6 +40,21 @@ var (
 	grpcListenAddr  string
 	apiKey          string
 	generateApiKey  bool
+	crlURL          string
+	ocspURL         string
+	caKeyAlgorithm  string
+	rootKeyPath     string
+	acmeEnabled     bool
+	acmeBaseURL     string
+	acmeTrustedMAC  bool
+	acmeProxies     []string
+
+	// Where the CA keys are kept
+	caKeyStore          string
+	caKeyPassphraseFile string
+	pkcs11Module        string
+	pkcs11Token         string
+	pkcs11PINFile       string
 )
 
 var certIssuerCmd = &cobra.Command{
@@ -58,29 +84,179 @@ var certIssuerCmd = &cobra.Command{
 
 		// Set default storage path if not provided
 		if certStoragePath == "" {
-			homeDir, err := os.UserHomeDir()
-			if err == nil {
-				certStoragePath = filepath.Join(homeDir, ".autoinstall-webhook", "certificates")
-			} else {
-				certStoragePath = "/var/lib/autoinstall-webhook/certificates"
-			}
+			certStoragePath = defaultCertStoragePath()
 		}
 
 		fmt.Printf("Using certificate storage path: %s\n", certStoragePath)
 
 		// Create certificate service
-		certService := certissuer.NewService(certStoragePath)
+		keyAlgorithm, err := certissuer.ParseKeyAlgorithm(caKeyAlgorithm)
+		if err != nil {
+			return err
+		}
+		caOpts := []certissuer.Option{certissuer.WithKeyAlgorithm(keyAlgorithm)}
+		if rootKeyPath != "" {
+			caOpts = append(caOpts, certissuer.WithRootKeyPath(rootKeyPath))
+		}
+		if crlURL != "" {
+			caOpts = append(caOpts, certissuer.WithCRLDistributionPoint(crlURL))
+		}
+		if ocspURL != "" {
+			if keyAlgorithm == certissuer.KeyAlgorithmEd25519 {
+				return fmt.Errorf("--ocsp-url cannot be used with an %s CA key, which cannot sign OCSP responses", keyAlgorithm)
+			}
+			caOpts = append(caOpts, certissuer.WithOCSPServer(ocspURL))
+		}
+		keys, closeKeys, err := caKeyProvider(certStoragePath)
+		if err != nil {
+			return err
+		}
+		defer closeKeys()
+		if keys != nil {
+			caOpts = append(caOpts, certissuer.WithKeyProvider(keys))
+		}
+
+		// The inventory database backs both the issuance policy and the
+		// trusted-mac-01 ACME challenge; connect only if one needs it
+		var db database.Database
+		inventoryDB := func() (database.Database, error) {
+			if db == nil {
+				db = database.NewService()
+				if err := db.Connect(cmd.Context()); err != nil {
+					db = nil
+					return nil, fmt.Errorf("failed to connect to the inventory database: %w", err)
+				}
+			}
+			return db, nil
+		}
+		defer func() {
+			if db != nil {
+				db.Close()
+			}
+		}()
+
+		// Issuance policy from the certissuer.policy configuration section
+		policyConfig := certissuer.PolicyConfigFromViper()
+		var inventory certissuer.Inventory
+		if policyConfig.RequiresInventory() {
+			invDB, err := inventoryDB()
+			if err != nil {
+				return err
+			}
+			inventory = certissuer.NewDatabaseInventory(invDB.Servers(), policyConfig.InventoryDomain)
+		}
+		policy, err := certissuer.NewPolicyEngine(policyConfig, inventory)
+		if err != nil {
+			return fmt.Errorf("invalid certificate issuance policy: %w", err)
+		}
+		caOpts = append(caOpts, certissuer.WithPolicy(policy))
+
+		// Issued certificates are kept as files next to the CA unless
+		// certissuer.store selects the shared database
+		switch store := viper.GetString("certissuer.store"); store {
+		case "", "files":
+		case "database":
+			storeDB, err := inventoryDB()
+			if err != nil {
+				return err
+			}
+			if err := storeDB.MigrateSchema(cmd.Context()); err != nil {
+				return fmt.Errorf("failed to migrate the certificate store: %w", err)
+			}
+			caOpts = append(caOpts, certissuer.WithStore(certissuer.NewDatabaseStore(storeDB.Certificates())))
+		default:
+			return fmt.Errorf("unknown certificate store %q, expected files or database", store)
+		}
 
-		// Setup API keys
-		apiKeys := map[string]string{
-			apiKey: "admin", // Use provided or generated API key
+		// Only administrators may put the names of client certificate
+		// principals into certificates
+		grpcConfig := mtls.ConfigFromViper()
+		principals := make([]string, 0, len(grpcConfig.Principals))
+		for name := range grpcConfig.Principals {
+			principals = append(principals, name)
 		}
+		caOpts = append(caOpts, certissuer.WithReservedPrincipals(principals...))
 
-		// Start HTTP server
-		httpServer := startHTTPServer(certService, httpListenAddr)
+		certService, err := certissuer.NewService(certStoragePath, caOpts...)
+		if err != nil {
+			return err
+		}
 
-		// Start gRPC server
-		grpcServer, grpcListener := startGRPCServer(certService, apiKeys, grpcListenAddr)
+		// Keep the published CRL fresh even when nobody downloads it
+		crlCtx, stopCRL := context.WithCancel(context.Background())
+		defer stopCRL()
+		go refreshCRL(crlCtx, certService)
+
+		// Optional ACME server for standard clients such as certbot and lego
+		var acmeHandler http.Handler
+		if acmeEnabled {
+			var acmeOpts []acme.Option
+			if acmeBaseURL != "" {
+				acmeOpts = append(acmeOpts, acme.WithBaseURL(acmeBaseURL))
+			}
+			if acmeTrustedMAC {
+				invDB, err := inventoryDB()
+				if err != nil {
+					return err
+				}
+				acmeOpts = append(acmeOpts, acme.WithInventory(acme.NewDatabaseInventory(invDB.Servers())),
+					acme.WithInventoryDomain(policyConfig.InventoryDomain))
+			}
+			for _, cidr := range acmeProxies {
+				prefix, err := netip.ParsePrefix(cidr)
+				if err != nil {
+					return fmt.Errorf("invalid --acme-trusted-proxy %q: %w", cidr, err)
+				}
+				acmeOpts = append(acmeOpts, acme.WithTrustedProxies(prefix))
+			}
+			acmeServer, err := acme.NewServer(certService, filepath.Join(certStoragePath, "acme", "state.json"), acmeOpts...)
+			if err != nil {
+				return fmt.Errorf("failed to create ACME server: %w", err)
+			}
+			acmeHandler = acmeServer
+		}
+
+		// Setup API keys: the admin key from the command line, and keys
+		// managed through the APIKeyService in the database
+		var keyStore *apikeys.Manager
+		if keyDB, err := inventoryDB(); err != nil {
+			fmt.Printf("Warning: API keys other than --api-key are disabled: %v\n", err)
+		} else if err := keyDB.MigrateSchema(cmd.Context()); err != nil {
+			fmt.Printf("Warning: API keys other than --api-key are disabled: %v\n", err)
+		} else {
+			keyStore = apikeys.NewManager(keyDB)
+			// Keys kept in plain text by older versions
+			if err := certadmin.ImportAPIKeys(cmd.Context(), keyStore, filepath.Join(certStoragePath, "../admin")); err != nil {
+				fmt.Printf("Warning: failed to import API keys: %v\n", err)
+			}
+		}
+
+		// Start gRPC server, with TLS, client certificates and permissions
+		// per auth.grpc. The admin key may do anything unless restricted.
+		if auth.UserScopes(grpcConfig.Scopes, "admin") == nil {
+			if grpcConfig.Scopes == nil {
+				grpcConfig.Scopes = make(map[string][]string)
+			}
+			grpcConfig.Scopes["admin"] = []string{string(auth.ScopeAll)}
+		}
+		for user, scopes := range grpcConfig.Scopes {
+			if err := auth.ValidateScopes(scopes); err != nil {
+				return fmt.Errorf("invalid permissions for %s: %w", user, err)
+			}
+		}
+		staticKeys := auth.StaticKeys(append([]configuration.APIKeyConfig{{User: "admin", Key: apiKey}}, grpcConfig.APIKeys...), grpcConfig.Scopes)
+		certAdminServer := certadmin.NewServer(certService, keyStore, grpcConfig)
+		grpcServer, grpcListener, err := startGRPCServer(certAdminServer, staticKeys, grpcListenAddr)
+		if err != nil {
+			return err
+		}
+
+		// Start HTTP server, taking the same API keys for issuance
+		interceptor, err := certAdminServer.Interceptor(staticKeys)
+		if err != nil {
+			return err
+		}
+		httpServer := startHTTPServer(certService, interceptor, acmeHandler, httpListenAddr)
 
 		// Wait for interrupt or termination signal
 		stop := make(chan os.Signal, 1)
@@ -107,8 +283,135 @@ var certIssuerCmd = &cobra.Command{
 	},
 }
 
-// startHTTPServer starts the HTTP API server
-func startHTTPServer(certService certissuer.CertIssuer, addr string) *http.Server {
+// rotateIntermediateCmd signs a new online intermediate with the offline root key
+var rotateIntermediateCmd = &cobra.Command{
+	Use:   "rotate-intermediate",
+	Short: "Replace the intermediate CA with a new one signed by the offline root key",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if certStoragePath == "" {
+			certStoragePath = defaultCertStoragePath()
+		}
+		if !certissuer.Exists(certStoragePath) {
+			return fmt.Errorf("no certificate authority found in %s", certStoragePath)
+		}
+
+		keyAlgorithm, err := certissuer.ParseKeyAlgorithm(caKeyAlgorithm)
+		if err != nil {
+			return err
+		}
+		keys, closeKeys, err := caKeyProvider(certStoragePath)
+		if err != nil {
+			return err
+		}
+		defer closeKeys()
+		caOpts := []certissuer.Option{certissuer.WithKeyAlgorithm(keyAlgorithm)}
+		if keys != nil {
+			caOpts = append(caOpts, certissuer.WithKeyProvider(keys))
+		}
+
+		ca, err := certissuer.NewCertificateAuthority(certStoragePath, caOpts...)
+		if err != nil {
+			return err
+		}
+
+		// The root key comes from offline storage, or from the key store
+		// when it is kept there (e.g. on an HSM)
+		var rootKey crypto.Signer
+		if rootKeyPath != "" {
+			keyPEM, err := os.ReadFile(rootKeyPath)
+			if err != nil {
+				return fmt.Errorf("failed to read root CA key: %w", err)
+			}
+			passphrase, err := readCAKeyPassphrase()
+			if err != nil {
+				return err
+			}
+			if rootKey, err = certissuer.ParseEncryptedPrivateKey(keyPEM, passphrase); err != nil {
+				return err
+			}
+		} else if rootKey, err = ca.RootKey(); err != nil {
+			return fmt.Errorf("no --root-key given and the root CA key is not in the key store: %w", err)
+		}
+		if err := ca.RotateIntermediate(rootKey); err != nil {
+			return fmt.Errorf("failed to rotate intermediate CA: %w", err)
+		}
+
+		intermediate := ca.IntermediateCertificate()
+		fmt.Printf("New intermediate CA %q (serial %s) valid until %s\n",
+			intermediate.Subject.CommonName, intermediate.SerialNumber, intermediate.NotAfter.Format(time.RFC3339))
+		fmt.Println("Restart cert-issuer to issue from the new intermediate; certificates from the previous one stay valid until they expire.")
+		return nil
+	},
+}
+
+// caKeyProvider returns the CA key store selected by the --ca-key-* flags,
+// or nil for the default unencrypted files, and a function releasing it.
+func caKeyProvider(storePath string) (certissuer.KeyProvider, func(), error) {
+	switch caKeyStore {
+	case "file":
+		if caKeyPassphraseFile == "" {
+			return nil, func() {}, nil
+		}
+		passphrase, err := readCAKeyPassphrase()
+		if err != nil {
+			return nil, nil, err
+		}
+		keys, err := certissuer.NewEncryptedFileKeyProvider(storePath, passphrase)
+		if err != nil {
+			return nil, nil, err
+		}
+		return keys, func() {}, nil
+	case "pkcs11":
+		if pkcs11Module == "" || pkcs11Token == "" {
+			return nil, nil, fmt.Errorf("--pkcs11-module and --pkcs11-token are required with --ca-key-store pkcs11")
+		}
+		var pin string
+		if pkcs11PINFile != "" {
+			data, err := os.ReadFile(pkcs11PINFile)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to read PKCS#11 PIN: %w", err)
+			}
+			pin = strings.TrimSpace(string(data))
+		}
+		keys, err := certissuer.NewPKCS11KeyProvider(certissuer.PKCS11Config{
+			Module:     pkcs11Module,
+			TokenLabel: pkcs11Token,
+			PIN:        pin,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		return keys, func() { keys.Close() }, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown CA key store %q, expected file or pkcs11", caKeyStore)
+	}
+}
+
+// readCAKeyPassphrase reads --ca-key-passphrase-file, if given
+func readCAKeyPassphrase() ([]byte, error) {
+	if caKeyPassphraseFile == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(caKeyPassphraseFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA key passphrase: %w", err)
+	}
+	return []byte(strings.TrimRight(string(data), "\r\n")), nil
+}
+
+// defaultCertStoragePath returns the certificate directory used when none is given
+func defaultCertStoragePath() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "/var/lib/autoinstall-webhook/certificates"
+	}
+	return filepath.Join(homeDir, ".autoinstall-webhook", "certificates")
+}
+
+// startHTTPServer starts the HTTP API server. Issuing and renewing
+// certificates needs an API key or token with the certs:issue permission,
+// checked by interceptor. acmeHandler is mounted under /acme/ when non-nil.
+func startHTTPServer(certService certissuer.CertIssuer, interceptor *auth.Interceptor, acmeHandler http.Handler, addr string) *http.Server {
 	// Create HTTP router
 	mux := http.NewServeMux()
 
@@ -130,7 +433,7 @@ func startHTTPServer(certService certissuer.CertIssuer, addr string) *http.Serve
 	})
 
 	// Handler for certificate issuance
-	mux.HandleFunc("/api/v1/issue", func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/api/v1/issue", interceptor.HTTPHandler(auth.ScopeCertsIssue, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
@@ -158,6 +461,10 @@ func startHTTPServer(certService certissuer.CertIssuer, addr string) *http.Serve
 
 		// Issue certificate
 		cert, err := certService.IssueCertificate(r.Context(), []byte(req.CSR), req.ClientInfo)
+		if errors.Is(err, certissuer.ErrPolicyViolation) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Failed to issue certificate: %v", err), http.StatusBadRequest)
 			return
@@ -167,10 +474,10 @@ func startHTTPServer(certService certissuer.CertIssuer, addr string) *http.Serve
 		json.NewEncoder(w).Encode(map[string]string{
 			"certificate": string(cert),
 		})
-	})
+	})))
 
 	// Handler for certificate renewal
-	mux.HandleFunc("/api/v1/renew", func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/api/v1/renew", interceptor.HTTPHandler(auth.ScopeCertsIssue, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
@@ -193,6 +500,10 @@ func startHTTPServer(certService certissuer.CertIssuer, addr string) *http.Serve
 
 		// Renew certificate
 		renewedCert, err := certService.RenewCertificate(r.Context(), []byte(req.Certificate))
+		if errors.Is(err, certissuer.ErrPolicyViolation) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Failed to renew certificate: %v", err), http.StatusBadRequest)
 			return
@@ -202,7 +513,98 @@ func startHTTPServer(certService certissuer.CertIssuer, addr string) *http.Serve
 		json.NewEncoder(w).Encode(map[string]string{
 			"certificate": string(renewedCert),
 		})
-	})
+	})))
+
+	// Handler for the certificate revocation lists (DER by default, PEM with
+	// ?format=pem). /api/v1/crl is the current intermediate's CRL and
+	// /api/v1/crl/<issuer-id> the one named in each certificate, which
+	// includes intermediates replaced by a rotation; ?format=bundle returns
+	// all of them as PEM.
+	crlHandler := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if r.URL.Query().Get("format") == "bundle" {
+			bundle, err := certService.GetCRLBundle(r.Context())
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to get CRLs: %v", err), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/x-pem-file")
+			w.Write(bundle)
+			return
+		}
+
+		var crl []byte
+		var err error
+		if issuerID := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/v1/crl"), "/"); issuerID != "" {
+			crl, err = certService.GetIssuerCRL(r.Context(), issuerID)
+		} else {
+			crl, err = certService.GetCRL(r.Context())
+		}
+		if errors.Is(err, certissuer.ErrUnknownIssuer) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get CRL: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		if r.URL.Query().Get("format") == "pem" {
+			w.Header().Set("Content-Type", "application/x-pem-file")
+			w.Write(certissuer.EncodeCRLPEM(crl))
+			return
+		}
+		w.Header().Set("Content-Type", "application/pkix-crl")
+		w.Write(crl)
+	}
+	mux.HandleFunc("/api/v1/crl", crlHandler)
+	mux.HandleFunc("/api/v1/crl/", crlHandler)
+
+	// OCSP responder (RFC 6960): POST with a DER request body, or GET with
+	// the base64-encoded request appended to the path
+	ocspHandler := func(w http.ResponseWriter, r *http.Request) {
+		var request []byte
+		switch r.Method {
+		case http.MethodPost:
+			body, err := io.ReadAll(io.LimitReader(r.Body, 64*1024))
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to read request: %v", err), http.StatusBadRequest)
+				return
+			}
+			request = body
+		case http.MethodGet:
+			encoded := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/v1/ocsp"), "/")
+			decoded, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				http.Error(w, "Invalid base64-encoded OCSP request", http.StatusBadRequest)
+				return
+			}
+			request = decoded
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// The response is valid OCSP even on error, so always send it
+		resp, err := certService.GetOCSPResponse(r.Context(), request)
+		if err != nil {
+			fmt.Printf("OCSP request failed: %v\n", err)
+		} else {
+			w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d, public", int(certissuer.OCSPValidityPeriod.Seconds())))
+		}
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		w.Write(resp)
+	}
+	mux.HandleFunc("/api/v1/ocsp", ocspHandler)
+	mux.HandleFunc("/api/v1/ocsp/", ocspHandler)
+
+	if acmeHandler != nil {
+		mux.Handle(acme.PathPrefix+"/", acmeHandler)
+	}
 
 	// Simple health check endpoint
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -228,28 +630,20 @@ func startHTTPServer(certService certissuer.CertIssuer, addr string) *http.Serve
 	return server
 }
 
-// startGRPCServer starts the gRPC server
-func startGRPCServer(certService certissuer.CertIssuer, apiKeys map[string]string, addr string) (*grpc.Server, net.Listener) {
-	// Create authentication interceptor
-	authInterceptor := certadmin.NewAuthInterceptor(apiKeys)
-
-	// Create gRPC server with authentication
-	grpcServer := grpc.NewServer(
-		grpc.UnaryInterceptor(authInterceptor.Unary()),
-	)
-
-	// Create config directory for API keys
-	configDir := filepath.Join(certStoragePath, "../admin")
-	if err := os.MkdirAll(configDir, 0755); err != nil {
-		panic(fmt.Sprintf("failed to create config directory: %v", err))
-	}
-
-	// Register the CertAdmin service - update to match your actual server constructor
-	certAdminServer, err := certadmin.NewServer(certService, configDir, "", "")
+// startGRPCServer starts the gRPC server of certAdminServer. Callers
+// authenticate with a key in apiKeys or the server's own key store; the
+// APIKeyService is served when it has one.
+func startGRPCServer(certAdminServer *certadmin.Server, apiKeys auth.KeyStore, addr string) (*grpc.Server, net.Listener, error) {
+	// Authenticate callers and serve TLS when a certificate is configured,
+	// checking client certificates against our own CA and CRL
+	serverOptions, err := certAdminServer.ServerOptions(apiKeys)
 	if err != nil {
-		panic(fmt.Sprintf("failed to create cert admin server: %v", err))
+		return nil, nil, fmt.Errorf("failed to configure gRPC server: %w", err)
 	}
-	pb.RegisterCertAdminServer(grpcServer, certAdminServer)
+
+	// Create gRPC server with authentication
+	grpcServer := grpc.NewServer(serverOptions...)
+	certAdminServer.Register(grpcServer)
 
 	// Enable reflection for easier client development
 	reflection.Register(grpcServer)
@@ -257,7 +651,7 @@ func startGRPCServer(certService certissuer.CertIssuer, apiKeys map[string]strin
 	// Listen on the specified port
 	lis, err := net.Listen("tcp", addr)
 	if err != nil {
-		panic(fmt.Sprintf("failed to listen on %s: %v", addr, err))
+		return nil, nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
 	}
 
 	// Start gRPC server
@@ -268,7 +662,25 @@ func startGRPCServer(certService certissuer.CertIssuer, apiKeys map[string]strin
 		}
 	}()
 
-	return grpcServer, lis
+	return grpcServer, lis, nil
+}
+
+// refreshCRL periodically regenerates the CRL until ctx is canceled
+func refreshCRL(ctx context.Context, certService certissuer.CertIssuer) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		if _, err := certService.GetCRL(ctx); err != nil {
+			fmt.Printf("Failed to refresh CRL: %v\n", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
 }
 
 // generateRandomAPIKey creates a secure random API key
@@ -291,6 +703,7 @@ func generateRandomAPIKey(length int) (string, error) {
 
 func init() {
 	rootCmd.AddCommand(certIssuerCmd)
+	certIssuerCmd.AddCommand(rotateIntermediateCmd)
 
 	// Add command-line flags
 	certIssuerCmd.Flags().StringVar(&certStoragePath, "cert-path", "", "Path to store certificates (default: ~/.autoinstall-webhook/certificates)")
@@ -298,4 +711,24 @@ func init() {
 	certIssuerCmd.Flags().StringVar(&grpcListenAddr, "grpc-listen", ":9443", "Address to listen on for gRPC requests")
 	certIssuerCmd.Flags().StringVar(&apiKey, "api-key", "", "API key for authenticating admin requests")
 	certIssuerCmd.Flags().BoolVar(&generateApiKey, "generate-api-key", false, "Generate and print a random API key")
+	certIssuerCmd.Flags().StringVar(&crlURL, "crl-url", "", "Public URL of /api/v1/crl; issued certificates name <url>/<issuer-id> as their CRL distribution point")
+	certIssuerCmd.Flags().StringVar(&ocspURL, "ocsp-url", "", "Public URL of /api/v1/ocsp to embed as the OCSP responder in issued certificates (not with Ed25519 CA keys)")
+	certIssuerCmd.Flags().StringVar(&caKeyAlgorithm, "ca-key-algorithm", string(certissuer.DefaultKeyAlgorithm), "Key algorithm for a newly created CA: rsa2048, rsa3072, rsa4096, ecdsa-p256, ecdsa-p384 or ed25519")
+	certIssuerCmd.Flags().StringVar(&rootKeyPath, "root-key", "", "Where to write the root CA key when creating a new CA with file keys, e.g. on removable media; required then, and must be outside --cert-path")
+	certIssuerCmd.Flags().BoolVar(&acmeEnabled, "acme", false, "Serve an ACME directory at /acme/directory")
+	certIssuerCmd.Flags().StringVar(&acmeBaseURL, "acme-base-url", "", "Public scheme and host of the ACME server, e.g. https://ca.example.com:8443 (default: taken from each request)")
+	certIssuerCmd.Flags().BoolVar(&acmeTrustedMAC, "acme-trusted-mac", false, "Offer the trusted-mac-01 challenge for servers in the inventory database")
+	certIssuerCmd.Flags().StringSliceVar(&acmeProxies, "acme-trusted-proxy", nil, "CIDR of a reverse proxy whose X-Forwarded-For header gives the client address for trusted-mac-01 (repeatable)")
+
+	for _, c := range []*cobra.Command{certIssuerCmd, rotateIntermediateCmd} {
+		c.Flags().StringVar(&caKeyStore, "ca-key-store", "file", "Where the CA keys are kept: file or pkcs11 (pkcs11 needs a build with -tags pkcs11)")
+		c.Flags().StringVar(&caKeyPassphraseFile, "ca-key-passphrase-file", "", "File with the passphrase encrypting the CA key files; existing plaintext keys are encrypted on start")
+		c.Flags().StringVar(&pkcs11Module, "pkcs11-module", "", "PKCS#11 library, e.g. /usr/lib/softhsm/libsofthsm2.so")
+		c.Flags().StringVar(&pkcs11Token, "pkcs11-token", "", "Label of the PKCS#11 token holding the CA keys")
+		c.Flags().StringVar(&pkcs11PINFile, "pkcs11-pin-file", "", "File with the PKCS#11 user PIN")
+	}
+
+	rotateIntermediateCmd.Flags().StringVar(&certStoragePath, "cert-path", "", "Path of the certificate authority (default: ~/.autoinstall-webhook/certificates)")
+	rotateIntermediateCmd.Flags().StringVar(&rootKeyPath, "root-key", "", "Path to the offline root CA private key (default: load it from the CA key store)")
+	rotateIntermediateCmd.Flags().StringVar(&caKeyAlgorithm, "key-algorithm", string(certissuer.DefaultKeyAlgorithm), "Key algorithm for the new intermediate: rsa2048, rsa3072, rsa4096, ecdsa-p256, ecdsa-p384 or ed25519")
 }
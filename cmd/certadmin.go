@@ -17,6 +17,7 @@ import (
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 var (
@@ -94,6 +95,12 @@ var revokeAPIKeyCmd = &cobra.Command{
 	RunE:  revokeAPIKey,
 }
 
+var updateAPIKeyCmd = &cobra.Command{
+	Use:   "update",
+	Short: "Update an API key's name, description, expiry, status or permissions",
+	RunE:  updateAPIKey,
+}
+
 func init() {
 	rootCmd.AddCommand(certAdminCmd)
 
@@ -117,6 +124,7 @@ func init() {
 	apiKeyCmd.AddCommand(createAPIKeyCmd)
 	apiKeyCmd.AddCommand(listAPIKeysCmd)
 	apiKeyCmd.AddCommand(revokeAPIKeyCmd)
+	apiKeyCmd.AddCommand(updateAPIKeyCmd)
 
 	// Get CA certificate flags
 	getCACmd.Flags().StringP("output", "o", "ca.crt", "Output file for CA certificate")
@@ -137,11 +145,28 @@ func init() {
 	// Create API key flags
 	createAPIKeyCmd.Flags().String("name", "", "Name for the API key")
 	createAPIKeyCmd.Flags().String("description", "", "Description for the API key")
+	createAPIKeyCmd.Flags().StringSlice("permissions", []string{}, "Permissions of the key, e.g. certs:read,certs:issue")
+	createAPIKeyCmd.Flags().Int32("expiry-days", 0, "Days until the key expires (0: never)")
 	createAPIKeyCmd.MarkFlagRequired("name")
 
+	// List API keys flags
+	listAPIKeysCmd.Flags().Bool("include-inactive", false, "Include revoked keys")
+	listAPIKeysCmd.Flags().Bool("include-expired", false, "Include expired keys")
+	listAPIKeysCmd.Flags().String("created-by", "", "Only list keys created by this user")
+
 	// Revoke API key flags
-	revokeAPIKeyCmd.Flags().String("name", "", "Name of the API key to revoke")
-	revokeAPIKeyCmd.MarkFlagRequired("name")
+	revokeAPIKeyCmd.Flags().String("id", "", "ID of the API key to revoke")
+	revokeAPIKeyCmd.Flags().String("reason", "", "Reason for the revocation")
+	revokeAPIKeyCmd.MarkFlagRequired("id")
+
+	// Update API key flags; unset flags keep the current values
+	updateAPIKeyCmd.Flags().String("id", "", "ID of the API key to update")
+	updateAPIKeyCmd.Flags().String("name", "", "New name")
+	updateAPIKeyCmd.Flags().String("description", "", "New description")
+	updateAPIKeyCmd.Flags().StringSlice("permissions", []string{}, "New permissions, replacing the current ones")
+	updateAPIKeyCmd.Flags().Int32("expiry-days", 0, "Days from now until the key expires (0: never)")
+	updateAPIKeyCmd.Flags().Bool("active", true, "Whether the key may be used")
+	updateAPIKeyCmd.MarkFlagRequired("id")
 }
 
 // setupClient sets up the gRPC client connection
@@ -348,12 +373,38 @@ func listCertificates(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// getAPIKeyClient creates a client for the APIKeyService
+func getAPIKeyClient() (*grpc.ClientConn, pb.APIKeyServiceClient, error) {
+	conn, _, err := getGRPCClient()
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, pb.NewAPIKeyServiceClient(conn), nil
+}
+
+// printAPIKey prints an API key's details
+func printAPIKey(key *pb.APIKey) {
+	expires := "never"
+	if key.GetExpiresAt() != nil {
+		expires = key.GetExpiresAt().AsTime().Format(time.RFC3339)
+	}
+	lastUsed := "never"
+	if key.GetLastUsedAt() != nil {
+		lastUsed = key.GetLastUsedAt().AsTime().Format(time.RFC3339)
+	}
+	fmt.Printf("ID: %s, Name: %s, Active: %v, Permissions: %s, Created: %s by %s, Last used: %s, Expires: %s, Description: %s\n",
+		key.GetId(), key.GetName(), key.GetActive(), strings.Join(key.GetPermissions(), ","),
+		key.GetCreatedAt().AsTime().Format(time.RFC3339), key.GetCreatedBy(), lastUsed, expires, key.GetDescription())
+}
+
 // createAPIKey creates a new API key
 func createAPIKey(cmd *cobra.Command, args []string) error {
 	name, _ := cmd.Flags().GetString("name")
 	description, _ := cmd.Flags().GetString("description")
+	permissions, _ := cmd.Flags().GetStringSlice("permissions")
+	expiryDays, _ := cmd.Flags().GetInt32("expiry-days")
 
-	conn, client, err := getGRPCClient()
+	conn, client, err := getAPIKeyClient()
 	if err != nil {
 		return err
 	}
@@ -365,6 +416,8 @@ func createAPIKey(cmd *cobra.Command, args []string) error {
 	req := &pb.CreateAPIKeyRequest{
 		Name:        name,
 		Description: description,
+		ExpiryDays:  expiryDays,
+		Permissions: permissions,
 	}
 
 	ctx = createAuthContext(ctx)
@@ -373,13 +426,19 @@ func createAPIKey(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to create API key: %w", err)
 	}
 
-	fmt.Printf("API key created: %s\n", res.ApiKey)
+	printAPIKey(res.GetApiKey())
+	fmt.Printf("API key created: %s\n", res.GetApiKey().GetKey())
+	fmt.Println("Store it now, it cannot be shown again.")
 	return nil
 }
 
 // listAPIKeys lists all API keys
 func listAPIKeys(cmd *cobra.Command, args []string) error {
-	conn, client, err := getGRPCClient()
+	includeInactive, _ := cmd.Flags().GetBool("include-inactive")
+	includeExpired, _ := cmd.Flags().GetBool("include-expired")
+	createdBy, _ := cmd.Flags().GetString("created-by")
+
+	conn, client, err := getAPIKeyClient()
 	if err != nil {
 		return err
 	}
@@ -389,14 +448,17 @@ func listAPIKeys(cmd *cobra.Command, args []string) error {
 	defer cancel()
 
 	ctx = createAuthContext(ctx)
-	res, err := client.ListAPIKeys(ctx, &emptypb.Empty{})
+	res, err := client.ListAPIKeys(ctx, &pb.ListAPIKeysRequest{
+		IncludeInactive: includeInactive,
+		IncludeExpired:  includeExpired,
+		CreatedBy:       createdBy,
+	})
 	if err != nil {
 		return fmt.Errorf("failed to list API keys: %w", err)
 	}
 
-	for _, key := range res.ApiKeys {
-		fmt.Printf("Name: %s, Description: %s, Created: %s\n",
-			key.Name, key.Description, key.CreatedAt)
+	for _, key := range res.GetApiKeys() {
+		printAPIKey(key)
 	}
 
 	return nil
@@ -404,9 +466,10 @@ func listAPIKeys(cmd *cobra.Command, args []string) error {
 
 // revokeAPIKey revokes an API key
 func revokeAPIKey(cmd *cobra.Command, args []string) error {
-	name, _ := cmd.Flags().GetString("name")
+	id, _ := cmd.Flags().GetString("id")
+	reason, _ := cmd.Flags().GetString("reason")
 
-	conn, client, err := getGRPCClient()
+	conn, client, err := getAPIKeyClient()
 	if err != nil {
 		return err
 	}
@@ -416,7 +479,8 @@ func revokeAPIKey(cmd *cobra.Command, args []string) error {
 	defer cancel()
 
 	req := &pb.RevokeAPIKeyRequest{
-		Name: name,
+		Id:     id,
+		Reason: reason,
 	}
 
 	ctx = createAuthContext(ctx)
@@ -425,7 +489,66 @@ func revokeAPIKey(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to revoke API key: %w", err)
 	}
 
-	fmt.Printf("API key %s revoked\n", name)
+	fmt.Printf("API key %s revoked\n", id)
+	return nil
+}
+
+// updateAPIKey changes the API key fields given on the command line. The
+// service replaces all fields, so the others are copied from the key.
+func updateAPIKey(cmd *cobra.Command, args []string) error {
+	id, _ := cmd.Flags().GetString("id")
+
+	conn, client, err := getAPIKeyClient()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ctx = createAuthContext(ctx)
+
+	current, err := client.GetAPIKey(ctx, &pb.GetAPIKeyRequest{Id: id})
+	if err != nil {
+		return fmt.Errorf("failed to get API key: %w", err)
+	}
+	key := current.GetApiKey()
+
+	req := &pb.UpdateAPIKeyRequest{
+		Id:          id,
+		Name:        key.GetName(),
+		Description: key.GetDescription(),
+		ExpiresAt:   key.GetExpiresAt(),
+		Active:      key.GetActive(),
+		Permissions: key.GetPermissions(),
+	}
+	flags := cmd.Flags()
+	if flags.Changed("name") {
+		req.Name, _ = flags.GetString("name")
+	}
+	if flags.Changed("description") {
+		req.Description, _ = flags.GetString("description")
+	}
+	if flags.Changed("permissions") {
+		req.Permissions, _ = flags.GetStringSlice("permissions")
+	}
+	if flags.Changed("expiry-days") {
+		days, _ := flags.GetInt32("expiry-days")
+		req.ExpiresAt = nil
+		if days > 0 {
+			req.ExpiresAt = timestamppb.New(time.Now().AddDate(0, 0, int(days)))
+		}
+	}
+	if flags.Changed("active") {
+		req.Active, _ = flags.GetBool("active")
+	}
+
+	res, err := client.UpdateAPIKey(ctx, req)
+	if err != nil {
+		return fmt.Errorf("failed to update API key: %w", err)
+	}
+
+	printAPIKey(res.GetApiKey())
 	return nil
 }
 
@@ -4,11 +4,19 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
 
 	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/database"
 	"github.com/spf13/cobra"
 )
 
+var (
+	migrateTarget int
+	migrateSteps  int
+)
+
 var databaseCmd = &cobra.Command{
 	Use:   "database",
 	Short: "Starts the database microservice",
@@ -27,6 +35,97 @@ var databaseCmd = &cobra.Command{
 	},
 }
 
+// Schema migration commands
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Manage database schema migrations",
+}
+
+var migrateUpCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Apply pending migrations",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withMigrator(cmd.Context(), func(ctx context.Context, m *database.Migrator) error {
+			if err := m.Up(ctx, migrateTarget); err != nil {
+				return err
+			}
+			fmt.Println("Database schema is up to date.")
+			return nil
+		})
+	},
+}
+
+var migrateDownCmd = &cobra.Command{
+	Use:   "down",
+	Short: "Revert the most recent migrations",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withMigrator(cmd.Context(), func(ctx context.Context, m *database.Migrator) error {
+			reverted, err := m.Down(ctx, migrateSteps)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Reverted %d migration(s).\n", reverted)
+			return nil
+		})
+	},
+}
+
+var migrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show applied and pending migrations",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withMigrator(cmd.Context(), func(ctx context.Context, m *database.Migrator) error {
+			statuses, err := m.Status(ctx)
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+			for _, s := range statuses {
+				state := "pending"
+				appliedAt := ""
+				if s.Applied {
+					state = "applied"
+					appliedAt = s.AppliedAt.Format(time.RFC3339)
+				}
+				if !s.ChecksumOK {
+					state = "checksum mismatch"
+				}
+				fmt.Fprintf(w, "%04d\t%s\t%s\t%s\n", s.Version, s.Name, state, appliedAt)
+			}
+			return w.Flush()
+		})
+	},
+}
+
+// withMigrator connects to the configured database and runs fn with its migrator.
+func withMigrator(ctx context.Context, fn func(context.Context, *database.Migrator) error) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	dbService := database.NewServiceWithConfig(database.ConfigFromViper())
+	if err := dbService.Connect(ctx); err != nil {
+		return err
+	}
+	defer dbService.Close()
+
+	migrator, err := dbService.Migrator()
+	if err != nil {
+		return err
+	}
+	return fn(ctx, migrator)
+}
+
 func init() {
 	rootCmd.AddCommand(databaseCmd)
+
+	databaseCmd.AddCommand(migrateCmd)
+	migrateCmd.AddCommand(migrateUpCmd)
+	migrateCmd.AddCommand(migrateDownCmd)
+	migrateCmd.AddCommand(migrateStatusCmd)
+
+	migrateUpCmd.Flags().IntVar(&migrateTarget, "to", 0, "Migrate up to this version (default: latest)")
+	migrateDownCmd.Flags().IntVar(&migrateSteps, "steps", 1, "Number of migrations to revert")
 }
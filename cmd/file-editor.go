@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/database"
 	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/fileeditor"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -24,19 +25,30 @@ var fileEditorCmd = &cobra.Command{
 		ctx, cancel := context.WithCancel(cmd.Context())
 		defer cancel()
 
+		// Replicas coordinate writes through a leader lease in the shared
+		// database. A single instance is the only writer and needs no database,
+		// so leader election is opt-in with fileeditor.leader_election.
+		var opts []fileeditor.Option
+		if viper.GetBool("fileeditor.leader_election") {
+			db := database.NewService()
+			if err := db.Connect(ctx); err != nil {
+				return fmt.Errorf("failed to connect to database for leader election: %w", err)
+			}
+			defer db.Close()
+			if err := db.MigrateSchema(ctx); err != nil {
+				return fmt.Errorf("failed to migrate database schema: %w", err)
+			}
+			opts = append(opts, fileeditor.WithLeaseStore(db))
+		}
+
 		// Instantiate the file-editor service
-		feService := fileeditor.NewService()
+		feService := fileeditor.NewService(opts...)
 
 		// Start the service (including background tasks)
 		if err := feService.Start(ctx); err != nil {
 			return fmt.Errorf("failed to start file editor service: %w", err)
 		}
 
-		// For example, call a method (stubbed for now)
-		if err := feService.WriteIpxeFile(ctx, "AA:BB:CC:DD:EE:FF", []byte("#!ipxe\necho Booting...")); err != nil {
-			return err
-		}
-
 		fmt.Println("File-editor microservice started successfully.")
 
 		// Block until context is canceled (e.g., by SIGINT)
@@ -46,5 +58,6 @@ var fileEditorCmd = &cobra.Command{
 }
 
 func init() {
+	viper.SetDefault("fileeditor.leader_election", false)
 	rootCmd.AddCommand(fileEditorCmd)
 }
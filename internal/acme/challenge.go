@@ -0,0 +1,159 @@
+// internal/acme/challenge.go
+package acme
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// validation carries what a challenge validator needs to know.
+type validation struct {
+	identifier Identifier
+	token      string
+	keyAuth    string
+	// payload is the client's challenge response body
+	payload []byte
+	// remoteIP is the address the challenge response came from
+	remoteIP string
+}
+
+// validateHTTP01 fetches the key authorization from the identifier over
+// plain HTTP (RFC 8555 section 8.3).
+func (s *Server) validateHTTP01(ctx context.Context, v validation) *Problem {
+	host := net.JoinHostPort(v.identifier.Value, strconv.Itoa(s.http01Port))
+	url := fmt.Sprintf("http://%s/.well-known/acme-challenge/%s", host, v.token)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return problem(http.StatusBadRequest, errMalformed, "invalid challenge URL: %v", err)
+	}
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return problem(http.StatusBadRequest, errConnection, "failed to fetch %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return problem(http.StatusForbidden, errUnauthorized, "%s returned HTTP %d", url, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return problem(http.StatusBadRequest, errConnection, "failed to read %s: %v", url, err)
+	}
+	if strings.TrimSpace(string(body)) != v.keyAuth {
+		return problem(http.StatusForbidden, errIncorrectResponse, "key authorization at %s does not match", url)
+	}
+	return nil
+}
+
+// trustedMACResponse is the client's answer to a trusted-mac-01 challenge.
+type trustedMACResponse struct {
+	MAC string `json:"mac"`
+}
+
+// validateTrustedMAC01 accepts the challenge when the named MAC address
+// belongs to an inventory server whose hostname or IP address matches the
+// identifier and the response was sent from that server's IP address.
+func (s *Server) validateTrustedMAC01(ctx context.Context, v validation) *Problem {
+	var resp trustedMACResponse
+	if len(v.payload) == 0 || json.Unmarshal(v.payload, &resp) != nil || resp.MAC == "" {
+		return problem(http.StatusBadRequest, errMalformed, "trusted-mac-01 response must include a mac")
+	}
+
+	host, err := s.inventory.LookupMAC(ctx, resp.MAC)
+	if errors.Is(err, ErrUnknownHost) {
+		return problem(http.StatusForbidden, errUnauthorized, "MAC address %s is not in the inventory", resp.MAC)
+	}
+	if err != nil {
+		return problem(http.StatusInternalServerError, errServerInternal, "inventory lookup failed: %v", err)
+	}
+
+	if !hostMatches(host, v.identifier, s.domain) {
+		return problem(http.StatusForbidden, errUnauthorized, "%s is not registered for MAC address %s", v.identifier.Value, resp.MAC)
+	}
+	if !containsIP(host.IPAddresses, v.remoteIP) {
+		return problem(http.StatusForbidden, errUnauthorized, "challenge response did not come from the inventory address of %s", resp.MAC)
+	}
+	return nil
+}
+
+// hostMatches reports whether id names the inventory host: its hostname,
+// the hostname directly under domain, or one of its addresses.
+func hostMatches(host *Host, id Identifier, domain string) bool {
+	switch id.Type {
+	case identifierDNS:
+		name := strings.ToLower(id.Value)
+		hostname := strings.ToLower(host.Hostname)
+		if hostname == "" {
+			return false
+		}
+		return name == hostname || (domain != "" && name == hostname+"."+domain)
+	case identifierIP:
+		return containsIP(host.IPAddresses, id.Value)
+	}
+	return false
+}
+
+// clientIP returns the address r came from. When the peer is a trusted
+// proxy, it is the nearest address in X-Forwarded-For that is not itself a
+// trusted proxy; addresses further left could have been forged by the client.
+func (s *Server) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !s.trustedProxy(ip) {
+		return ip
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip = hops[i]
+		if !s.trustedProxy(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// trustedProxy reports whether ip belongs to a configured proxy network.
+func (s *Server) trustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range s.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsIP reports whether ip is one of addrs, comparing parsed addresses
+func containsIP(addrs []string, ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if candidate := net.ParseIP(addr); candidate != nil && candidate.Equal(parsed) {
+			return true
+		}
+	}
+	return false
+}
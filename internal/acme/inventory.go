@@ -0,0 +1,56 @@
+// internal/acme/inventory.go
+package acme
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/database"
+)
+
+// ErrUnknownHost is returned by an Inventory when no server has the MAC address.
+var ErrUnknownHost = errors.New("acme: no inventory server with this MAC address")
+
+// Host is an inventory server as seen by the trusted-mac-01 challenge.
+type Host struct {
+	Hostname    string
+	IPAddresses []string
+}
+
+// Inventory looks up known servers by MAC address.
+type Inventory interface {
+	LookupMAC(ctx context.Context, mac string) (*Host, error)
+}
+
+// DatabaseInventory resolves MAC addresses against the servers table.
+type DatabaseInventory struct {
+	servers database.ServerRepository
+}
+
+// NewDatabaseInventory returns an Inventory backed by the server repository.
+func NewDatabaseInventory(servers database.ServerRepository) *DatabaseInventory {
+	return &DatabaseInventory{servers: servers}
+}
+
+// LookupMAC implements Inventory. MAC addresses are matched in lower case
+// with either colon or hyphen separators.
+func (d *DatabaseInventory) LookupMAC(ctx context.Context, mac string) (*Host, error) {
+	normalized := strings.ToLower(strings.ReplaceAll(mac, "-", ":"))
+	for _, candidate := range []string{normalized, strings.ReplaceAll(normalized, ":", "-")} {
+		page, err := d.servers.List(ctx, database.ServerFilter{MACAddress: candidate}, database.ListOptions{PageSize: 1})
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up MAC address: %w", err)
+		}
+		if len(page.Items) > 0 {
+			server := page.Items[0]
+			host := &Host{Hostname: server.Hostname}
+			if server.IPAddress != "" {
+				host.IPAddresses = []string{server.IPAddress}
+			}
+			return host, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownHost, mac)
+}
@@ -0,0 +1,99 @@
+// internal/acme/jws.go
+package acme
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	jose "github.com/go-jose/go-jose/v4"
+)
+
+// maxRequestSize caps the size of a JWS request body.
+const maxRequestSize = 1 << 20
+
+// supportedAlgorithms are the JWS algorithms accepted for account keys.
+var supportedAlgorithms = []jose.SignatureAlgorithm{
+	jose.RS256, jose.ES256, jose.ES384, jose.EdDSA,
+}
+
+// signedRequest is a verified JWS request body.
+type signedRequest struct {
+	payload []byte
+	// Exactly one of account (kid requests) and key (jwk requests) is set
+	account *Account
+	key     *jose.JSONWebKey
+}
+
+// verifyRequest checks the JWS envelope of an ACME POST: content type,
+// signature, nonce and url header. If allowJWK is set the request may carry
+// its key inline (new-account and revoke-cert); otherwise it must reference
+// an existing account by kid.
+func (s *Server) verifyRequest(r *http.Request, allowJWK bool) (*signedRequest, *Problem) {
+	if r.Method != http.MethodPost {
+		return nil, problem(http.StatusMethodNotAllowed, errMalformed, "method %s not allowed", r.Method)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/jose+json" {
+		return nil, problem(http.StatusUnsupportedMediaType, errMalformed, "content type must be application/jose+json")
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+	if err != nil {
+		return nil, problem(http.StatusBadRequest, errMalformed, "failed to read request: %v", err)
+	}
+	jws, err := jose.ParseSigned(string(body), supportedAlgorithms)
+	if err != nil {
+		return nil, problem(http.StatusBadRequest, errMalformed, "invalid JWS: %v", err)
+	}
+	if len(jws.Signatures) != 1 {
+		return nil, problem(http.StatusBadRequest, errMalformed, "JWS must have exactly one signature")
+	}
+	header := jws.Signatures[0].Protected
+
+	if !s.nonces.consume(header.Nonce) {
+		return nil, problem(http.StatusBadRequest, errBadNonce, "invalid or reused nonce")
+	}
+	if url, _ := header.ExtraHeaders["url"].(string); url != s.requestURL(r) {
+		return nil, problem(http.StatusUnauthorized, errUnauthorized, "url header %q does not match request", url)
+	}
+
+	req := &signedRequest{}
+	var verificationKey *jose.JSONWebKey
+	switch {
+	case header.JSONWebKey != nil && header.KeyID != "":
+		return nil, problem(http.StatusBadRequest, errMalformed, "jwk and kid are mutually exclusive")
+	case header.JSONWebKey != nil:
+		if !allowJWK {
+			return nil, problem(http.StatusBadRequest, errMalformed, "request must use kid")
+		}
+		if !header.JSONWebKey.Valid() || !header.JSONWebKey.IsPublic() {
+			return nil, problem(http.StatusBadRequest, errMalformed, "jwk must be a valid public key")
+		}
+		req.key = header.JSONWebKey
+		verificationKey = header.JSONWebKey
+	case header.KeyID != "":
+		prefix := s.url(r, "account") + "/"
+		if !strings.HasPrefix(header.KeyID, prefix) {
+			return nil, problem(http.StatusBadRequest, errMalformed, "unrecognised kid")
+		}
+		account, ok := s.store.account(strings.TrimPrefix(header.KeyID, prefix))
+		if !ok {
+			return nil, problem(http.StatusBadRequest, errAccountDoesNotExist, "account does not exist")
+		}
+		if account.Status != statusValid {
+			return nil, problem(http.StatusUnauthorized, errUnauthorized, "account is %s", account.Status)
+		}
+		req.account = account
+		verificationKey = account.Key
+	default:
+		return nil, problem(http.StatusBadRequest, errMalformed, "JWS must carry jwk or kid")
+	}
+
+	payload, err := jws.Verify(verificationKey)
+	if err != nil {
+		return nil, problem(http.StatusBadRequest, errMalformed, "JWS verification failed")
+	}
+	req.payload = payload
+	return req, nil
+}
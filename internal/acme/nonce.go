@@ -0,0 +1,61 @@
+// internal/acme/nonce.go
+package acme
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
+	"time"
+)
+
+// nonceLifetime bounds how long an unused nonce stays valid.
+const nonceLifetime = 15 * time.Minute
+
+// nonceStore issues single-use anti-replay nonces.
+type nonceStore struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time
+}
+
+func newNonceStore() *nonceStore {
+	return &nonceStore{nonces: make(map[string]time.Time)}
+}
+
+// issue returns a fresh nonce, dropping expired ones as a side effect.
+func (n *nonceStore) issue() string {
+	nonce := randomID()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := time.Now()
+	for value, expires := range n.nonces {
+		if now.After(expires) {
+			delete(n.nonces, value)
+		}
+	}
+	n.nonces[nonce] = now.Add(nonceLifetime)
+	return nonce
+}
+
+// consume reports whether nonce was issued and unused, and invalidates it.
+func (n *nonceStore) consume(nonce string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	expires, ok := n.nonces[nonce]
+	if !ok {
+		return false
+	}
+	delete(n.nonces, nonce)
+	return time.Now().Before(expires)
+}
+
+// randomID returns a URL-safe random identifier.
+func randomID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic("acme: failed to read random bytes: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,49 @@
+// internal/acme/problem.go
+package acme
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// ACME error types (RFC 8555 section 6.7).
+const (
+	errAccountDoesNotExist   = "urn:ietf:params:acme:error:accountDoesNotExist"
+	errBadCSR                = "urn:ietf:params:acme:error:badCSR"
+	errBadNonce              = "urn:ietf:params:acme:error:badNonce"
+	errBadRevocationReason   = "urn:ietf:params:acme:error:badRevocationReason"
+	errConnection            = "urn:ietf:params:acme:error:connection"
+	errIncorrectResponse     = "urn:ietf:params:acme:error:incorrectResponse"
+	errMalformed             = "urn:ietf:params:acme:error:malformed"
+	errOrderNotReady         = "urn:ietf:params:acme:error:orderNotReady"
+	errRejectedIdentifier    = "urn:ietf:params:acme:error:rejectedIdentifier"
+	errServerInternal        = "urn:ietf:params:acme:error:serverInternal"
+	errUnauthorized          = "urn:ietf:params:acme:error:unauthorized"
+	errUnsupportedIdentifier = "urn:ietf:params:acme:error:unsupportedIdentifier"
+	errAlreadyRevoked        = "urn:ietf:params:acme:error:alreadyRevoked"
+)
+
+// Problem is an RFC 7807 problem document as used by ACME.
+type Problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail,omitempty"`
+	Status int    `json:"status,omitempty"`
+}
+
+// Error implements the error interface.
+func (p *Problem) Error() string {
+	return fmt.Sprintf("%s: %s", p.Type, p.Detail)
+}
+
+// problem builds a Problem with a formatted detail message.
+func problem(status int, typ, format string, args ...interface{}) *Problem {
+	return &Problem{Type: typ, Detail: fmt.Sprintf(format, args...), Status: status}
+}
+
+// writeProblem sends p as an application/problem+json response.
+func writeProblem(w http.ResponseWriter, p *Problem) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
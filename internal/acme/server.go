@@ -0,0 +1,858 @@
+// internal/acme/server.go
+
+// Package acme implements an RFC 8555 ACME server in front of the
+// certificate authority, so standard clients such as certbot and lego can
+// obtain certificates from cert-issuer.
+package acme
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v4"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certissuer"
+)
+
+const (
+	// PathPrefix is where the ACME server is mounted on the HTTP listener.
+	PathPrefix = "/acme"
+
+	// PolicyRequester names the certissuer issuance policy applied to
+	// certificates ordered over ACME.
+	PolicyRequester = "acme"
+
+	// orderLifetime is how long an order and its authorizations stay valid.
+	orderLifetime = 24 * time.Hour
+
+	// validationTimeout bounds a single challenge validation.
+	validationTimeout = 10 * time.Second
+)
+
+// Server is an ACME server issuing certificates through a CertIssuer.
+type Server struct {
+	ca         certissuer.CertIssuer
+	store      *store
+	nonces     *nonceStore
+	inventory  Inventory
+	baseURL    string
+	http01Port int
+	httpClient *http.Client
+	// domain is the inventory domain trusted-mac-01 names may be under
+	domain         string
+	trustedProxies []netip.Prefix
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithInventory enables the trusted-mac-01 challenge using inv.
+func WithInventory(inv Inventory) Option {
+	return func(s *Server) {
+		s.inventory = inv
+	}
+}
+
+// WithInventoryDomain lets trusted-mac-01 authorize <hostname>.<domain> in
+// addition to the bare inventory hostname.
+func WithInventoryDomain(domain string) Option {
+	return func(s *Server) {
+		s.domain = strings.ToLower(strings.Trim(domain, "."))
+	}
+}
+
+// WithTrustedProxies sets the networks of reverse proxies whose
+// X-Forwarded-For header is believed when checking where a trusted-mac-01
+// response came from.
+func WithTrustedProxies(prefixes ...netip.Prefix) Option {
+	return func(s *Server) {
+		s.trustedProxies = append(s.trustedProxies, prefixes...)
+	}
+}
+
+// WithBaseURL sets the externally visible scheme and host, e.g.
+// "https://ca.example.com:8443". By default it is derived from each request,
+// which is wrong behind a TLS-terminating proxy.
+func WithBaseURL(url string) Option {
+	return func(s *Server) {
+		s.baseURL = strings.TrimSuffix(url, "/")
+	}
+}
+
+// WithHTTP01Port overrides the port http-01 challenges are fetched from.
+func WithHTTP01Port(port int) Option {
+	return func(s *Server) {
+		s.http01Port = port
+	}
+}
+
+// NewServer creates an ACME server. Accounts, orders and authorizations are
+// persisted to statePath; an empty path keeps them in memory.
+func NewServer(ca certissuer.CertIssuer, statePath string, opts ...Option) (*Server, error) {
+	st, err := newStore(statePath)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		ca:         ca,
+		store:      st,
+		nonces:     newNonceStore(),
+		http01Port: 80,
+		httpClient: &http.Client{Timeout: validationTimeout},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s, nil
+}
+
+// ServeHTTP routes ACME requests below PathPrefix.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, PathPrefix), "/")
+	parts := strings.Split(path, "/")
+
+	// Every response carries a fresh nonce and a link to the directory
+	w.Header().Set("Replay-Nonce", s.nonces.issue())
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Add("Link", link(s.url(r, "directory"), "index"))
+
+	switch {
+	case path == "directory":
+		s.handleDirectory(w, r)
+	case path == "new-nonce":
+		s.handleNewNonce(w, r)
+	case path == "new-account":
+		s.handleNewAccount(w, r)
+	case path == "new-order":
+		s.handleNewOrder(w, r)
+	case path == "revoke-cert":
+		s.handleRevokeCert(w, r)
+	case len(parts) == 2 && parts[0] == "account":
+		s.handleAccount(w, r, parts[1])
+	case len(parts) == 2 && parts[0] == "order":
+		s.handleOrder(w, r, parts[1])
+	case len(parts) == 3 && parts[0] == "order" && parts[2] == "finalize":
+		s.handleFinalize(w, r, parts[1])
+	case len(parts) == 2 && parts[0] == "authz":
+		s.handleAuthorization(w, r, parts[1])
+	case len(parts) == 2 && parts[0] == "chall":
+		s.handleChallenge(w, r, parts[1])
+	case len(parts) == 2 && parts[0] == "cert":
+		s.handleCertificate(w, r, parts[1])
+	default:
+		writeProblem(w, problem(http.StatusNotFound, errMalformed, "unknown resource %s", r.URL.Path))
+	}
+}
+
+func (s *Server) handleDirectory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, problem(http.StatusMethodNotAllowed, errMalformed, "method %s not allowed", r.Method))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"newNonce":   s.url(r, "new-nonce"),
+		"newAccount": s.url(r, "new-account"),
+		"newOrder":   s.url(r, "new-order"),
+		"revokeCert": s.url(r, "revoke-cert"),
+		"meta": map[string]interface{}{
+			"externalAccountRequired": false,
+		},
+	})
+}
+
+func (s *Server) handleNewNonce(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeProblem(w, problem(http.StatusMethodNotAllowed, errMalformed, "method %s not allowed", r.Method))
+	}
+}
+
+func (s *Server) handleNewAccount(w http.ResponseWriter, r *http.Request) {
+	req, p := s.verifyRequest(r, true)
+	if p != nil {
+		writeProblem(w, p)
+		return
+	}
+	if req.key == nil {
+		writeProblem(w, problem(http.StatusBadRequest, errMalformed, "new-account must use jwk"))
+		return
+	}
+
+	var payload struct {
+		Contact              []string `json:"contact"`
+		TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed"`
+		OnlyReturnExisting   bool     `json:"onlyReturnExisting"`
+	}
+	if err := json.Unmarshal(req.payload, &payload); err != nil {
+		writeProblem(w, problem(http.StatusBadRequest, errMalformed, "invalid account request: %v", err))
+		return
+	}
+
+	if account, ok := s.store.accountByKey(keyThumbprint(req.key)); ok {
+		w.Header().Set("Location", s.url(r, "account", account.ID))
+		writeJSON(w, http.StatusOK, accountResource{Status: account.Status, Contact: account.Contact})
+		return
+	}
+	if payload.OnlyReturnExisting {
+		writeProblem(w, problem(http.StatusBadRequest, errAccountDoesNotExist, "no account for this key"))
+		return
+	}
+
+	account := &Account{
+		ID:        randomID(),
+		Key:       req.key,
+		Contact:   payload.Contact,
+		Status:    statusValid,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := s.store.putAccount(account); err != nil {
+		writeProblem(w, problem(http.StatusInternalServerError, errServerInternal, "%v", err))
+		return
+	}
+
+	w.Header().Set("Location", s.url(r, "account", account.ID))
+	writeJSON(w, http.StatusCreated, accountResource{Status: account.Status, Contact: account.Contact})
+}
+
+func (s *Server) handleAccount(w http.ResponseWriter, r *http.Request, id string) {
+	req, p := s.verifyRequest(r, false)
+	if p != nil {
+		writeProblem(w, p)
+		return
+	}
+	if req.account.ID != id {
+		writeProblem(w, problem(http.StatusForbidden, errUnauthorized, "account mismatch"))
+		return
+	}
+
+	account := *req.account
+	if len(req.payload) > 0 {
+		var payload struct {
+			Contact []string `json:"contact"`
+			Status  string   `json:"status"`
+		}
+		if err := json.Unmarshal(req.payload, &payload); err != nil {
+			writeProblem(w, problem(http.StatusBadRequest, errMalformed, "invalid account update: %v", err))
+			return
+		}
+		if payload.Contact != nil {
+			account.Contact = payload.Contact
+		}
+		switch payload.Status {
+		case "":
+		case statusDeactivated:
+			account.Status = statusDeactivated
+		default:
+			writeProblem(w, problem(http.StatusBadRequest, errMalformed, "cannot set account status to %q", payload.Status))
+			return
+		}
+		if err := s.store.putAccount(&account); err != nil {
+			writeProblem(w, problem(http.StatusInternalServerError, errServerInternal, "%v", err))
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, accountResource{Status: account.Status, Contact: account.Contact})
+}
+
+func (s *Server) handleNewOrder(w http.ResponseWriter, r *http.Request) {
+	req, p := s.verifyRequest(r, false)
+	if p != nil {
+		writeProblem(w, p)
+		return
+	}
+
+	var payload struct {
+		Identifiers []Identifier `json:"identifiers"`
+		NotBefore   string       `json:"notBefore"`
+		NotAfter    string       `json:"notAfter"`
+	}
+	if err := json.Unmarshal(req.payload, &payload); err != nil {
+		writeProblem(w, problem(http.StatusBadRequest, errMalformed, "invalid order: %v", err))
+		return
+	}
+	if payload.NotBefore != "" || payload.NotAfter != "" {
+		writeProblem(w, problem(http.StatusBadRequest, errMalformed, "notBefore and notAfter are not supported"))
+		return
+	}
+	if len(payload.Identifiers) == 0 {
+		writeProblem(w, problem(http.StatusBadRequest, errMalformed, "order must contain identifiers"))
+		return
+	}
+
+	expires := time.Now().Add(orderLifetime).UTC()
+	o := &order{
+		ID:        randomID(),
+		AccountID: req.account.ID,
+		Status:    statusPending,
+		Expires:   expires,
+	}
+	var authzs []*authorization
+	for _, id := range payload.Identifiers {
+		id, p := normalizeIdentifier(id)
+		if p != nil {
+			writeProblem(w, p)
+			return
+		}
+		o.Identifiers = append(o.Identifiers, id)
+
+		authz := &authorization{
+			ID:         randomID(),
+			AccountID:  req.account.ID,
+			Identifier: id,
+			Status:     statusPending,
+			Expires:    expires,
+		}
+		for _, typ := range s.challengeTypes() {
+			authz.Challenges = append(authz.Challenges, &challenge{
+				ID:      randomID(),
+				AuthzID: authz.ID,
+				Type:    typ,
+				Token:   randomID(),
+				Status:  statusPending,
+			})
+		}
+		o.AuthzIDs = append(o.AuthzIDs, authz.ID)
+		authzs = append(authzs, authz)
+	}
+	if err := s.store.putOrder(o, authzs); err != nil {
+		writeProblem(w, problem(http.StatusInternalServerError, errServerInternal, "%v", err))
+		return
+	}
+
+	w.Header().Set("Location", s.url(r, "order", o.ID))
+	writeJSON(w, http.StatusCreated, s.orderResource(r, o))
+}
+
+func (s *Server) handleOrder(w http.ResponseWriter, r *http.Request, id string) {
+	req, p := s.verifyRequest(r, false)
+	if p != nil {
+		writeProblem(w, p)
+		return
+	}
+	o, p := s.ownedOrder(req.account, id)
+	if p != nil {
+		writeProblem(w, p)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.orderResource(r, o))
+}
+
+func (s *Server) handleAuthorization(w http.ResponseWriter, r *http.Request, id string) {
+	req, p := s.verifyRequest(r, false)
+	if p != nil {
+		writeProblem(w, p)
+		return
+	}
+	authz, ok := s.store.authorization(id)
+	if !ok || authz.AccountID != req.account.ID {
+		writeProblem(w, problem(http.StatusNotFound, errMalformed, "authorization not found"))
+		return
+	}
+
+	if len(req.payload) > 0 {
+		var payload struct {
+			Status string `json:"status"`
+		}
+		if err := json.Unmarshal(req.payload, &payload); err != nil || payload.Status != statusDeactivated {
+			writeProblem(w, problem(http.StatusBadRequest, errMalformed, "authorizations can only be deactivated"))
+			return
+		}
+		s.store.mu.Lock()
+		authz.Status = statusDeactivated
+		err := s.store.save()
+		s.store.mu.Unlock()
+		if err != nil {
+			writeProblem(w, problem(http.StatusInternalServerError, errServerInternal, "%v", err))
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, s.authorizationResource(r, authz))
+}
+
+func (s *Server) handleChallenge(w http.ResponseWriter, r *http.Request, id string) {
+	req, p := s.verifyRequest(r, false)
+	if p != nil {
+		writeProblem(w, p)
+		return
+	}
+	chall, ok := s.store.challenge(id)
+	if !ok {
+		writeProblem(w, problem(http.StatusNotFound, errMalformed, "challenge not found"))
+		return
+	}
+	authz, ok := s.store.authorization(chall.AuthzID)
+	if !ok || authz.AccountID != req.account.ID {
+		writeProblem(w, problem(http.StatusNotFound, errMalformed, "challenge not found"))
+		return
+	}
+
+	// An empty payload is a POST-as-GET; anything else asks for validation
+	s.store.mu.Lock()
+	start := len(req.payload) > 0 && chall.Status == statusPending && authz.Status == statusPending
+	if start {
+		if time.Now().After(authz.Expires) {
+			s.store.mu.Unlock()
+			writeProblem(w, problem(http.StatusForbidden, errMalformed, "authorization has expired"))
+			return
+		}
+		chall.Status = statusProcessing
+	}
+	s.store.mu.Unlock()
+
+	if start {
+		if err := s.validate(r, req, authz, chall); err != nil {
+			writeProblem(w, problem(http.StatusInternalServerError, errServerInternal, "%v", err))
+			return
+		}
+	}
+
+	w.Header().Add("Link", link(s.url(r, "authz", authz.ID), "up"))
+	s.store.mu.RLock()
+	resource := s.challengeResource(r, chall)
+	s.store.mu.RUnlock()
+	writeJSON(w, http.StatusOK, resource)
+}
+
+// validate runs a challenge and records the outcome on it and its
+// authorization. It only fails if the outcome cannot be persisted.
+func (s *Server) validate(r *http.Request, req *signedRequest, authz *authorization, chall *challenge) error {
+	v := validation{
+		identifier: authz.Identifier,
+		token:      chall.Token,
+		keyAuth:    chall.Token + "." + keyThumbprint(req.account.Key),
+		payload:    req.payload,
+	}
+	v.remoteIP = s.clientIP(r)
+
+	ctx, cancel := context.WithTimeout(r.Context(), validationTimeout)
+	defer cancel()
+
+	var p *Problem
+	switch chall.Type {
+	case ChallengeHTTP01:
+		p = s.validateHTTP01(ctx, v)
+	case ChallengeTrustedMAC01:
+		p = s.validateTrustedMAC01(ctx, v)
+	default:
+		p = problem(http.StatusBadRequest, errMalformed, "unsupported challenge type %s", chall.Type)
+	}
+
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+
+	if p != nil {
+		chall.Status = statusInvalid
+		chall.Error = p
+		authz.Status = statusInvalid
+	} else {
+		chall.Status = statusValid
+		chall.Validated = time.Now().UTC()
+		authz.Status = statusValid
+	}
+	return s.store.save()
+}
+
+func (s *Server) handleFinalize(w http.ResponseWriter, r *http.Request, id string) {
+	req, p := s.verifyRequest(r, false)
+	if p != nil {
+		writeProblem(w, p)
+		return
+	}
+	o, p := s.ownedOrder(req.account, id)
+	if p != nil {
+		writeProblem(w, p)
+		return
+	}
+
+	var payload struct {
+		CSR string `json:"csr"`
+	}
+	if err := json.Unmarshal(req.payload, &payload); err != nil {
+		writeProblem(w, problem(http.StatusBadRequest, errMalformed, "invalid finalize request: %v", err))
+		return
+	}
+	der, err := base64.RawURLEncoding.DecodeString(payload.CSR)
+	if err != nil {
+		writeProblem(w, problem(http.StatusBadRequest, errBadCSR, "CSR is not base64url encoded"))
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		writeProblem(w, problem(http.StatusBadRequest, errBadCSR, "invalid CSR: %v", err))
+		return
+	}
+	if err := csr.CheckSignature(); err != nil {
+		writeProblem(w, problem(http.StatusBadRequest, errBadCSR, "invalid CSR signature: %v", err))
+		return
+	}
+
+	s.store.mu.Lock()
+	s.refreshOrder(o)
+	if o.Status != statusReady {
+		status := o.Status
+		s.store.mu.Unlock()
+		writeProblem(w, problem(http.StatusForbidden, errOrderNotReady, "order is %s", status))
+		return
+	}
+	if !csrMatchesOrder(csr, o.Identifiers) {
+		s.store.mu.Unlock()
+		writeProblem(w, problem(http.StatusBadRequest, errBadCSR, "CSR names do not match the order identifiers"))
+		return
+	}
+	o.Status = statusProcessing
+	identifiers := o.Identifiers
+	s.store.mu.Unlock()
+
+	sans := make([]string, len(identifiers))
+	for i, id := range identifiers {
+		sans[i] = id.Value
+	}
+	clientInfo := map[string]string{
+		"common_name":  identifiers[0].Value,
+		"sans":         strings.Join(sans, ","),
+		"acme_account": req.account.ID,
+	}
+	csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+	// The CA returns the full chain: the leaf followed by the intermediate.
+	// ACME orders are subject to the "acme" issuance policy.
+	ctx := certissuer.ContextWithRequester(r.Context(), PolicyRequester)
+	certPEM, err := s.ca.IssueCertificate(ctx, csrPEM, clientInfo)
+
+	s.store.mu.Lock()
+	if err != nil {
+		// The order stays ready so the client can retry with another CSR
+		o.Status = statusReady
+		s.store.mu.Unlock()
+		if errors.Is(err, certissuer.ErrPolicyViolation) {
+			writeProblem(w, problem(http.StatusForbidden, errRejectedIdentifier, "%v", err))
+			return
+		}
+		writeProblem(w, problem(http.StatusBadRequest, errBadCSR, "failed to issue certificate: %v", err))
+		return
+	}
+	o.Status = statusValid
+	o.CertificateID = randomID()
+	o.CertificatePEM = certPEM
+	if serial, err := leafSerial(certPEM); err == nil {
+		s.store.certOwners[serial] = o.AccountID
+	}
+	err = s.store.save()
+	s.store.mu.Unlock()
+	if err != nil {
+		writeProblem(w, problem(http.StatusInternalServerError, errServerInternal, "%v", err))
+		return
+	}
+
+	w.Header().Set("Location", s.url(r, "order", o.ID))
+	writeJSON(w, http.StatusOK, s.orderResource(r, o))
+}
+
+func (s *Server) handleCertificate(w http.ResponseWriter, r *http.Request, id string) {
+	req, p := s.verifyRequest(r, false)
+	if p != nil {
+		writeProblem(w, p)
+		return
+	}
+
+	s.store.mu.RLock()
+	var certPEM []byte
+	for _, o := range s.store.orders {
+		if o.CertificateID == id && o.AccountID == req.account.ID {
+			certPEM = o.CertificatePEM
+			break
+		}
+	}
+	s.store.mu.RUnlock()
+
+	if certPEM == nil {
+		writeProblem(w, problem(http.StatusNotFound, errMalformed, "certificate not found"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	w.WriteHeader(http.StatusOK)
+	w.Write(certPEM)
+}
+
+func (s *Server) handleRevokeCert(w http.ResponseWriter, r *http.Request) {
+	req, p := s.verifyRequest(r, true)
+	if p != nil {
+		writeProblem(w, p)
+		return
+	}
+
+	var payload struct {
+		Certificate string `json:"certificate"`
+		Reason      *int   `json:"reason"`
+	}
+	if err := json.Unmarshal(req.payload, &payload); err != nil {
+		writeProblem(w, problem(http.StatusBadRequest, errMalformed, "invalid revocation request: %v", err))
+		return
+	}
+	der, err := base64.RawURLEncoding.DecodeString(payload.Certificate)
+	if err != nil {
+		writeProblem(w, problem(http.StatusBadRequest, errMalformed, "certificate is not base64url encoded"))
+		return
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		writeProblem(w, problem(http.StatusBadRequest, errMalformed, "invalid certificate: %v", err))
+		return
+	}
+
+	reason := certissuer.ReasonUnspecified
+	if payload.Reason != nil {
+		reason, err = certissuer.ParseRevocationReason(strconv.Itoa(*payload.Reason))
+		if err != nil {
+			writeProblem(w, problem(http.StatusBadRequest, errBadRevocationReason, "%v", err))
+			return
+		}
+	}
+
+	// Either the ordering account or the certificate's own key may revoke
+	serial := cert.SerialNumber.String()
+	if req.account != nil {
+		s.store.mu.RLock()
+		owner := s.store.certOwners[serial]
+		s.store.mu.RUnlock()
+		if owner != req.account.ID {
+			writeProblem(w, problem(http.StatusForbidden, errUnauthorized, "account did not order this certificate"))
+			return
+		}
+	} else {
+		certKey := jose.JSONWebKey{Key: cert.PublicKey}
+		if keyThumbprint(&certKey) == "" || keyThumbprint(&certKey) != keyThumbprint(req.key) {
+			writeProblem(w, problem(http.StatusForbidden, errUnauthorized, "jwk does not match the certificate key"))
+			return
+		}
+	}
+
+	err = s.ca.RevokeCertificate(r.Context(), serial, reason)
+	switch {
+	case errors.Is(err, certissuer.ErrCertificateAlreadyRevoked):
+		writeProblem(w, problem(http.StatusBadRequest, errAlreadyRevoked, "certificate is already revoked"))
+	case errors.Is(err, certissuer.ErrCertificateNotFound):
+		writeProblem(w, problem(http.StatusNotFound, errMalformed, "certificate was not issued by this CA"))
+	case err != nil:
+		writeProblem(w, problem(http.StatusInternalServerError, errServerInternal, "%v", err))
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// challengeTypes lists the challenges offered for each authorization.
+func (s *Server) challengeTypes() []string {
+	types := []string{ChallengeHTTP01}
+	if s.inventory != nil {
+		types = append(types, ChallengeTrustedMAC01)
+	}
+	return types
+}
+
+// ownedOrder fetches an order belonging to account.
+func (s *Server) ownedOrder(account *Account, id string) (*order, *Problem) {
+	o, ok := s.store.order(id)
+	if !ok || o.AccountID != account.ID {
+		return nil, problem(http.StatusNotFound, errMalformed, "order not found")
+	}
+	return o, nil
+}
+
+// refreshOrder derives the order status from its authorizations. The caller
+// must hold s.store.mu for writing.
+func (s *Server) refreshOrder(o *order) {
+	if o.Status != statusPending {
+		return
+	}
+	if time.Now().After(o.Expires) {
+		o.Status = statusInvalid
+		return
+	}
+
+	ready := true
+	for _, id := range o.AuthzIDs {
+		authz := s.store.authorizations[id]
+		switch authz.Status {
+		case statusValid:
+		case statusPending:
+			ready = false
+		default:
+			o.Status = statusInvalid
+			o.Error = problem(http.StatusForbidden, errUnauthorized, "authorization for %s is %s", authz.Identifier.Value, authz.Status)
+			return
+		}
+	}
+	if ready {
+		o.Status = statusReady
+	}
+}
+
+func (s *Server) orderResource(r *http.Request, o *order) orderResource {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+
+	s.refreshOrder(o)
+	res := orderResource{
+		Status:      o.Status,
+		Expires:     o.Expires.Format(time.RFC3339),
+		Identifiers: o.Identifiers,
+		Error:       o.Error,
+		Finalize:    s.url(r, "order", o.ID, "finalize"),
+	}
+	for _, id := range o.AuthzIDs {
+		res.Authorizations = append(res.Authorizations, s.url(r, "authz", id))
+	}
+	if o.CertificateID != "" {
+		res.Certificate = s.url(r, "cert", o.CertificateID)
+	}
+	return res
+}
+
+func (s *Server) authorizationResource(r *http.Request, authz *authorization) authorizationResource {
+	s.store.mu.RLock()
+	defer s.store.mu.RUnlock()
+
+	res := authorizationResource{
+		Identifier: authz.Identifier,
+		Status:     authz.Status,
+		Expires:    authz.Expires.Format(time.RFC3339),
+	}
+	for _, chall := range authz.Challenges {
+		res.Challenges = append(res.Challenges, s.challengeResource(r, chall))
+	}
+	return res
+}
+
+// challengeResource renders a challenge. The caller must hold s.store.mu.
+func (s *Server) challengeResource(r *http.Request, chall *challenge) challengeResource {
+	res := challengeResource{
+		Type:   chall.Type,
+		URL:    s.url(r, "chall", chall.ID),
+		Status: chall.Status,
+		Token:  chall.Token,
+		Error:  chall.Error,
+	}
+	if !chall.Validated.IsZero() {
+		res.Validated = chall.Validated.Format(time.RFC3339)
+	}
+	return res
+}
+
+// base returns the externally visible scheme and host.
+func (s *Server) base(r *http.Request) string {
+	if s.baseURL != "" {
+		return s.baseURL
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// url builds the absolute URL of an ACME resource.
+func (s *Server) url(r *http.Request, parts ...string) string {
+	return s.base(r) + PathPrefix + "/" + strings.Join(parts, "/")
+}
+
+// requestURL is the absolute URL the client addressed, for the JWS url check.
+func (s *Server) requestURL(r *http.Request) string {
+	return s.base(r) + r.URL.Path
+}
+
+// normalizeIdentifier validates an order identifier.
+func normalizeIdentifier(id Identifier) (Identifier, *Problem) {
+	switch id.Type {
+	case identifierDNS:
+		name := strings.ToLower(strings.TrimSuffix(id.Value, "."))
+		if name == "" || strings.Contains(name, "*") {
+			return id, problem(http.StatusBadRequest, errRejectedIdentifier, "unsupported DNS identifier %q", id.Value)
+		}
+		if net.ParseIP(name) != nil {
+			return id, problem(http.StatusBadRequest, errMalformed, "IP address %q must use the ip identifier type", id.Value)
+		}
+		return Identifier{Type: identifierDNS, Value: name}, nil
+	case identifierIP:
+		ip := net.ParseIP(id.Value)
+		if ip == nil {
+			return id, problem(http.StatusBadRequest, errMalformed, "invalid IP identifier %q", id.Value)
+		}
+		return Identifier{Type: identifierIP, Value: ip.String()}, nil
+	default:
+		return id, problem(http.StatusBadRequest, errUnsupportedIdentifier, "identifier type %q is not supported", id.Type)
+	}
+}
+
+// csrMatchesOrder reports whether the CSR requests exactly the order's names.
+func csrMatchesOrder(csr *x509.CertificateRequest, identifiers []Identifier) bool {
+	want := make([]string, 0, len(identifiers))
+	for _, id := range identifiers {
+		want = append(want, id.Type+":"+id.Value)
+	}
+
+	seen := make(map[string]bool)
+	var got []string
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			got = append(got, name)
+		}
+	}
+	for _, name := range csr.DNSNames {
+		add(identifierDNS + ":" + strings.ToLower(name))
+	}
+	for _, ip := range csr.IPAddresses {
+		add(identifierIP + ":" + ip.String())
+	}
+	if cn := csr.Subject.CommonName; cn != "" {
+		if ip := net.ParseIP(cn); ip != nil {
+			add(identifierIP + ":" + ip.String())
+		} else {
+			add(identifierDNS + ":" + strings.ToLower(cn))
+		}
+	}
+
+	sort.Strings(want)
+	sort.Strings(got)
+	return strings.Join(want, ",") == strings.Join(got, ",")
+}
+
+// leafSerial returns the serial number of the first certificate in a PEM chain.
+func leafSerial(chainPEM []byte) (string, error) {
+	block, _ := pem.Decode(chainPEM)
+	if block == nil {
+		return "", fmt.Errorf("no certificate in PEM data")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", err
+	}
+	return cert.SerialNumber.String(), nil
+}
+
+func link(url, rel string) string {
+	return fmt.Sprintf("<%s>;rel=%q", url, rel)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
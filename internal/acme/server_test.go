@@ -0,0 +1,440 @@
+// internal/acme/server_test.go
+package acme_test
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	jose "github.com/go-jose/go-jose/v4"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/acme"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certissuer"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certissuer/certissuertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	xacme "golang.org/x/crypto/acme"
+)
+
+// fakeInventory maps MAC addresses to hosts.
+type fakeInventory map[string]*acme.Host
+
+func (f fakeInventory) LookupMAC(ctx context.Context, mac string) (*acme.Host, error) {
+	if host, ok := f[strings.ToLower(mac)]; ok {
+		return host, nil
+	}
+	return nil, acme.ErrUnknownHost
+}
+
+// newTestServer starts an ACME server backed by a fresh CA.
+func newTestServer(t *testing.T, opts ...acme.Option) (*httptest.Server, certissuer.CertIssuer) {
+	t.Helper()
+
+	ca, err := certissuer.NewService(t.TempDir(), certissuertest.OfflineRootKey(t))
+	require.NoError(t, err)
+	server, err := acme.NewServer(ca, t.TempDir()+"/state.json", opts...)
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	mux.Handle(acme.PathPrefix+"/", server)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return ts, ca
+}
+
+// newClient registers an ACME account against ts.
+func newClient(t *testing.T, ts *httptest.Server) *xacme.Client {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	client := &xacme.Client{
+		Key:          key,
+		DirectoryURL: ts.URL + acme.PathPrefix + "/directory",
+	}
+	_, err = client.Register(context.Background(), &xacme.Account{}, xacme.AcceptTOS)
+	require.NoError(t, err)
+	return client
+}
+
+func createCSR(t *testing.T, names ...string) []byte {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.CertificateRequest{Subject: pkix.Name{CommonName: names[0]}}
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	require.NoError(t, err)
+	return der
+}
+
+func findChallenge(t *testing.T, authz *xacme.Authorization, typ string) *xacme.Challenge {
+	t.Helper()
+
+	for _, chall := range authz.Challenges {
+		if chall.Type == typ {
+			return chall
+		}
+	}
+	t.Fatalf("authorization has no %s challenge", typ)
+	return nil
+}
+
+func TestHTTP01Flow(t *testing.T) {
+	ctx := context.Background()
+
+	// The client side of http-01: serve key authorizations on a local port
+	responses := make(map[string]string)
+	challengeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := responses[strings.TrimPrefix(r.URL.Path, "/.well-known/acme-challenge/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, body)
+	}))
+	defer challengeServer.Close()
+	_, port, err := net.SplitHostPort(challengeServer.Listener.Addr().String())
+	require.NoError(t, err)
+	http01Port, err := strconv.Atoi(port)
+	require.NoError(t, err)
+
+	ts, ca := newTestServer(t, acme.WithHTTP01Port(http01Port))
+	client := newClient(t, ts)
+
+	order, err := client.AuthorizeOrder(ctx, xacme.IPIDs("127.0.0.1"))
+	require.NoError(t, err)
+	assert.Equal(t, xacme.StatusPending, order.Status)
+	require.Len(t, order.AuthzURLs, 1)
+
+	authz, err := client.GetAuthorization(ctx, order.AuthzURLs[0])
+	require.NoError(t, err)
+	assert.Len(t, authz.Challenges, 1, "trusted-mac-01 should only be offered with an inventory")
+	chall := findChallenge(t, authz, acme.ChallengeHTTP01)
+
+	// Finalizing before the order is ready is rejected
+	_, _, err = client.CreateOrderCert(ctx, order.FinalizeURL, createCSR(t, "127.0.0.1"), true)
+	require.Error(t, err)
+
+	keyAuth, err := client.HTTP01ChallengeResponse(chall.Token)
+	require.NoError(t, err)
+	responses[chall.Token] = keyAuth
+
+	_, err = client.Accept(ctx, chall)
+	require.NoError(t, err)
+	_, err = client.WaitAuthorization(ctx, authz.URI)
+	require.NoError(t, err)
+	order, err = client.WaitOrder(ctx, order.URI)
+	require.NoError(t, err)
+	assert.Equal(t, xacme.StatusReady, order.Status)
+
+	// A CSR for other names than the order is refused
+	_, _, err = client.CreateOrderCert(ctx, order.FinalizeURL, createCSR(t, "127.0.0.1", "other.example.com"), true)
+	require.Error(t, err)
+
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, createCSR(t, "127.0.0.1"), true)
+	require.NoError(t, err)
+	require.Len(t, chain, 2, "The chain should contain the leaf and the intermediate")
+
+	leaf, err := x509.ParseCertificate(chain[0])
+	require.NoError(t, err)
+	require.Len(t, leaf.IPAddresses, 1)
+	assert.Equal(t, "127.0.0.1", leaf.IPAddresses[0].String())
+
+	issued, err := ca.GetCertificate(ctx, leaf.SerialNumber.String())
+	require.NoError(t, err)
+	assert.NotEmpty(t, issued.ClientInfo["acme_account"])
+
+	// Only the ordering account may revoke
+	other := newClient(t, ts)
+	err = other.RevokeCert(ctx, nil, chain[0], xacme.CRLReasonKeyCompromise)
+	var acmeErr *xacme.Error
+	require.ErrorAs(t, err, &acmeErr)
+	assert.Equal(t, "urn:ietf:params:acme:error:unauthorized", acmeErr.ProblemType)
+
+	require.NoError(t, client.RevokeCert(ctx, nil, chain[0], xacme.CRLReasonKeyCompromise))
+	// The client treats alreadyRevoked as success
+	require.NoError(t, client.RevokeCert(ctx, nil, chain[0], xacme.CRLReasonKeyCompromise))
+
+	issued, err = ca.GetCertificate(ctx, leaf.SerialNumber.String())
+	require.NoError(t, err)
+	assert.True(t, issued.IsRevoked)
+	assert.Equal(t, certissuer.ReasonKeyCompromise, issued.RevocationReason)
+}
+
+func TestHTTP01WrongKeyAuthorization(t *testing.T) {
+	ctx := context.Background()
+
+	challengeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "not-the-key-authorization")
+	}))
+	defer challengeServer.Close()
+	_, port, _ := net.SplitHostPort(challengeServer.Listener.Addr().String())
+	http01Port, _ := strconv.Atoi(port)
+
+	ts, _ := newTestServer(t, acme.WithHTTP01Port(http01Port))
+	client := newClient(t, ts)
+
+	order, err := client.AuthorizeOrder(ctx, xacme.IPIDs("127.0.0.1"))
+	require.NoError(t, err)
+	authz, err := client.GetAuthorization(ctx, order.AuthzURLs[0])
+	require.NoError(t, err)
+
+	_, err = client.Accept(ctx, findChallenge(t, authz, acme.ChallengeHTTP01))
+	require.NoError(t, err)
+	_, err = client.WaitAuthorization(ctx, authz.URI)
+	require.Error(t, err)
+
+	_, err = client.WaitOrder(ctx, order.URI)
+	require.Error(t, err, "The order should become invalid with its authorization")
+}
+
+func TestNewOrderRejectsWildcards(t *testing.T) {
+	ts, _ := newTestServer(t)
+	client := newClient(t, ts)
+
+	_, err := client.AuthorizeOrder(context.Background(), xacme.DomainIDs("*.example.com"))
+	require.Error(t, err)
+}
+
+// postJWS sends a kid-signed ACME request with an arbitrary payload, which
+// the x/crypto client cannot do for custom challenge types.
+func postJWS(t *testing.T, ts *httptest.Server, key crypto.Signer, kid, target string, payload interface{}) *http.Response {
+	t.Helper()
+
+	resp, err := http.DefaultClient.Do(newJWSRequest(t, ts, key, kid, target, payload))
+	require.NoError(t, err)
+	return resp
+}
+
+// newJWSRequest builds the request sent by postJWS.
+func newJWSRequest(t *testing.T, ts *httptest.Server, key crypto.Signer, kid, target string, payload interface{}) *http.Request {
+	t.Helper()
+
+	resp, err := http.Head(ts.URL + acme.PathPrefix + "/new-nonce")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.ES256, Key: jose.JSONWebKey{Key: key, KeyID: kid}},
+		&jose.SignerOptions{ExtraHeaders: map[jose.HeaderKey]interface{}{
+			"nonce": resp.Header.Get("Replay-Nonce"),
+			"url":   target,
+		}},
+	)
+	require.NoError(t, err)
+	body, err := json.Marshal(payload)
+	require.NoError(t, err)
+	jws, err := signer.Sign(body)
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewBufferString(jws.FullSerialize()))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/jose+json")
+	return req
+}
+
+func TestTrustedMAC01Flow(t *testing.T) {
+	ctx := context.Background()
+	inventory := fakeInventory{
+		"52:54:00:12:34:56": {Hostname: "node01", IPAddresses: []string{"127.0.0.1"}},
+		"52:54:00:ab:cd:ef": {Hostname: "node02", IPAddresses: []string{"192.0.2.10"}},
+	}
+	ts, _ := newTestServer(t, acme.WithInventory(inventory), acme.WithInventoryDomain("lab.example.com"))
+	client := newClient(t, ts)
+
+	respond := func(name, mac string) string {
+		order, err := client.AuthorizeOrder(ctx, xacme.DomainIDs(name))
+		require.NoError(t, err)
+		authz, err := client.GetAuthorization(ctx, order.AuthzURLs[0])
+		require.NoError(t, err)
+		chall := findChallenge(t, authz, acme.ChallengeTrustedMAC01)
+
+		resp := postJWS(t, ts, client.Key, string(client.KID), chall.URI, map[string]string{"mac": mac})
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var result struct {
+			Status string `json:"status"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		return result.Status
+	}
+
+	// Unknown MAC, hostname mismatch and a request from the wrong address all fail
+	assert.Equal(t, "invalid", respond("node01.lab.example.com", "52:54:00:00:00:00"))
+	assert.Equal(t, "invalid", respond("node03.lab.example.com", "52:54:00:12:34:56"))
+	assert.Equal(t, "invalid", respond("node02.lab.example.com", "52:54:00:ab:cd:ef"))
+	// Only names directly under the inventory domain belong to the host
+	assert.Equal(t, "invalid", respond("node01.attacker.example", "52:54:00:12:34:56"))
+	assert.Equal(t, "invalid", respond("node01.sub.lab.example.com", "52:54:00:12:34:56"))
+
+	// The inventory host answering from its own address is trusted
+	assert.Equal(t, "valid", respond("node01.lab.example.com", "52:54:00:12:34:56"))
+
+	order, err := client.AuthorizeOrder(ctx, xacme.DomainIDs("node01.lab.example.com"))
+	require.NoError(t, err)
+	authz, err := client.GetAuthorization(ctx, order.AuthzURLs[0])
+	require.NoError(t, err)
+	chall := findChallenge(t, authz, acme.ChallengeTrustedMAC01)
+	resp := postJWS(t, ts, client.Key, string(client.KID), chall.URI, map[string]string{"mac": "52:54:00:12:34:56"})
+	resp.Body.Close()
+
+	order, err = client.WaitOrder(ctx, order.URI)
+	require.NoError(t, err)
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, createCSR(t, "node01.lab.example.com"), true)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(chain[0])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"node01.lab.example.com"}, leaf.DNSNames)
+}
+
+func TestAccountPersistence(t *testing.T) {
+	path := t.TempDir() + "/state.json"
+	ca, err := certissuer.NewService(t.TempDir(), certissuertest.OfflineRootKey(t))
+	require.NoError(t, err)
+
+	start := func() *httptest.Server {
+		server, err := acme.NewServer(ca, path)
+		require.NoError(t, err)
+		ts := httptest.NewServer(server)
+		t.Cleanup(ts.Close)
+		return ts
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	ts := start()
+	client := &xacme.Client{Key: key, DirectoryURL: ts.URL + acme.PathPrefix + "/directory"}
+	account, err := client.Register(context.Background(), &xacme.Account{}, xacme.AcceptTOS)
+	require.NoError(t, err)
+
+	// A restarted server still knows the key
+	ts = start()
+	client = &xacme.Client{Key: key, DirectoryURL: ts.URL + acme.PathPrefix + "/directory"}
+	existing, err := client.GetReg(context.Background(), "")
+	require.NoError(t, err)
+	registered, err := url.Parse(account.URI)
+	require.NoError(t, err)
+	found, err := url.Parse(existing.URI)
+	require.NoError(t, err)
+	assert.Equal(t, registered.Path, found.Path)
+}
+
+func TestOrderPersistence(t *testing.T) {
+	ctx := context.Background()
+	path := t.TempDir() + "/state.json"
+	ca, err := certissuer.NewService(t.TempDir(), certissuertest.OfflineRootKey(t))
+	require.NoError(t, err)
+	inventory := fakeInventory{
+		"52:54:00:12:34:56": {Hostname: "node01", IPAddresses: []string{"127.0.0.1"}},
+	}
+
+	start := func() *httptest.Server {
+		server, err := acme.NewServer(ca, path, acme.WithInventory(inventory))
+		require.NoError(t, err)
+		ts := httptest.NewServer(server)
+		t.Cleanup(ts.Close)
+		return ts
+	}
+	connect := func(ts *httptest.Server, key crypto.Signer) *xacme.Client {
+		client := &xacme.Client{Key: key, DirectoryURL: ts.URL + acme.PathPrefix + "/directory"}
+		_, err := client.Register(ctx, &xacme.Account{}, xacme.AcceptTOS)
+		if err != nil {
+			require.ErrorIs(t, err, xacme.ErrAccountAlreadyExists)
+		}
+		return client
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ts := start()
+	client := connect(ts, key)
+	order, err := client.AuthorizeOrder(ctx, xacme.DomainIDs("node01"))
+	require.NoError(t, err)
+	orderPath, err := url.Parse(order.URI)
+	require.NoError(t, err)
+	authzPath, err := url.Parse(order.AuthzURLs[0])
+	require.NoError(t, err)
+
+	// The order and its challenges survive a restart
+	ts = start()
+	client = connect(ts, key)
+	authz, err := client.GetAuthorization(ctx, ts.URL+authzPath.Path)
+	require.NoError(t, err)
+	chall := findChallenge(t, authz, acme.ChallengeTrustedMAC01)
+	resp := postJWS(t, ts, client.Key, string(client.KID), chall.URI, map[string]string{"mac": "52:54:00:12:34:56"})
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	order, err = client.WaitOrder(ctx, ts.URL+orderPath.Path)
+	require.NoError(t, err)
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, createCSR(t, "node01"), true)
+	require.NoError(t, err)
+
+	// So does the record of which account ordered the certificate
+	ts = start()
+	client = connect(ts, key)
+	require.NoError(t, client.RevokeCert(ctx, nil, chain[0], xacme.CRLReasonUnspecified))
+}
+
+func TestTrustedMAC01BehindProxy(t *testing.T) {
+	ctx := context.Background()
+	inventory := fakeInventory{
+		"52:54:00:ab:cd:ef": {Hostname: "node02", IPAddresses: []string{"192.0.2.10"}},
+	}
+
+	respond := func(ts *httptest.Server, forwardedFor string) string {
+		client := newClient(t, ts)
+		order, err := client.AuthorizeOrder(ctx, xacme.DomainIDs("node02"))
+		require.NoError(t, err)
+		authz, err := client.GetAuthorization(ctx, order.AuthzURLs[0])
+		require.NoError(t, err)
+		chall := findChallenge(t, authz, acme.ChallengeTrustedMAC01)
+
+		req := newJWSRequest(t, ts, client.Key, string(client.KID), chall.URI, map[string]string{"mac": "52:54:00:ab:cd:ef"})
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var result struct {
+			Status string `json:"status"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		return result.Status
+	}
+
+	// X-Forwarded-For is ignored unless the peer is a trusted proxy
+	direct, _ := newTestServer(t, acme.WithInventory(inventory))
+	assert.Equal(t, "invalid", respond(direct, "192.0.2.10"))
+
+	proxied, _ := newTestServer(t, acme.WithInventory(inventory),
+		acme.WithTrustedProxies(netip.MustParsePrefix("127.0.0.0/8")))
+	assert.Equal(t, "valid", respond(proxied, "192.0.2.10"))
+	// Addresses left of the last untrusted hop may be forged by the client
+	assert.Equal(t, "invalid", respond(proxied, "192.0.2.10, 198.51.100.7"))
+}
@@ -0,0 +1,226 @@
+// internal/acme/store.go
+package acme
+
+import (
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	jose "github.com/go-jose/go-jose/v4"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/atomicfile"
+	"github.com/spf13/afero"
+)
+
+// store holds ACME state. Accounts, orders, authorizations and the owners of
+// issued certificates are persisted together, so that clients can finish
+// their orders and revoke their certificates after a restart.
+type store struct {
+	mu             sync.RWMutex
+	path           string
+	accounts       map[string]*Account
+	orders         map[string]*order
+	authorizations map[string]*authorization
+	challenges     map[string]*challenge
+	// certificate serial number to the account that ordered it
+	certOwners map[string]string
+}
+
+// storeState is the on-disk form of a store. Challenges are kept inside
+// their authorizations.
+type storeState struct {
+	Accounts       []*Account        `json:"accounts"`
+	Orders         []*order          `json:"orders,omitempty"`
+	Authorizations []*authorization  `json:"authorizations,omitempty"`
+	CertOwners     map[string]string `json:"cert_owners,omitempty"`
+}
+
+func newStore(path string) (*store, error) {
+	s := &store{
+		path:           path,
+		accounts:       make(map[string]*Account),
+		orders:         make(map[string]*order),
+		authorizations: make(map[string]*authorization),
+		challenges:     make(map[string]*challenge),
+		certOwners:     make(map[string]string),
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ACME state: %w", err)
+	}
+	var state storeState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to decode ACME state: %w", err)
+	}
+	for _, account := range state.Accounts {
+		s.accounts[account.ID] = account
+	}
+	for _, o := range state.Orders {
+		// Issuance was interrupted by the restart; let the client retry
+		if o.Status == statusProcessing {
+			o.Status = statusReady
+		}
+		s.orders[o.ID] = o
+	}
+	for _, authz := range state.Authorizations {
+		s.authorizations[authz.ID] = authz
+		for _, chall := range authz.Challenges {
+			if chall.Status == statusProcessing {
+				chall.Status = statusPending
+			}
+			s.challenges[chall.ID] = chall
+		}
+	}
+	for serial, owner := range state.CertOwners {
+		s.certOwners[serial] = owner
+	}
+	return s, nil
+}
+
+// save writes the whole store to disk. The caller must hold s.mu.
+func (s *store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	state := storeState{
+		Accounts:       make([]*Account, 0, len(s.accounts)),
+		Orders:         make([]*order, 0, len(s.orders)),
+		Authorizations: make([]*authorization, 0, len(s.authorizations)),
+		CertOwners:     s.certOwners,
+	}
+	for _, account := range s.accounts {
+		state.Accounts = append(state.Accounts, account)
+	}
+	for _, o := range s.orders {
+		state.Orders = append(state.Orders, o)
+	}
+	for _, authz := range s.authorizations {
+		state.Authorizations = append(state.Authorizations, authz)
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode ACME state: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create ACME storage directory: %w", err)
+	}
+	if err := atomicfile.WriteFile(afero.NewOsFs(), s.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to save ACME state: %w", err)
+	}
+	return nil
+}
+
+// putAccount stores and persists an account.
+func (s *store) putAccount(account *Account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, existed := s.accounts[account.ID]
+	s.accounts[account.ID] = account
+	if err := s.save(); err != nil {
+		if existed {
+			s.accounts[account.ID] = previous
+		} else {
+			delete(s.accounts, account.ID)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *store) account(id string) (*Account, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	account, ok := s.accounts[id]
+	return account, ok
+}
+
+// accountByKey finds the account registered with the given key.
+func (s *store) accountByKey(thumbprint string) (*Account, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, account := range s.accounts {
+		if keyThumbprint(account.Key) == thumbprint {
+			return account, true
+		}
+	}
+	return nil, false
+}
+
+// putOrder stores and persists an order together with its authorizations
+// and challenges.
+func (s *store) putOrder(o *order, authzs []*authorization) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.orders[o.ID] = o
+	for _, authz := range authzs {
+		s.authorizations[authz.ID] = authz
+		for _, chall := range authz.Challenges {
+			s.challenges[chall.ID] = chall
+		}
+	}
+	if err := s.save(); err != nil {
+		delete(s.orders, o.ID)
+		for _, authz := range authzs {
+			delete(s.authorizations, authz.ID)
+			for _, chall := range authz.Challenges {
+				delete(s.challenges, chall.ID)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// The accessors below return live objects; callers mutate them under s.mu
+// and then call s.save.
+
+func (s *store) order(id string) (*order, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	o, ok := s.orders[id]
+	return o, ok
+}
+
+func (s *store) authorization(id string) (*authorization, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	authz, ok := s.authorizations[id]
+	return authz, ok
+}
+
+func (s *store) challenge(id string) (*challenge, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	chall, ok := s.challenges[id]
+	return chall, ok
+}
+
+// keyThumbprint returns the RFC 7638 SHA-256 thumbprint of key, base64url
+// encoded as used in key authorizations.
+func keyThumbprint(key *jose.JSONWebKey) string {
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(thumbprint)
+}
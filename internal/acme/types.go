@@ -0,0 +1,113 @@
+// internal/acme/types.go
+package acme
+
+import (
+	"time"
+
+	jose "github.com/go-jose/go-jose/v4"
+)
+
+// Object statuses (RFC 8555 section 7.1.6).
+const (
+	statusPending     = "pending"
+	statusReady       = "ready"
+	statusProcessing  = "processing"
+	statusValid       = "valid"
+	statusInvalid     = "invalid"
+	statusDeactivated = "deactivated"
+)
+
+// Identifier and challenge types.
+const (
+	identifierDNS = "dns"
+	identifierIP  = "ip"
+
+	// ChallengeHTTP01 is the RFC 8555 http-01 challenge.
+	ChallengeHTTP01 = "http-01"
+	// ChallengeTrustedMAC01 proves control of a host registered in the
+	// inventory: the request must come from the inventory IP address of the
+	// server with the MAC address given in the challenge response.
+	ChallengeTrustedMAC01 = "trusted-mac-01"
+)
+
+// Identifier names a DNS name or IP address in an order.
+type Identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// Account is a registered ACME account.
+type Account struct {
+	ID        string           `json:"id"`
+	Key       *jose.JSONWebKey `json:"key"`
+	Contact   []string         `json:"contact,omitempty"`
+	Status    string           `json:"status"`
+	CreatedAt time.Time        `json:"created_at"`
+}
+
+// order tracks a certificate order through its lifecycle.
+type order struct {
+	ID             string       `json:"id"`
+	AccountID      string       `json:"account_id"`
+	Status         string       `json:"status"`
+	Expires        time.Time    `json:"expires"`
+	Identifiers    []Identifier `json:"identifiers"`
+	AuthzIDs       []string     `json:"authz_ids"`
+	CertificateID  string       `json:"certificate_id,omitempty"`
+	Error          *Problem     `json:"error,omitempty"`
+	CertificatePEM []byte       `json:"certificate_pem,omitempty"`
+}
+
+// authorization is the proof of control over a single identifier.
+type authorization struct {
+	ID         string       `json:"id"`
+	AccountID  string       `json:"account_id"`
+	Identifier Identifier   `json:"identifier"`
+	Status     string       `json:"status"`
+	Expires    time.Time    `json:"expires"`
+	Challenges []*challenge `json:"challenges"`
+}
+
+// challenge is one way of satisfying an authorization.
+type challenge struct {
+	ID        string    `json:"id"`
+	AuthzID   string    `json:"authz_id"`
+	Type      string    `json:"type"`
+	Token     string    `json:"token"`
+	Status    string    `json:"status"`
+	Validated time.Time `json:"validated"`
+	Error     *Problem  `json:"error,omitempty"`
+}
+
+// JSON representations sent to clients.
+
+type accountResource struct {
+	Status  string   `json:"status"`
+	Contact []string `json:"contact,omitempty"`
+}
+
+type orderResource struct {
+	Status         string       `json:"status"`
+	Expires        string       `json:"expires,omitempty"`
+	Identifiers    []Identifier `json:"identifiers"`
+	Error          *Problem     `json:"error,omitempty"`
+	Authorizations []string     `json:"authorizations"`
+	Finalize       string       `json:"finalize"`
+	Certificate    string       `json:"certificate,omitempty"`
+}
+
+type authorizationResource struct {
+	Identifier Identifier          `json:"identifier"`
+	Status     string              `json:"status"`
+	Expires    string              `json:"expires,omitempty"`
+	Challenges []challengeResource `json:"challenges"`
+}
+
+type challengeResource struct {
+	Type      string   `json:"type"`
+	URL       string   `json:"url"`
+	Status    string   `json:"status"`
+	Token     string   `json:"token"`
+	Validated string   `json:"validated,omitempty"`
+	Error     *Problem `json:"error,omitempty"`
+}
@@ -0,0 +1,331 @@
+// internal/apikeys/apikeys.go
+// Package apikeys creates, verifies and manages API keys stored in the
+// database. A key is shown once when it is created; only its SHA-256 hash
+// is stored. Keys look like "aiw_<id>_<secret>", so the ID in the key finds
+// its record without scanning the table.
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/auth"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/database"
+)
+
+// KeyPrefix starts every API key, which makes leaked keys easy to spot.
+const KeyPrefix = "aiw"
+
+// LastUsedResolution is how stale last_used_at may get before a use is
+// written to the database, so busy keys do not cost a write per request.
+const LastUsedResolution = time.Minute
+
+var (
+	// ErrNotFound is returned for an unknown key ID.
+	ErrNotFound = errors.New("API key not found")
+	// ErrInvalidKey is returned for a key that does not match any record.
+	ErrInvalidKey = errors.New("invalid API key")
+	// ErrInactive is returned for a revoked or deactivated key.
+	ErrInactive = errors.New("API key is not active")
+	// ErrExpired is returned for a key past its expiry.
+	ErrExpired = errors.New("API key has expired")
+)
+
+// Manager creates and verifies API keys.
+type Manager struct {
+	keys  database.APIKeyRepository
+	usage database.APIKeyUsage
+	now   func() time.Time
+}
+
+// NewManager returns a Manager for the keys stored in db.
+func NewManager(db database.Database) *Manager {
+	return &Manager{keys: db.APIKeys(), usage: db, now: time.Now}
+}
+
+// CreateOptions describe a new API key.
+type CreateOptions struct {
+	Name        string
+	Description string
+	CreatedBy   string
+	// Permissions are auth scopes such as "certs:issue".
+	Permissions []string
+	// ExpiresAt is when the key stops working; zero never expires.
+	ExpiresAt time.Time
+}
+
+// Create stores a new key and returns its record and the key itself, which
+// cannot be retrieved again.
+func (m *Manager) Create(ctx context.Context, opts CreateOptions) (*database.APIKey, string, error) {
+	id, secret, err := generate()
+	if err != nil {
+		return nil, "", err
+	}
+	key := formatKey(id, secret)
+	record, err := m.store(ctx, id, key, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	return record, key, nil
+}
+
+// Import stores an existing key, e.g. one saved by an older version in
+// plain text. Keys without the "aiw_" prefix are found by their hash.
+func (m *Manager) Import(ctx context.Context, key string, opts CreateOptions) (*database.APIKey, error) {
+	id, _, ok := parseKey(key)
+	if !ok {
+		// Let the repository assign an ID
+		id = ""
+	}
+	return m.store(ctx, id, key, opts)
+}
+
+func (m *Manager) store(ctx context.Context, id, key string, opts CreateOptions) (*database.APIKey, error) {
+	if strings.TrimSpace(opts.Name) == "" {
+		return nil, fmt.Errorf("an API key needs a name")
+	}
+	if err := auth.ValidateScopes(opts.Permissions); err != nil {
+		return nil, err
+	}
+	permissions := opts.Permissions
+	if permissions == nil {
+		permissions = []string{}
+	}
+
+	record := &database.APIKey{
+		ID:          id,
+		Name:        opts.Name,
+		KeyHash:     hashKey(key),
+		CreatedBy:   opts.CreatedBy,
+		CreatedAt:   m.now().UTC(),
+		ExpiresAt:   opts.ExpiresAt,
+		Active:      true,
+		Permissions: permissions,
+		Description: opts.Description,
+	}
+	if err := m.keys.Create(ctx, record); err != nil {
+		return nil, fmt.Errorf("failed to store API key: %w", err)
+	}
+	return record, nil
+}
+
+// Authenticate returns the record of an active, unexpired key and records
+// its use. Records are found by the ID embedded in the key, and by hash
+// for imported keys without one.
+func (m *Manager) Authenticate(ctx context.Context, key string) (*database.APIKey, error) {
+	record, err := m.lookup(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	now := m.now()
+	switch {
+	case !record.Active:
+		return nil, fmt.Errorf("%w: %s", ErrInactive, record.Name)
+	case Expired(record, now):
+		return nil, fmt.Errorf("%w: %s", ErrExpired, record.Name)
+	}
+
+	if now.Sub(record.LastUsedAt) >= LastUsedResolution {
+		// A failure to record the use must not lock the key out
+		if err := m.usage.TouchAPIKey(ctx, record.ID, now); err != nil {
+			fmt.Printf("Warning: failed to record use of API key %s: %v\n", record.ID, err)
+		} else {
+			record.LastUsedAt = now.UTC()
+		}
+	}
+	return record, nil
+}
+
+// VerifyKey implements auth.KeyStore. Stored keys act under their name
+// with their own permissions.
+func (m *Manager) VerifyKey(ctx context.Context, key string) (*auth.Identity, error) {
+	record, err := m.Authenticate(ctx, key)
+	switch {
+	case errors.Is(err, ErrInvalidKey):
+		return nil, nil
+	case errors.Is(err, ErrInactive), errors.Is(err, ErrExpired):
+		return nil, fmt.Errorf("%w: %v", auth.ErrInvalidCredentials, err)
+	case err != nil:
+		return nil, err
+	}
+	return &auth.Identity{
+		User:   record.Name,
+		Method: auth.MethodAPIKey,
+		KeyID:  record.ID,
+		Scopes: record.Permissions,
+	}, nil
+}
+
+func (m *Manager) lookup(ctx context.Context, key string) (*database.APIKey, error) {
+	hash := hashKey(key)
+
+	if id, _, ok := parseKey(key); ok {
+		record, err := m.keys.Get(ctx, id)
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, ErrInvalidKey
+		}
+		if err != nil {
+			return nil, err
+		}
+		if subtle.ConstantTimeCompare([]byte(record.KeyHash), []byte(hash)) != 1 {
+			return nil, ErrInvalidKey
+		}
+		return record, nil
+	}
+
+	page, err := m.keys.List(ctx, database.APIKeyFilter{KeyHash: hash}, database.ListOptions{PageSize: 1})
+	if err != nil {
+		return nil, err
+	}
+	if len(page.Items) == 0 {
+		return nil, ErrInvalidKey
+	}
+	return page.Items[0], nil
+}
+
+// Get returns the record with the given ID.
+func (m *Manager) Get(ctx context.Context, id string) (*database.APIKey, error) {
+	record, err := m.keys.Get(ctx, id)
+	if errors.Is(err, database.ErrNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	return record, err
+}
+
+// ListOptions narrow List.
+type ListOptions struct {
+	IncludeInactive bool
+	IncludeExpired  bool
+	CreatedBy       string
+}
+
+// List returns the stored keys, by default only active, unexpired ones.
+func (m *Manager) List(ctx context.Context, opts ListOptions) ([]*database.APIKey, error) {
+	filter := database.APIKeyFilter{CreatedBy: opts.CreatedBy}
+	if !opts.IncludeInactive {
+		active := true
+		filter.Active = &active
+	}
+
+	now := m.now()
+	var records []*database.APIKey
+	var pageToken string
+	for {
+		page, err := m.keys.List(ctx, filter, database.ListOptions{PageSize: database.MaxPageSize, PageToken: pageToken})
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range page.Items {
+			if opts.IncludeExpired || !Expired(record, now) {
+				records = append(records, record)
+			}
+		}
+		if page.NextPageToken == "" {
+			return records, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// Update describes changes to a key. Nil fields are left unchanged.
+type Update struct {
+	Name        *string
+	Description *string
+	// ExpiresAt set to the zero time removes the expiry.
+	ExpiresAt   *time.Time
+	Active      *bool
+	Permissions *[]string
+}
+
+// Update applies u to the key with the given ID and returns the result.
+func (m *Manager) Update(ctx context.Context, id string, u Update) (*database.APIKey, error) {
+	record, err := m.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Name != nil {
+		if strings.TrimSpace(*u.Name) == "" {
+			return nil, fmt.Errorf("an API key needs a name")
+		}
+		record.Name = *u.Name
+	}
+	if u.Description != nil {
+		record.Description = *u.Description
+	}
+	if u.ExpiresAt != nil {
+		record.ExpiresAt = *u.ExpiresAt
+	}
+	if u.Active != nil {
+		record.Active = *u.Active
+	}
+	if u.Permissions != nil {
+		if err := auth.ValidateScopes(*u.Permissions); err != nil {
+			return nil, err
+		}
+		record.Permissions = append([]string{}, *u.Permissions...)
+	}
+
+	if err := m.keys.Update(ctx, record); err != nil {
+		return nil, fmt.Errorf("failed to update API key: %w", err)
+	}
+	return record, nil
+}
+
+// Revoke deactivates the key with the given ID. The record is kept so its
+// history stays visible.
+func (m *Manager) Revoke(ctx context.Context, id string) error {
+	inactive := false
+	_, err := m.Update(ctx, id, Update{Active: &inactive})
+	return err
+}
+
+// Expired reports whether record is past its expiry at now.
+func Expired(record *database.APIKey, now time.Time) bool {
+	return !record.ExpiresAt.IsZero() && !now.Before(record.ExpiresAt)
+}
+
+// generate returns a new key ID and secret.
+func generate() (id, secret string, err error) {
+	idBytes := make([]byte, 8)
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	return hex.EncodeToString(idBytes), base64.RawURLEncoding.EncodeToString(secretBytes), nil
+}
+
+func formatKey(id, secret string) string {
+	return KeyPrefix + "_" + id + "_" + secret
+}
+
+// parseKey splits a key into its ID and secret.
+func parseKey(key string) (id, secret string, ok bool) {
+	parts := strings.SplitN(key, "_", 3)
+	if len(parts) != 3 || parts[0] != KeyPrefix || len(parts[1]) != 16 || parts[2] == "" {
+		return "", "", false
+	}
+	if _, err := hex.DecodeString(parts[1]); err != nil {
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
+
+// hashKey returns the stored form of a key. Keys carry 256 random bits, so
+// a fast hash cannot be brute-forced.
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,162 @@
+// internal/apikeys/apikeys_test.go
+package apikeys_test
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/apikeys"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/auth"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/configuration"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDB(t *testing.T) *database.Service {
+	t.Helper()
+	db := database.NewServiceWithConfig(configuration.DatabaseConfig{
+		Type:   "sqlite",
+		SQLite: configuration.SQLiteConfig{Path: filepath.Join(t.TempDir(), "test.sqlite")},
+	})
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, db.Connect(context.Background()))
+	require.NoError(t, db.MigrateSchema(context.Background()))
+	return db
+}
+
+func TestCreateAndAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	keys := apikeys.NewManager(db)
+
+	record, key, err := keys.Create(ctx, apikeys.CreateOptions{
+		Name:        "cert-agent",
+		CreatedBy:   "admin",
+		Permissions: []string{"certs:issue"},
+	})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(key, "aiw_"+record.ID+"_"), "The key embeds its ID")
+
+	// Only the hash is stored
+	stored, err := db.APIKeys().Get(ctx, record.ID)
+	require.NoError(t, err)
+	assert.NotContains(t, stored.KeyHash, key)
+	assert.NotEqual(t, key, stored.KeyHash)
+
+	got, err := keys.Authenticate(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, "cert-agent", got.Name)
+	assert.Equal(t, []string{"certs:issue"}, got.Permissions)
+
+	stored, err = db.APIKeys().Get(ctx, record.ID)
+	require.NoError(t, err)
+	assert.False(t, stored.LastUsedAt.IsZero(), "The use is recorded")
+
+	_, err = keys.Authenticate(ctx, key+"x")
+	assert.ErrorIs(t, err, apikeys.ErrInvalidKey)
+	_, err = keys.Authenticate(ctx, "aiw_0000000000000000_secret")
+	assert.ErrorIs(t, err, apikeys.ErrInvalidKey)
+	_, err = keys.Authenticate(ctx, "not-a-key")
+	assert.ErrorIs(t, err, apikeys.ErrInvalidKey)
+
+	_, _, err = keys.Create(ctx, apikeys.CreateOptions{Name: "bad", Permissions: []string{"certs:everything"}})
+	assert.Error(t, err)
+	_, _, err = keys.Create(ctx, apikeys.CreateOptions{})
+	assert.Error(t, err, "A key needs a name")
+}
+
+func TestExpiryAndRevocation(t *testing.T) {
+	ctx := context.Background()
+	keys := apikeys.NewManager(newTestDB(t))
+
+	expiring, expiringKey, err := keys.Create(ctx, apikeys.CreateOptions{Name: "expired", ExpiresAt: time.Now().Add(-time.Minute)})
+	require.NoError(t, err)
+	_, err = keys.Authenticate(ctx, expiringKey)
+	assert.ErrorIs(t, err, apikeys.ErrExpired)
+
+	// Extending the expiry makes the key usable again
+	later := time.Now().Add(time.Hour)
+	_, err = keys.Update(ctx, expiring.ID, apikeys.Update{ExpiresAt: &later})
+	require.NoError(t, err)
+	_, err = keys.Authenticate(ctx, expiringKey)
+	assert.NoError(t, err)
+
+	revoked, revokedKey, err := keys.Create(ctx, apikeys.CreateOptions{Name: "revoked"})
+	require.NoError(t, err)
+	require.NoError(t, keys.Revoke(ctx, revoked.ID))
+	_, err = keys.Authenticate(ctx, revokedKey)
+	assert.ErrorIs(t, err, apikeys.ErrInactive)
+
+	assert.ErrorIs(t, keys.Revoke(ctx, "missing"), apikeys.ErrNotFound)
+
+	// As an auth.KeyStore, unknown keys are left to other stores
+	_, err = keys.VerifyKey(ctx, revokedKey)
+	assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+	id, err := keys.VerifyKey(ctx, "not-a-key")
+	assert.NoError(t, err)
+	assert.Nil(t, id)
+	id, err = keys.VerifyKey(ctx, expiringKey)
+	require.NoError(t, err)
+	assert.Equal(t, expiring.ID, id.KeyID)
+	assert.Equal(t, "expired", id.User)
+}
+
+func TestListAndUpdate(t *testing.T) {
+	ctx := context.Background()
+	keys := apikeys.NewManager(newTestDB(t))
+
+	active, _, err := keys.Create(ctx, apikeys.CreateOptions{Name: "active", CreatedBy: "alice"})
+	require.NoError(t, err)
+	revoked, _, err := keys.Create(ctx, apikeys.CreateOptions{Name: "revoked", CreatedBy: "bob"})
+	require.NoError(t, err)
+	require.NoError(t, keys.Revoke(ctx, revoked.ID))
+	_, _, err = keys.Create(ctx, apikeys.CreateOptions{Name: "expired", ExpiresAt: time.Now().Add(-time.Hour)})
+	require.NoError(t, err)
+
+	list, err := keys.List(ctx, apikeys.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, active.ID, list[0].ID)
+
+	list, err = keys.List(ctx, apikeys.ListOptions{IncludeInactive: true, IncludeExpired: true})
+	require.NoError(t, err)
+	assert.Len(t, list, 3)
+
+	list, err = keys.List(ctx, apikeys.ListOptions{IncludeInactive: true, CreatedBy: "bob"})
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, revoked.ID, list[0].ID)
+
+	name := "renamed"
+	permissions := []string{"inventory:read"}
+	updated, err := keys.Update(ctx, active.ID, apikeys.Update{Name: &name, Permissions: &permissions})
+	require.NoError(t, err)
+	assert.Equal(t, "renamed", updated.Name)
+	assert.Equal(t, []string{"inventory:read"}, updated.Permissions)
+
+	got, err := keys.Get(ctx, active.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "renamed", got.Name)
+	assert.Equal(t, "alice", got.CreatedBy, "Fields not in the update are kept")
+
+	bad := []string{"nope"}
+	_, err = keys.Update(ctx, active.ID, apikeys.Update{Permissions: &bad})
+	assert.Error(t, err)
+}
+
+func TestImport(t *testing.T) {
+	ctx := context.Background()
+	keys := apikeys.NewManager(newTestDB(t))
+
+	_, err := keys.Import(ctx, "legacy-plain-text-key", apikeys.CreateOptions{Name: "legacy", Permissions: []string{"*"}})
+	require.NoError(t, err)
+
+	got, err := keys.Authenticate(ctx, "legacy-plain-text-key")
+	require.NoError(t, err)
+	assert.Equal(t, "legacy", got.Name)
+	assert.Equal(t, []string{"*"}, got.Permissions)
+}
@@ -0,0 +1,66 @@
+// internal/atomicfile/atomicfile.go
+// Package atomicfile replaces files so that readers see either the old
+// contents or the new ones, never a partial write, even across a crash.
+package atomicfile
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/afero"
+)
+
+// WriteFile replaces path with data. The data is written to a temporary
+// file in the same directory, synced and renamed into place, and the
+// directory is synced so that the rename survives a crash.
+func WriteFile(fs afero.Fs, path string, data []byte, perm os.FileMode) error {
+	tmpPath, err := WriteTemp(fs, path, data, perm)
+	if err != nil {
+		return err
+	}
+	if err := fs.Rename(tmpPath, path); err != nil {
+		_ = fs.Remove(tmpPath)
+		return err
+	}
+	return SyncDir(fs, filepath.Dir(path))
+}
+
+// WriteTemp writes data to a synced temporary file next to path and returns
+// its name, for the caller to rename over path. Temporary files are hidden
+// so that listings and globs of the directory skip them.
+func WriteTemp(fs afero.Fs, path string, data []byte, perm os.FileMode) (string, error) {
+	f, err := afero.TempFile(fs, filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return "", err
+	}
+	tmpPath := f.Name()
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = fs.Chmod(tmpPath, perm)
+	}
+	if err != nil {
+		_ = fs.Remove(tmpPath)
+		return "", err
+	}
+	return tmpPath, nil
+}
+
+// SyncDir flushes a directory's entries to disk.
+func SyncDir(fs afero.Fs, dir string) error {
+	d, err := fs.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,41 @@
+// internal/atomicfile/atomicfile_test.go
+package atomicfile_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/atomicfile"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/atomicfile/atomicfiletest"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFile(t *testing.T) {
+	fs := afero.NewBasePathFs(afero.NewOsFs(), t.TempDir())
+	require.NoError(t, fs.MkdirAll("/boot", 0755))
+	path := "/boot/mac-00-11-22-33-44-55.ipxe"
+
+	require.NoError(t, atomicfile.WriteFile(fs, path, []byte("#!ipxe\necho one\n"), 0644))
+	require.NoError(t, atomicfile.WriteFile(fs, path, []byte("#!ipxe\necho two\n"), 0640))
+
+	content, err := afero.ReadFile(fs, path)
+	require.NoError(t, err)
+	assert.Equal(t, "#!ipxe\necho two\n", string(content))
+	info, err := fs.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+	atomicfiletest.AssertNoTempFiles(t, fs, "/boot")
+
+	// A failed rename leaves the old file and no temporary file behind
+	failing := &atomicfiletest.FailingRenameFs{Fs: fs, FailPath: path}
+	assert.Error(t, atomicfile.WriteFile(failing, path, []byte("#!ipxe\necho three\n"), 0644))
+	content, err = afero.ReadFile(fs, path)
+	require.NoError(t, err)
+	assert.Equal(t, "#!ipxe\necho two\n", string(content))
+	atomicfiletest.AssertNoTempFiles(t, fs, "/boot")
+
+	// A missing directory is reported rather than created
+	assert.Error(t, atomicfile.WriteFile(fs, "/missing/file", []byte("x"), 0644))
+}
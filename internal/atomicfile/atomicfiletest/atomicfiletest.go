@@ -0,0 +1,37 @@
+// internal/atomicfile/atomicfiletest/atomicfiletest.go
+// Package atomicfiletest provides test helpers for code writing files
+// through package atomicfile.
+package atomicfiletest
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// FailingRenameFs fails renames onto FailPath.
+type FailingRenameFs struct {
+	afero.Fs
+	FailPath string
+}
+
+// Rename implements afero.Fs.
+func (f *FailingRenameFs) Rename(oldname, newname string) error {
+	if newname == f.FailPath {
+		return errors.New("disk on fire")
+	}
+	return f.Fs.Rename(oldname, newname)
+}
+
+// AssertNoTempFiles checks that no temporary or staged files were left in
+// dir.
+func AssertNoTempFiles(t *testing.T, fs afero.Fs, dir string) {
+	t.Helper()
+	matches, err := afero.Glob(fs, filepath.Join(dir, ".*.tmp-*"))
+	require.NoError(t, err)
+	assert.Empty(t, matches)
+}
@@ -0,0 +1,41 @@
+// internal/auth/identity.go
+package auth
+
+import "context"
+
+// Method is how a caller proved its identity.
+type Method string
+
+// Authentication methods.
+const (
+	MethodAPIKey      Method = "api_key"
+	MethodJWT         Method = "jwt"
+	MethodCertificate Method = "certificate"
+)
+
+// Identity is an authenticated caller.
+type Identity struct {
+	// User is the name the caller acts as: the user of a configured API
+	// key, client certificate or token, or the name of a stored API key.
+	User string
+	// Method is how the caller authenticated.
+	Method Method
+	// KeyID identifies the credential: the stored API key ID, the token
+	// ID or the certificate serial number. It may be empty.
+	KeyID string
+	// Scopes are the caller's permissions.
+	Scopes []string
+}
+
+type identityKey struct{}
+
+// ContextWithIdentity returns a copy of ctx carrying id.
+func ContextWithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext returns the caller authenticated by the Interceptor.
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(*Identity)
+	return id, ok && id != nil
+}
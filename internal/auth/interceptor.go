@@ -0,0 +1,286 @@
+// internal/auth/interceptor.go
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/configuration"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/mtls"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ErrInvalidCredentials is wrapped by errors for credentials that are
+// recognized but rejected, such as a revoked API key or an expired token.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// KeyStore verifies API keys.
+type KeyStore interface {
+	// VerifyKey returns the identity of key, or nil if the store does not
+	// know it. Errors wrapping ErrInvalidCredentials reject the key; other
+	// errors are failures of the store.
+	VerifyKey(ctx context.Context, key string) (*Identity, error)
+}
+
+// staticKeys is a KeyStore of keys from the configuration.
+type staticKeys struct {
+	keys   []configuration.APIKeyConfig
+	scopes map[string][]string
+}
+
+// StaticKeys returns a KeyStore of fixed keys, each acting as its user
+// with the permissions scopes grant that user.
+func StaticKeys(keys []configuration.APIKeyConfig, scopes map[string][]string) KeyStore {
+	return &staticKeys{keys: keys, scopes: scopes}
+}
+
+// VerifyKey implements KeyStore.
+func (s *staticKeys) VerifyKey(ctx context.Context, key string) (*Identity, error) {
+	for _, k := range s.keys {
+		if k.Key != "" && subtle.ConstantTimeCompare([]byte(k.Key), []byte(key)) == 1 {
+			return &Identity{User: k.User, Method: MethodAPIKey, Scopes: UserScopes(s.scopes, k.User)}, nil
+		}
+	}
+	return nil, nil
+}
+
+// DefaultPublicMethods are served without authentication.
+var DefaultPublicMethods = []string{"/grpc.health.v1.Health/"}
+
+// Interceptor authenticates gRPC calls and checks the caller's permissions
+// for each method. A call is authenticated by the bearer token in its
+// "authorization" metadata, a JWT or an API key, or else by a verified
+// client certificate whose principal is mapped to a user. The caller's
+// Identity is added to the handler's context. HTTPHandler applies the same
+// token checks to HTTP endpoints.
+type Interceptor struct {
+	keyStores  []KeyStore
+	jwt        *JWTVerifier
+	principals map[string]string
+	scopes     map[string][]string
+	public     []string
+	contexts   []func(context.Context, *Identity) context.Context
+}
+
+// Option customizes an Interceptor.
+type Option func(*Interceptor)
+
+// WithKeyStore accepts the API keys of store. Stores are asked in the
+// order they are added.
+func WithKeyStore(store KeyStore) Option {
+	return func(i *Interceptor) {
+		i.keyStores = append(i.keyStores, store)
+	}
+}
+
+// WithPublicMethods replaces DefaultPublicMethods. Methods starting with
+// one of prefixes are served without authentication.
+func WithPublicMethods(prefixes ...string) Option {
+	return func(i *Interceptor) {
+		i.public = prefixes
+	}
+}
+
+// WithContext lets f add to the context of authenticated calls, e.g. to
+// pass the user to lower layers.
+func WithContext(f func(ctx context.Context, id *Identity) context.Context) Option {
+	return func(i *Interceptor) {
+		i.contexts = append(i.contexts, f)
+	}
+}
+
+// NewInterceptor returns an Interceptor for the principals, scopes and
+// JWT settings of grpcConfig.
+func NewInterceptor(grpcConfig configuration.GRPCAuthConfig, opts ...Option) (*Interceptor, error) {
+	verifier, err := NewJWTVerifier(grpcConfig.JWT, grpcConfig.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	i := &Interceptor{
+		jwt:        verifier,
+		principals: grpcConfig.Principals,
+		scopes:     grpcConfig.Scopes,
+		public:     DefaultPublicMethods,
+	}
+	for _, opt := range opts {
+		opt(i)
+	}
+	return i, nil
+}
+
+// ServerOptions returns the options installing the interceptor on a
+// grpc.Server.
+func (i *Interceptor) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(i.Unary()),
+		grpc.ChainStreamInterceptor(i.Stream()),
+	}
+}
+
+// Unary returns a unary server interceptor.
+func (i *Interceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := i.authorize(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Stream returns a stream server interceptor.
+func (i *Interceptor) Stream() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := i.authorize(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// authorize authenticates a call to fullMethod, checks its permissions and
+// returns the context for its handler.
+func (i *Interceptor) authorize(ctx context.Context, fullMethod string) (context.Context, error) {
+	if i.isPublic(fullMethod) {
+		return ctx, nil
+	}
+
+	id, err := i.Authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := Authorize(id.Scopes, fullMethod); err != nil {
+		return nil, err
+	}
+
+	ctx = ContextWithIdentity(ctx, id)
+	for _, f := range i.contexts {
+		ctx = f(ctx, id)
+	}
+	return ctx, nil
+}
+
+// Authenticate returns the caller of the call in ctx, or an Unauthenticated
+// status error.
+func (i *Interceptor) Authenticate(ctx context.Context) (*Identity, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	authHeader := md.Get("authorization")
+	if len(authHeader) == 0 {
+		// A mapped client certificate stands in for a token
+		if principal, ok := mtls.PrincipalFromContext(ctx); ok {
+			if user, ok := principal.User(i.principals); ok {
+				return &Identity{
+					User:   user,
+					Method: MethodCertificate,
+					KeyID:  principal.SerialNumber,
+					Scopes: UserScopes(i.scopes, user),
+				}, nil
+			}
+		}
+		return nil, status.Error(codes.Unauthenticated, "missing authorization header")
+	}
+
+	return i.verifyToken(ctx, authHeader[0])
+}
+
+// verifyToken authenticates the "Bearer <token>" authHeader, a JWT or an
+// API key, returning a status error if it is rejected.
+func (i *Interceptor) verifyToken(ctx context.Context, authHeader string) (*Identity, error) {
+	scheme, token, ok := strings.Cut(authHeader, " ")
+	if !ok || !strings.EqualFold(scheme, "bearer") || token == "" {
+		return nil, status.Error(codes.Unauthenticated, "invalid authorization format")
+	}
+
+	var rejected error
+	if i.jwt != nil && looksLikeJWT(token) {
+		id, err := i.jwt.Verify(token)
+		if err == nil {
+			return id, nil
+		}
+		// Fall back to the key stores in case an API key contains dots
+		rejected = err
+	}
+
+	for _, store := range i.keyStores {
+		id, err := store.VerifyKey(ctx, token)
+		switch {
+		case errors.Is(err, ErrInvalidCredentials):
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		case err != nil:
+			log.Printf("Failed to verify API key: %v", err)
+			return nil, status.Error(codes.Internal, "failed to verify API key")
+		case id != nil:
+			return id, nil
+		}
+	}
+
+	if rejected != nil {
+		return nil, status.Error(codes.Unauthenticated, rejected.Error())
+	}
+	return nil, status.Error(codes.Unauthenticated, "invalid API key")
+}
+
+// HTTPHandler serves next only to HTTP requests whose "Authorization"
+// header carries a JWT or API key granted scope. The caller's Identity and
+// the contexts added by WithContext are passed on in the request context.
+func (i *Interceptor) HTTPHandler(scope Scope, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeader := r.Header.Get("Authorization")
+		if authHeader == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "missing authorization header", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := r.Context()
+		id, err := i.verifyToken(ctx, authHeader)
+		if err != nil {
+			switch status.Code(err) {
+			case codes.Unauthenticated:
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, status.Convert(err).Message(), http.StatusUnauthorized)
+			default:
+				http.Error(w, status.Convert(err).Message(), http.StatusInternalServerError)
+			}
+			return
+		}
+		if !Grants(id.Scopes, scope) {
+			http.Error(w, fmt.Sprintf("permission %q is required", scope), http.StatusForbidden)
+			return
+		}
+
+		ctx = ContextWithIdentity(ctx, id)
+		for _, f := range i.contexts {
+			ctx = f(ctx, id)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (i *Interceptor) isPublic(fullMethod string) bool {
+	for _, prefix := range i.public {
+		if strings.HasPrefix(fullMethod, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// serverStream replaces the context of a grpc.ServerStream.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,307 @@
+// internal/auth/interceptor_test.go
+package auth_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/auth"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/configuration"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	issueMethod  = "/proto.CertAdmin/IssueCertificate"
+	revokeMethod = "/proto.CertAdmin/RevokeCertificate"
+)
+
+// fakeKeyStore knows a single key.
+type fakeKeyStore struct {
+	key string
+	id  *auth.Identity
+	err error
+}
+
+func (s *fakeKeyStore) VerifyKey(ctx context.Context, key string) (*auth.Identity, error) {
+	if key != s.key {
+		return nil, nil
+	}
+	return s.id, s.err
+}
+
+func withToken(token string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+}
+
+// callUnary calls fullMethod through the interceptor and returns the
+// identity its handler saw.
+func callUnary(t *testing.T, i *auth.Interceptor, ctx context.Context, fullMethod string) (*auth.Identity, error) {
+	t.Helper()
+	var seen *auth.Identity
+	_, err := i.Unary()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: fullMethod}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		seen, _ = auth.IdentityFromContext(ctx)
+		return nil, nil
+	})
+	return seen, err
+}
+
+func TestInterceptorAPIKeys(t *testing.T) {
+	grpcConfig := configuration.GRPCAuthConfig{
+		Scopes: map[string][]string{"agent": {"certs:issue"}},
+	}
+	stored := &fakeKeyStore{key: "stored-key", id: &auth.Identity{User: "ci", Method: auth.MethodAPIKey, KeyID: "42", Scopes: []string{"certs:*"}}}
+	revoked := &fakeKeyStore{key: "revoked-key", err: fmt.Errorf("%w: revoked", auth.ErrInvalidCredentials)}
+
+	var requester string
+	i, err := auth.NewInterceptor(grpcConfig,
+		auth.WithKeyStore(auth.StaticKeys([]configuration.APIKeyConfig{{User: "agent", Key: "static-key"}}, grpcConfig.Scopes)),
+		auth.WithKeyStore(stored),
+		auth.WithKeyStore(revoked),
+		auth.WithContext(func(ctx context.Context, id *auth.Identity) context.Context {
+			requester = id.User
+			return ctx
+		}),
+	)
+	require.NoError(t, err)
+
+	id, err := callUnary(t, i, withToken("static-key"), issueMethod)
+	require.NoError(t, err)
+	assert.Equal(t, "agent", id.User)
+	assert.Equal(t, auth.MethodAPIKey, id.Method)
+	assert.Equal(t, "agent", requester)
+
+	_, err = callUnary(t, i, withToken("static-key"), revokeMethod)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "Configured scopes apply to static keys")
+
+	id, err = callUnary(t, i, withToken("stored-key"), revokeMethod)
+	require.NoError(t, err)
+	assert.Equal(t, "42", id.KeyID)
+
+	_, err = callUnary(t, i, withToken("revoked-key"), issueMethod)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.Contains(t, err.Error(), "revoked")
+
+	_, err = callUnary(t, i, withToken("unknown"), issueMethod)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = callUnary(t, i, context.Background(), issueMethod)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Basic static-key"))
+	_, err = callUnary(t, i, ctx, issueMethod)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// Health checks need no credentials
+	id, err = callUnary(t, i, context.Background(), "/grpc.health.v1.Health/Check")
+	assert.NoError(t, err)
+	assert.Nil(t, id)
+}
+
+// fakeStream is a grpc.ServerStream carrying a context.
+type fakeStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeStream) Context() context.Context { return s.ctx }
+
+func TestInterceptorStream(t *testing.T) {
+	i, err := auth.NewInterceptor(configuration.GRPCAuthConfig{
+		Scopes: map[string][]string{"admin": {"*"}},
+	}, auth.WithKeyStore(auth.StaticKeys([]configuration.APIKeyConfig{{User: "admin", Key: "admin-key"}}, map[string][]string{"admin": {"*"}})))
+	require.NoError(t, err)
+
+	info := &grpc.StreamServerInfo{FullMethod: "/grpc.reflection.v1.ServerReflection/ServerReflectionInfo"}
+	var seen *auth.Identity
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		seen, _ = auth.IdentityFromContext(ss.Context())
+		return nil
+	}
+
+	require.NoError(t, i.Stream()(nil, &fakeStream{ctx: withToken("admin-key")}, info, handler))
+	require.NotNil(t, seen)
+	assert.Equal(t, "admin", seen.User)
+
+	err = i.Stream()(nil, &fakeStream{ctx: context.Background()}, info, handler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestInterceptorHTTPHandler(t *testing.T) {
+	grpcConfig := configuration.GRPCAuthConfig{
+		Scopes: map[string][]string{"agent": {"certs:issue"}, "reader": {"certs:read"}},
+	}
+	i, err := auth.NewInterceptor(grpcConfig,
+		auth.WithKeyStore(auth.StaticKeys([]configuration.APIKeyConfig{
+			{User: "agent", Key: "agent-key"},
+			{User: "reader", Key: "reader-key"},
+		}, grpcConfig.Scopes)),
+		auth.WithKeyStore(&fakeKeyStore{key: "broken-key", err: fmt.Errorf("database is down")}),
+	)
+	require.NoError(t, err)
+
+	handler := i.HTTPHandler(auth.ScopeCertsIssue, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := auth.IdentityFromContext(r.Context())
+		require.True(t, ok)
+		fmt.Fprint(w, id.User)
+	}))
+	serve := func(authHeader string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/issue", nil)
+		if authHeader != "" {
+			req.Header.Set("Authorization", authHeader)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve("Bearer agent-key")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "agent", rec.Body.String())
+
+	assert.Equal(t, http.StatusUnauthorized, serve("").Code)
+	assert.Equal(t, http.StatusUnauthorized, serve("Bearer unknown").Code)
+	assert.Equal(t, http.StatusUnauthorized, serve("Basic agent-key").Code)
+	assert.Equal(t, http.StatusForbidden, serve("Bearer reader-key").Code)
+	assert.Equal(t, http.StatusInternalServerError, serve("Bearer broken-key").Code)
+}
+
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, data, 0600))
+	return path
+}
+
+func signToken(t *testing.T, alg jose.SignatureAlgorithm, key interface{}, claims ...interface{}) string {
+	t.Helper()
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: key}, nil)
+	require.NoError(t, err)
+	builder := jwt.Signed(signer)
+	for _, c := range claims {
+		builder = builder.Claims(c)
+	}
+	token, err := builder.Serialize()
+	require.NoError(t, err)
+	return token
+}
+
+func TestInterceptorJWT(t *testing.T) {
+	secret := []byte(strings.Repeat("s", 32))
+	i, err := auth.NewInterceptor(configuration.GRPCAuthConfig{
+		Scopes: map[string][]string{"bob": {"certs:read"}},
+		JWT: configuration.JWTConfig{
+			Issuer:     "https://idp.example.com",
+			Audience:   "autoinstall",
+			SecretFile: writeFile(t, "secret", secret),
+		},
+	})
+	require.NoError(t, err)
+
+	valid := jwt.Claims{
+		Issuer:   "https://idp.example.com",
+		Audience: jwt.Audience{"autoinstall"},
+		Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		ID:       "token-1",
+	}
+
+	alice := valid
+	alice.Subject = "alice"
+	id, err := callUnary(t, i, withToken(signToken(t, jose.HS256, secret, alice, map[string]interface{}{"scope": "certs:issue certs:read"})), issueMethod)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", id.User)
+	assert.Equal(t, auth.MethodJWT, id.Method)
+	assert.Equal(t, "token-1", id.KeyID)
+	assert.Equal(t, []string{"certs:issue", "certs:read"}, id.Scopes)
+
+	// Without a scopes claim the configured scopes apply
+	bob := valid
+	bob.Subject = "bob"
+	bobToken := signToken(t, jose.HS256, secret, bob)
+	_, err = callUnary(t, i, withToken(bobToken), "/proto.CertAdmin/ListCertificates")
+	assert.NoError(t, err)
+	_, err = callUnary(t, i, withToken(bobToken), issueMethod)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	rejected := map[string]jwt.Claims{}
+	expired := alice
+	expired.Expiry = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+	rejected["expired"] = expired
+	noExpiry := alice
+	noExpiry.Expiry = nil
+	rejected["no expiry"] = noExpiry
+	wrongIssuer := alice
+	wrongIssuer.Issuer = "https://evil.example.com"
+	rejected["wrong issuer"] = wrongIssuer
+	wrongAudience := alice
+	wrongAudience.Audience = jwt.Audience{"other"}
+	rejected["wrong audience"] = wrongAudience
+	for name, claims := range rejected {
+		_, err := callUnary(t, i, withToken(signToken(t, jose.HS256, secret, claims)), "/proto.CertAdmin/GetCACertificate")
+		assert.Equal(t, codes.Unauthenticated, status.Code(err), name)
+	}
+
+	otherSecret := []byte(strings.Repeat("x", 32))
+	_, err = callUnary(t, i, withToken(signToken(t, jose.HS256, otherSecret, alice)), "/proto.CertAdmin/GetCACertificate")
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "A token signed with another key is rejected")
+}
+
+func TestInterceptorJWTPublicKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+
+	i, err := auth.NewInterceptor(configuration.GRPCAuthConfig{
+		JWT: configuration.JWTConfig{
+			PublicKeyFile: writeFile(t, "jwt.pem", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+			Audience:      "autoinstall",
+			UserClaim:     "email",
+			ScopesClaim:   "permissions",
+		},
+	})
+	require.NoError(t, err)
+
+	token := signToken(t, jose.ES256, key,
+		jwt.Claims{Audience: jwt.Audience{"autoinstall"}, Expiry: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+		map[string]interface{}{"email": "carol@example.com", "permissions": []string{"inventory:read"}})
+	id, err := callUnary(t, i, withToken(token), "/proto.InventoryService/ListServers")
+	require.NoError(t, err)
+	assert.Equal(t, "carol@example.com", id.User)
+	assert.Equal(t, []string{"inventory:read"}, id.Scopes)
+
+	// An HMAC token cannot be accepted when only public keys are configured
+	hmacToken := signToken(t, jose.HS256, der[:32],
+		jwt.Claims{Expiry: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+		map[string]interface{}{"email": "mallory@example.com"})
+	_, err = callUnary(t, i, withToken(hmacToken), "/proto.CertAdmin/GetCACertificate")
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = auth.NewInterceptor(configuration.GRPCAuthConfig{
+		JWT: configuration.JWTConfig{SecretFile: writeFile(t, "short", []byte("short")), Audience: "autoinstall"},
+	})
+	assert.Error(t, err, "Short secrets are refused")
+
+	_, err = auth.NewInterceptor(configuration.GRPCAuthConfig{
+		JWT: configuration.JWTConfig{PublicKeyFile: writeFile(t, "jwt.pem", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))},
+	})
+	assert.Error(t, err, "Keys without an audience are refused")
+}
@@ -0,0 +1,187 @@
+// internal/auth/jwt.go
+package auth
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/configuration"
+)
+
+var hmacAlgorithms = []jose.SignatureAlgorithm{jose.HS256, jose.HS384, jose.HS512}
+
+var publicKeyAlgorithms = []jose.SignatureAlgorithm{
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.PS256, jose.PS384, jose.PS512,
+	jose.ES256, jose.ES384, jose.ES512,
+	jose.EdDSA,
+}
+
+// JWTVerifier authenticates bearer tokens signed by an identity provider.
+type JWTVerifier struct {
+	keys        []interface{}
+	algorithms  []jose.SignatureAlgorithm
+	issuer      string
+	audience    string
+	userClaim   string
+	scopesClaim string
+	scopes      map[string][]string
+	now         func() time.Time
+}
+
+// NewJWTVerifier returns a verifier for the tokens described by cfg, or nil
+// if cfg configures no signing keys. An audience is required with any key,
+// so tokens the identity provider issued for other services are refused.
+// scopes apply to users whose tokens carry no scopes claim.
+func NewJWTVerifier(cfg configuration.JWTConfig, scopes map[string][]string) (*JWTVerifier, error) {
+	if cfg.SecretFile == "" && cfg.PublicKeyFile == "" {
+		return nil, nil
+	}
+	if cfg.Audience == "" {
+		return nil, errors.New("a JWT audience is required when JWT keys are configured")
+	}
+
+	v := &JWTVerifier{
+		issuer:      cfg.Issuer,
+		audience:    cfg.Audience,
+		userClaim:   cfg.UserClaim,
+		scopesClaim: cfg.ScopesClaim,
+		scopes:      scopes,
+		now:         time.Now,
+	}
+	if v.userClaim == "" {
+		v.userClaim = "sub"
+	}
+	if v.scopesClaim == "" {
+		v.scopesClaim = "scope"
+	}
+
+	if cfg.SecretFile != "" {
+		secret, err := os.ReadFile(cfg.SecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWT secret: %w", err)
+		}
+		secret = bytes.TrimSpace(secret)
+		// HS256 needs a key at least as long as its hash
+		if len(secret) < 32 {
+			return nil, fmt.Errorf("JWT secret must be at least 32 bytes")
+		}
+		v.keys = append(v.keys, secret)
+		v.algorithms = append(v.algorithms, hmacAlgorithms...)
+	}
+	if cfg.PublicKeyFile != "" {
+		data, err := os.ReadFile(cfg.PublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWT public keys: %w", err)
+		}
+		keys, err := parsePublicKeys(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse JWT public keys: %w", err)
+		}
+		v.keys = append(v.keys, keys...)
+		v.algorithms = append(v.algorithms, publicKeyAlgorithms...)
+	}
+	return v, nil
+}
+
+// Verify checks the token's signature and claims and returns its identity.
+// Tokens must expire. Errors wrap ErrInvalidCredentials.
+func (v *JWTVerifier) Verify(token string) (*Identity, error) {
+	parsed, err := jwt.ParseSigned(token, v.algorithms)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidCredentials)
+	}
+
+	var claims jwt.Claims
+	var custom map[string]interface{}
+	verified := false
+	for _, key := range v.keys {
+		if err := parsed.Claims(key, &claims, &custom); err == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("%w: invalid token signature", ErrInvalidCredentials)
+	}
+
+	if claims.Expiry == nil {
+		return nil, fmt.Errorf("%w: token has no expiry", ErrInvalidCredentials)
+	}
+	expected := jwt.Expected{Issuer: v.issuer, AnyAudience: jwt.Audience{v.audience}, Time: v.now()}
+	if err := claims.ValidateWithLeeway(expected, jwt.DefaultLeeway); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+
+	user, _ := custom[v.userClaim].(string)
+	if user == "" {
+		return nil, fmt.Errorf("%w: token has no %q claim", ErrInvalidCredentials, v.userClaim)
+	}
+
+	scopes, ok := claimStrings(custom[v.scopesClaim])
+	if !ok {
+		scopes = UserScopes(v.scopes, user)
+	}
+	return &Identity{User: user, Method: MethodJWT, KeyID: claims.ID, Scopes: scopes}, nil
+}
+
+// claimStrings reads a space separated string or an array of strings.
+func claimStrings(claim interface{}) ([]string, bool) {
+	switch value := claim.(type) {
+	case string:
+		return strings.Fields(value), true
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values, true
+	}
+	return nil, false
+}
+
+// parsePublicKeys reads the public keys and certificates in a PEM bundle.
+func parsePublicKeys(data []byte) ([]interface{}, error) {
+	var keys []interface{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, cert.PublicKey)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no public keys found")
+	}
+	return keys, nil
+}
+
+// looksLikeJWT reports whether a bearer token is a compact JWS rather than
+// an API key.
+func looksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
@@ -0,0 +1,193 @@
+// internal/auth/permissions.go
+// Package auth holds the permission model of the gRPC APIs: the scopes an
+// API key or user can be granted and the scope each RPC requires.
+package auth
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Scope is a permission granted to an API key or user.
+type Scope string
+
+// Scopes understood by the gRPC APIs.
+const (
+	ScopeCertsRead          Scope = "certs:read"
+	ScopeCertsIssue         Scope = "certs:issue"
+	ScopeCertsRevoke        Scope = "certs:revoke"
+	ScopeInstallationsRead  Scope = "installations:read"
+	ScopeInstallationsWrite Scope = "installations:write"
+	ScopeInventoryRead      Scope = "inventory:read"
+	ScopeInventoryWrite     Scope = "inventory:write"
+	ScopeAPIKeysAdmin       Scope = "apikeys:admin"
+
+	// ScopeAll grants every scope. "<resource>:*" grants every scope of
+	// one resource, e.g. "certs:*".
+	ScopeAll Scope = "*"
+
+	// ScopeAuthenticated marks RPCs any authenticated caller may use.
+	ScopeAuthenticated Scope = ""
+)
+
+// AllScopes lists the scopes in the order they are documented.
+var AllScopes = []Scope{
+	ScopeCertsRead, ScopeCertsIssue, ScopeCertsRevoke,
+	ScopeInstallationsRead, ScopeInstallationsWrite,
+	ScopeInventoryRead, ScopeInventoryWrite,
+	ScopeAPIKeysAdmin,
+}
+
+// methodScopes maps gRPC services and their methods to the scope they
+// require. Methods of services not listed here are denied.
+var methodScopes = map[string]map[string]Scope{
+	"proto.CertAdmin": {
+		"GetCACertificate":   ScopeAuthenticated,
+		"ListCertificates":   ScopeCertsRead,
+		"GetCertificateInfo": ScopeCertsRead,
+		"VerifyCertificate":  ScopeCertsRead,
+		"IssueCertificate":   ScopeCertsIssue,
+		"RenewCertificate":   ScopeCertsIssue,
+		"RevokeCertificate":  ScopeCertsRevoke,
+	},
+	"proto.InstallationService": {
+		"GetInstallation":          ScopeInstallationsRead,
+		"ListInstallations":        ScopeInstallationsRead,
+		"GetInstallationLogs":      ScopeInstallationsRead,
+		"CreateInstallation":       ScopeInstallationsWrite,
+		"UpdateInstallationStatus": ScopeInstallationsWrite,
+		"CancelInstallation":       ScopeInstallationsWrite,
+		"ReportStatus":             ScopeInstallationsWrite,
+	},
+	// The status service served by the webserver
+	"proto.InstallService": {
+		"ReportStatus": ScopeInstallationsWrite,
+	},
+	"proto.InventoryService": {
+		"GetServer":      ScopeInventoryRead,
+		"ListServers":    ScopeInventoryRead,
+		"RegisterServer": ScopeInventoryWrite,
+		"UpdateServer":   ScopeInventoryWrite,
+		"DeleteServer":   ScopeInventoryWrite,
+		"ReportHardware": ScopeInventoryWrite,
+	},
+	"proto.APIKeyService": {
+		"CreateAPIKey": ScopeAPIKeysAdmin,
+		"GetAPIKey":    ScopeAPIKeysAdmin,
+		"ListAPIKeys":  ScopeAPIKeysAdmin,
+		"RevokeAPIKey": ScopeAPIKeysAdmin,
+		"UpdateAPIKey": ScopeAPIKeysAdmin,
+	},
+	// Reflection only describes the API, so tools like grpcurl work for
+	// any authenticated caller
+	"grpc.reflection.v1.ServerReflection": {
+		"ServerReflectionInfo": ScopeAuthenticated,
+	},
+	"grpc.reflection.v1alpha.ServerReflection": {
+		"ServerReflectionInfo": ScopeAuthenticated,
+	},
+}
+
+// RequiredScope returns the scope needed to call fullMethod, a gRPC method
+// name such as "/proto.CertAdmin/IssueCertificate". It reports false for
+// methods without a scope, which are denied.
+func RequiredScope(fullMethod string) (Scope, bool) {
+	service, method, ok := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	if !ok {
+		return "", false
+	}
+	scope, ok := methodScopes[service][method]
+	return scope, ok
+}
+
+// Grants reports whether the granted scopes include scope, directly or
+// through a wildcard.
+func Grants(granted []string, scope Scope) bool {
+	if scope == ScopeAuthenticated {
+		return true
+	}
+	resource, _, _ := strings.Cut(string(scope), ":")
+	for _, g := range granted {
+		switch g {
+		case string(scope), string(ScopeAll), resource + ":*":
+			return true
+		}
+	}
+	return false
+}
+
+// Delegate returns a PermissionDenied status error unless the granted
+// scopes include every one of requested, so that a caller cannot give a new
+// or updated API key permissions it does not have itself.
+func Delegate(granted, requested []string) error {
+	for _, scope := range requested {
+		if !Grants(granted, Scope(scope)) {
+			return status.Errorf(codes.PermissionDenied, "cannot grant permission %q you do not have", scope)
+		}
+	}
+	return nil
+}
+
+// Authorize returns a PermissionDenied status error unless the granted
+// scopes allow calling fullMethod.
+func Authorize(granted []string, fullMethod string) error {
+	scope, ok := RequiredScope(fullMethod)
+	if !ok {
+		return status.Errorf(codes.PermissionDenied, "%s is not available to API clients", fullMethod)
+	}
+	if !Grants(granted, scope) {
+		return status.Errorf(codes.PermissionDenied, "permission %q is required for %s", scope, fullMethod)
+	}
+	return nil
+}
+
+// ValidateScopes checks that every scope is known or a valid wildcard.
+func ValidateScopes(scopes []string) error {
+	for _, s := range scopes {
+		if s == string(ScopeAll) {
+			continue
+		}
+		if resource, found := strings.CutSuffix(s, ":*"); found && isResource(resource) {
+			continue
+		}
+		if !isScope(s) {
+			return fmt.Errorf("unknown permission %q", s)
+		}
+	}
+	return nil
+}
+
+// UserScopes returns the scopes configured for user in scopes, matching
+// the name case-insensitively because configuration keys are lowercased.
+func UserScopes(scopes map[string][]string, user string) []string {
+	if granted, ok := scopes[user]; ok {
+		return granted
+	}
+	for name, granted := range scopes {
+		if strings.EqualFold(name, user) {
+			return granted
+		}
+	}
+	return nil
+}
+
+func isScope(s string) bool {
+	for _, scope := range AllScopes {
+		if s == string(scope) {
+			return true
+		}
+	}
+	return false
+}
+
+func isResource(resource string) bool {
+	for _, scope := range AllScopes {
+		if strings.HasPrefix(string(scope), resource+":") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+// internal/auth/permissions_test.go
+package auth_test
+
+import (
+	"testing"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/auth"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRequiredScope(t *testing.T) {
+	scope, ok := auth.RequiredScope("/proto.CertAdmin/IssueCertificate")
+	assert.True(t, ok)
+	assert.Equal(t, auth.ScopeCertsIssue, scope)
+
+	scope, ok = auth.RequiredScope("/proto.CertAdmin/GetCACertificate")
+	assert.True(t, ok)
+	assert.Equal(t, auth.ScopeAuthenticated, scope)
+
+	_, ok = auth.RequiredScope("/proto.CertAdmin/Unknown")
+	assert.False(t, ok)
+	_, ok = auth.RequiredScope("malformed")
+	assert.False(t, ok)
+}
+
+func TestAuthorize(t *testing.T) {
+	tests := []struct {
+		name    string
+		granted []string
+		method  string
+		allowed bool
+	}{
+		{"exact scope", []string{"certs:issue"}, "/proto.CertAdmin/IssueCertificate", true},
+		{"same scope for renewals", []string{"certs:issue"}, "/proto.CertAdmin/RenewCertificate", true},
+		{"missing scope", []string{"certs:issue"}, "/proto.CertAdmin/RevokeCertificate", false},
+		{"resource wildcard", []string{"certs:*"}, "/proto.CertAdmin/RevokeCertificate", true},
+		{"other resource wildcard", []string{"inventory:*"}, "/proto.CertAdmin/RevokeCertificate", false},
+		{"all scopes", []string{"*"}, "/proto.InventoryService/DeleteServer", true},
+		{"no scopes", nil, "/proto.InventoryService/ListServers", false},
+		{"authenticated only", nil, "/proto.CertAdmin/GetCACertificate", true},
+		{"installation status", []string{"installations:write"}, "/proto.InstallService/ReportStatus", true},
+		{"read is not write", []string{"inventory:read"}, "/proto.InventoryService/RegisterServer", false},
+		{"unknown method", []string{"*"}, "/proto.UserService/DeleteUser", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := auth.Authorize(tt.granted, tt.method)
+			if tt.allowed {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		})
+	}
+}
+
+func TestDelegate(t *testing.T) {
+	assert.NoError(t, auth.Delegate([]string{"*"}, []string{"*", "certs:issue"}))
+	assert.NoError(t, auth.Delegate([]string{"certs:*", "apikeys:admin"}, []string{"certs:issue", "certs:*"}))
+	assert.NoError(t, auth.Delegate([]string{"apikeys:admin"}, nil))
+
+	for _, requested := range []string{"*", "inventory:*", "certs:revoke"} {
+		err := auth.Delegate([]string{"apikeys:admin", "certs:issue"}, []string{requested})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err), requested)
+	}
+}
+
+func TestValidateScopes(t *testing.T) {
+	assert.NoError(t, auth.ValidateScopes([]string{"certs:issue", "inventory:read", "certs:*", "*"}))
+	assert.Error(t, auth.ValidateScopes([]string{"certs:delete"}))
+	assert.Error(t, auth.ValidateScopes([]string{"things:*"}))
+}
+
+func TestUserScopes(t *testing.T) {
+	scopes := map[string][]string{"cert-agent": {"certs:issue"}}
+	assert.Equal(t, []string{"certs:issue"}, auth.UserScopes(scopes, "cert-agent"))
+	assert.Equal(t, []string{"certs:issue"}, auth.UserScopes(scopes, "Cert-Agent"))
+	assert.Nil(t, auth.UserScopes(scopes, "someone"))
+}
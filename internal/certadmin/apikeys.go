@@ -0,0 +1,273 @@
+// internal/certadmin/apikeys.go
+package certadmin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/apikeys"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/auth"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certissuer"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/database"
+	pb "github.com/jdfalk/ubuntu-autoinstall-webhook/pkg/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// APIKeyServer implements the gRPC APIKeyService over the stored API keys
+type APIKeyServer struct {
+	pb.UnimplementedAPIKeyServiceServer
+	keys *apikeys.Manager
+}
+
+// NewAPIKeyServer creates an API key service managing keys
+func NewAPIKeyServer(keys *apikeys.Manager) *APIKeyServer {
+	return &APIKeyServer{keys: keys}
+}
+
+// CreateAPIKey creates a new API key. The key itself is only returned here.
+func (s *APIKeyServer) CreateAPIKey(ctx context.Context, req *pb.CreateAPIKeyRequest) (*pb.CreateAPIKeyResponse, error) {
+	if req.GetName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "Name is required")
+	}
+	if req.GetExpiryDays() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "Expiry days must not be negative")
+	}
+
+	opts := apikeys.CreateOptions{
+		Name:        req.GetName(),
+		Description: req.GetDescription(),
+		CreatedBy:   certissuer.RequesterFromContext(ctx),
+		Permissions: req.GetPermissions(),
+	}
+	if days := req.GetExpiryDays(); days > 0 {
+		opts.ExpiresAt = time.Now().AddDate(0, 0, int(days)).UTC()
+	}
+	if err := auth.ValidateScopes(opts.Permissions); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := checkDelegation(ctx, opts.Permissions); err != nil {
+		return nil, err
+	}
+
+	record, key, err := s.keys.Create(ctx, opts)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to create API key: %v", err)
+	}
+	log.Printf("API key '%s' (%s) created by '%s'", record.Name, record.ID, record.CreatedBy)
+
+	apiKey := toAPIKey(record)
+	apiKey.Key = key
+	return &pb.CreateAPIKeyResponse{ApiKey: apiKey}, nil
+}
+
+// GetAPIKey returns an API key's details, without the key
+func (s *APIKeyServer) GetAPIKey(ctx context.Context, req *pb.GetAPIKeyRequest) (*pb.GetAPIKeyResponse, error) {
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "ID is required")
+	}
+	record, err := s.keys.Get(ctx, req.GetId())
+	if err != nil {
+		return nil, apiKeyError("get", req.GetId(), err)
+	}
+	return &pb.GetAPIKeyResponse{ApiKey: toAPIKey(record)}, nil
+}
+
+// ListAPIKeys lists API keys, by default only active, unexpired ones
+func (s *APIKeyServer) ListAPIKeys(ctx context.Context, req *pb.ListAPIKeysRequest) (*pb.ListAPIKeysResponse, error) {
+	records, err := s.keys.List(ctx, apikeys.ListOptions{
+		IncludeInactive: req.GetIncludeInactive(),
+		IncludeExpired:  req.GetIncludeExpired(),
+		CreatedBy:       req.GetCreatedBy(),
+	})
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to list API keys: %v", err)
+	}
+
+	keys := make([]*pb.APIKey, 0, len(records))
+	for _, record := range records {
+		keys = append(keys, toAPIKey(record))
+	}
+	return &pb.ListAPIKeysResponse{ApiKeys: keys}, nil
+}
+
+// RevokeAPIKey deactivates an API key. Revoked keys stay listed with
+// include_inactive and can be reactivated with UpdateAPIKey.
+func (s *APIKeyServer) RevokeAPIKey(ctx context.Context, req *pb.RevokeAPIKeyRequest) (*pb.RevokeAPIKeyResponse, error) {
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "ID is required")
+	}
+	if err := s.keys.Revoke(ctx, req.GetId()); err != nil {
+		return nil, apiKeyError("revoke", req.GetId(), err)
+	}
+	log.Printf("API key %s revoked by '%s': %s", req.GetId(), certissuer.RequesterFromContext(ctx), req.GetReason())
+	return &pb.RevokeAPIKeyResponse{Success: true}, nil
+}
+
+// UpdateAPIKey changes the fields of an API key that the request sets:
+// name, description, active flag, permissions (permission_set, or a
+// non-empty permissions list) and expiry (expires_at, or remove_expiry).
+// Callers can only update keys, and grant permissions, within their own.
+func (s *APIKeyServer) UpdateAPIKey(ctx context.Context, req *pb.UpdateAPIKeyRequest) (*pb.UpdateAPIKeyResponse, error) {
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "ID is required")
+	}
+	if req.ExpiresAt != nil && req.GetRemoveExpiry() {
+		return nil, status.Error(codes.InvalidArgument, "expires_at and remove_expiry are mutually exclusive")
+	}
+
+	var update apikeys.Update
+	if req.Name != nil {
+		if req.GetName() == "" {
+			return nil, status.Error(codes.InvalidArgument, "Name must not be empty")
+		}
+		update.Name = req.Name
+	}
+	if req.Description != nil {
+		update.Description = req.Description
+	}
+	if req.Active != nil {
+		update.Active = req.Active
+	}
+	switch {
+	case req.ExpiresAt != nil:
+		expiresAt := req.GetExpiresAt().AsTime()
+		update.ExpiresAt = &expiresAt
+	case req.GetRemoveExpiry():
+		update.ExpiresAt = &time.Time{}
+	}
+	switch {
+	case req.PermissionSet != nil:
+		permissions := req.GetPermissionSet().GetScopes()
+		update.Permissions = &permissions
+	case len(req.GetPermissions()) > 0:
+		permissions := req.GetPermissions()
+		update.Permissions = &permissions
+	}
+
+	if update.Permissions != nil {
+		if err := auth.ValidateScopes(*update.Permissions); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if err := checkDelegation(ctx, *update.Permissions); err != nil {
+			return nil, err
+		}
+	}
+	// A key with more permissions than the caller is out of its reach
+	current, err := s.keys.Get(ctx, req.GetId())
+	if err != nil {
+		return nil, apiKeyError("update", req.GetId(), err)
+	}
+	if err := checkDelegation(ctx, current.Permissions); err != nil {
+		return nil, err
+	}
+
+	record, err := s.keys.Update(ctx, req.GetId(), update)
+	if err != nil {
+		return nil, apiKeyError("update", req.GetId(), err)
+	}
+	log.Printf("API key '%s' (%s) updated by '%s'", record.Name, record.ID, certissuer.RequesterFromContext(ctx))
+	return &pb.UpdateAPIKeyResponse{ApiKey: toAPIKey(record)}, nil
+}
+
+// checkDelegation refuses permissions the caller in ctx does not have.
+func checkDelegation(ctx context.Context, permissions []string) error {
+	id, ok := auth.IdentityFromContext(ctx)
+	if !ok {
+		return status.Error(codes.PermissionDenied, "unauthenticated callers cannot manage API keys")
+	}
+	return auth.Delegate(id.Scopes, permissions)
+}
+
+// toAPIKey converts a stored API key to its API representation, which
+// never includes the key
+func toAPIKey(record *database.APIKey) *pb.APIKey {
+	key := &pb.APIKey{
+		Id:          record.ID,
+		Name:        record.Name,
+		CreatedBy:   record.CreatedBy,
+		CreatedAt:   timestamppb.New(record.CreatedAt),
+		Active:      record.Active,
+		Permissions: record.Permissions,
+		Description: record.Description,
+	}
+	if !record.LastUsedAt.IsZero() {
+		key.LastUsedAt = timestamppb.New(record.LastUsedAt)
+	}
+	if !record.ExpiresAt.IsZero() {
+		key.ExpiresAt = timestamppb.New(record.ExpiresAt)
+	}
+	return key
+}
+
+// apiKeyError maps API key store errors to gRPC status errors
+func apiKeyError(op, id string, err error) error {
+	if errors.Is(err, apikeys.ErrNotFound) {
+		return status.Errorf(codes.NotFound, "API key %s not found", id)
+	}
+	return status.Errorf(codes.Internal, "Failed to %s API key: %v", op, err)
+}
+
+// legacyAPIKey is an entry of the api_keys.json file older versions kept
+// the plain text keys in, keyed by the key itself
+type legacyAPIKey struct {
+	Name        string    `json:"name"`
+	CreatedAt   time.Time `json:"created_at"`
+	Description string    `json:"description,omitempty"`
+	Permissions []string  `json:"permissions"`
+}
+
+// ImportAPIKeys moves the keys of a legacy api_keys.json in configDir into
+// keys, storing only their hashes, and removes the file. Keys saved without
+// permissions keep full access. It does nothing if the file does not exist.
+func ImportAPIKeys(ctx context.Context, keys *apikeys.Manager, configDir string) error {
+	path := filepath.Join(configDir, "api_keys.json")
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read API keys file: %w", err)
+	}
+
+	var legacy map[string]legacyAPIKey
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return fmt.Errorf("failed to unmarshal API keys: %w", err)
+	}
+
+	for key, info := range legacy {
+		permissions := info.Permissions
+		if permissions == nil {
+			permissions = []string{string(auth.ScopeAll)}
+		}
+		name := info.Name
+		if name == "" {
+			name = "imported"
+		}
+		record, err := keys.Import(ctx, key, apikeys.CreateOptions{
+			Name:        name,
+			Description: info.Description,
+			Permissions: permissions,
+		})
+		if errors.Is(err, database.ErrAlreadyExists) {
+			// Imported before the file could be removed
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to import API key '%s': %w", info.Name, err)
+		}
+		log.Printf("Imported API key '%s' as %s", record.Name, record.ID)
+	}
+
+	// Don't leave the plain text keys behind
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove imported API keys file: %w", err)
+	}
+	return nil
+}
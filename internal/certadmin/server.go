@@ -3,29 +3,24 @@ package certadmin
 
 import (
 	"context"
-	"crypto/rand"
-	"crypto/subtle"
+	"crypto/tls"
 	"crypto/x509"
-	"encoding/base64"
-	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"log"
 	"net"
-	"os"
-	"path/filepath"
-	"strings"
-	"sync"
 	"time"
 
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/apikeys"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/auth"
 	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certissuer"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/configuration"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/mtls"
 	pb "github.com/jdfalk/ubuntu-autoinstall-webhook/pkg/proto"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/metadata"
-	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
 )
@@ -34,84 +29,116 @@ import (
 type Server struct {
 	pb.UnimplementedCertAdminServer
 	certIssuer      certissuer.CertIssuer
-	apiKeys         map[string]apiKeyInfo
-	apiKeysMutex    sync.RWMutex
-	configPath      string
-	serverOptions   []grpc.ServerOption
+	keys            *apikeys.Manager
+	grpcConfig      configuration.GRPCAuthConfig
 	serverStartTime time.Time
 }
 
-type apiKeyInfo struct {
-	Name        string    `json:"name"`
-	Key         string    `json:"key"`
-	CreatedAt   time.Time `json:"created_at"`
-	LastUsedAt  time.Time `json:"last_used_at,omitempty"`
-	Description string    `json:"description,omitempty"`
-}
-
-// NewServer creates a new certificate admin server
-func NewServer(certIssuer certissuer.CertIssuer, configPath string, tlsCert, tlsKey string) (*Server, error) {
-	server := &Server{
+// NewServer creates a new certificate admin server authenticating API keys
+// against keys, which may be nil to accept client certificates only. With a
+// server certificate in grpcConfig it serves TLS, verifying client
+// certificates against the issuer's own CA and CRL; see ServerTLSConfig.
+func NewServer(certIssuer certissuer.CertIssuer, keys *apikeys.Manager, grpcConfig configuration.GRPCAuthConfig) *Server {
+	return &Server{
 		certIssuer:      certIssuer,
-		apiKeys:         make(map[string]apiKeyInfo),
-		configPath:      configPath,
+		keys:            keys,
+		grpcConfig:      grpcConfig,
 		serverStartTime: time.Now(),
 	}
+}
 
-	// Load API keys from config if it exists
-	if err := server.loadAPIKeys(); err != nil {
-		log.Printf("Warning: Failed to load API keys: %v", err)
-
-		// Generate a default API key if none exist
-		if len(server.apiKeys) == 0 {
-			apiKey, err := generateAPIKey()
-			if err != nil {
-				return nil, fmt.Errorf("failed to generate default API key: %w", err)
+// Interceptor returns the authentication of the services of s, also used
+// for the HTTP issuance API. API keys are looked up in keyStores before the
+// server's own key store. Certificates are issued under the caller's
+// issuance policy, and only callers granted every permission may bind them
+// to client certificate principals.
+func (s *Server) Interceptor(keyStores ...auth.KeyStore) (*auth.Interceptor, error) {
+	opts := []auth.Option{
+		auth.WithContext(func(ctx context.Context, id *auth.Identity) context.Context {
+			ctx = certissuer.ContextWithRequester(ctx, id.User)
+			if auth.Grants(id.Scopes, auth.ScopeAll) {
+				ctx = certissuer.ContextAllowingPrincipals(ctx)
 			}
+			return ctx
+		}),
+	}
+	for _, store := range keyStores {
+		opts = append(opts, auth.WithKeyStore(store))
+	}
+	if s.keys != nil {
+		opts = append(opts, auth.WithKeyStore(s.keys))
+	}
+	interceptor, err := auth.NewInterceptor(s.grpcConfig, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure authentication: %w", err)
+	}
+	return interceptor, nil
+}
 
-			server.apiKeys[apiKey] = apiKeyInfo{
-				Name:        "default",
-				Key:         apiKey,
-				CreatedAt:   time.Now(),
-				Description: "Default API key",
-			}
+// ServerOptions returns the authentication and TLS options of a gRPC server
+// for the services of s, authenticating callers with the Interceptor of
+// keyStores.
+func (s *Server) ServerOptions(keyStores ...auth.KeyStore) ([]grpc.ServerOption, error) {
+	interceptor, err := s.Interceptor(keyStores...)
+	if err != nil {
+		return nil, err
+	}
+	serverOptions := interceptor.ServerOptions()
 
-			fmt.Printf("Generated default API key: %s\n", apiKey)
+	// Add TLS if certificates provided
+	tlsConfig, err := ServerTLSConfig(s.certIssuer, s.grpcConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS credentials: %w", err)
+	}
+	if tlsConfig != nil {
+		serverOptions = append(serverOptions, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	return serverOptions, nil
+}
 
-			// Save the default key
-			if err := server.saveAPIKeys(); err != nil {
-				log.Printf("Warning: Failed to save default API key: %v", err)
-			}
-		}
+// Register registers the CertAdmin service, and the APIKeyService if s has
+// a key store, on grpcServer.
+func (s *Server) Register(grpcServer *grpc.Server) {
+	pb.RegisterCertAdminServer(grpcServer, s)
+	if s.keys != nil {
+		pb.RegisterAPIKeyServiceServer(grpcServer, NewAPIKeyServer(s.keys))
 	}
+}
 
-	// Setup server options with authentication interceptor
-	serverOptions := []grpc.ServerOption{
-		grpc.UnaryInterceptor(server.authInterceptor),
+// ServerTLSConfig returns the TLS configuration for a gRPC listener in the
+// same process as certIssuer, or nil for plaintext. Client certificates are
+// checked against the CRLs of all its intermediates, and verified against
+// its CA chain unless grpcConfig names a client CA file.
+func ServerTLSConfig(certIssuer certissuer.CertIssuer, grpcConfig configuration.GRPCAuthConfig) (*tls.Config, error) {
+	if grpcConfig.CertFile == "" && grpcConfig.KeyFile == "" && !grpcConfig.MutualTLS {
+		return nil, nil
 	}
 
-	// Add TLS if certificates provided
-	if tlsCert != "" && tlsKey != "" {
-		creds, err := credentials.NewServerTLSFromFile(tlsCert, tlsKey)
+	opts := []mtls.Option{mtls.WithCRLSource(certIssuer.GetCRLBundle)}
+	if grpcConfig.ClientCAFile == "" {
+		caPEM, err := certIssuer.GetRootCA(context.Background())
 		if err != nil {
-			return nil, fmt.Errorf("failed to load TLS credentials: %w", err)
+			return nil, fmt.Errorf("failed to get CA certificate: %w", err)
 		}
-		serverOptions = append(serverOptions, grpc.Creds(creds))
+		opts = append(opts, mtls.WithClientCAs(caPEM))
 	}
-
-	server.serverOptions = serverOptions
-	return server, nil
+	return mtls.ServerTLSConfig(grpcConfig, opts...)
 }
 
 // Start starts the gRPC server
 func (s *Server) Start(listenAddr string) error {
+	serverOptions, err := s.ServerOptions()
+	if err != nil {
+		return err
+	}
+
 	listener, err := net.Listen("tcp", listenAddr)
 	if err != nil {
 		return fmt.Errorf("failed to listen: %w", err)
 	}
 
-	grpcServer := grpc.NewServer(s.serverOptions...)
-	pb.RegisterCertAdminServer(grpcServer, s)
+	grpcServer := grpc.NewServer(serverOptions...)
+	s.Register(grpcServer)
 
 	// Enable reflection for tools like grpcurl
 	reflection.Register(grpcServer)
@@ -120,51 +147,6 @@ func (s *Server) Start(listenAddr string) error {
 	return grpcServer.Serve(listener)
 }
 
-// Authentication interceptor for gRPC
-func (s *Server) authInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-	// Extract API key from metadata
-	md, ok := metadata.FromIncomingContext(ctx)
-	if !ok {
-		return nil, status.Errorf(codes.Unauthenticated, "missing metadata")
-	}
-
-	authHeader, ok := md["authorization"]
-	if !ok || len(authHeader) == 0 {
-		return nil, status.Errorf(codes.Unauthenticated, "missing authorization header")
-	}
-
-	// Parse "Bearer {token}" format
-	authParts := strings.SplitN(authHeader[0], " ", 2)
-	if len(authParts) != 2 || authParts[0] != "Bearer" {
-		return nil, status.Errorf(codes.Unauthenticated, "invalid authorization format")
-	}
-
-	apiKey := authParts[1]
-
-	// Verify API key
-	s.apiKeysMutex.RLock()
-	keyInfo, valid := s.apiKeys[apiKey]
-	s.apiKeysMutex.RUnlock()
-
-	if !valid {
-		return nil, status.Errorf(codes.Unauthenticated, "invalid API key")
-	}
-
-	// Update last used timestamp
-	s.apiKeysMutex.Lock()
-	keyInfo.LastUsedAt = time.Now()
-	s.apiKeys[apiKey] = keyInfo
-	s.apiKeysMutex.Unlock()
-
-	// Log the API key usage and client information
-	if p, ok := peer.FromContext(ctx); ok {
-		log.Printf("API key '%s' used from %s for %s", keyInfo.Name, p.Addr, info.FullMethod)
-	}
-
-	// Proceed with the handler
-	return handler(ctx, req)
-}
-
 // GetCACertificate returns the CA certificate
 func (s *Server) GetCACertificate(ctx context.Context, req *pb.GetCACertificateRequest) (*pb.GetCACertificateResponse, error) {
 	// Get CA certificate from the issuer
@@ -187,7 +169,10 @@ func (s *Server) IssueCertificate(ctx context.Context, req *pb.IssueCertificateR
 
 	// Issue certificate
 	cert, err := s.certIssuer.IssueCertificate(ctx, []byte(req.CsrPem), req.ClientInfo)
-	if err != nil {
+	switch {
+	case errors.Is(err, certissuer.ErrPolicyViolation):
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	case err != nil:
 		return nil, status.Errorf(codes.Internal, "Failed to issue certificate: %v", err)
 	}
 
@@ -211,148 +196,154 @@ func (s *Server) RevokeCertificate(ctx context.Context, req *pb.RevokeCertificat
 		return nil, status.Error(codes.InvalidArgument, "Serial number is required")
 	}
 
-	// In a real implementation, we would call a RevokeCertificate method on the cert issuer
-	// For now, we'll simulate success
-	// TODO: Implement proper revocation in the certissuer package
+	reason, err := certissuer.ParseRevocationReason(req.Reason)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	err = s.certIssuer.RevokeCertificate(ctx, req.SerialNumber, reason)
+	switch {
+	case errors.Is(err, certissuer.ErrCertificateNotFound):
+		return nil, status.Errorf(codes.NotFound, "Certificate %s not found", req.SerialNumber)
+	case errors.Is(err, certissuer.ErrCertificateAlreadyRevoked):
+		return nil, status.Errorf(codes.AlreadyExists, "Certificate %s is already revoked", req.SerialNumber)
+	case err != nil:
+		return nil, status.Errorf(codes.Internal, "Failed to revoke certificate: %v", err)
+	}
+
 	return &pb.RevokeCertificateResponse{
 		Success: true,
+		Message: fmt.Sprintf("Certificate %s revoked (%s)", req.SerialNumber, reason),
 	}, nil
 }
 
-// ListCertificates lists all issued certificates
+// ListCertificates lists issued certificates matching the request filters
 func (s *Server) ListCertificates(ctx context.Context, req *pb.ListCertificatesRequest) (*pb.ListCertificatesResponse, error) {
-	// In a real implementation, this would query the certificate store
-	// For now, we'll return a sample certificate for demonstration
-
-	certificates := []*pb.CertificateInfo{}
-
-	// Add a sample certificate
-	now := time.Now()
-	sampleCert := &pb.CertificateInfo{
-		SerialNumber: "sample-serial-123456",
-		SubjectName:  "CN=sample.example.com",
-		IssuedTo:     "sample-client",
-		IssuedAt:     now.Add(-24 * time.Hour).Format(time.RFC3339),
-		ExpiresAt:    now.AddDate(1, 0, 0).Format(time.RFC3339),
-		Revoked:      false,
+	if req.GetPageSize() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "Page size must not be negative")
+	}
+
+	filter := certissuer.CertificateFilter{
+		IncludeRevoked: req.GetIncludeRevoked(),
+		IncludeExpired: req.GetIncludeExpired(),
+		Subject:        req.GetSubjectFilter(),
+		IssuedTo:       req.GetIssuedToFilter(),
+	}
+	if req.GetIssuedAfter() != nil {
+		filter.IssuedAfter = req.GetIssuedAfter().AsTime()
+	}
+	if req.GetIssuedBefore() != nil {
+		filter.IssuedBefore = req.GetIssuedBefore().AsTime()
+	}
+
+	page, err := s.certIssuer.ListCertificates(ctx, filter, int(req.GetPageSize()), req.GetPageToken())
+	if errors.Is(err, certissuer.ErrInvalidPageToken) {
+		return nil, status.Error(codes.InvalidArgument, "Invalid page token")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to list certificates: %v", err)
+	}
+
+	certificates := make([]*pb.CertificateInfo, 0, len(page.Certificates))
+	for _, cert := range page.Certificates {
 		// Don't include the full PEM in listings to keep response size smaller
+		certificates = append(certificates, toCertificateInfo(cert, false))
 	}
-	certificates = append(certificates, sampleCert)
 
 	return &pb.ListCertificatesResponse{
-		Certificates: certificates,
+		Certificates:  certificates,
+		NextPageToken: page.NextPageToken,
+		TotalCount:    int32(page.TotalCount),
 	}, nil
 }
 
 // GetCertificateInfo gets detailed information about a certificate
 func (s *Server) GetCertificateInfo(ctx context.Context, req *pb.GetCertificateInfoRequest) (*pb.GetCertificateInfoResponse, error) {
 	// Validate request
-	if req.SerialNumber == "" {
+	if req.GetSerialNumber() == "" {
 		return nil, status.Error(codes.InvalidArgument, "Serial number is required")
 	}
 
-	// In a real implementation, this would query the certificate store
-	// For now, return a sample certificate info that matches the serial number
-
-	// Sample certificate info with the requested serial number
-	now := time.Now()
-	certInfo := &pb.CertificateInfo{
-		SerialNumber:   req.SerialNumber,
-		SubjectName:    fmt.Sprintf("CN=%s.example.com", req.SerialNumber),
-		IssuedTo:       "client-" + req.SerialNumber,
-		IssuedAt:       now.Add(-24 * time.Hour).Format(time.RFC3339),
-		ExpiresAt:      now.AddDate(1, 0, 0).Format(time.RFC3339),
-		Revoked:        false,
-		CertificatePem: fmt.Sprintf("-----BEGIN CERTIFICATE-----\nSample certificate with serial number %s\n-----END CERTIFICATE-----", req.SerialNumber),
+	cert, err := s.certIssuer.GetCertificate(ctx, req.GetSerialNumber())
+	if errors.Is(err, certissuer.ErrCertificateNotFound) {
+		return nil, status.Errorf(codes.NotFound, "Certificate %s not found", req.GetSerialNumber())
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to get certificate: %v", err)
 	}
 
 	return &pb.GetCertificateInfoResponse{
-		Certificate: certInfo,
+		Certificate: toCertificateInfo(cert, true),
 	}, nil
 }
 
-// Helper functions for API key management
-func (s *Server) loadAPIKeys() error {
-	if s.configPath == "" {
-		return errors.New("config path not set")
+// RenewCertificate renews a certificate given either in PEM form or by serial
+// number. The renewed certificate keeps the original key.
+func (s *Server) RenewCertificate(ctx context.Context, req *pb.RenewCertificateRequest) (*pb.RenewCertificateResponse, error) {
+	certPEM := []byte(req.GetCertificatePem())
+	if len(certPEM) == 0 {
+		if req.GetSerialNumber() == "" {
+			return nil, status.Error(codes.InvalidArgument, "Certificate or serial number is required")
+		}
+		cert, err := s.certIssuer.GetCertificate(ctx, req.GetSerialNumber())
+		if errors.Is(err, certissuer.ErrCertificateNotFound) {
+			return nil, status.Errorf(codes.NotFound, "Certificate %s not found", req.GetSerialNumber())
+		}
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "Failed to get certificate: %v", err)
+		}
+		certPEM = cert.CertPEM
 	}
 
-	// Ensure the directory exists
-	configDir := s.configPath
-	if err := os.MkdirAll(configDir, 0755); err != nil {
-		return fmt.Errorf("failed to create config directory: %w", err)
+	renewed, err := s.certIssuer.RenewCertificate(ctx, certPEM)
+	switch {
+	case errors.Is(err, certissuer.ErrPolicyViolation):
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	case err != nil:
+		return nil, status.Errorf(codes.FailedPrecondition, "Failed to renew certificate: %v", err)
 	}
 
-	// Read the API keys file
-	apiKeysFilePath := filepath.Join(configDir, "api_keys.json")
-	data, err := os.ReadFile(apiKeysFilePath)
-	if os.IsNotExist(err) {
-		// No file yet, start with empty map
-		return nil
-	} else if err != nil {
-		return fmt.Errorf("failed to read API keys file: %w", err)
+	block, _ := pem.Decode(renewed)
+	if block == nil {
+		return nil, status.Error(codes.Internal, "Renewed certificate is not PEM encoded")
 	}
-
-	// Unmarshal the API keys
-	var apiKeys map[string]apiKeyInfo
-	if err := json.Unmarshal(data, &apiKeys); err != nil {
-		return fmt.Errorf("failed to unmarshal API keys: %w", err)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to parse renewed certificate: %v", err)
 	}
 
-	s.apiKeysMutex.Lock()
-	s.apiKeys = apiKeys
-	s.apiKeysMutex.Unlock()
-
-	return nil
+	return &pb.RenewCertificateResponse{
+		CertificatePem: string(renewed),
+		SerialNumber:   cert.SerialNumber.String(),
+		ExpiresAt:      cert.NotAfter.Format(time.RFC3339),
+	}, nil
 }
 
-func (s *Server) saveAPIKeys() error {
-	if s.configPath == "" {
-		return errors.New("config path not set")
+// toCertificateInfo converts a stored certificate to its API representation.
+// Client info and revocation details are returned as metadata.
+func toCertificateInfo(cert *certissuer.Certificate, includePEM bool) *pb.CertificateInfo {
+	metadata := make(map[string]string, len(cert.ClientInfo)+2)
+	for k, v := range cert.ClientInfo {
+		metadata[k] = v
 	}
-
-	// Ensure the directory exists
-	configDir := s.configPath
-	if err := os.MkdirAll(configDir, 0755); err != nil {
-		return fmt.Errorf("failed to create config directory: %w", err)
+	if cert.IsRevoked {
+		metadata["revocation_reason"] = cert.RevocationReason.String()
+		metadata["revoked_at"] = cert.RevokedAt.Format(time.RFC3339)
 	}
 
-	// Marshal the API keys
-	s.apiKeysMutex.RLock()
-	data, err := json.MarshalIndent(s.apiKeys, "", "  ")
-	s.apiKeysMutex.RUnlock()
-
-	if err != nil {
-		return fmt.Errorf("failed to marshal API keys: %w", err)
+	info := &pb.CertificateInfo{
+		SerialNumber: cert.Serial.String(),
+		SubjectName:  cert.Cert.Subject.String(),
+		IssuedTo:     cert.IssuedTo,
+		IssuedAt:     cert.IssuedAt.Format(time.RFC3339),
+		ExpiresAt:    cert.ExpiresAt.Format(time.RFC3339),
+		Revoked:      cert.IsRevoked,
+		Metadata:     metadata,
 	}
-
-	// Write to file
-	apiKeysFilePath := filepath.Join(configDir, "api_keys.json")
-	if err := os.WriteFile(apiKeysFilePath, data, 0600); err != nil {
-		return fmt.Errorf("failed to write API keys file: %w", err)
-	}
-
-	return nil
-}
-
-// generateAPIKey creates a secure random API key
-func generateAPIKey() (string, error) {
-	// Generate 32 bytes of random data
-	bytes := make([]byte, 32)
-	if _, err := rand.Read(bytes); err != nil {
-		return "", err
+	if includePEM {
+		info.CertificatePem = string(cert.CertPEM)
 	}
-
-	// Encode as base64 and trim padding for a cleaner token
-	key := base64.URLEncoding.EncodeToString(bytes)
-	key = strings.TrimRight(key, "=")
-
-	return key, nil
-}
-
-// compareAPIKeys compares two API keys in constant time to prevent timing attacks
-func compareAPIKeys(a, b string) bool {
-	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+	return info
 }
 
 // Helper function to extract serial number from a certificate
@@ -369,5 +360,3 @@ func extractSerialNumber(certPEM []byte) (string, error) {
 
 	return cert.SerialNumber.String(), nil
 }
-
-// API key management methods - these will need to be added to the proto file if we want to expose them via gRPC
@@ -0,0 +1,197 @@
+// internal/certagent/agent.go
+package certagent
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/atomicfile"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certissuer"
+	"github.com/spf13/afero"
+)
+
+// Default timings for the agent.
+const (
+	DefaultCheckInterval = 12 * time.Hour
+	DefaultRetryInterval = 15 * time.Minute
+	DefaultHookTimeout   = 2 * time.Minute
+)
+
+// Renewer renews a certificate, returning the new PEM chain. The CertAdmin
+// gRPC client is the production implementation.
+type Renewer interface {
+	RenewCertificate(ctx context.Context, certPEM []byte) ([]byte, error)
+}
+
+// Config describes the certificate the agent keeps current.
+type Config struct {
+	// CertPath is the PEM certificate (optionally followed by its chain).
+	CertPath string
+	// KeyPath is the private key; renewal keeps the key, so it is only read
+	// to check that the renewed certificate still matches it.
+	KeyPath string
+	// RenewBefore is how long before expiry to renew. Certificates with a
+	// short lifetime renew after two thirds of it instead.
+	RenewBefore time.Duration
+	// CheckInterval is how often the certificate is checked.
+	CheckInterval time.Duration
+	// RetryInterval is how soon a failed renewal is retried.
+	RetryInterval time.Duration
+	// ReloadCommand is run with /bin/sh after the certificate is replaced.
+	ReloadCommand string
+	// HookTimeout bounds the reload command.
+	HookTimeout time.Duration
+}
+
+// Agent renews a certificate and key pair on a host.
+type Agent struct {
+	cfg     Config
+	renewer Renewer
+	// reloadPending is set while a renewed certificate has been written
+	// but the reload command has not yet succeeded for it
+	reloadPending bool
+}
+
+// New creates an agent. Zero timings in cfg take the package defaults.
+func New(cfg Config, renewer Renewer) *Agent {
+	if cfg.RenewBefore <= 0 {
+		cfg.RenewBefore = certissuer.RenewBeforeExpiry
+	}
+	if cfg.CheckInterval <= 0 {
+		cfg.CheckInterval = DefaultCheckInterval
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = DefaultRetryInterval
+	}
+	if cfg.HookTimeout <= 0 {
+		cfg.HookTimeout = DefaultHookTimeout
+	}
+	return &Agent{cfg: cfg, renewer: renewer}
+}
+
+// RenewAt returns when a certificate enters its renewal window: renewBefore
+// ahead of expiry, or after two thirds of its lifetime if that is sooner.
+func RenewAt(cert *x509.Certificate, renewBefore time.Duration) time.Time {
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	if window := lifetime / 3; window < renewBefore {
+		renewBefore = window
+	}
+	return cert.NotAfter.Add(-renewBefore)
+}
+
+// CheckOnce renews the certificate if it is inside its renewal window and
+// reports whether it did. A reload command that failed after an earlier
+// renewal is retried first, so that the service does not keep using the old
+// certificate until the next renewal. CheckOnce must not be called
+// concurrently.
+func (a *Agent) CheckOnce(ctx context.Context) (bool, error) {
+	if a.reloadPending {
+		if err := a.reload(ctx); err != nil {
+			return false, err
+		}
+		a.reloadPending = false
+	}
+
+	certPEM, err := os.ReadFile(a.cfg.CertPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to read certificate: %w", err)
+	}
+	cert, err := parseLeaf(certPEM)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse %s: %w", a.cfg.CertPath, err)
+	}
+	if time.Now().Before(RenewAt(cert, a.cfg.RenewBefore)) {
+		return false, nil
+	}
+
+	log.Printf("Certificate %s expires %s, renewing", a.cfg.CertPath, cert.NotAfter.Format(time.RFC3339))
+	renewedPEM, err := a.renewer.RenewCertificate(ctx, certPEM)
+	if err != nil {
+		return false, fmt.Errorf("failed to renew certificate: %w", err)
+	}
+	if _, err := parseLeaf(renewedPEM); err != nil {
+		return false, fmt.Errorf("server returned an invalid certificate: %w", err)
+	}
+
+	// Never install a certificate the key on disk can't use
+	keyPEM, err := os.ReadFile(a.cfg.KeyPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to read private key: %w", err)
+	}
+	if _, err := tls.X509KeyPair(renewedPEM, keyPEM); err != nil {
+		return false, fmt.Errorf("renewed certificate does not match %s: %w", a.cfg.KeyPath, err)
+	}
+
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(a.cfg.CertPath); err == nil {
+		perm = info.Mode().Perm()
+	}
+	if err := atomicfile.WriteFile(afero.NewOsFs(), a.cfg.CertPath, renewedPEM, perm); err != nil {
+		return false, fmt.Errorf("failed to write renewed certificate: %w", err)
+	}
+	log.Printf("Renewed certificate written to %s", a.cfg.CertPath)
+
+	if err := a.reload(ctx); err != nil {
+		a.reloadPending = true
+		return true, err
+	}
+	return true, nil
+}
+
+// Run checks the certificate until ctx is canceled, retrying failures
+// sooner than the regular interval.
+func (a *Agent) Run(ctx context.Context) error {
+	for {
+		wait := a.cfg.CheckInterval
+		if _, err := a.CheckOnce(ctx); err != nil {
+			log.Printf("Certificate renewal check failed: %v", err)
+			wait = a.cfg.RetryInterval
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+	}
+}
+
+// reload runs the configured reload command.
+func (a *Agent) reload(ctx context.Context) error {
+	if a.cfg.ReloadCommand == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, a.cfg.HookTimeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, "/bin/sh", "-c", a.cfg.ReloadCommand).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("reload command failed: %w: %s", err, output)
+	}
+	log.Printf("Reload command succeeded")
+	return nil
+}
+
+// parseLeaf returns the first certificate in PEM data.
+func parseLeaf(certPEM []byte) (*x509.Certificate, error) {
+	for {
+		var block *pem.Block
+		block, certPEM = pem.Decode(certPEM)
+		if block == nil {
+			return nil, errors.New("no certificate found")
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
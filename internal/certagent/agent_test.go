@@ -0,0 +1,209 @@
+// internal/certagent/agent_test.go
+package certagent_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certagent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRenewer re-signs the certificate's key with a fresh 90 day lifetime.
+type fakeRenewer struct {
+	calls int
+	key   crypto.Signer // overrides the renewed public key when set
+	err   error
+}
+
+func (f *fakeRenewer) RenewCertificate(_ context.Context, certPEM []byte) ([]byte, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	pub := cert.PublicKey
+	if f.key != nil {
+		pub = f.key.Public()
+	}
+	return selfSign(time.Now(), time.Now().Add(90*24*time.Hour), pub), nil
+}
+
+var signer, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+// selfSign returns a PEM certificate for pub valid between the given times.
+func selfSign(notBefore, notAfter time.Time, pub crypto.PublicKey) []byte {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "host.example.com"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, pub, signer)
+	if err != nil {
+		panic(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// writePair writes a key and a certificate valid between the given times.
+func writePair(t *testing.T, notBefore, notAfter time.Time) (certPath, keyPath string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certPath = filepath.Join(dir, "host.crt")
+	keyPath = filepath.Join(dir, "host.key")
+	require.NoError(t, os.WriteFile(certPath, selfSign(notBefore, notAfter, key.Public()), 0640))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certPath, keyPath
+}
+
+func readLeaf(t *testing.T, path string) *x509.Certificate {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	block, _ := pem.Decode(data)
+	require.NotNil(t, block)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	return cert
+}
+
+func TestRenewAt(t *testing.T) {
+	now := time.Now()
+	long := &x509.Certificate{NotBefore: now, NotAfter: now.Add(365 * 24 * time.Hour)}
+	assert.Equal(t, long.NotAfter.Add(-30*24*time.Hour), certagent.RenewAt(long, 30*24*time.Hour))
+
+	// Short-lived certificates renew after two thirds of their lifetime
+	short := &x509.Certificate{NotBefore: now, NotAfter: now.Add(9 * 24 * time.Hour)}
+	assert.Equal(t, now.Add(6*24*time.Hour), certagent.RenewAt(short, 30*24*time.Hour))
+}
+
+func TestCheckOnceOutsideWindow(t *testing.T) {
+	certPath, keyPath := writePair(t, time.Now(), time.Now().Add(365*24*time.Hour))
+	renewer := &fakeRenewer{}
+	marker := filepath.Join(t.TempDir(), "reloaded")
+
+	agent := certagent.New(certagent.Config{
+		CertPath:      certPath,
+		KeyPath:       keyPath,
+		ReloadCommand: "touch " + marker,
+	}, renewer)
+	renewed, err := agent.CheckOnce(context.Background())
+	require.NoError(t, err)
+	assert.False(t, renewed)
+	assert.Zero(t, renewer.calls)
+	assert.NoFileExists(t, marker)
+}
+
+func TestCheckOnceRenews(t *testing.T) {
+	certPath, keyPath := writePair(t, time.Now().Add(-350*24*time.Hour), time.Now().Add(10*24*time.Hour))
+	renewer := &fakeRenewer{}
+	marker := filepath.Join(t.TempDir(), "reloaded")
+
+	agent := certagent.New(certagent.Config{
+		CertPath:      certPath,
+		KeyPath:       keyPath,
+		ReloadCommand: "touch " + marker,
+	}, renewer)
+	renewed, err := agent.CheckOnce(context.Background())
+	require.NoError(t, err)
+	assert.True(t, renewed)
+	assert.Equal(t, 1, renewer.calls)
+
+	cert := readLeaf(t, certPath)
+	assert.True(t, cert.NotAfter.After(time.Now().Add(80*24*time.Hour)))
+	info, err := os.Stat(certPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm(), "The certificate's permissions should be kept")
+	assert.FileExists(t, marker, "The reload command should run after renewal")
+
+	// The fresh certificate is outside the window
+	renewed, err = agent.CheckOnce(context.Background())
+	require.NoError(t, err)
+	assert.False(t, renewed)
+}
+
+func TestCheckOnceKeepsCertificateOnFailure(t *testing.T) {
+	certPath, keyPath := writePair(t, time.Now().Add(-350*24*time.Hour), time.Now().Add(10*24*time.Hour))
+	original, err := os.ReadFile(certPath)
+	require.NoError(t, err)
+
+	// The server is unavailable
+	agent := certagent.New(certagent.Config{CertPath: certPath, KeyPath: keyPath},
+		&fakeRenewer{err: errors.New("connection refused")})
+	_, err = agent.CheckOnce(context.Background())
+	require.Error(t, err)
+
+	// The server certified a different key
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	agent = certagent.New(certagent.Config{CertPath: certPath, KeyPath: keyPath},
+		&fakeRenewer{key: otherKey})
+	_, err = agent.CheckOnce(context.Background())
+	require.Error(t, err)
+
+	current, err := os.ReadFile(certPath)
+	require.NoError(t, err)
+	assert.Equal(t, original, current)
+	entries, err := os.ReadDir(filepath.Dir(certPath))
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "No temporary files should be left behind")
+}
+
+func TestCheckOnceReportsHookFailure(t *testing.T) {
+	certPath, keyPath := writePair(t, time.Now().Add(-350*24*time.Hour), time.Now().Add(10*24*time.Hour))
+	dir := t.TempDir()
+	ready := filepath.Join(dir, "ready")
+	marker := filepath.Join(dir, "reloaded")
+	renewer := &fakeRenewer{}
+
+	// The reload fails until the service is ready
+	agent := certagent.New(certagent.Config{
+		CertPath:      certPath,
+		KeyPath:       keyPath,
+		ReloadCommand: "test -e " + ready + " && touch " + marker,
+	}, renewer)
+	renewed, err := agent.CheckOnce(context.Background())
+	assert.True(t, renewed, "The certificate is replaced even if the reload fails")
+	assert.Error(t, err)
+
+	// The next check retries the reload although no renewal is due
+	_, err = agent.CheckOnce(context.Background())
+	assert.Error(t, err)
+	require.NoError(t, os.WriteFile(ready, nil, 0644))
+	renewed, err = agent.CheckOnce(context.Background())
+	require.NoError(t, err)
+	assert.False(t, renewed)
+	assert.FileExists(t, marker)
+	assert.Equal(t, 1, renewer.calls)
+
+	// Once it succeeded the reload is not repeated
+	require.NoError(t, os.Remove(marker))
+	_, err = agent.CheckOnce(context.Background())
+	require.NoError(t, err)
+	assert.NoFileExists(t, marker)
+}
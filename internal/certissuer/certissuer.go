@@ -2,21 +2,29 @@
 package certissuer
 
 import (
+	"bytes"
+	"context"
+	"crypto"
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"math/big"
 	"os"
 	"path/filepath"
+	"strconv"
 	"sync"
 	"time"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/atomicfile"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/mtls"
+	"github.com/spf13/afero"
 )
 
 const (
-	// Default key size for RSA keys
+	// Default key size for RSA keys, matching DefaultKeyAlgorithm
 	DefaultKeySize = 2048
 
 	// Certificate validity periods
@@ -28,16 +36,43 @@ const (
 // CertificateAuthority represents a certificate authority for issuing and managing certificates
 type CertificateAuthority struct {
 	// CA certificate and private key
-	caCert    *x509.Certificate
-	caPrivKey *rsa.PrivateKey
+	caCert       *x509.Certificate
+	caPrivKey    crypto.Signer
+	caKeyName    string
+	keyAlgorithm KeyAlgorithm
+	keys         KeyProvider
 
-	// PEM-encoded versions for storage/return
+	// PEM-encoded version for storage/return
 	caCertPEM []byte
-	caKeyPEM  []byte
+
+	// Root of the hierarchy; the same certificate as caCert for a
+	// single-tier CA. Its key is not held online.
+	rootCert    *x509.Certificate
+	rootCertPEM []byte
+	rootKeyPath string
+
+	// Intermediates replaced by a rotation that still answer for the
+	// unexpired certificates they issued
+	retired []*keyPair
+
+	// Issuance policy; nil allows everything
+	policy *PolicyEngine
+
+	// Names of client certificate principals only administrators may use
+	principals []string
 
 	// Certificate storage
 	certStore map[string]*Certificate
 	storePath string
+	store     Store
+
+	// Certificate revocation list
+	crlDistributionPoints []string
+	crls                  map[string]*cachedCRL
+	crlNumber             *big.Int
+
+	// OCSP responder URLs for the AIA extension
+	ocspServers []string
 
 	// Concurrent access
 	mu sync.RWMutex
@@ -45,19 +80,60 @@ type CertificateAuthority struct {
 
 // Certificate holds information about an issued certificate
 type Certificate struct {
-	Serial    *big.Int
-	Cert      *x509.Certificate
-	CertPEM   []byte
-	ExpiresAt time.Time
-	IssuedTo  string
-	IsRevoked bool
+	Serial     *big.Int
+	Cert       *x509.Certificate
+	CertPEM    []byte
+	IssuedAt   time.Time
+	ExpiresAt  time.Time
+	IssuedTo   string
+	ClientInfo map[string]string
+
+	// Revocation state
+	IsRevoked        bool
+	RevocationReason RevocationReason
+	RevokedAt        time.Time
+}
+
+// Option configures a CertificateAuthority.
+type Option func(*CertificateAuthority)
+
+// WithStore overrides where issued certificates and their metadata are
+// persisted. By default they are kept as files in the storage directory.
+func WithStore(store Store) Option {
+	return func(ca *CertificateAuthority) {
+		ca.store = store
+	}
 }
 
 // NewCertificateAuthority creates a new certificate authority
-func NewCertificateAuthority(storePath string) (*CertificateAuthority, error) {
+func NewCertificateAuthority(storePath string, opts ...Option) (*CertificateAuthority, error) {
 	ca := &CertificateAuthority{
-		certStore: make(map[string]*Certificate),
-		storePath: storePath,
+		certStore:    make(map[string]*Certificate),
+		storePath:    storePath,
+		keyAlgorithm: DefaultKeyAlgorithm,
+	}
+	if storePath != "" {
+		ca.store = NewFileStore(storePath)
+	}
+	for _, opt := range opts {
+		opt(ca)
+	}
+	if ca.keys == nil {
+		if storePath == "" {
+			ca.keys = newMemoryKeyProvider()
+		} else {
+			ca.keys = NewFileKeyProvider(storePath)
+		}
+	}
+	if ca.rootKeyPath != "" {
+		fileKeys, ok := ca.keys.(*FileKeyProvider)
+		if !ok {
+			return nil, fmt.Errorf("a root key path only applies to keys stored in files")
+		}
+		fileKeys.SetKeyPath(RootKeyName, ca.rootKeyPath)
+	}
+	if err := ca.checkRootKeyPath(); err != nil {
+		return nil, err
 	}
 
 	// Create storage directory if it doesn't exist
@@ -67,26 +143,101 @@ func NewCertificateAuthority(storePath string) (*CertificateAuthority, error) {
 		}
 	}
 
-	// Check if CA already exists in storage
-	if err := ca.loadCA(); err != nil {
-		// If not, create a new CA
-		if err := ca.createCA(); err != nil {
-			return nil, fmt.Errorf("failed to create certificate authority: %w", err)
+	// Load an existing CA; only create one when there is none, so a damaged
+	// CA is never silently replaced
+	if storePath != "" && Exists(storePath) {
+		if err := ca.loadCA(); err != nil {
+			return nil, fmt.Errorf("failed to load certificate authority: %w", err)
 		}
+	} else if err := ca.createCA(); err != nil {
+		return nil, fmt.Errorf("failed to create certificate authority: %w", err)
 	}
 
+	if len(ca.ocspServers) > 0 && !canSignOCSP(ca.caPrivKey) {
+		fmt.Println("Warning: Ed25519 CA keys cannot sign OCSP responses, issued certificates will not name an OCSP responder")
+	}
+
+	// Reload previously issued certificates
+	if err := ca.loadCertificates(); err != nil {
+		return nil, err
+	}
+	ca.pruneRetired()
+
 	return ca, nil
 }
 
-// createCA generates a new self-signed CA certificate
+// loadCertificates fills the in-memory index from the persistent store
+func (ca *CertificateAuthority) loadCertificates() error {
+	if ca.store == nil {
+		return nil
+	}
+
+	certs, err := ca.store.LoadAll()
+	if err != nil {
+		return fmt.Errorf("failed to load issued certificates: %w", err)
+	}
+	for _, cert := range certs {
+		ca.certStore[cert.Serial.String()] = cert
+	}
+	return nil
+}
+
+// persist saves a certificate record if a store is configured
+func (ca *CertificateAuthority) persist(cert *Certificate) error {
+	if ca.store == nil {
+		return nil
+	}
+	return ca.store.Save(cert)
+}
+
+// LookupCertificate returns a copy of the stored record for serialNumber
+func (ca *CertificateAuthority) LookupCertificate(serialNumber string) (*Certificate, bool) {
+	ca.mu.RLock()
+	defer ca.mu.RUnlock()
+
+	cert, ok := ca.certStore[serialNumber]
+	if !ok {
+		return nil, false
+	}
+	copied := *cert
+	return &copied, true
+}
+
+// CA files in the storage directory. A two-tier CA keeps the root
+// certificate and the online intermediate that signs leaf certificates; the
+// root key belongs offline and is only needed to rotate the intermediate.
+// Single-tier CAs created by older versions sign with ca.crt and ca.key.
+const (
+	rootCertFile         = "root.crt"
+	rootKeyFile          = "root.key"
+	intermediateCertFile = "intermediate.crt"
+	legacyCertFile       = "ca.crt"
+	legacyKeyFile        = "ca.key"
+)
+
+// osFs holds the CA's files; they are replaced atomically so that a crash
+// never leaves a half-written key, certificate or CRL behind.
+var osFs = afero.NewOsFs()
+
+// Exists reports whether storePath already holds a certificate authority.
+func Exists(storePath string) bool {
+	for _, name := range []string{rootCertFile, intermediateCertFile, legacyCertFile} {
+		if _, err := os.Stat(filepath.Join(storePath, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// createCA generates a new self-signed root and an intermediate signed by it
 func (ca *CertificateAuthority) createCA() error {
-	// Generate CA private key
-	privateKey, err := rsa.GenerateKey(rand.Reader, DefaultKeySize)
+	// Generate root private key
+	rootKey, err := ca.keys.GenerateKey(RootKeyName, ca.keyAlgorithm)
 	if err != nil {
-		return fmt.Errorf("failed to generate CA private key: %w", err)
+		return fmt.Errorf("failed to generate root CA private key: %w", err)
 	}
 
-	// Prepare CA certificate template
+	// Prepare root certificate template
 	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
 	if err != nil {
 		return fmt.Errorf("failed to generate serial number: %w", err)
@@ -109,137 +260,154 @@ func (ca *CertificateAuthority) createCA() error {
 		MaxPathLen:            1,
 	}
 
-	// Self-sign the CA certificate
+	// Self-sign the root certificate
 	certBytes, err := x509.CreateCertificate(
 		rand.Reader,
 		&template,
 		&template,
-		&privateKey.PublicKey,
-		privateKey,
+		rootKey.Public(),
+		rootKey,
 	)
 	if err != nil {
-		return fmt.Errorf("failed to create CA certificate: %w", err)
+		return fmt.Errorf("failed to create root CA certificate: %w", err)
 	}
 
-	// Parse the certificate bytes
-	cert, err := x509.ParseCertificate(certBytes)
+	rootCert, err := x509.ParseCertificate(certBytes)
 	if err != nil {
-		return fmt.Errorf("failed to parse CA certificate: %w", err)
+		return fmt.Errorf("failed to parse root CA certificate: %w", err)
 	}
-
-	// Store the CA certificate and private key
-	ca.caCert = cert
-	ca.caPrivKey = privateKey
-
-	// Encode to PEM
-	caCertPEM := pem.EncodeToMemory(&pem.Block{
+	ca.rootCert = rootCert
+	ca.rootCertPEM = pem.EncodeToMemory(&pem.Block{
 		Type:  "CERTIFICATE",
 		Bytes: certBytes,
 	})
 
-	caKeyPEM := pem.EncodeToMemory(&pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
-	})
-
-	ca.caCertPEM = caCertPEM
-	ca.caKeyPEM = caKeyPEM
+	// The intermediate does the day-to-day signing
+	intermediate, err := ca.newIntermediate(rootKey, IntermediateKeyName)
+	if err != nil {
+		return err
+	}
+	ca.setIssuer(intermediate)
 
 	// Save to storage if path is provided
-	if ca.storePath != "" {
-		if err := ca.saveCA(); err != nil {
-			return fmt.Errorf("failed to save CA certificate: %w", err)
-		}
+	if ca.storePath == "" {
+		return nil
+	}
+
+	if err := atomicfile.WriteFile(osFs, filepath.Join(ca.storePath, rootCertFile), ca.rootCertPEM, 0644); err != nil {
+		return fmt.Errorf("failed to save root CA certificate: %w", err)
+	}
+	if err := ca.saveIntermediate(intermediate); err != nil {
+		return err
 	}
 
+	if fileKeys, ok := ca.keys.(*FileKeyProvider); ok {
+		fmt.Printf("Created root CA; keep its private key %s offline\n", fileKeys.KeyPath(RootKeyName))
+	}
 	return nil
 }
 
-// loadCA loads the CA certificate and private key from storage
+// loadCA loads the CA certificates and the signing key from storage
 func (ca *CertificateAuthority) loadCA() error {
 	if ca.storePath == "" {
 		return fmt.Errorf("no storage path provided")
 	}
 
-	// Read CA certificate
-	certPath := filepath.Join(ca.storePath, "ca.crt")
-	certPEM, err := os.ReadFile(certPath)
-	if err != nil {
-		return err
+	// Single-tier CA from an older version: the root signs directly
+	if _, err := os.Stat(filepath.Join(ca.storePath, intermediateCertFile)); errors.Is(err, os.ErrNotExist) {
+		issuer, err := ca.loadKeyPair(filepath.Join(ca.storePath, legacyCertFile), LegacyKeyName)
+		if err != nil {
+			return err
+		}
+		ca.rootCert = issuer.cert
+		ca.rootCertPEM = issuer.certPEM
+		ca.setIssuer(issuer)
+		return nil
 	}
 
-	// Read CA private key
-	keyPath := filepath.Join(ca.storePath, "ca.key")
-	keyPEM, err := os.ReadFile(keyPath)
+	// Read root certificate
+	rootPEM, err := os.ReadFile(filepath.Join(ca.storePath, rootCertFile))
 	if err != nil {
 		return err
 	}
-
-	// Parse certificate PEM
-	certBlock, _ := pem.Decode(certPEM)
-	if certBlock == nil {
-		return fmt.Errorf("failed to decode CA certificate PEM")
+	rootCert, err := parseCertificatePEM(rootPEM)
+	if err != nil {
+		return fmt.Errorf("failed to parse root CA certificate: %w", err)
 	}
 
-	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	// A rotation interrupted before its key rename left the key pending
+	intermediate, err := ca.loadKeyPair(filepath.Join(ca.storePath, intermediateCertFile), IntermediateKeyName, pendingIntermediateKeyName)
 	if err != nil {
-		return fmt.Errorf("failed to parse CA certificate: %w", err)
+		return err
 	}
-
-	// Parse private key PEM
-	keyBlock, _ := pem.Decode(keyPEM)
-	if keyBlock == nil {
-		return fmt.Errorf("failed to decode CA private key PEM")
+	if err := intermediate.cert.CheckSignatureFrom(rootCert); err != nil {
+		return fmt.Errorf("intermediate CA certificate is not signed by the root: %w", err)
 	}
 
-	privateKey, err := x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
-	if err != nil {
-		return fmt.Errorf("failed to parse CA private key: %w", err)
+	// The root key only belongs on offline storage
+	if _, err := os.Stat(filepath.Join(ca.storePath, rootKeyFile)); err == nil {
+		return fmt.Errorf("root CA private key %s is in the CA store; move it to offline storage", filepath.Join(ca.storePath, rootKeyFile))
 	}
-
-	// Store in memory
-	ca.caCert = cert
-	ca.caPrivKey = privateKey
-	ca.caCertPEM = certPEM
-	ca.caKeyPEM = keyPEM
-
-	return nil
-}
-
-// saveCA saves the CA certificate and private key to storage
-func (ca *CertificateAuthority) saveCA() error {
-	if ca.storePath == "" {
-		return fmt.Errorf("no storage path provided")
+	if _, err := os.Stat(filepath.Join(ca.storePath, legacyKeyFile)); err == nil {
+		fmt.Printf("Warning: root CA private key %s is stored online; move it to offline storage\n", filepath.Join(ca.storePath, legacyKeyFile))
 	}
 
-	// Save CA certificate
-	certPath := filepath.Join(ca.storePath, "ca.crt")
-	if err := os.WriteFile(certPath, ca.caCertPEM, 0644); err != nil {
-		return fmt.Errorf("failed to save CA certificate: %w", err)
+	ca.rootCert = rootCert
+	ca.rootCertPEM = rootPEM
+	ca.setIssuer(intermediate)
+	if err := ca.loadRetired(); err != nil {
+		return fmt.Errorf("failed to load retired intermediate CAs: %w", err)
 	}
 
-	// Save CA private key (restricted permissions)
-	keyPath := filepath.Join(ca.storePath, "ca.key")
-	if err := os.WriteFile(keyPath, ca.caKeyPEM, 0600); err != nil {
-		return fmt.Errorf("failed to save CA private key: %w", err)
+	if renamer, ok := ca.keys.(keyRenamer); ok && intermediate.keyName == pendingIntermediateKeyName {
+		if err := ca.finishRotation(renamer); err != nil {
+			return fmt.Errorf("failed to finish intermediate CA rotation: %w", err)
+		}
 	}
-
 	return nil
 }
 
-// GetCACertificate returns the PEM-encoded CA certificate
+// GetCACertificate returns the PEM-encoded CA chain: the issuing
+// intermediate first and the root last
 func (ca *CertificateAuthority) GetCACertificate() []byte {
 	ca.mu.RLock()
 	defer ca.mu.RUnlock()
 
-	return ca.caCertPEM
+	chain := append([]byte{}, ca.issuerChainPEM()...)
+	return append(chain, ca.rootCertPEM...)
 }
 
-// IssueCertificateFromCSR issues a certificate from a CSR
+// RootCertificate returns the PEM-encoded root CA certificate
+func (ca *CertificateAuthority) RootCertificate() []byte {
+	ca.mu.RLock()
+	defer ca.mu.RUnlock()
+
+	return ca.rootCertPEM
+}
+
+// IssueCertificateFromCSR issues a certificate from a CSR under the default
+// issuance policy. The result is the full chain: the leaf followed by the
+// issuing intermediate
 func (ca *CertificateAuthority) IssueCertificateFromCSR(csrPEM []byte, clientInfo map[string]string) ([]byte, error) {
-	ca.mu.Lock()
-	defer ca.mu.Unlock()
+	return ca.IssueCertificateContext(context.Background(), csrPEM, clientInfo)
+}
 
+// certificateRequest is a decoded CSR together with the client-supplied
+// subject details
+type certificateRequest struct {
+	csr          *x509.CertificateRequest
+	commonName   string
+	organization string
+	names        SubjectNames
+	validity     time.Duration
+	principal    string
+}
+
+// parseCertificateRequest decodes and verifies a CSR. Subject alternative
+// names requested in the CSR and in clientInfo["sans"] are combined;
+// clientInfo["validity_days"] optionally asks for a shorter lifetime and
+// clientInfo["principal"] binds the certificate to a client principal.
+func parseCertificateRequest(csrPEM []byte, clientInfo map[string]string) (*certificateRequest, error) {
 	// Decode CSR
 	block, _ := pem.Decode(csrPEM)
 	if block == nil || block.Type != "CERTIFICATE REQUEST" {
@@ -257,44 +425,120 @@ func (ca *CertificateAuthority) IssueCertificateFromCSR(csrPEM []byte, clientInf
 		return nil, fmt.Errorf("invalid CSR signature: %w", err)
 	}
 
+	// RSA, ECDSA and Ed25519 keys are accepted regardless of the CA key type
+	if err := checkPublicKey(csr.PublicKey); err != nil {
+		return nil, err
+	}
+
+	req := &certificateRequest{
+		csr:          csr,
+		commonName:   clientInfo["common_name"],
+		organization: clientInfo["organization"],
+		principal:    clientInfo["principal"],
+	}
+	if req.commonName == "" {
+		req.commonName = csr.Subject.CommonName
+	}
+
+	if req.names, err = ParseSANs(clientInfo["sans"]); err != nil {
+		return nil, err
+	}
+	if err := req.names.merge(csr); err != nil {
+		return nil, err
+	}
+
+	if days := clientInfo["validity_days"]; days != "" {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid validity_days %q", days)
+		}
+		req.validity = time.Duration(n) * 24 * time.Hour
+	}
+	return req, nil
+}
+
+// defaultOrganization is the subject organization of certificates whose
+// requester did not ask for one.
+const defaultOrganization = "Ubuntu Autoinstall"
+
+// renewalRequest describes the names of an existing certificate the way a
+// CSR for it would have, for checking its renewal against the policy. A
+// principal URI is left out: only an administrator can have added it.
+func renewalRequest(cert *x509.Certificate) *certificateRequest {
+	req := &certificateRequest{
+		commonName: cert.Subject.CommonName,
+		names: SubjectNames{
+			DNSNames:       cert.DNSNames,
+			IPAddresses:    cert.IPAddresses,
+			URIs:           withoutPrincipalURIs(cert.URIs),
+			EmailAddresses: cert.EmailAddresses,
+		},
+	}
+	if len(cert.Subject.Organization) > 0 && cert.Subject.Organization[0] != defaultOrganization {
+		req.organization = cert.Subject.Organization[0]
+	}
+	return req
+}
+
+// IssueCertificateContext issues a certificate from a CSR after checking it
+// against the issuance policy of the requester named in ctx (see
+// ContextWithRequester). The result is the full chain.
+func (ca *CertificateAuthority) IssueCertificateContext(ctx context.Context, csrPEM []byte, clientInfo map[string]string) ([]byte, error) {
+	req, err := parseCertificateRequest(csrPEM, clientInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	// Policy checks may consult the inventory, so run them before locking
+	requester := RequesterFromContext(ctx)
+	if err := ca.checkPrincipals(ctx, req); err != nil {
+		return nil, err
+	}
+	if err := ca.policy.check(ctx, requester, req); err != nil {
+		return nil, err
+	}
+	validity := ca.policy.Validity(requester, req.validity)
+	uris := req.names.URIs
+	if req.principal != "" {
+		uris = append(uris[:len(uris):len(uris)], mtls.PrincipalURI(req.principal))
+	}
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
 	// Generate serial number
 	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate serial number: %w", err)
 	}
 
-	// Extract client information
-	commonName := clientInfo["common_name"]
-	if commonName == "" {
-		commonName = csr.Subject.CommonName
+	organization := req.organization
+	if organization == "" {
+		organization = defaultOrganization
 	}
 
 	now := time.Now()
+	csr := req.csr
+	commonName := req.commonName
 	// Create certificate template
 	template := x509.Certificate{
 		SerialNumber: serialNumber,
 		Subject: pkix.Name{
-			CommonName: commonName,
-			Organization: []string{func() string {
-				if org, ok := clientInfo["organization"]; ok && org != "" {
-					return org
-				}
-				return "Ubuntu Autoinstall"
-			}()},
+			CommonName:   commonName,
+			Organization: []string{organization},
 		},
 		NotBefore:             now,
-		NotAfter:              now.Add(CertValidityPeriod),
-		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		NotAfter:              now.Add(validity),
+		KeyUsage:              leafKeyUsage(csr.PublicKey),
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
 		BasicConstraintsValid: true,
 		IsCA:                  false,
-	}
-
-	// Add Subject Alternative Names if provided
-	if sans, ok := clientInfo["sans"]; ok && sans != "" {
-		// Parse comma-separated list of SANs
-		// Note: In a real implementation, you would parse this properly
-		template.DNSNames = []string{sans}
+		DNSNames:              req.names.DNSNames,
+		IPAddresses:           req.names.IPAddresses,
+		URIs:                  uris,
+		EmailAddresses:        req.names.EmailAddresses,
+		CRLDistributionPoints: ca.issuerCRLDistributionPoints(),
+		OCSPServer:            ca.issuerOCSPServers(),
 	}
 
 	// Sign the certificate
@@ -323,33 +567,37 @@ func (ca *CertificateAuthority) IssueCertificateFromCSR(csrPEM []byte, clientInf
 
 	// Store the certificate
 	certInfo := &Certificate{
-		Serial:    serialNumber,
-		Cert:      cert,
-		CertPEM:   certPEM,
-		ExpiresAt: template.NotAfter,
-		IssuedTo:  commonName,
+		Serial:     serialNumber,
+		Cert:       cert,
+		CertPEM:    certPEM,
+		IssuedAt:   template.NotBefore,
+		ExpiresAt:  template.NotAfter,
+		IssuedTo:   commonName,
+		ClientInfo: copyClientInfo(clientInfo),
+	}
+
+	// Persist before handing the certificate out so it can always be revoked
+	if err := ca.persist(certInfo); err != nil {
+		return nil, fmt.Errorf("failed to store issued certificate: %w", err)
 	}
 
 	// Store the certificate using the serial number as a key
 	ca.certStore[serialNumber.String()] = certInfo
 
-	// Save to storage if configured
-	if ca.storePath != "" {
-		certPath := filepath.Join(ca.storePath, fmt.Sprintf("%s.crt", serialNumber.String()))
-		if err := os.WriteFile(certPath, certPEM, 0644); err != nil {
-			// Log but don't fail
-			fmt.Printf("Warning: Failed to save certificate to storage: %v\n", err)
-		}
-	}
-
-	return certPEM, nil
+	return ca.fullChain(certPEM), nil
 }
 
-// RenewCertificate renews an existing certificate
+// RenewCertificate renews an existing certificate under the default
+// issuance policy, returning the full chain
 func (ca *CertificateAuthority) RenewCertificate(certPEM []byte) ([]byte, error) {
-	ca.mu.Lock()
-	defer ca.mu.Unlock()
+	return ca.RenewCertificateContext(context.Background(), certPEM)
+}
 
+// RenewCertificateContext renews an existing certificate, returning the
+// full chain. Its names are checked against the current issuance policy of
+// the requester named in ctx, so a policy that was tightened since the
+// certificate was issued also applies to its renewals.
+func (ca *CertificateAuthority) RenewCertificateContext(ctx context.Context, certPEM []byte) ([]byte, error) {
 	// Decode certificate
 	block, _ := pem.Decode(certPEM)
 	if block == nil || block.Type != "CERTIFICATE" {
@@ -362,13 +610,35 @@ func (ca *CertificateAuthority) RenewCertificate(certPEM []byte) ([]byte, error)
 		return nil, fmt.Errorf("failed to parse certificate: %w", err)
 	}
 
-	// Verify certificate was issued by this CA
-	if err := cert.CheckSignatureFrom(ca.caCert); err != nil {
+	// Policy checks may consult the inventory, so run them before locking
+	requester := RequesterFromContext(ctx)
+	renewal := renewalRequest(cert)
+	if len(renewal.names.URIs) < len(cert.URIs) {
+		// A principal certificate was issued by an administrator and may
+		// keep its reserved names
+		ctx = ContextAllowingPrincipals(ctx)
+	}
+	if err := ca.checkPrincipals(ctx, renewal); err != nil {
+		return nil, err
+	}
+	if err := ca.policy.check(ctx, requester, renewal); err != nil {
+		return nil, err
+	}
+	validity := ca.policy.Validity(requester, 0)
+
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	// Verify certificate was issued by this CA, either by the current
+	// intermediate or by one it has since replaced
+	storedCert, known := ca.certStore[cert.SerialNumber.String()]
+	issuedByPrevious := known && bytes.Equal(storedCert.Cert.Raw, cert.Raw)
+	if err := cert.CheckSignatureFrom(ca.caCert); err != nil && !issuedByPrevious {
 		return nil, fmt.Errorf("certificate not issued by this CA")
 	}
 
 	// Check if certificate is revoked
-	if storedCert, ok := ca.certStore[cert.SerialNumber.String()]; ok && storedCert.IsRevoked {
+	if known && storedCert.IsRevoked {
 		return nil, fmt.Errorf("certificate has been revoked")
 	}
 
@@ -378,19 +648,24 @@ func (ca *CertificateAuthority) RenewCertificate(certPEM []byte) ([]byte, error)
 		return nil, fmt.Errorf("failed to generate serial number: %w", err)
 	}
 
-	// Create new certificate with same information but new validity period
+	// Create new certificate with same information but a new validity period,
+	// capped by the requester's policy
 	now := time.Now()
 	template := x509.Certificate{
 		SerialNumber:          serialNumber,
 		Subject:               cert.Subject,
 		NotBefore:             now,
-		NotAfter:              now.Add(CertValidityPeriod),
+		NotAfter:              now.Add(validity),
 		KeyUsage:              cert.KeyUsage,
 		ExtKeyUsage:           cert.ExtKeyUsage,
 		BasicConstraintsValid: true,
 		IsCA:                  false,
 		DNSNames:              cert.DNSNames,
 		IPAddresses:           cert.IPAddresses,
+		URIs:                  cert.URIs,
+		EmailAddresses:        cert.EmailAddresses,
+		CRLDistributionPoints: ca.issuerCRLDistributionPoints(),
+		OCSPServer:            ca.issuerOCSPServers(),
 	}
 
 	// Sign the certificate with CA
@@ -417,39 +692,64 @@ func (ca *CertificateAuthority) RenewCertificate(certPEM []byte) ([]byte, error)
 		return nil, fmt.Errorf("failed to parse renewed certificate: %w", err)
 	}
 
-	// Store the renewed certificate
+	// Store the renewed certificate, carrying over what we know about the original
 	certInfo := &Certificate{
 		Serial:    serialNumber,
 		Cert:      newCert,
 		CertPEM:   newCertPEM,
+		IssuedAt:  template.NotBefore,
 		ExpiresAt: template.NotAfter,
 		IssuedTo:  cert.Subject.CommonName,
 	}
+	if known {
+		certInfo.IssuedTo = storedCert.IssuedTo
+		certInfo.ClientInfo = copyClientInfo(storedCert.ClientInfo)
+	}
 
-	ca.certStore[serialNumber.String()] = certInfo
-
-	// Save to storage if configured
-	if ca.storePath != "" {
-		certPath := filepath.Join(ca.storePath, fmt.Sprintf("%s.crt", serialNumber.String()))
-		if err := os.WriteFile(certPath, newCertPEM, 0644); err != nil {
-			// Log but don't fail
-			fmt.Printf("Warning: Failed to save renewed certificate to storage: %v\n", err)
-		}
+	if err := ca.persist(certInfo); err != nil {
+		return nil, fmt.Errorf("failed to store renewed certificate: %w", err)
 	}
 
-	return newCertPEM, nil
+	ca.certStore[serialNumber.String()] = certInfo
+
+	return ca.fullChain(newCertPEM), nil
 }
 
-// RevokeCertificate marks a certificate as revoked
-func (ca *CertificateAuthority) RevokeCertificate(serialNumber string) error {
+// RevokeCertificate marks a certificate as revoked for the given reason
+func (ca *CertificateAuthority) RevokeCertificate(serialNumber string, reason RevocationReason) error {
 	ca.mu.Lock()
 	defer ca.mu.Unlock()
 
 	cert, ok := ca.certStore[serialNumber]
 	if !ok {
-		return fmt.Errorf("certificate with serial number %s not found", serialNumber)
+		return fmt.Errorf("%w: serial number %s", ErrCertificateNotFound, serialNumber)
+	}
+	if cert.IsRevoked {
+		return fmt.Errorf("%w: serial number %s", ErrCertificateAlreadyRevoked, serialNumber)
+	}
+
+	// Update a copy so a failed write leaves the in-memory state untouched
+	revoked := *cert
+	revoked.IsRevoked = true
+	revoked.RevocationReason = reason
+	revoked.RevokedAt = time.Now().UTC()
+	if err := ca.persist(&revoked); err != nil {
+		return fmt.Errorf("failed to store revocation: %w", err)
 	}
 
-	cert.IsRevoked = true
+	ca.certStore[serialNumber] = &revoked
+	ca.invalidateCRL()
 	return nil
 }
+
+// copyClientInfo returns a copy of the client information map
+func copyClientInfo(info map[string]string) map[string]string {
+	if len(info) == 0 {
+		return nil
+	}
+	copied := make(map[string]string, len(info))
+	for k, v := range info {
+		copied[k] = v
+	}
+	return copied
+}
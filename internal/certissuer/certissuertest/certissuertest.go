@@ -0,0 +1,18 @@
+// internal/certissuer/certissuertest/certissuertest.go
+// Package certissuertest provides test helpers for code creating
+// certificate authorities through package certissuer.
+package certissuertest
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certissuer"
+)
+
+// OfflineRootKey returns an option that writes the root key of a new CA to
+// its own temporary directory, standing in for offline storage.
+func OfflineRootKey(t testing.TB) certissuer.Option {
+	t.Helper()
+	return certissuer.WithRootKeyPath(filepath.Join(t.TempDir(), "root.key"))
+}
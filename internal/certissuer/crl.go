@@ -0,0 +1,235 @@
+// internal/certissuer/crl.go
+package certissuer
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/atomicfile"
+)
+
+const (
+	// CRLValidityPeriod is how long a generated CRL is valid (its nextUpdate).
+	CRLValidityPeriod = 24 * time.Hour
+
+	// CRLRefreshAfter is the age after which a cached CRL is regenerated,
+	// leaving clients plenty of overlap before nextUpdate.
+	CRLRefreshAfter = CRLValidityPeriod / 2
+)
+
+// ErrUnknownIssuer is returned for a CRL of an issuer this CA does not
+// hold, or no longer needs because all its certificates have expired.
+var ErrUnknownIssuer = errors.New("unknown CA issuer")
+
+// WithCRLDistributionPoint embeds url, followed by the issuing CA's ID, as
+// the CRL distribution point of every issued certificate. Each issuer's CRL
+// is served under its own URL so certificates from a replaced intermediate
+// can still be checked.
+func WithCRLDistributionPoint(url string) Option {
+	return func(ca *CertificateAuthority) {
+		if url != "" {
+			ca.crlDistributionPoints = append(ca.crlDistributionPoints, url)
+		}
+	}
+}
+
+// issuerCRLDistributionPoints returns the CRL URLs to embed in certificates
+// signed by the current issuer. The caller must hold ca.mu.
+func (ca *CertificateAuthority) issuerCRLDistributionPoints() []string {
+	if len(ca.crlDistributionPoints) == 0 {
+		return nil
+	}
+	id := issuerID(ca.caCert)
+	urls := make([]string, len(ca.crlDistributionPoints))
+	for i, url := range ca.crlDistributionPoints {
+		urls[i] = strings.TrimSuffix(url, "/") + "/" + id
+	}
+	return urls
+}
+
+// cachedCRL is the last CRL signed by one issuer.
+type cachedCRL struct {
+	der         []byte
+	generatedAt time.Time
+}
+
+// CRL returns the current issuer's DER-encoded CRL, regenerating it when it
+// is missing, older than CRLRefreshAfter, or invalidated by a revocation.
+func (ca *CertificateAuthority) CRL() ([]byte, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	crls, err := ca.refreshCRLs(false)
+	if err != nil {
+		return nil, err
+	}
+	return crls[0], nil
+}
+
+// IssuerCRL returns the DER-encoded CRL of the current or a retired
+// intermediate, identified by the ID in its CRL distribution point.
+func (ca *CertificateAuthority) IssuerCRL(id string) ([]byte, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	crls, err := ca.refreshCRLs(false)
+	if err != nil {
+		return nil, err
+	}
+	for i, issuer := range ca.issuers() {
+		if issuerID(issuer.cert) == id {
+			return crls[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownIssuer, id)
+}
+
+// CRLs returns the DER-encoded CRLs of the current issuer and of every
+// retired intermediate that still has unexpired certificates.
+func (ca *CertificateAuthority) CRLs() ([][]byte, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	return ca.refreshCRLs(false)
+}
+
+// GenerateCRL signs fresh CRLs for all issuers and returns the current
+// issuer's, which covers its revoked, unexpired certificates.
+func (ca *CertificateAuthority) GenerateCRL() ([]byte, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	crls, err := ca.refreshCRLs(true)
+	if err != nil {
+		return nil, err
+	}
+	return crls[0], nil
+}
+
+// refreshCRLs returns the CRLs of all issuers in the order of ca.issuers,
+// signing new ones where the cached CRL is stale or force is set. The
+// caller must hold ca.mu.
+func (ca *CertificateAuthority) refreshCRLs(force bool) ([][]byte, error) {
+	ca.pruneRetired()
+	if ca.crls == nil {
+		ca.crls = make(map[string]*cachedCRL)
+	}
+
+	issuers := ca.issuers()
+	crls := make([][]byte, len(issuers))
+	changed := false
+	for i, issuer := range issuers {
+		id := issuerID(issuer.cert)
+		if cached, ok := ca.crls[id]; ok && !force && time.Since(cached.generatedAt) < CRLRefreshAfter {
+			crls[i] = cached.der
+			continue
+		}
+		crlDER, err := ca.generateCRL(issuer)
+		if err != nil {
+			return nil, err
+		}
+		ca.crls[id] = &cachedCRL{der: crlDER, generatedAt: time.Now()}
+		crls[i] = crlDER
+		changed = true
+	}
+
+	// Keep a copy on disk for servers that read the CRL from a file: the
+	// current issuer's as ca.crl, and those of all issuers in crls.pem
+	if changed && ca.storePath != "" {
+		var bundle []byte
+		for _, crlDER := range crls {
+			bundle = append(bundle, EncodeCRLPEM(crlDER)...)
+		}
+		if err := atomicfile.WriteFile(osFs, filepath.Join(ca.storePath, "ca.crl"), crls[0], 0644); err != nil {
+			fmt.Printf("Warning: Failed to save CRL to storage: %v\n", err)
+		}
+		if err := atomicfile.WriteFile(osFs, filepath.Join(ca.storePath, "crls.pem"), bundle, 0644); err != nil {
+			fmt.Printf("Warning: Failed to save CRL bundle to storage: %v\n", err)
+		}
+	}
+	return crls, nil
+}
+
+// generateCRL signs a new CRL listing the revoked, unexpired certificates
+// issued by issuer. The caller must hold ca.mu.
+func (ca *CertificateAuthority) generateCRL(issuer *keyPair) ([]byte, error) {
+	number, err := ca.nextCRLNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	var entries []x509.RevocationListEntry
+	for _, cert := range ca.certStore {
+		if !cert.IsRevoked || now.After(cert.ExpiresAt) || !signedBy(cert.Cert, issuer.cert) {
+			continue
+		}
+		entries = append(entries, x509.RevocationListEntry{
+			SerialNumber:   cert.Serial,
+			RevocationTime: cert.RevokedAt,
+			ReasonCode:     int(cert.RevocationReason),
+		})
+	}
+
+	template := &x509.RevocationList{
+		Number:                    number,
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(CRLValidityPeriod),
+		RevokedCertificateEntries: entries,
+	}
+
+	crlDER, err := x509.CreateRevocationList(rand.Reader, template, issuer.cert, issuer.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CRL: %w", err)
+	}
+	return crlDER, nil
+}
+
+// invalidateCRL forces the next CRL request to regenerate. The caller must
+// hold ca.mu.
+func (ca *CertificateAuthority) invalidateCRL() {
+	ca.crls = nil
+}
+
+// nextCRLNumber increments and persists the monotonically increasing CRL
+// number required by RFC 5280. The caller must hold ca.mu.
+func (ca *CertificateAuthority) nextCRLNumber() (*big.Int, error) {
+	if ca.crlNumber == nil {
+		ca.crlNumber = big.NewInt(0)
+		if ca.storePath != "" {
+			data, err := os.ReadFile(filepath.Join(ca.storePath, "crl_number"))
+			if err == nil {
+				if _, ok := ca.crlNumber.SetString(strings.TrimSpace(string(data)), 10); !ok {
+					return nil, fmt.Errorf("invalid CRL number in storage: %q", data)
+				}
+			} else if !os.IsNotExist(err) {
+				return nil, fmt.Errorf("failed to read CRL number: %w", err)
+			}
+		}
+	}
+
+	next := new(big.Int).Add(ca.crlNumber, big.NewInt(1))
+	if ca.storePath != "" {
+		if err := atomicfile.WriteFile(osFs, filepath.Join(ca.storePath, "crl_number"), []byte(next.String()+"\n"), 0644); err != nil {
+			return nil, fmt.Errorf("failed to save CRL number: %w", err)
+		}
+	}
+	ca.crlNumber = next
+	return new(big.Int).Set(next), nil
+}
+
+// EncodeCRLPEM wraps a DER-encoded CRL in an "X509 CRL" PEM block.
+func EncodeCRLPEM(crlDER []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "X509 CRL",
+		Bytes: crlDER,
+	})
+}
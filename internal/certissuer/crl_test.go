@@ -0,0 +1,72 @@
+// internal/certissuer/crl_test.go
+package certissuer_test
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certissuer"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certissuer/certissuertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCRL(t *testing.T) {
+	tempDir := t.TempDir()
+	const crlURL = "https://ca.example.com/api/v1/crl"
+
+	ca, err := certissuer.NewCertificateAuthority(tempDir, certissuertest.OfflineRootKey(t), certissuer.WithCRLDistributionPoint(crlURL))
+	require.NoError(t, err)
+
+	csrPEM, _ := createTestCSR(t, "crl.example.com")
+	certPEM, err := ca.IssueCertificateFromCSR(csrPEM, map[string]string{})
+	require.NoError(t, err)
+
+	block, _ := pem.Decode(certPEM)
+	require.NotNil(t, block)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	require.Len(t, cert.CRLDistributionPoints, 1, "Issued certificates should carry the CRL distribution point")
+	issuerID, ok := strings.CutPrefix(cert.CRLDistributionPoints[0], crlURL+"/")
+	require.True(t, ok, "The CRL distribution point should name the issuer")
+	_, err = ca.IssuerCRL(issuerID)
+	require.NoError(t, err)
+	_, err = ca.IssuerCRL("unknown")
+	assert.ErrorIs(t, err, certissuer.ErrUnknownIssuer)
+
+	caBlock, _ := pem.Decode(ca.GetCACertificate())
+	caCert, err := x509.ParseCertificate(caBlock.Bytes)
+	require.NoError(t, err)
+
+	// Initially empty and signed by the CA
+	crlDER, err := ca.CRL()
+	require.NoError(t, err)
+	crl, err := x509.ParseRevocationList(crlDER)
+	require.NoError(t, err)
+	require.NoError(t, crl.CheckSignatureFrom(caCert))
+	assert.Empty(t, crl.RevokedCertificateEntries)
+	firstNumber := crl.Number
+
+	// Revocation invalidates the cached CRL
+	require.NoError(t, ca.RevokeCertificate(cert.SerialNumber.String(), certissuer.ReasonKeyCompromise))
+	crlDER, err = ca.CRL()
+	require.NoError(t, err)
+	crl, err = x509.ParseRevocationList(crlDER)
+	require.NoError(t, err)
+	require.Len(t, crl.RevokedCertificateEntries, 1)
+	assert.Equal(t, cert.SerialNumber, crl.RevokedCertificateEntries[0].SerialNumber)
+	assert.Equal(t, int(certissuer.ReasonKeyCompromise), crl.RevokedCertificateEntries[0].ReasonCode)
+	assert.Equal(t, 1, crl.Number.Cmp(firstNumber), "CRL number should increase")
+
+	// The CRL number keeps increasing across restarts
+	reloaded, err := certissuer.NewCertificateAuthority(tempDir)
+	require.NoError(t, err)
+	crlDER, err = reloaded.GenerateCRL()
+	require.NoError(t, err)
+	again, err := x509.ParseRevocationList(crlDER)
+	require.NoError(t, err)
+	assert.Equal(t, 1, again.Number.Cmp(crl.Number), "CRL number should survive a restart")
+	assert.Len(t, again.RevokedCertificateEntries, 1)
+}
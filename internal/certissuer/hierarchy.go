@@ -0,0 +1,416 @@
+// internal/certissuer/hierarchy.go
+package certissuer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/atomicfile"
+)
+
+// IntermediateValidityPeriod is how long a new intermediate CA is valid,
+// capped at the expiry of the root.
+const IntermediateValidityPeriod = 5 * 365 * 24 * time.Hour
+
+// ErrRootKeyMismatch is returned when rotating the intermediate with a key
+// that does not belong to the root certificate.
+var ErrRootKeyMismatch = errors.New("key does not match the root CA certificate")
+
+// WithRootKeyPath sets where the root key is kept, such as removable media,
+// when the CA keys are files. Creating a CA with a FileKeyProvider requires
+// it, and it must be outside the storage directory so that the root key is
+// never kept next to the online CA.
+func WithRootKeyPath(path string) Option {
+	return func(ca *CertificateAuthority) {
+		ca.rootKeyPath = path
+	}
+}
+
+// checkRootKeyPath refuses to create a CA whose root key would be written
+// to the storage directory, where FileKeyProvider keeps the online keys.
+func (ca *CertificateAuthority) checkRootKeyPath() error {
+	if _, ok := ca.keys.(*FileKeyProvider); !ok || ca.storePath == "" {
+		return nil
+	}
+	if ca.rootKeyPath == "" {
+		if Exists(ca.storePath) {
+			return nil
+		}
+		return fmt.Errorf("creating a certificate authority in %s needs a root key path outside it", ca.storePath)
+	}
+
+	store, err := filepath.Abs(ca.storePath)
+	if err != nil {
+		return fmt.Errorf("invalid certificate storage directory: %w", err)
+	}
+	rootKey, err := filepath.Abs(ca.rootKeyPath)
+	if err != nil {
+		return fmt.Errorf("invalid root key path: %w", err)
+	}
+	if rel, err := filepath.Rel(store, rootKey); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("root key path %s must be outside the certificate storage directory %s", ca.rootKeyPath, ca.storePath)
+	}
+	return nil
+}
+
+// retiredDir holds the intermediates replaced by a rotation, as <id>.crt
+// with their keys named retiredDir/<id>. They keep signing CRLs and OCSP
+// responses for the certificates they issued until the last one expires.
+const retiredDir = "intermediates"
+
+// pendingIntermediateKeyName is where a rotation generates the new
+// intermediate key before its certificate is saved.
+const pendingIntermediateKeyName = "intermediate-pending"
+
+// keyPair is a CA certificate together with its private key and the name
+// the key provider holds it under.
+type keyPair struct {
+	cert    *x509.Certificate
+	certPEM []byte
+	key     crypto.Signer
+	keyName string
+}
+
+// issuerID identifies a CA certificate in file names and CRL URLs by its
+// subject key identifier.
+func issuerID(cert *x509.Certificate) string {
+	if len(cert.SubjectKeyId) > 0 {
+		return hex.EncodeToString(cert.SubjectKeyId)
+	}
+	sum := sha1.Sum(cert.RawSubjectPublicKeyInfo)
+	return hex.EncodeToString(sum[:])
+}
+
+// retiredKeyName is the key name of a retired intermediate.
+func retiredKeyName(id string) string {
+	return retiredDir + "/" + id
+}
+
+// signedBy reports whether leaf was issued by the CA certificate issuer.
+func signedBy(leaf, issuer *x509.Certificate) bool {
+	if len(leaf.AuthorityKeyId) > 0 && len(issuer.SubjectKeyId) > 0 {
+		return bytes.Equal(leaf.AuthorityKeyId, issuer.SubjectKeyId)
+	}
+	return leaf.CheckSignatureFrom(issuer) == nil
+}
+
+// newIntermediate creates an intermediate CA signed by rootKey, using the
+// configured key algorithm, with its key stored under keyName. The caller
+// must have set ca.rootCert.
+func (ca *CertificateAuthority) newIntermediate(rootKey crypto.Signer, keyName string) (*keyPair, error) {
+	key, err := ca.keys.GenerateKey(keyName, ca.keyAlgorithm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate intermediate CA private key: %w", err)
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	now := time.Now()
+	notAfter := now.Add(IntermediateValidityPeriod)
+	if notAfter.After(ca.rootCert.NotAfter) {
+		notAfter = ca.rootCert.NotAfter
+	}
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			Organization:       []string{"Ubuntu Autoinstall Webhook CA"},
+			OrganizationalUnit: []string{"Certificate Authority"},
+			CommonName:         fmt.Sprintf("Ubuntu Autoinstall Webhook Intermediate CA %s", now.UTC().Format("2006-01-02")),
+		},
+		NotBefore:             now,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            0,
+		MaxPathLenZero:        true,
+	}
+
+	certBytes, err := x509.CreateCertificate(rand.Reader, &template, ca.rootCert, key.Public(), rootKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create intermediate CA certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse intermediate CA certificate: %w", err)
+	}
+
+	return &keyPair{
+		cert:    cert,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes}),
+		key:     key,
+		keyName: keyName,
+	}, nil
+}
+
+// setIssuer makes pair the CA that signs certificates, CRLs and OCSP responses
+func (ca *CertificateAuthority) setIssuer(pair *keyPair) {
+	ca.caCert = pair.cert
+	ca.caCertPEM = pair.certPEM
+	ca.caPrivKey = pair.key
+	ca.caKeyName = pair.keyName
+}
+
+// issuers returns the current issuer followed by the retired intermediates.
+// The caller must hold ca.mu.
+func (ca *CertificateAuthority) issuers() []*keyPair {
+	current := &keyPair{cert: ca.caCert, certPEM: ca.caCertPEM, key: ca.caPrivKey, keyName: ca.caKeyName}
+	return append([]*keyPair{current}, ca.retired...)
+}
+
+// saveIntermediate writes the intermediate certificate to storage; its key
+// was stored by the key provider when it was generated
+func (ca *CertificateAuthority) saveIntermediate(pair *keyPair) error {
+	if err := atomicfile.WriteFile(osFs, filepath.Join(ca.storePath, intermediateCertFile), pair.certPEM, 0644); err != nil {
+		return fmt.Errorf("failed to save intermediate CA certificate: %w", err)
+	}
+	return nil
+}
+
+// RotateIntermediate replaces the online intermediate with a new one signed
+// by rootKey. Certificates issued by the previous intermediate stay valid
+// until they expire and can still be renewed; the previous intermediate is
+// archived and keeps signing a CRL and OCSP responses for them. A
+// single-tier CA is converted in place: its certificate becomes the root of
+// the new hierarchy.
+func (ca *CertificateAuthority) RotateIntermediate(rootKey crypto.Signer) error {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	if !publicKeyMatches(rootKey, ca.rootCert) {
+		return ErrRootKeyMismatch
+	}
+
+	// A provider that would overwrite the current key gets the new one under
+	// a temporary name, so a failed rotation leaves the current pair intact
+	keyName := IntermediateKeyName
+	renamer, canRename := ca.keys.(keyRenamer)
+	if canRename && ca.storePath != "" {
+		keyName = pendingIntermediateKeyName
+	}
+	intermediate, err := ca.newIntermediate(rootKey, keyName)
+	if err != nil {
+		return err
+	}
+
+	previous := ca.issuers()[0]
+	if ca.storePath != "" {
+		rootPath := filepath.Join(ca.storePath, rootCertFile)
+		if _, err := os.Stat(rootPath); errors.Is(err, os.ErrNotExist) {
+			if err := atomicfile.WriteFile(osFs, rootPath, ca.rootCertPEM, 0644); err != nil {
+				return fmt.Errorf("failed to save root CA certificate: %w", err)
+			}
+		}
+		if err := ca.archiveIssuer(previous); err != nil {
+			return err
+		}
+		if err := ca.saveIntermediate(intermediate); err != nil {
+			return err
+		}
+	}
+
+	ca.retired = append(ca.retired, previous)
+	ca.setIssuer(intermediate)
+	ca.invalidateCRL()
+
+	// The rotation is complete once the certificate is saved: loadCA finds
+	// the keys under either name and finishes an interrupted rename
+	if keyName == pendingIntermediateKeyName {
+		if err := ca.finishRotation(renamer); err != nil {
+			fmt.Printf("Warning: failed to rename the new intermediate CA key: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// archiveIssuer saves the certificate of an intermediate that is about to
+// be replaced to the retired directory. Its key is moved alongside by
+// finishRotation.
+func (ca *CertificateAuthority) archiveIssuer(pair *keyPair) error {
+	dir := filepath.Join(ca.storePath, retiredDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create retired CA directory: %w", err)
+	}
+	path := filepath.Join(dir, issuerID(pair.cert)+".crt")
+	if err := atomicfile.WriteFile(osFs, path, pair.certPEM, 0644); err != nil {
+		return fmt.Errorf("failed to archive intermediate CA certificate: %w", err)
+	}
+	return nil
+}
+
+// finishRotation moves the retired intermediate key out of the way and
+// renames the pending key to the intermediate key name. The caller must
+// hold ca.mu.
+func (ca *CertificateAuthority) finishRotation(renamer keyRenamer) error {
+	for _, pair := range ca.retired {
+		if pair.keyName != IntermediateKeyName {
+			continue
+		}
+		name := retiredKeyName(issuerID(pair.cert))
+		if err := renamer.RenameKey(IntermediateKeyName, name); err != nil {
+			return err
+		}
+		pair.keyName = name
+	}
+	if err := renamer.RenameKey(pendingIntermediateKeyName, IntermediateKeyName); err != nil {
+		return err
+	}
+	ca.caKeyName = IntermediateKeyName
+	return nil
+}
+
+// loadRetired loads the archived intermediates that are still within their
+// validity. One whose key is gone can no longer sign a CRL for its
+// certificates, which is reported but not fatal. The caller must have set
+// the current issuer.
+func (ca *CertificateAuthority) loadRetired() error {
+	paths, err := filepath.Glob(filepath.Join(ca.storePath, retiredDir, "*.crt"))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, path := range paths {
+		id := strings.TrimSuffix(filepath.Base(path), ".crt")
+		// Before its key is renamed a retired intermediate is still held
+		// under the name it signed with
+		pair, err := ca.loadKeyPair(path, retiredKeyName(id), IntermediateKeyName, LegacyKeyName)
+		if err != nil {
+			fmt.Printf("Warning: retired intermediate CA %s cannot sign CRLs for its certificates: %v\n", id, err)
+			continue
+		}
+		if pair.cert.Equal(ca.caCert) || now.After(pair.cert.NotAfter) {
+			continue
+		}
+		ca.retired = append(ca.retired, pair)
+	}
+	return nil
+}
+
+// pruneRetired drops retired intermediates whose certificates have all
+// expired. The caller must hold ca.mu or have exclusive access.
+func (ca *CertificateAuthority) pruneRetired() {
+	now := time.Now()
+	kept := ca.retired[:0]
+	for _, pair := range ca.retired {
+		for _, cert := range ca.certStore {
+			if now.Before(cert.ExpiresAt) && signedBy(cert.Cert, pair.cert) {
+				kept = append(kept, pair)
+				break
+			}
+		}
+	}
+	ca.retired = kept
+}
+
+// IntermediateCertificate returns the certificate currently signing leaf
+// certificates, or nil for a single-tier CA.
+func (ca *CertificateAuthority) IntermediateCertificate() *x509.Certificate {
+	ca.mu.RLock()
+	defer ca.mu.RUnlock()
+
+	if ca.caCert.Equal(ca.rootCert) {
+		return nil
+	}
+	return ca.caCert
+}
+
+// issuerChainPEM returns the intermediate between issued certificates and
+// the root, or nothing for a single-tier CA. The caller must hold ca.mu.
+func (ca *CertificateAuthority) issuerChainPEM() []byte {
+	if ca.caCert.Equal(ca.rootCert) {
+		return nil
+	}
+	return ca.caCertPEM
+}
+
+// fullChain appends the issuer chain to a leaf certificate. The caller must
+// hold ca.mu.
+func (ca *CertificateAuthority) fullChain(leafPEM []byte) []byte {
+	chain := append([]byte{}, leafPEM...)
+	return append(chain, ca.issuerChainPEM()...)
+}
+
+// loadKeyPair reads a CA certificate, loads its private key from the key
+// provider and checks that they belong together. The key is looked up under
+// each of keyNames in turn.
+func (ca *CertificateAuthority) loadKeyPair(certPath string, keyNames ...string) (*keyPair, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := parseCertificatePEM(certPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate %s: %w", certPath, err)
+	}
+
+	var firstErr error
+	for _, keyName := range keyNames {
+		key, err := ca.keys.LoadKey(keyName, cert)
+		if err == nil && !publicKeyMatches(key, cert) {
+			err = fmt.Errorf("CA private key %q does not match certificate %s", keyName, certPath)
+		}
+		if err == nil {
+			return &keyPair{cert: cert, certPEM: certPEM, key: key, keyName: keyName}, nil
+		}
+		// Report a damaged key rather than one that is merely absent
+		if firstErr == nil || errors.Is(firstErr, ErrKeyNotFound) && !errors.Is(err, ErrKeyNotFound) {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
+
+// publicKeyMatches reports whether key belongs to cert.
+func publicKeyMatches(key crypto.Signer, cert *x509.Certificate) bool {
+	public, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	return ok && public.Equal(cert.PublicKey)
+}
+
+// RootKey loads the root CA key from the key provider, for rotating the
+// intermediate when the root key is kept by the provider rather than
+// offline.
+func (ca *CertificateAuthority) RootKey() (crypto.Signer, error) {
+	ca.mu.RLock()
+	rootCert := ca.rootCert
+	ca.mu.RUnlock()
+
+	name := RootKeyName
+	if ca.IntermediateCertificate() == nil {
+		name = LegacyKeyName
+	}
+	key, err := ca.keys.LoadKey(name, rootCert)
+	if err != nil {
+		return nil, err
+	}
+	if !publicKeyMatches(key, rootCert) {
+		return nil, ErrRootKeyMismatch
+	}
+	return key, nil
+}
+
+// parseCertificatePEM parses the first certificate in PEM data.
+func parseCertificatePEM(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no PEM certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
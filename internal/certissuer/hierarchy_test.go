@@ -0,0 +1,355 @@
+// internal/certissuer/hierarchy_test.go
+package certissuer_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certissuer"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certissuer/certissuertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ocsp"
+)
+
+// writeLegacyCA creates a single-tier CA directory the way older versions
+// did: ca.crt and a PKCS#1 ca.key.
+func writeLegacyCA(t *testing.T) string {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Ubuntu Autoinstall Webhook Root CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(certissuer.CAValidityPeriod),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLen:            1,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ca.crt"),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ca.key"),
+		pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600))
+	return dir
+}
+
+// parseChain parses every certificate in PEM data.
+func parseChain(t *testing.T, chainPEM []byte) []*x509.Certificate {
+	t.Helper()
+
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, chainPEM = pem.Decode(chainPEM)
+		if block == nil {
+			return certs
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		require.NoError(t, err)
+		certs = append(certs, cert)
+	}
+}
+
+// verifyLeaf checks that the issued chain leads to root.
+func verifyLeaf(t *testing.T, chainPEM []byte, root *x509.Certificate) {
+	t.Helper()
+
+	chain := parseChain(t, chainPEM)
+	require.NotEmpty(t, chain)
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	require.NoError(t, err)
+}
+
+func TestIntermediateHierarchy(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := certissuer.NewCertificateAuthority(dir, certissuertest.OfflineRootKey(t))
+	require.NoError(t, err)
+
+	// The CA chain is the intermediate followed by the root
+	chain := parseChain(t, ca.GetCACertificate())
+	require.Len(t, chain, 2)
+	intermediate, root := chain[0], chain[1]
+	assert.Equal(t, root.Raw, parsePEMCertificate(t, ca.RootCertificate()).Raw)
+	assert.True(t, intermediate.MaxPathLenZero)
+	require.NoError(t, intermediate.CheckSignatureFrom(root))
+	assert.Equal(t, intermediate.Raw, ca.IntermediateCertificate().Raw)
+
+	// Leaf certificates come with the intermediate and chain to the root
+	csrPEM, _ := createTestCSR(t, "leaf.example.com")
+	certPEM, err := ca.IssueCertificateFromCSR(csrPEM, map[string]string{})
+	require.NoError(t, err)
+	issued := parseChain(t, certPEM)
+	require.Len(t, issued, 2)
+	assert.Equal(t, intermediate.Raw, issued[1].Raw)
+	verifyLeaf(t, certPEM, root)
+
+	renewed, err := ca.RenewCertificate(certPEM)
+	require.NoError(t, err)
+	verifyLeaf(t, renewed, root)
+}
+
+func TestRootKeyPath(t *testing.T) {
+	dir := t.TempDir()
+	rootKeyPath := filepath.Join(t.TempDir(), "offline", "root.key")
+
+	_, err := certissuer.NewCertificateAuthority(dir, certissuer.WithRootKeyPath(rootKeyPath))
+	require.NoError(t, err)
+
+	assert.FileExists(t, rootKeyPath)
+	assert.NoFileExists(t, filepath.Join(dir, "root.key"), "The root key should not be written next to the online CA")
+
+	// A new CA needs a root key path outside the CA store
+	_, err = certissuer.NewCertificateAuthority(t.TempDir())
+	assert.Error(t, err)
+	other := t.TempDir()
+	_, err = certissuer.NewCertificateAuthority(other, certissuer.WithRootKeyPath(filepath.Join(other, "offline", "root.key")))
+	assert.Error(t, err)
+	assert.False(t, certissuer.Exists(other), "No CA should be created")
+
+	// A root key put back into the CA store keeps the CA from starting
+	rootKeyPEM, err := os.ReadFile(rootKeyPath)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "root.key"), rootKeyPEM, 0600))
+	_, err = certissuer.NewCertificateAuthority(dir)
+	assert.ErrorContains(t, err, "offline storage")
+}
+
+func TestRotateIntermediate(t *testing.T) {
+	dir := t.TempDir()
+	rootKeyPath := filepath.Join(t.TempDir(), "root.key")
+	ca, err := certissuer.NewCertificateAuthority(dir, certissuer.WithRootKeyPath(rootKeyPath))
+	require.NoError(t, err)
+	root := parsePEMCertificate(t, ca.RootCertificate())
+
+	csrPEM, _ := createTestCSR(t, "before.example.com")
+	oldCertPEM, err := ca.IssueCertificateFromCSR(csrPEM, map[string]string{})
+	require.NoError(t, err)
+
+	// The root key is brought back from offline storage to rotate
+	rootKeyPEM, err := os.ReadFile(rootKeyPath)
+	require.NoError(t, err)
+	rootKey, err := certissuer.ParsePrivateKey(rootKeyPEM)
+	require.NoError(t, err)
+
+	ca, err = certissuer.NewCertificateAuthority(dir)
+	require.NoError(t, err, "The CA should run without the root key")
+	oldIntermediate := ca.IntermediateCertificate()
+
+	// Only the root key can sign a new intermediate
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	assert.ErrorIs(t, ca.RotateIntermediate(otherKey), certissuer.ErrRootKeyMismatch)
+
+	require.NoError(t, ca.RotateIntermediate(rootKey))
+	newIntermediate := ca.IntermediateCertificate()
+	assert.NotEqual(t, oldIntermediate.Raw, newIntermediate.Raw)
+
+	csrPEM, _ = createTestCSR(t, "after.example.com")
+	newCertPEM, err := ca.IssueCertificateFromCSR(csrPEM, map[string]string{})
+	require.NoError(t, err)
+	verifyLeaf(t, newCertPEM, root)
+	assert.NoError(t, parsePEMCertificate(t, newCertPEM).CheckSignatureFrom(newIntermediate))
+
+	// Certificates from the previous intermediate can still be renewed
+	renewed, err := ca.RenewCertificate(oldCertPEM)
+	require.NoError(t, err)
+	assert.NoError(t, parsePEMCertificate(t, renewed).CheckSignatureFrom(newIntermediate))
+
+	// The CRL is now signed by the new intermediate
+	crlDER, err := ca.CRL()
+	require.NoError(t, err)
+	crl, err := x509.ParseRevocationList(crlDER)
+	require.NoError(t, err)
+	assert.NoError(t, crl.CheckSignatureFrom(newIntermediate))
+
+	// The rotation survives a restart
+	reloaded, err := certissuer.NewCertificateAuthority(dir)
+	require.NoError(t, err)
+	assert.Equal(t, newIntermediate.Raw, reloaded.IntermediateCertificate().Raw)
+}
+
+func TestRotateLegacyCA(t *testing.T) {
+	dir := writeLegacyCA(t)
+	legacyPEM, err := os.ReadFile(filepath.Join(dir, "ca.crt"))
+	require.NoError(t, err)
+	legacyKeyPEM, err := os.ReadFile(filepath.Join(dir, "ca.key"))
+	require.NoError(t, err)
+
+	ca, err := certissuer.NewCertificateAuthority(dir)
+	require.NoError(t, err)
+	assert.Nil(t, ca.IntermediateCertificate(), "A legacy CA signs directly with its root")
+	assert.Len(t, parseChain(t, ca.GetCACertificate()), 1)
+
+	csrPEM, _ := createTestCSR(t, "legacy.example.com")
+	certPEM, err := ca.IssueCertificateFromCSR(csrPEM, map[string]string{})
+	require.NoError(t, err)
+	assert.Len(t, parseChain(t, certPEM), 1)
+
+	// Rotating converts the CA: the old certificate becomes the root
+	rootKey, err := certissuer.ParsePrivateKey(legacyKeyPEM)
+	require.NoError(t, err)
+	require.NoError(t, ca.RotateIntermediate(rootKey))
+
+	reloaded, err := certissuer.NewCertificateAuthority(dir)
+	require.NoError(t, err)
+	assert.Equal(t, legacyPEM, reloaded.RootCertificate())
+	require.NotNil(t, reloaded.IntermediateCertificate())
+
+	csrPEM, _ = createTestCSR(t, "converted.example.com")
+	certPEM, err = reloaded.IssueCertificateFromCSR(csrPEM, map[string]string{})
+	require.NoError(t, err)
+	verifyLeaf(t, certPEM, parsePEMCertificate(t, legacyPEM))
+}
+
+func TestDamagedCAIsNotReplaced(t *testing.T) {
+	dir := t.TempDir()
+	_, err := certissuer.NewCertificateAuthority(dir, certissuertest.OfflineRootKey(t))
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "intermediate.key"), []byte("garbage"), 0600))
+	_, err = certissuer.NewCertificateAuthority(dir)
+	require.Error(t, err)
+}
+
+func TestRotatedIntermediateStillRevokes(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := certissuer.NewCertificateAuthority(dir, certissuertest.OfflineRootKey(t))
+	require.NoError(t, err)
+	oldIntermediate := ca.IntermediateCertificate()
+
+	csrPEM, _ := createTestCSR(t, "before.example.com")
+	oldCertPEM, err := ca.IssueCertificateFromCSR(csrPEM, map[string]string{})
+	require.NoError(t, err)
+	oldCert := parsePEMCertificate(t, oldCertPEM)
+
+	rootKey, err := ca.RootKey()
+	require.NoError(t, err)
+	require.NoError(t, ca.RotateIntermediate(rootKey))
+	newIntermediate := ca.IntermediateCertificate()
+
+	// The previous intermediate is archived and its key renamed with it
+	oldID := hex.EncodeToString(oldIntermediate.SubjectKeyId)
+	assert.FileExists(t, filepath.Join(dir, "intermediates", oldID+".crt"))
+	assert.FileExists(t, filepath.Join(dir, "intermediates", oldID+".key"))
+	assert.NoFileExists(t, filepath.Join(dir, "intermediate-pending.key"))
+
+	// Revoking a certificate of the previous intermediate shows up in its
+	// CRL and OCSP responses
+	require.NoError(t, ca.RevokeCertificate(oldCert.SerialNumber.String(), certissuer.ReasonKeyCompromise))
+
+	checkRevoked := func(ca *certissuer.CertificateAuthority) {
+		t.Helper()
+
+		crlDER, err := ca.IssuerCRL(oldID)
+		require.NoError(t, err)
+		crl, err := x509.ParseRevocationList(crlDER)
+		require.NoError(t, err)
+		require.NoError(t, crl.CheckSignatureFrom(oldIntermediate))
+		require.Len(t, crl.RevokedCertificateEntries, 1)
+		assert.Equal(t, oldCert.SerialNumber, crl.RevokedCertificateEntries[0].SerialNumber)
+
+		crls, err := ca.CRLs()
+		require.NoError(t, err)
+		assert.Len(t, crls, 2)
+
+		// The current intermediate's CRL only lists its own certificates
+		crlDER, err = ca.CRL()
+		require.NoError(t, err)
+		crl, err = x509.ParseRevocationList(crlDER)
+		require.NoError(t, err)
+		require.NoError(t, crl.CheckSignatureFrom(newIntermediate))
+		assert.Empty(t, crl.RevokedCertificateEntries)
+
+		req, err := ocsp.CreateRequest(oldCert, oldIntermediate, nil)
+		require.NoError(t, err)
+		respDER, err := ca.OCSPResponse(req)
+		require.NoError(t, err)
+		resp, err := ocsp.ParseResponseForCert(respDER, oldCert, oldIntermediate)
+		require.NoError(t, err)
+		assert.Equal(t, ocsp.Revoked, resp.Status)
+	}
+	checkRevoked(ca)
+
+	// The archive survives a restart
+	reloaded, err := certissuer.NewCertificateAuthority(dir)
+	require.NoError(t, err)
+	assert.Equal(t, newIntermediate.Raw, reloaded.IntermediateCertificate().Raw)
+	checkRevoked(reloaded)
+}
+
+func TestFailedRotationKeepsIntermediateKey(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := certissuer.NewCertificateAuthority(dir, certissuertest.OfflineRootKey(t))
+	require.NoError(t, err)
+	oldIntermediate := ca.IntermediateCertificate()
+	rootKey, err := ca.RootKey()
+	require.NoError(t, err)
+
+	// A directory in place of the certificate makes saving it fail
+	certPath := filepath.Join(dir, "intermediate.crt")
+	certPEM, err := os.ReadFile(certPath)
+	require.NoError(t, err)
+	require.NoError(t, os.Remove(certPath))
+	require.NoError(t, os.Mkdir(certPath, 0755))
+	require.Error(t, ca.RotateIntermediate(rootKey))
+	assert.Equal(t, oldIntermediate.Raw, ca.IntermediateCertificate().Raw)
+
+	require.NoError(t, os.Remove(certPath))
+	require.NoError(t, os.WriteFile(certPath, certPEM, 0644))
+	reloaded, err := certissuer.NewCertificateAuthority(dir)
+	require.NoError(t, err, "The intermediate key should still match its certificate")
+	assert.Equal(t, oldIntermediate.Raw, reloaded.IntermediateCertificate().Raw)
+}
+
+func TestInterruptedRotationIsFinished(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := certissuer.NewCertificateAuthority(dir, certissuertest.OfflineRootKey(t))
+	require.NoError(t, err)
+	oldID := hex.EncodeToString(ca.IntermediateCertificate().SubjectKeyId)
+	csrPEM, _ := createTestCSR(t, "before.example.com")
+	_, err = ca.IssueCertificateFromCSR(csrPEM, map[string]string{})
+	require.NoError(t, err)
+	rootKey, err := ca.RootKey()
+	require.NoError(t, err)
+	require.NoError(t, ca.RotateIntermediate(rootKey))
+
+	// Put the keys back where they were when the new certificate was saved
+	require.NoError(t, os.Rename(filepath.Join(dir, "intermediate.key"), filepath.Join(dir, "intermediate-pending.key")))
+	require.NoError(t, os.Rename(filepath.Join(dir, "intermediates", oldID+".key"), filepath.Join(dir, "intermediate.key")))
+
+	reloaded, err := certissuer.NewCertificateAuthority(dir)
+	require.NoError(t, err)
+	assert.Equal(t, ca.IntermediateCertificate().Raw, reloaded.IntermediateCertificate().Raw)
+	assert.NoFileExists(t, filepath.Join(dir, "intermediate-pending.key"))
+	assert.FileExists(t, filepath.Join(dir, "intermediates", oldID+".key"))
+
+	crls, err := reloaded.CRLs()
+	require.NoError(t, err)
+	assert.Len(t, crls, 2, "The previous intermediate should still sign its CRL")
+}
@@ -0,0 +1,73 @@
+// internal/certissuer/inventory.go
+package certissuer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/database"
+)
+
+// ErrHostNotFound is returned by an Inventory when no server has the name.
+var ErrHostNotFound = errors.New("no inventory server with this name")
+
+// InventoryHost is a known server as seen by the issuance policy.
+type InventoryHost struct {
+	ID          string
+	Hostname    string
+	IPAddresses []string
+}
+
+// Inventory resolves certificate names to known servers.
+type Inventory interface {
+	// FindHost returns the server a DNS name or IP address belongs to.
+	FindHost(ctx context.Context, name string) (*InventoryHost, error)
+}
+
+// DatabaseInventory resolves names against the servers table.
+type DatabaseInventory struct {
+	servers database.ServerRepository
+	domain  string
+}
+
+// NewDatabaseInventory returns an Inventory backed by the server repository.
+// Servers registered by short hostname are found under domain, e.g. web01
+// as web01.lab.example.com; with an empty domain only exact names match.
+func NewDatabaseInventory(servers database.ServerRepository, domain string) *DatabaseInventory {
+	return &DatabaseInventory{servers: servers, domain: strings.ToLower(strings.Trim(domain, "."))}
+}
+
+// FindHost implements Inventory. IP addresses match a server's address; DNS
+// names match its hostname exactly, or as the first label of a name directly
+// under the inventory domain.
+func (d *DatabaseInventory) FindHost(ctx context.Context, name string) (*InventoryHost, error) {
+	var filters []database.ServerFilter
+	if ip := net.ParseIP(name); ip != nil {
+		filters = append(filters, database.ServerFilter{IPAddress: ip.String()})
+	} else {
+		name = strings.ToLower(name)
+		filters = append(filters, database.ServerFilter{Hostname: name})
+		if short, domain, ok := strings.Cut(name, "."); ok && d.domain != "" && domain == d.domain {
+			filters = append(filters, database.ServerFilter{Hostname: short})
+		}
+	}
+
+	for _, filter := range filters {
+		page, err := d.servers.List(ctx, filter, database.ListOptions{PageSize: 1})
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up inventory server: %w", err)
+		}
+		if len(page.Items) > 0 {
+			server := page.Items[0]
+			host := &InventoryHost{ID: server.ID, Hostname: server.Hostname}
+			if server.IPAddress != "" {
+				host.IPAddresses = []string{server.IPAddress}
+			}
+			return host, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrHostNotFound, name)
+}
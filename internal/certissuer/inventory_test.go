@@ -0,0 +1,59 @@
+// internal/certissuer/inventory_test.go
+package certissuer_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certissuer"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/configuration"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabaseInventory(t *testing.T) {
+	ctx := context.Background()
+	db := database.NewServiceWithConfig(configuration.DatabaseConfig{
+		Type:   "sqlite",
+		SQLite: configuration.SQLiteConfig{Path: filepath.Join(t.TempDir(), "inventory.sqlite")},
+	})
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, db.Connect(ctx))
+	require.NoError(t, db.MigrateSchema(ctx))
+
+	short := &database.Server{Hostname: "web1", MACAddress: "aa-bb-cc-dd-ee-01", IPAddress: "10.0.0.11"}
+	full := &database.Server{Hostname: "db1.other.example.com", MACAddress: "aa-bb-cc-dd-ee-02"}
+	require.NoError(t, db.Servers().Create(ctx, short))
+	require.NoError(t, db.Servers().Create(ctx, full))
+
+	inventory := certissuer.NewDatabaseInventory(db.Servers(), "lab.example.com")
+	tests := []struct {
+		name   string
+		server *database.Server
+	}{
+		{"web1", short},
+		{"WEB1.lab.example.com", short},
+		{"10.0.0.11", short},
+		{"db1.other.example.com", full},
+		{"web1.attacker.example", nil},
+		{"web1.sub.lab.example.com", nil},
+		{"db1.lab.example.com", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, err := inventory.FindHost(ctx, tt.name)
+			if tt.server == nil {
+				assert.ErrorIs(t, err, certissuer.ErrHostNotFound)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.server.ID, host.ID)
+		})
+	}
+
+	// Without a domain only exact hostnames match
+	_, err := certissuer.NewDatabaseInventory(db.Servers(), "").FindHost(ctx, "web1.lab.example.com")
+	assert.ErrorIs(t, err, certissuer.ErrHostNotFound)
+}
@@ -0,0 +1,221 @@
+// internal/certissuer/keyprovider.go
+package certissuer
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/atomicfile"
+)
+
+// Names of the CA keys held by a KeyProvider.
+const (
+	RootKeyName         = "root"
+	IntermediateKeyName = "intermediate"
+	// LegacyKeyName is the key of a single-tier CA from an older version.
+	LegacyKeyName = "ca"
+)
+
+// ErrKeyNotFound is returned by a KeyProvider that holds no matching key.
+var ErrKeyNotFound = errors.New("CA private key not found")
+
+// KeyProvider creates and holds the CA private keys. The CA only ever signs
+// through the returned crypto.Signer, so a provider backed by a hardware
+// token never has to reveal the key.
+type KeyProvider interface {
+	// GenerateKey creates and stores a new key under name, replacing any
+	// key a file-based provider kept there.
+	GenerateKey(name string, alg KeyAlgorithm) (crypto.Signer, error)
+	// LoadKey returns the key stored under name for cert.
+	LoadKey(name string, cert *x509.Certificate) (crypto.Signer, error)
+}
+
+// keyRenamer is implemented by providers whose GenerateKey replaces the key
+// already stored under a name. The CA generates a replacement intermediate
+// key under a temporary name and renames it once its certificate is saved.
+type keyRenamer interface {
+	RenameKey(from, to string) error
+}
+
+// WithKeyProvider makes the CA keep its keys in provider instead of PEM
+// files in the storage directory.
+func WithKeyProvider(provider KeyProvider) Option {
+	return func(ca *CertificateAuthority) {
+		ca.keys = provider
+	}
+}
+
+// FileKeyProvider keeps keys as PKCS#8 PEM files named <name>.key in a
+// directory. With a passphrase the files are encrypted at rest.
+type FileKeyProvider struct {
+	dir        string
+	passphrase []byte
+	paths      map[string]string
+}
+
+// NewFileKeyProvider stores unencrypted keys in dir.
+func NewFileKeyProvider(dir string) *FileKeyProvider {
+	return &FileKeyProvider{dir: dir, paths: make(map[string]string)}
+}
+
+// NewEncryptedFileKeyProvider stores keys in dir encrypted with passphrase.
+// Unencrypted keys found there are encrypted when first loaded.
+func NewEncryptedFileKeyProvider(dir string, passphrase []byte) (*FileKeyProvider, error) {
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("an empty passphrase cannot protect the CA keys")
+	}
+	p := NewFileKeyProvider(dir)
+	p.passphrase = passphrase
+	return p, nil
+}
+
+// SetKeyPath stores the key called name at path instead of in the
+// provider's directory.
+func (p *FileKeyProvider) SetKeyPath(name, path string) {
+	p.paths[name] = path
+}
+
+// KeyPath returns the file holding the key called name.
+func (p *FileKeyProvider) KeyPath(name string) string {
+	if path, ok := p.paths[name]; ok {
+		return path
+	}
+	return filepath.Join(p.dir, name+".key")
+}
+
+// GenerateKey implements KeyProvider.
+func (p *FileKeyProvider) GenerateKey(name string, alg KeyAlgorithm) (crypto.Signer, error) {
+	key, err := generateKey(alg)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.writeKey(name, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// LoadKey implements KeyProvider.
+func (p *FileKeyProvider) LoadKey(name string, cert *x509.Certificate) (crypto.Signer, error) {
+	path := p.KeyPath(name)
+	keyPEM, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var key crypto.Signer
+	if len(p.passphrase) > 0 {
+		key, err = ParseEncryptedPrivateKey(keyPEM, p.passphrase)
+	} else {
+		key, err = ParsePrivateKey(keyPEM)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CA private key %s: %w", path, err)
+	}
+
+	if len(p.passphrase) > 0 && !isEncryptedKey(keyPEM) {
+		if err := p.writeKey(name, key); err != nil {
+			return nil, err
+		}
+		fmt.Printf("Encrypted CA private key %s\n", path)
+	}
+	return key, nil
+}
+
+// RenameKey moves the key stored under from to the name to.
+func (p *FileKeyProvider) RenameKey(from, to string) error {
+	oldPath, newPath := p.KeyPath(from), p.KeyPath(to)
+	if err := os.MkdirAll(filepath.Dir(newPath), 0700); err != nil {
+		return fmt.Errorf("failed to create CA key directory: %w", err)
+	}
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return fmt.Errorf("failed to rename CA private key %s: %w", oldPath, err)
+	}
+	for _, dir := range []string{filepath.Dir(oldPath), filepath.Dir(newPath)} {
+		if err := atomicfile.SyncDir(osFs, dir); err != nil {
+			return fmt.Errorf("failed to sync CA key directory: %w", err)
+		}
+	}
+	return nil
+}
+
+// writeKey saves key under name, encrypting it if the provider has a
+// passphrase.
+func (p *FileKeyProvider) writeKey(name string, key crypto.Signer) error {
+	var keyPEM []byte
+	var err error
+	if len(p.passphrase) > 0 {
+		keyPEM, err = EncryptPrivateKey(key, p.passphrase)
+	} else {
+		keyPEM, err = encodePrivateKey(key)
+	}
+	if err != nil {
+		return err
+	}
+
+	path := p.KeyPath(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create CA key directory: %w", err)
+	}
+	if err := atomicfile.WriteFile(osFs, path, keyPEM, 0600); err != nil {
+		return fmt.Errorf("failed to save CA private key %s: %w", path, err)
+	}
+	return nil
+}
+
+// DefaultPKCS11KeyLabelPrefix is prepended to key names to label the CA
+// key objects on a PKCS#11 token.
+const DefaultPKCS11KeyLabelPrefix = "autoinstall-ca-"
+
+// PKCS11Config selects the token a PKCS11KeyProvider keeps the CA keys on.
+// The provider itself is only available in builds with the pkcs11 tag,
+// which needs cgo and github.com/miekg/pkcs11.
+type PKCS11Config struct {
+	// Module is the PKCS#11 library, e.g. /usr/lib/softhsm/libsofthsm2.so.
+	Module string
+	// TokenLabel selects the token.
+	TokenLabel string
+	// PIN is the token's user PIN.
+	PIN string
+	// KeyLabelPrefix overrides DefaultPKCS11KeyLabelPrefix.
+	KeyLabelPrefix string
+}
+
+// memoryKeyProvider keeps the keys of a CA without a storage directory.
+type memoryKeyProvider struct {
+	mu   sync.Mutex
+	keys map[string]crypto.Signer
+}
+
+func newMemoryKeyProvider() *memoryKeyProvider {
+	return &memoryKeyProvider{keys: make(map[string]crypto.Signer)}
+}
+
+func (p *memoryKeyProvider) GenerateKey(name string, alg KeyAlgorithm) (crypto.Signer, error) {
+	key, err := generateKey(alg)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys[name] = key
+	return key, nil
+}
+
+func (p *memoryKeyProvider) LoadKey(name string, cert *x509.Certificate) (crypto.Signer, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key, ok := p.keys[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, name)
+	}
+	return key, nil
+}
@@ -0,0 +1,137 @@
+// internal/certissuer/keyprovider_test.go
+package certissuer_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certissuer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptPrivateKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	keyPEM, err := certissuer.EncryptPrivateKey(key, []byte("correct horse"))
+	require.NoError(t, err)
+	block, _ := pem.Decode(keyPEM)
+	require.NotNil(t, block)
+	assert.Equal(t, "ENCRYPTED PRIVATE KEY", block.Type)
+
+	decrypted, err := certissuer.ParseEncryptedPrivateKey(keyPEM, []byte("correct horse"))
+	require.NoError(t, err)
+	assert.True(t, key.Equal(decrypted))
+
+	_, err = certissuer.ParseEncryptedPrivateKey(keyPEM, []byte("battery staple"))
+	assert.ErrorIs(t, err, certissuer.ErrIncorrectPassphrase)
+	_, err = certissuer.ParsePrivateKey(keyPEM)
+	assert.Error(t, err, "An encrypted key cannot be parsed without a passphrase")
+}
+
+func TestEncryptedKeyOpenSSLCompatibility(t *testing.T) {
+	openssl, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Skip("openssl is not installed")
+	}
+	dir := t.TempDir()
+
+	// OpenSSL reads our keys
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	keyPEM, err := certissuer.EncryptPrivateKey(key, []byte("secret"))
+	require.NoError(t, err)
+	ourPath := filepath.Join(dir, "ours.key")
+	require.NoError(t, os.WriteFile(ourPath, keyPEM, 0600))
+	out, err := exec.Command(openssl, "pkey", "-in", ourPath, "-passin", "pass:secret", "-noout").CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	// and we read OpenSSL's
+	theirPath := filepath.Join(dir, "theirs.key")
+	out, err = exec.Command(openssl, "genpkey", "-algorithm", "EC", "-pkeyopt", "ec_paramgen_curve:P-256",
+		"-aes-256-cbc", "-pass", "pass:secret", "-out", theirPath).CombinedOutput()
+	require.NoError(t, err, string(out))
+	theirPEM, err := os.ReadFile(theirPath)
+	require.NoError(t, err)
+	_, err = certissuer.ParseEncryptedPrivateKey(theirPEM, []byte("secret"))
+	assert.NoError(t, err)
+}
+
+func TestEncryptedFileKeyProvider(t *testing.T) {
+	dir := t.TempDir()
+	passphrase := []byte("ca passphrase")
+	keys, err := certissuer.NewEncryptedFileKeyProvider(dir, passphrase)
+	require.NoError(t, err)
+
+	rootKeyPath := filepath.Join(t.TempDir(), "root.key")
+	ca, err := certissuer.NewCertificateAuthority(dir, certissuer.WithKeyProvider(keys),
+		certissuer.WithRootKeyPath(rootKeyPath), certissuer.WithKeyAlgorithm(certissuer.KeyAlgorithmECDSAP256))
+	require.NoError(t, err)
+
+	for _, path := range []string{rootKeyPath, filepath.Join(dir, "intermediate.key")} {
+		keyPEM, err := os.ReadFile(path)
+		require.NoError(t, err)
+		block, _ := pem.Decode(keyPEM)
+		require.NotNil(t, block)
+		assert.Equal(t, "ENCRYPTED PRIVATE KEY", block.Type, path)
+	}
+
+	// Reloading needs the passphrase
+	_, err = certissuer.NewCertificateAuthority(dir)
+	assert.Error(t, err)
+	wrong, err := certissuer.NewEncryptedFileKeyProvider(dir, []byte("wrong"))
+	require.NoError(t, err)
+	_, err = certissuer.NewCertificateAuthority(dir, certissuer.WithKeyProvider(wrong))
+	assert.ErrorIs(t, err, certissuer.ErrIncorrectPassphrase)
+
+	reloaded, err := certissuer.NewCertificateAuthority(dir, certissuer.WithKeyProvider(keys))
+	require.NoError(t, err)
+	assert.Equal(t, ca.GetCACertificate(), reloaded.GetCACertificate())
+
+	csrPEM, _ := createTestCSR(t, "encrypted.example.com")
+	certPEM, err := reloaded.IssueCertificateFromCSR(csrPEM, map[string]string{})
+	require.NoError(t, err)
+	verifyLeaf(t, certPEM, parsePEMCertificate(t, reloaded.RootCertificate()))
+
+	// The root key, mounted from offline storage, decrypts with the same passphrase
+	rootKey, err := reloaded.RootKey()
+	require.NoError(t, err)
+	require.NoError(t, reloaded.RotateIntermediate(rootKey))
+	_, err = certissuer.NewCertificateAuthority(dir, certissuer.WithKeyProvider(keys))
+	assert.NoError(t, err)
+}
+
+func TestEncryptExistingKeys(t *testing.T) {
+	dir := writeLegacyCA(t)
+	keys, err := certissuer.NewEncryptedFileKeyProvider(dir, []byte("migrate"))
+	require.NoError(t, err)
+
+	_, err = certissuer.NewCertificateAuthority(dir, certissuer.WithKeyProvider(keys))
+	require.NoError(t, err)
+
+	keyPEM, err := os.ReadFile(filepath.Join(dir, "ca.key"))
+	require.NoError(t, err)
+	block, _ := pem.Decode(keyPEM)
+	require.NotNil(t, block)
+	assert.Equal(t, "ENCRYPTED PRIVATE KEY", block.Type, "A plaintext key should be encrypted on first load")
+
+	_, err = certissuer.NewCertificateAuthority(dir, certissuer.WithKeyProvider(keys))
+	assert.NoError(t, err)
+}
+
+func TestRootKeyPathNeedsFileKeys(t *testing.T) {
+	_, err := certissuer.NewCertificateAuthority(t.TempDir(),
+		certissuer.WithKeyProvider(&certissuer.PKCS11KeyProvider{}),
+		certissuer.WithRootKeyPath(filepath.Join(t.TempDir(), "root.key")))
+	assert.Error(t, err)
+
+	_, err = certissuer.NewEncryptedFileKeyProvider(t.TempDir(), nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,158 @@
+// internal/certissuer/keys.go
+package certissuer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// KeyAlgorithm selects the type and size of the CA private key.
+type KeyAlgorithm string
+
+// Supported CA key algorithms. Ed25519 CAs cannot sign OCSP responses, as
+// RFC 6960 responders only support RSA and ECDSA signatures in practice.
+const (
+	KeyAlgorithmRSA2048   KeyAlgorithm = "rsa2048"
+	KeyAlgorithmRSA3072   KeyAlgorithm = "rsa3072"
+	KeyAlgorithmRSA4096   KeyAlgorithm = "rsa4096"
+	KeyAlgorithmECDSAP256 KeyAlgorithm = "ecdsa-p256"
+	KeyAlgorithmECDSAP384 KeyAlgorithm = "ecdsa-p384"
+	KeyAlgorithmEd25519   KeyAlgorithm = "ed25519"
+
+	// DefaultKeyAlgorithm keeps the historical RSA-2048 CA key.
+	DefaultKeyAlgorithm = KeyAlgorithmRSA2048
+)
+
+// ErrUnsupportedKey is returned for CA key algorithms or CSR public keys
+// the CA does not accept.
+var ErrUnsupportedKey = errors.New("unsupported key")
+
+// KeyAlgorithms lists every supported CA key algorithm.
+func KeyAlgorithms() []KeyAlgorithm {
+	return []KeyAlgorithm{
+		KeyAlgorithmRSA2048,
+		KeyAlgorithmRSA3072,
+		KeyAlgorithmRSA4096,
+		KeyAlgorithmECDSAP256,
+		KeyAlgorithmECDSAP384,
+		KeyAlgorithmEd25519,
+	}
+}
+
+// ParseKeyAlgorithm parses a key algorithm name, case-insensitively.
+func ParseKeyAlgorithm(s string) (KeyAlgorithm, error) {
+	for _, alg := range KeyAlgorithms() {
+		if strings.EqualFold(s, string(alg)) {
+			return alg, nil
+		}
+	}
+	return "", fmt.Errorf("%w: algorithm %q", ErrUnsupportedKey, s)
+}
+
+// WithKeyAlgorithm sets the key algorithm used when a new CA is created. An
+// existing CA keeps the key it already has.
+func WithKeyAlgorithm(alg KeyAlgorithm) Option {
+	return func(ca *CertificateAuthority) {
+		ca.keyAlgorithm = alg
+	}
+}
+
+// generateKey creates a private key of the given algorithm.
+func generateKey(alg KeyAlgorithm) (crypto.Signer, error) {
+	switch alg {
+	case KeyAlgorithmRSA2048:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case KeyAlgorithmRSA3072:
+		return rsa.GenerateKey(rand.Reader, 3072)
+	case KeyAlgorithmRSA4096:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case KeyAlgorithmECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyAlgorithmECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyAlgorithmEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	default:
+		return nil, fmt.Errorf("%w: algorithm %q", ErrUnsupportedKey, alg)
+	}
+}
+
+// encodePrivateKey PEM-encodes a private key as PKCS#8.
+func encodePrivateKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal private key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// ParsePrivateKey parses a PEM private key. PKCS#8 is preferred; PKCS#1
+// RSA and SEC 1 EC keys written by older versions are still accepted.
+func ParsePrivateKey(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode private key PEM")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "ENCRYPTED PRIVATE KEY":
+		return nil, fmt.Errorf("private key is encrypted and needs a passphrase")
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, key)
+	}
+	return signer, nil
+}
+
+// checkPublicKey rejects CSR keys the CA will not certify: RSA keys shorter
+// than 2048 bits and ECDSA curves other than P-256, P-384 and P-521.
+func checkPublicKey(pub crypto.PublicKey) error {
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		if key.N.BitLen() < 2048 {
+			return fmt.Errorf("%w: RSA key of %d bits is too short", ErrUnsupportedKey, key.N.BitLen())
+		}
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256(), elliptic.P384(), elliptic.P521():
+		default:
+			return fmt.Errorf("%w: ECDSA curve %s", ErrUnsupportedKey, key.Curve.Params().Name)
+		}
+	case ed25519.PublicKey:
+	default:
+		return fmt.Errorf("%w: %T", ErrUnsupportedKey, pub)
+	}
+	return nil
+}
+
+// leafKeyUsage returns the key usage for a certificate over pub. Key
+// encipherment only applies to RSA key transport.
+func leafKeyUsage(pub crypto.PublicKey) x509.KeyUsage {
+	if _, ok := pub.(*rsa.PublicKey); ok {
+		return x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	}
+	return x509.KeyUsageDigitalSignature
+}
@@ -0,0 +1,133 @@
+// internal/certissuer/keys_test.go
+package certissuer_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certissuer"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certissuer/certissuertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createCSRWithKey builds a PEM CSR signed by key.
+func createCSRWithKey(t *testing.T, commonName string, key crypto.Signer) []byte {
+	t.Helper()
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: commonName},
+	}, key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+func TestCAKeyAlgorithms(t *testing.T) {
+	expected := map[certissuer.KeyAlgorithm]x509.PublicKeyAlgorithm{
+		certissuer.KeyAlgorithmRSA2048:   x509.RSA,
+		certissuer.KeyAlgorithmRSA3072:   x509.RSA,
+		certissuer.KeyAlgorithmRSA4096:   x509.RSA,
+		certissuer.KeyAlgorithmECDSAP256: x509.ECDSA,
+		certissuer.KeyAlgorithmECDSAP384: x509.ECDSA,
+		certissuer.KeyAlgorithmEd25519:   x509.Ed25519,
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	csrKeys := map[string]crypto.Signer{"rsa": rsaKey, "ecdsa": ecKey, "ed25519": edKey}
+
+	for _, alg := range certissuer.KeyAlgorithms() {
+		t.Run(string(alg), func(t *testing.T) {
+			if testing.Short() && (alg == certissuer.KeyAlgorithmRSA3072 || alg == certissuer.KeyAlgorithmRSA4096) {
+				t.Skip("large RSA key generation is slow")
+			}
+
+			dir := t.TempDir()
+			rootKeyPath := filepath.Join(t.TempDir(), "root.key")
+			ca, err := certissuer.NewCertificateAuthority(dir, certissuer.WithRootKeyPath(rootKeyPath), certissuer.WithKeyAlgorithm(alg))
+			require.NoError(t, err)
+
+			caCert := parsePEMCertificate(t, ca.GetCACertificate())
+			assert.Equal(t, expected[alg], caCert.PublicKeyAlgorithm)
+
+			// The keys are stored as PKCS#8 and reload into the same CA
+			for _, path := range []string{rootKeyPath, filepath.Join(dir, "intermediate.key")} {
+				keyPEM, err := os.ReadFile(path)
+				require.NoError(t, err)
+				block, _ := pem.Decode(keyPEM)
+				require.NotNil(t, block)
+				assert.Equal(t, "PRIVATE KEY", block.Type, path)
+			}
+
+			reloaded, err := certissuer.NewCertificateAuthority(dir)
+			require.NoError(t, err)
+			assert.Equal(t, ca.GetCACertificate(), reloaded.GetCACertificate())
+
+			// Any supported CSR key type can be certified
+			for name, key := range csrKeys {
+				certPEM, err := reloaded.IssueCertificateFromCSR(createCSRWithKey(t, name+".example.com", key), map[string]string{})
+				require.NoError(t, err, name)
+				cert := parsePEMCertificate(t, certPEM)
+				require.NoError(t, cert.CheckSignatureFrom(caCert), name)
+				assert.Equal(t, name == "rsa", cert.KeyUsage&x509.KeyUsageKeyEncipherment != 0,
+					"Only RSA certificates should allow key encipherment")
+			}
+
+			crlDER, err := reloaded.CRL()
+			require.NoError(t, err)
+			crl, err := x509.ParseRevocationList(crlDER)
+			require.NoError(t, err)
+			assert.NoError(t, crl.CheckSignatureFrom(caCert))
+		})
+	}
+}
+
+func TestLoadPKCS1CAKey(t *testing.T) {
+	dir := writeLegacyCA(t)
+	legacyPEM, err := os.ReadFile(filepath.Join(dir, "ca.crt"))
+	require.NoError(t, err)
+
+	ca, err := certissuer.NewCertificateAuthority(dir, certissuertest.OfflineRootKey(t), certissuer.WithKeyAlgorithm(certissuer.KeyAlgorithmEd25519))
+	require.NoError(t, err)
+	assert.Equal(t, legacyPEM, ca.GetCACertificate(), "An existing CA should keep its key")
+
+	csrPEM, _ := createTestCSR(t, "legacy.example.com")
+	certPEM, err := ca.IssueCertificateFromCSR(csrPEM, map[string]string{})
+	require.NoError(t, err)
+	assert.NoError(t, parsePEMCertificate(t, certPEM).CheckSignatureFrom(parsePEMCertificate(t, legacyPEM)))
+}
+
+func TestRejectWeakCSRKeys(t *testing.T) {
+	ca, err := certissuer.NewCertificateAuthority(t.TempDir(), certissuertest.OfflineRootKey(t))
+	require.NoError(t, err)
+
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	_, err = ca.IssueCertificateFromCSR(createCSRWithKey(t, "weak.example.com", weak), map[string]string{})
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, certissuer.ErrUnsupportedKey))
+}
+
+func TestParseKeyAlgorithm(t *testing.T) {
+	alg, err := certissuer.ParseKeyAlgorithm("ECDSA-P256")
+	require.NoError(t, err)
+	assert.Equal(t, certissuer.KeyAlgorithmECDSAP256, alg)
+
+	_, err = certissuer.ParseKeyAlgorithm("dsa")
+	assert.ErrorIs(t, err, certissuer.ErrUnsupportedKey)
+}
@@ -0,0 +1,164 @@
+// internal/certissuer/list.go
+package certissuer
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultPageSize is used when a list request does not specify a page size.
+	DefaultPageSize = 50
+
+	// MaxPageSize caps the number of certificates returned per page.
+	MaxPageSize = 1000
+)
+
+// ErrInvalidPageToken is returned when a page token cannot be decoded.
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// CertificateFilter selects certificates in ListCertificates. Zero values do
+// not filter.
+type CertificateFilter struct {
+	IncludeRevoked bool
+	IncludeExpired bool
+	// Subject and IssuedTo match case-insensitive substrings
+	Subject      string
+	IssuedTo     string
+	IssuedAfter  time.Time
+	IssuedBefore time.Time
+}
+
+// CertificatePage is one page of a certificate listing.
+type CertificatePage struct {
+	Certificates  []*Certificate
+	NextPageToken string
+	// TotalCount is the number of certificates matching the filter across all pages
+	TotalCount int
+}
+
+// matches reports whether cert passes the filter at the given time
+func (f CertificateFilter) matches(cert *Certificate, now time.Time) bool {
+	if cert.IsRevoked && !f.IncludeRevoked {
+		return false
+	}
+	if now.After(cert.ExpiresAt) && !f.IncludeExpired {
+		return false
+	}
+	if f.Subject != "" && !containsFold(cert.Cert.Subject.String(), f.Subject) {
+		return false
+	}
+	if f.IssuedTo != "" && !containsFold(cert.IssuedTo, f.IssuedTo) {
+		return false
+	}
+	if !f.IssuedAfter.IsZero() && !cert.IssuedAt.After(f.IssuedAfter) {
+		return false
+	}
+	if !f.IssuedBefore.IsZero() && !cert.IssuedAt.Before(f.IssuedBefore) {
+		return false
+	}
+	return true
+}
+
+// ListCertificates returns certificates matching filter ordered by issue
+// time, oldest first. pageToken is the NextPageToken of a previous page.
+func (ca *CertificateAuthority) ListCertificates(filter CertificateFilter, pageSize int, pageToken string) (*CertificatePage, error) {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	if pageSize > MaxPageSize {
+		pageSize = MaxPageSize
+	}
+
+	var after *pageCursor
+	if pageToken != "" {
+		cursor, err := decodePageCursor(pageToken)
+		if err != nil {
+			return nil, err
+		}
+		after = cursor
+	}
+
+	ca.mu.RLock()
+	now := time.Now()
+	var matched []*Certificate
+	for _, cert := range ca.certStore {
+		if filter.matches(cert, now) {
+			copied := *cert
+			matched = append(matched, &copied)
+		}
+	}
+	ca.mu.RUnlock()
+
+	sort.Slice(matched, func(i, j int) bool {
+		return cursorOf(matched[i]).less(cursorOf(matched[j]))
+	})
+
+	page := &CertificatePage{TotalCount: len(matched)}
+	start := 0
+	if after != nil {
+		start = sort.Search(len(matched), func(i int) bool {
+			return after.less(cursorOf(matched[i]))
+		})
+	}
+	end := start + pageSize
+	if end < len(matched) {
+		page.NextPageToken = cursorOf(matched[end-1]).encode()
+	} else {
+		end = len(matched)
+	}
+	page.Certificates = matched[start:end]
+	return page, nil
+}
+
+// pageCursor identifies a position in the issue-time ordering
+type pageCursor struct {
+	issuedAt int64
+	serial   *big.Int
+}
+
+func cursorOf(cert *Certificate) pageCursor {
+	return pageCursor{issuedAt: cert.IssuedAt.UnixNano(), serial: cert.Serial}
+}
+
+func (c pageCursor) less(other pageCursor) bool {
+	if c.issuedAt != other.issuedAt {
+		return c.issuedAt < other.issuedAt
+	}
+	return c.serial.Cmp(other.serial) < 0
+}
+
+func (c pageCursor) encode() string {
+	raw := strconv.FormatInt(c.issuedAt, 10) + ":" + c.serial.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodePageCursor(token string) (*pageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPageToken, err)
+	}
+	issuedAt, serial, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return nil, ErrInvalidPageToken
+	}
+	cursor := &pageCursor{serial: new(big.Int)}
+	if cursor.issuedAt, err = strconv.ParseInt(issuedAt, 10, 64); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPageToken, err)
+	}
+	if _, ok := cursor.serial.SetString(serial, 10); !ok {
+		return nil, ErrInvalidPageToken
+	}
+	return cursor, nil
+}
+
+// containsFold reports whether substr is within s, ignoring case
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
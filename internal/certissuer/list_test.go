@@ -0,0 +1,74 @@
+// internal/certissuer/list_test.go
+package certissuer_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certissuer"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certissuer/certissuertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListCertificates(t *testing.T) {
+	svc, err := certissuer.NewService(t.TempDir(), certissuertest.OfflineRootKey(t))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	var serials []string
+	for i := 0; i < 5; i++ {
+		csrPEM, _ := createTestCSR(t, fmt.Sprintf("node%02d.example.com", i))
+		certPEM, err := svc.IssueCertificate(ctx, csrPEM, map[string]string{})
+		require.NoError(t, err)
+		serials = append(serials, serialOf(t, certPEM))
+	}
+	require.NoError(t, svc.RevokeCertificate(ctx, serials[1], certissuer.ReasonSuperseded))
+
+	// Revoked certificates are excluded unless requested
+	page, err := svc.ListCertificates(ctx, certissuer.CertificateFilter{}, 0, "")
+	require.NoError(t, err)
+	assert.Equal(t, 4, page.TotalCount)
+
+	// Walk every page in issue order
+	var listed []string
+	token := ""
+	for {
+		page, err := svc.ListCertificates(ctx, certissuer.CertificateFilter{IncludeRevoked: true}, 2, token)
+		require.NoError(t, err)
+		assert.Equal(t, 5, page.TotalCount)
+		for _, cert := range page.Certificates {
+			listed = append(listed, cert.Serial.String())
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		token = page.NextPageToken
+	}
+	assert.ElementsMatch(t, serials, listed)
+	assert.Len(t, listed, 5, "No certificate should be listed twice")
+
+	// Subject and issued-to filters are case-insensitive substrings
+	page, err = svc.ListCertificates(ctx, certissuer.CertificateFilter{Subject: "CN=NODE03"}, 0, "")
+	require.NoError(t, err)
+	require.Len(t, page.Certificates, 1)
+	assert.Equal(t, serials[3], page.Certificates[0].Serial.String())
+
+	page, err = svc.ListCertificates(ctx, certissuer.CertificateFilter{IssuedTo: "node04"}, 0, "")
+	require.NoError(t, err)
+	assert.Len(t, page.Certificates, 1)
+
+	// Issue-time bounds
+	first, err := svc.GetCertificate(ctx, serials[0])
+	require.NoError(t, err)
+	page, err = svc.ListCertificates(ctx, certissuer.CertificateFilter{IssuedBefore: first.IssuedAt}, 0, "")
+	require.NoError(t, err)
+	assert.Empty(t, page.Certificates)
+
+	_, err = svc.ListCertificates(ctx, certissuer.CertificateFilter{}, 0, "not-a-token")
+	assert.ErrorIs(t, err, certissuer.ErrInvalidPageToken)
+
+	_, err = svc.GetCertificate(ctx, "42")
+	assert.ErrorIs(t, err, certissuer.ErrCertificateNotFound)
+}
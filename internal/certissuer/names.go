@@ -0,0 +1,153 @@
+// internal/certissuer/names.go
+package certissuer
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// SubjectNames are the subject alternative names of a certificate.
+type SubjectNames struct {
+	DNSNames       []string
+	IPAddresses    []net.IP
+	URIs           []*url.URL
+	EmailAddresses []string
+}
+
+// ParseSANs parses a comma-separated list of subject alternative names.
+// Entries may carry a type prefix ("dns:", "ip:", "uri:" or "email:");
+// untyped entries are classified by their form.
+func ParseSANs(list string) (SubjectNames, error) {
+	var names SubjectNames
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if err := names.add(entry); err != nil {
+			return SubjectNames{}, err
+		}
+	}
+	return names, nil
+}
+
+// add parses and appends one subject alternative name.
+func (n *SubjectNames) add(entry string) error {
+	typ, value := "", entry
+	if i := strings.Index(entry, ":"); i > 0 {
+		switch prefix := strings.ToLower(entry[:i]); prefix {
+		case "dns", "ip", "uri", "email":
+			typ, value = prefix, entry[i+1:]
+		}
+	}
+	if typ == "" {
+		switch {
+		case net.ParseIP(value) != nil:
+			typ = "ip"
+		case strings.Contains(value, "://"):
+			typ = "uri"
+		case strings.Contains(value, "@"):
+			typ = "email"
+		default:
+			typ = "dns"
+		}
+	}
+
+	switch typ {
+	case "dns":
+		name, err := normalizeDNSName(value)
+		if err != nil {
+			return err
+		}
+		n.DNSNames = appendUnique(n.DNSNames, name)
+	case "ip":
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return fmt.Errorf("invalid IP address SAN %q", value)
+		}
+		for _, existing := range n.IPAddresses {
+			if existing.Equal(ip) {
+				return nil
+			}
+		}
+		n.IPAddresses = append(n.IPAddresses, ip)
+	case "uri":
+		u, err := url.Parse(value)
+		if err != nil || u.Scheme == "" {
+			return fmt.Errorf("invalid URI SAN %q", value)
+		}
+		for _, existing := range n.URIs {
+			if existing.String() == u.String() {
+				return nil
+			}
+		}
+		n.URIs = append(n.URIs, u)
+	case "email":
+		at := strings.LastIndex(value, "@")
+		if at <= 0 || at == len(value)-1 {
+			return fmt.Errorf("invalid email SAN %q", value)
+		}
+		n.EmailAddresses = appendUnique(n.EmailAddresses, value[:at]+"@"+strings.ToLower(value[at+1:]))
+	}
+	return nil
+}
+
+// merge adds the names requested in a CSR.
+func (n *SubjectNames) merge(csr *x509.CertificateRequest) error {
+	for _, name := range csr.DNSNames {
+		if err := n.add("dns:" + name); err != nil {
+			return err
+		}
+	}
+	for _, ip := range csr.IPAddresses {
+		if err := n.add("ip:" + ip.String()); err != nil {
+			return err
+		}
+	}
+	for _, u := range csr.URIs {
+		if err := n.add("uri:" + u.String()); err != nil {
+			return err
+		}
+	}
+	for _, email := range csr.EmailAddresses {
+		if err := n.add("email:" + email); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// normalizeDNSName lower-cases a DNS name and checks its syntax. A wildcard
+// is only allowed as the whole leftmost label.
+func normalizeDNSName(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name == "" || len(name) > 253 {
+		return "", fmt.Errorf("invalid DNS name SAN %q", name)
+	}
+	for i, label := range strings.Split(name, ".") {
+		if label == "*" && i == 0 {
+			continue
+		}
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return "", fmt.Errorf("invalid DNS name SAN %q", name)
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return "", fmt.Errorf("invalid DNS name SAN %q", name)
+			}
+		}
+	}
+	return name, nil
+}
+
+func appendUnique(list []string, value string) []string {
+	for _, existing := range list {
+		if existing == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
@@ -0,0 +1,121 @@
+// internal/certissuer/ocsp.go
+package certissuer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// OCSPValidityPeriod is how long an OCSP response may be cached by clients.
+const OCSPValidityPeriod = time.Hour
+
+// WithOCSPServer embeds url as the OCSP responder in the Authority
+// Information Access extension of every issued certificate.
+func WithOCSPServer(url string) Option {
+	return func(ca *CertificateAuthority) {
+		if url != "" {
+			ca.ocspServers = append(ca.ocspServers, url)
+		}
+	}
+}
+
+// canSignOCSP reports whether an OCSP response can be signed with key.
+// Ed25519 signatures are not supported by OCSP responders or clients.
+func canSignOCSP(key crypto.Signer) bool {
+	_, ok := key.Public().(ed25519.PublicKey)
+	return !ok
+}
+
+// issuerOCSPServers returns the OCSP URLs to embed in certificates signed by
+// the current issuer. None are embedded by an Ed25519 issuer: its responder
+// could only answer with errors, and clients that require OCSP would reject
+// every certificate. The caller must hold ca.mu.
+func (ca *CertificateAuthority) issuerOCSPServers() []string {
+	if !canSignOCSP(ca.caPrivKey) {
+		return nil
+	}
+	return ca.ocspServers
+}
+
+// OCSPResponse answers a DER-encoded RFC 6960 OCSP request. Requests that
+// cannot be answered still produce a valid, unsigned OCSP error response, so
+// the returned bytes can always be sent to the client; the error only
+// explains why.
+func (ca *CertificateAuthority) OCSPResponse(requestDER []byte) ([]byte, error) {
+	req, err := ocsp.ParseRequest(requestDER)
+	if err != nil {
+		return ocsp.MalformedRequestErrorResponse, fmt.Errorf("failed to parse OCSP request: %w", err)
+	}
+
+	ca.mu.RLock()
+	defer ca.mu.RUnlock()
+
+	// Only answer for certificates issued by this CA, including those of
+	// intermediates it has since replaced
+	var issuer *keyPair
+	for _, candidate := range ca.issuers() {
+		if requestFor(req, candidate.cert) {
+			issuer = candidate
+			break
+		}
+	}
+	if issuer == nil {
+		return ocsp.UnauthorizedErrorResponse, fmt.Errorf("OCSP request for a different issuer")
+	}
+
+	now := time.Now().UTC().Truncate(time.Minute)
+	template := ocsp.Response{
+		Status:       ocsp.Unknown,
+		SerialNumber: req.SerialNumber,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(OCSPValidityPeriod),
+		IssuerHash:   req.HashAlgorithm,
+	}
+	if cert, ok := ca.certStore[req.SerialNumber.String()]; ok && signedBy(cert.Cert, issuer.cert) {
+		template.Status = ocsp.Good
+		if cert.IsRevoked {
+			template.Status = ocsp.Revoked
+			template.RevokedAt = cert.RevokedAt
+			template.RevocationReason = int(cert.RevocationReason)
+		}
+	}
+
+	resp, err := ocsp.CreateResponse(issuer.cert, issuer.cert, template, issuer.key)
+	if err != nil {
+		return ocsp.InternalErrorErrorResponse, fmt.Errorf("failed to sign OCSP response: %w", err)
+	}
+	return resp, nil
+}
+
+// requestFor reports whether the request's issuer hashes match issuer.
+func requestFor(req *ocsp.Request, issuer *x509.Certificate) bool {
+	if !req.HashAlgorithm.Available() {
+		return false
+	}
+
+	var publicKeyInfo struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &publicKeyInfo); err != nil {
+		return false
+	}
+
+	h := req.HashAlgorithm.New()
+	h.Write(publicKeyInfo.PublicKey.RightAlign())
+	if !bytes.Equal(h.Sum(nil), req.IssuerKeyHash) {
+		return false
+	}
+
+	h.Reset()
+	h.Write(issuer.RawSubject)
+	return bytes.Equal(h.Sum(nil), req.IssuerNameHash)
+}
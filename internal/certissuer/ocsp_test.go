@@ -0,0 +1,91 @@
+// internal/certissuer/ocsp_test.go
+package certissuer_test
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"testing"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certissuer"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certissuer/certissuertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ocsp"
+)
+
+// parsePEMCertificate decodes a single PEM certificate
+func parsePEMCertificate(t *testing.T, certPEM []byte) *x509.Certificate {
+	t.Helper()
+	block, _ := pem.Decode(certPEM)
+	require.NotNil(t, block, "Failed to decode certificate PEM")
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	return cert
+}
+
+func TestOCSPResponder(t *testing.T) {
+	const ocspURL = "https://ca.example.com/api/v1/ocsp"
+	svc, err := certissuer.NewService(t.TempDir(), certissuertest.OfflineRootKey(t), certissuer.WithOCSPServer(ocspURL))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	rootPEM, err := svc.GetRootCA(ctx)
+	require.NoError(t, err)
+	issuer := parsePEMCertificate(t, rootPEM)
+
+	csrPEM, _ := createTestCSR(t, "ocsp.example.com")
+	certPEM, err := svc.IssueCertificate(ctx, csrPEM, map[string]string{})
+	require.NoError(t, err)
+	cert := parsePEMCertificate(t, certPEM)
+	assert.Equal(t, []string{ocspURL}, cert.OCSPServer, "Issued certificates should carry the AIA OCSP URL")
+
+	query := func() *ocsp.Response {
+		req, err := ocsp.CreateRequest(cert, issuer, nil)
+		require.NoError(t, err)
+		respDER, err := svc.GetOCSPResponse(ctx, req)
+		require.NoError(t, err)
+		resp, err := ocsp.ParseResponseForCert(respDER, cert, issuer)
+		require.NoError(t, err)
+		return resp
+	}
+
+	assert.Equal(t, ocsp.Good, query().Status)
+
+	require.NoError(t, svc.RevokeCertificate(ctx, cert.SerialNumber.String(), certissuer.ReasonKeyCompromise))
+	resp := query()
+	assert.Equal(t, ocsp.Revoked, resp.Status)
+	assert.Equal(t, ocsp.KeyCompromise, resp.RevocationReason)
+
+	// Unknown serials and malformed requests still yield OCSP responses
+	unknown := *cert
+	unknown.SerialNumber = new(big.Int).Add(cert.SerialNumber, big.NewInt(1))
+	req, err := ocsp.CreateRequest(&unknown, issuer, nil)
+	require.NoError(t, err)
+	respDER, err := svc.GetOCSPResponse(ctx, req)
+	require.NoError(t, err)
+	resp, err = ocsp.ParseResponse(respDER, issuer)
+	require.NoError(t, err)
+	assert.Equal(t, ocsp.Unknown, resp.Status)
+
+	respDER, err = svc.GetOCSPResponse(ctx, []byte("garbage"))
+	assert.Error(t, err)
+	assert.Equal(t, ocsp.MalformedRequestErrorResponse, respDER)
+}
+
+func TestOCSPServerOmittedForEd25519(t *testing.T) {
+	ca, err := certissuer.NewCertificateAuthority(t.TempDir(), certissuertest.OfflineRootKey(t),
+		certissuer.WithKeyAlgorithm(certissuer.KeyAlgorithmEd25519),
+		certissuer.WithOCSPServer("https://ca.example.com/api/v1/ocsp"))
+	require.NoError(t, err)
+
+	csrPEM, _ := createTestCSR(t, "ed25519.example.com")
+	certPEM, err := ca.IssueCertificateFromCSR(csrPEM, map[string]string{})
+	require.NoError(t, err)
+	assert.Empty(t, parsePEMCertificate(t, certPEM).OCSPServer, "Ed25519 CAs cannot answer OCSP requests")
+
+	renewedPEM, err := ca.RenewCertificate(certPEM)
+	require.NoError(t, err)
+	assert.Empty(t, parsePEMCertificate(t, renewedPEM).OCSPServer)
+}
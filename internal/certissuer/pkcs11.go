@@ -0,0 +1,376 @@
+// internal/certissuer/pkcs11.go
+//go:build pkcs11 && cgo
+
+package certissuer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+// PKCS11Supported reports whether this binary was built with PKCS#11
+// support (the pkcs11 build tag).
+const PKCS11Supported = true
+
+var (
+	oidNamedCurveP256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+	oidNamedCurveP384 = asn1.ObjectIdentifier{1, 3, 132, 0, 34}
+)
+
+// digestInfoPrefixes are the DER DigestInfo headers prepended to a digest
+// for CKM_RSA_PKCS signatures.
+var digestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// PKCS11KeyProvider keeps the CA keys on a PKCS#11 token such as an HSM,
+// a smart card or SoftHSM. Keys are generated on the token as sensitive,
+// non-extractable objects and never leave it. RSA and ECDSA P-256/P-384
+// keys are supported.
+type PKCS11KeyProvider struct {
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	prefix  string
+
+	// A session handles one operation at a time
+	mu sync.Mutex
+}
+
+// NewPKCS11KeyProvider loads the PKCS#11 module and logs in to the token.
+func NewPKCS11KeyProvider(cfg PKCS11Config) (*PKCS11KeyProvider, error) {
+	ctx := pkcs11.New(cfg.Module)
+	if ctx == nil {
+		return nil, fmt.Errorf("failed to load PKCS#11 module %s", cfg.Module)
+	}
+	if err := ctx.Initialize(); err != nil {
+		ctx.Destroy()
+		return nil, fmt.Errorf("failed to initialize PKCS#11 module %s: %w", cfg.Module, err)
+	}
+
+	p := &PKCS11KeyProvider{ctx: ctx, prefix: cfg.KeyLabelPrefix}
+	if p.prefix == "" {
+		p.prefix = DefaultPKCS11KeyLabelPrefix
+	}
+
+	slot, err := p.findSlot(cfg.TokenLabel)
+	if err != nil {
+		p.finalize()
+		return nil, err
+	}
+	p.session, err = ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		p.finalize()
+		return nil, fmt.Errorf("failed to open PKCS#11 session: %w", err)
+	}
+	if err := ctx.Login(p.session, pkcs11.CKU_USER, cfg.PIN); err != nil {
+		var ckErr pkcs11.Error
+		if !errors.As(err, &ckErr) || ckErr != pkcs11.CKR_USER_ALREADY_LOGGED_IN {
+			ctx.CloseSession(p.session)
+			p.finalize()
+			return nil, fmt.Errorf("failed to log in to PKCS#11 token %q: %w", cfg.TokenLabel, err)
+		}
+	}
+	return p, nil
+}
+
+// Close logs out and unloads the module. Signers from the provider stop
+// working.
+func (p *PKCS11KeyProvider) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.ctx.Logout(p.session)
+	err := p.ctx.CloseSession(p.session)
+	p.finalize()
+	return err
+}
+
+func (p *PKCS11KeyProvider) finalize() {
+	p.ctx.Finalize()
+	p.ctx.Destroy()
+}
+
+// findSlot returns the slot holding the token with the given label.
+func (p *PKCS11KeyProvider) findSlot(label string) (uint, error) {
+	slots, err := p.ctx.GetSlotList(true)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list PKCS#11 slots: %w", err)
+	}
+	for _, slot := range slots {
+		info, err := p.ctx.GetTokenInfo(slot)
+		if err != nil {
+			continue
+		}
+		if strings.TrimRight(info.Label, " \x00") == label {
+			return slot, nil
+		}
+	}
+	return 0, fmt.Errorf("no PKCS#11 token labeled %q", label)
+}
+
+// GenerateKey implements KeyProvider. Each key gets a random CKA_ID shared
+// by its public and private objects; earlier keys with the same name stay
+// on the token.
+func (p *PKCS11KeyProvider) GenerateKey(name string, alg KeyAlgorithm) (crypto.Signer, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	label := p.prefix + name
+
+	public := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+	}
+	private := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+	}
+
+	var mechanism *pkcs11.Mechanism
+	var keyType uint
+	switch alg {
+	case KeyAlgorithmRSA2048, KeyAlgorithmRSA3072, KeyAlgorithmRSA4096:
+		bits := map[KeyAlgorithm]int{KeyAlgorithmRSA2048: 2048, KeyAlgorithmRSA3072: 3072, KeyAlgorithmRSA4096: 4096}[alg]
+		mechanism = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN, nil)
+		keyType = pkcs11.CKK_RSA
+		public = append(public,
+			pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, bits),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, []byte{1, 0, 1}))
+		private = append(private, pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA))
+	case KeyAlgorithmECDSAP256, KeyAlgorithmECDSAP384:
+		oid := oidNamedCurveP256
+		if alg == KeyAlgorithmECDSAP384 {
+			oid = oidNamedCurveP384
+		}
+		params, err := asn1.Marshal(oid)
+		if err != nil {
+			return nil, err
+		}
+		mechanism = pkcs11.NewMechanism(pkcs11.CKM_EC_KEY_PAIR_GEN, nil)
+		keyType = pkcs11.CKK_EC
+		public = append(public,
+			pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, params))
+		private = append(private, pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC))
+	default:
+		return nil, fmt.Errorf("%w: algorithm %q on a PKCS#11 token", ErrUnsupportedKey, alg)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pubHandle, privHandle, err := p.ctx.GenerateKeyPair(p.session, []*pkcs11.Mechanism{mechanism}, public, private)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate %s key on PKCS#11 token: %w", alg, err)
+	}
+	pub, err := p.publicKey(pubHandle, keyType)
+	if err != nil {
+		return nil, err
+	}
+	return &pkcs11Signer{provider: p, handle: privHandle, public: pub}, nil
+}
+
+// LoadKey implements KeyProvider. Of the private keys labeled with name, the
+// one whose public key object matches cert is returned.
+func (p *PKCS11KeyProvider) LoadKey(name string, cert *x509.Certificate) (crypto.Signer, error) {
+	var keyType uint
+	switch cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		keyType = pkcs11.CKK_RSA
+	case *ecdsa.PublicKey:
+		keyType = pkcs11.CKK_EC
+	default:
+		return nil, fmt.Errorf("%w: %T on a PKCS#11 token", ErrUnsupportedKey, cert.PublicKey)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	privHandles, err := p.findObjects([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, p.prefix+name),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, privHandle := range privHandles {
+		attrs, err := p.ctx.GetAttributeValue(p.session, privHandle, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_ID, nil),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read PKCS#11 key ID: %w", err)
+		}
+		pubHandles, err := p.findObjects([]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+			pkcs11.NewAttribute(pkcs11.CKA_ID, attrs[0].Value),
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, pubHandle := range pubHandles {
+			pub, err := p.publicKey(pubHandle, keyType)
+			if err != nil {
+				continue
+			}
+			if public, ok := pub.(interface{ Equal(crypto.PublicKey) bool }); ok && public.Equal(cert.PublicKey) {
+				return &pkcs11Signer{provider: p, handle: privHandle, public: pub}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w: no key labeled %q on the PKCS#11 token matches the certificate", ErrKeyNotFound, p.prefix+name)
+}
+
+// findObjects returns all objects matching template. The caller must hold
+// p.mu.
+func (p *PKCS11KeyProvider) findObjects(template []*pkcs11.Attribute) ([]pkcs11.ObjectHandle, error) {
+	if err := p.ctx.FindObjectsInit(p.session, template); err != nil {
+		return nil, fmt.Errorf("failed to search PKCS#11 token: %w", err)
+	}
+	defer p.ctx.FindObjectsFinal(p.session)
+
+	var handles []pkcs11.ObjectHandle
+	for {
+		found, _, err := p.ctx.FindObjects(p.session, 16)
+		if err != nil {
+			return nil, fmt.Errorf("failed to search PKCS#11 token: %w", err)
+		}
+		if len(found) == 0 {
+			return handles, nil
+		}
+		handles = append(handles, found...)
+	}
+}
+
+// publicKey reads a public key object. The caller must hold p.mu.
+func (p *PKCS11KeyProvider) publicKey(handle pkcs11.ObjectHandle, keyType uint) (crypto.PublicKey, error) {
+	switch keyType {
+	case pkcs11.CKK_RSA:
+		attrs, err := p.ctx.GetAttributeValue(p.session, handle, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read RSA public key from PKCS#11 token: %w", err)
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(attrs[0].Value),
+			E: int(new(big.Int).SetBytes(attrs[1].Value).Int64()),
+		}, nil
+	case pkcs11.CKK_EC:
+		attrs, err := p.ctx.GetAttributeValue(p.session, handle, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read EC public key from PKCS#11 token: %w", err)
+		}
+		var oid asn1.ObjectIdentifier
+		if _, err := asn1.Unmarshal(attrs[0].Value, &oid); err != nil {
+			return nil, fmt.Errorf("failed to parse EC parameters from PKCS#11 token: %w", err)
+		}
+		var curve elliptic.Curve
+		switch {
+		case oid.Equal(oidNamedCurveP256):
+			curve = elliptic.P256()
+		case oid.Equal(oidNamedCurveP384):
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("%w: EC curve %s", ErrUnsupportedKey, oid)
+		}
+		// CKA_EC_POINT is a DER OCTET STRING, though some tokens omit it
+		point := attrs[1].Value
+		var inner []byte
+		if rest, err := asn1.Unmarshal(point, &inner); err == nil && len(rest) == 0 {
+			point = inner
+		}
+		x, y := elliptic.Unmarshal(curve, point)
+		if x == nil {
+			return nil, fmt.Errorf("invalid EC point on PKCS#11 token")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("%w: PKCS#11 key type %d", ErrUnsupportedKey, keyType)
+	}
+}
+
+// sign signs data with the private key object handle.
+func (p *PKCS11KeyProvider) sign(handle pkcs11.ObjectHandle, mechanism *pkcs11.Mechanism, data []byte) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.ctx.SignInit(p.session, []*pkcs11.Mechanism{mechanism}, handle); err != nil {
+		return nil, fmt.Errorf("failed to start PKCS#11 signature: %w", err)
+	}
+	signature, err := p.ctx.Sign(p.session, data)
+	if err != nil {
+		return nil, fmt.Errorf("PKCS#11 signature failed: %w", err)
+	}
+	return signature, nil
+}
+
+// pkcs11Signer is a crypto.Signer for a private key on a PKCS#11 token.
+type pkcs11Signer struct {
+	provider *PKCS11KeyProvider
+	handle   pkcs11.ObjectHandle
+	public   crypto.PublicKey
+}
+
+func (s *pkcs11Signer) Public() crypto.PublicKey {
+	return s.public
+}
+
+// Sign implements crypto.Signer with PKCS#1 v1.5 for RSA keys and ASN.1
+// encoded signatures for ECDSA keys.
+func (s *pkcs11Signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	switch s.public.(type) {
+	case *rsa.PublicKey:
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			return nil, fmt.Errorf("%w: RSA-PSS signatures on a PKCS#11 token", ErrUnsupportedKey)
+		}
+		prefix, ok := digestInfoPrefixes[opts.HashFunc()]
+		if !ok {
+			return nil, fmt.Errorf("%w: hash %s for PKCS#11 RSA signatures", ErrUnsupportedKey, opts.HashFunc())
+		}
+		data := append(append([]byte{}, prefix...), digest...)
+		return s.provider.sign(s.handle, pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil), data)
+	case *ecdsa.PublicKey:
+		raw, err := s.provider.sign(s.handle, pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil), digest)
+		if err != nil {
+			return nil, err
+		}
+		// PKCS#11 returns r || s; X.509 wants an ASN.1 sequence
+		half := len(raw) / 2
+		return asn1.Marshal(struct{ R, S *big.Int }{
+			R: new(big.Int).SetBytes(raw[:half]),
+			S: new(big.Int).SetBytes(raw[half:]),
+		})
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, s.public)
+	}
+}
@@ -0,0 +1,39 @@
+// internal/certissuer/pkcs11_stub.go
+//go:build !pkcs11 || !cgo
+
+package certissuer
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+)
+
+// PKCS11Supported reports whether this binary was built with PKCS#11
+// support (the pkcs11 build tag).
+const PKCS11Supported = false
+
+var errNoPKCS11 = errors.New("PKCS#11 support is not compiled in; rebuild with -tags pkcs11")
+
+// PKCS11KeyProvider is unavailable in builds without the pkcs11 tag.
+type PKCS11KeyProvider struct{}
+
+// NewPKCS11KeyProvider always fails in builds without the pkcs11 tag.
+func NewPKCS11KeyProvider(cfg PKCS11Config) (*PKCS11KeyProvider, error) {
+	return nil, errNoPKCS11
+}
+
+// Close implements io.Closer.
+func (p *PKCS11KeyProvider) Close() error {
+	return errNoPKCS11
+}
+
+// GenerateKey implements KeyProvider.
+func (p *PKCS11KeyProvider) GenerateKey(name string, alg KeyAlgorithm) (crypto.Signer, error) {
+	return nil, errNoPKCS11
+}
+
+// LoadKey implements KeyProvider.
+func (p *PKCS11KeyProvider) LoadKey(name string, cert *x509.Certificate) (crypto.Signer, error) {
+	return nil, errNoPKCS11
+}
@@ -0,0 +1,111 @@
+// internal/certissuer/pkcs11_test.go
+//go:build pkcs11 && cgo
+
+package certissuer_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certissuer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// softHSM initializes a fresh SoftHSM token and returns its configuration,
+// skipping the test when SoftHSM is not installed. SOFTHSM2_MODULE
+// overrides the library location.
+func softHSM(t *testing.T) certissuer.PKCS11Config {
+	t.Helper()
+
+	module := os.Getenv("SOFTHSM2_MODULE")
+	if module == "" {
+		for _, candidate := range []string{
+			"/usr/lib/softhsm/libsofthsm2.so",
+			"/usr/lib/x86_64-linux-gnu/softhsm/libsofthsm2.so",
+			"/usr/lib/aarch64-linux-gnu/softhsm/libsofthsm2.so",
+			"/usr/local/lib/softhsm/libsofthsm2.so",
+			"/opt/homebrew/lib/softhsm/libsofthsm2.so",
+		} {
+			if _, err := os.Stat(candidate); err == nil {
+				module = candidate
+				break
+			}
+		}
+	}
+	util, err := exec.LookPath("softhsm2-util")
+	if module == "" || err != nil {
+		t.Skip("SoftHSM is not installed")
+	}
+
+	dir := t.TempDir()
+	tokens := filepath.Join(dir, "tokens")
+	require.NoError(t, os.Mkdir(tokens, 0700))
+	conf := filepath.Join(dir, "softhsm2.conf")
+	require.NoError(t, os.WriteFile(conf, []byte("directories.tokendir = "+tokens+"\nobjectstore.backend = file\n"), 0600))
+	t.Setenv("SOFTHSM2_CONF", conf)
+
+	out, err := exec.Command(util, "--init-token", "--free", "--label", "autoinstall-test",
+		"--pin", "1234", "--so-pin", "123456").CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	return certissuer.PKCS11Config{Module: module, TokenLabel: "autoinstall-test", PIN: "1234"}
+}
+
+func TestPKCS11KeyProvider(t *testing.T) {
+	cfg := softHSM(t)
+
+	for _, alg := range []certissuer.KeyAlgorithm{certissuer.KeyAlgorithmECDSAP256, certissuer.KeyAlgorithmRSA2048} {
+		t.Run(string(alg), func(t *testing.T) {
+			dir := t.TempDir()
+			keys, err := certissuer.NewPKCS11KeyProvider(cfg)
+			require.NoError(t, err)
+
+			ca, err := certissuer.NewCertificateAuthority(dir,
+				certissuer.WithKeyProvider(keys), certissuer.WithKeyAlgorithm(alg))
+			require.NoError(t, err)
+			root := parsePEMCertificate(t, ca.RootCertificate())
+
+			// No key material is written next to the certificates
+			assert.NoFileExists(t, filepath.Join(dir, "root.key"))
+			assert.NoFileExists(t, filepath.Join(dir, "intermediate.key"))
+
+			csrPEM, _ := createTestCSR(t, "hsm.example.com")
+			certPEM, err := ca.IssueCertificateFromCSR(csrPEM, map[string]string{})
+			require.NoError(t, err)
+			verifyLeaf(t, certPEM, root)
+			require.NoError(t, keys.Close())
+
+			// A new session finds the keys again, including after rotation
+			keys, err = certissuer.NewPKCS11KeyProvider(cfg)
+			require.NoError(t, err)
+			defer keys.Close()
+
+			reloaded, err := certissuer.NewCertificateAuthority(dir, certissuer.WithKeyProvider(keys))
+			require.NoError(t, err)
+			rootKey, err := reloaded.RootKey()
+			require.NoError(t, err)
+			require.NoError(t, reloaded.RotateIntermediate(rootKey))
+
+			csrPEM, _ = createTestCSR(t, "rotated.example.com")
+			certPEM, err = reloaded.IssueCertificateFromCSR(csrPEM, map[string]string{})
+			require.NoError(t, err)
+			verifyLeaf(t, certPEM, root)
+
+			crlDER, err := reloaded.CRL()
+			require.NoError(t, err)
+			assert.NotEmpty(t, crlDER)
+		})
+	}
+}
+
+func TestPKCS11RejectsEd25519(t *testing.T) {
+	keys, err := certissuer.NewPKCS11KeyProvider(softHSM(t))
+	require.NoError(t, err)
+	defer keys.Close()
+
+	_, err = keys.GenerateKey("root", certissuer.KeyAlgorithmEd25519)
+	assert.ErrorIs(t, err, certissuer.ErrUnsupportedKey)
+}
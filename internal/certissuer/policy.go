@@ -0,0 +1,288 @@
+// internal/certissuer/policy.go
+package certissuer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// ErrPolicyViolation is returned when a certificate request is refused by
+// the issuance policy.
+var ErrPolicyViolation = errors.New("certificate request violates issuance policy")
+
+// PolicyRule is the configuration of an issuance policy. Empty lists leave
+// that kind of name unrestricted.
+type PolicyRule struct {
+	// AllowedDomains are DNS suffixes that DNS names, URI hosts and email
+	// domains must fall under; "example.com" also allows its subdomains.
+	AllowedDomains []string `mapstructure:"allowed_domains"`
+	// AllowedCIDRs are the networks IP address SANs must fall within.
+	AllowedCIDRs []string `mapstructure:"allowed_cidrs"`
+	// AllowedOrganizations may be requested as the subject organization.
+	AllowedOrganizations []string `mapstructure:"allowed_organizations"`
+	// MaxValidity caps the lifetime of issued certificates.
+	MaxValidity time.Duration `mapstructure:"max_validity"`
+	// RequireInventory only allows names of a single inventory server.
+	RequireInventory bool `mapstructure:"require_inventory"`
+}
+
+// PolicyConfig holds the default policy and per-requester overrides, keyed
+// by API key name.
+type PolicyConfig struct {
+	Default PolicyRule            `mapstructure:"default"`
+	APIKeys map[string]PolicyRule `mapstructure:"api_keys"`
+	// InventoryDomain is the domain under which servers registered by
+	// short hostname are known to RequireInventory.
+	InventoryDomain string `mapstructure:"inventory_domain"`
+}
+
+// RequiresInventory reports whether any rule sets RequireInventory.
+func (c PolicyConfig) RequiresInventory() bool {
+	if c.Default.RequireInventory {
+		return true
+	}
+	for _, rule := range c.APIKeys {
+		if rule.RequireInventory {
+			return true
+		}
+	}
+	return false
+}
+
+// PolicyConfigFromViper reads the issuance policy from the certissuer.policy key.
+func PolicyConfigFromViper() PolicyConfig {
+	var cfg PolicyConfig
+	if err := viper.UnmarshalKey("certissuer.policy", &cfg); err != nil {
+		fmt.Printf("Warning: failed to read certificate policy configuration: %v\n", err)
+	}
+	return cfg
+}
+
+// policy is a parsed PolicyRule.
+type policy struct {
+	domains          []string
+	networks         []*net.IPNet
+	organizations    []string
+	maxValidity      time.Duration
+	requireInventory bool
+}
+
+// PolicyEngine decides which certificate requests may be issued.
+type PolicyEngine struct {
+	defaultPolicy *policy
+	policies      map[string]*policy
+	inventory     Inventory
+}
+
+// NewPolicyEngine parses cfg. An inventory is required if any rule sets
+// RequireInventory.
+func NewPolicyEngine(cfg PolicyConfig, inventory Inventory) (*PolicyEngine, error) {
+	e := &PolicyEngine{
+		policies:  make(map[string]*policy),
+		inventory: inventory,
+	}
+
+	var err error
+	if e.defaultPolicy, err = e.parseRule("default", cfg.Default); err != nil {
+		return nil, err
+	}
+	for name, rule := range cfg.APIKeys {
+		if e.policies[name], err = e.parseRule(name, rule); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+func (e *PolicyEngine) parseRule(name string, rule PolicyRule) (*policy, error) {
+	p := &policy{
+		organizations:    rule.AllowedOrganizations,
+		maxValidity:      rule.MaxValidity,
+		requireInventory: rule.RequireInventory,
+	}
+	for _, domain := range rule.AllowedDomains {
+		domain = strings.ToLower(strings.Trim(domain, "."))
+		if domain == "" {
+			return nil, fmt.Errorf("policy %s: empty allowed domain", name)
+		}
+		p.domains = append(p.domains, domain)
+	}
+	for _, cidr := range rule.AllowedCIDRs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("policy %s: invalid CIDR %q: %w", name, cidr, err)
+		}
+		p.networks = append(p.networks, network)
+	}
+	if p.maxValidity < 0 {
+		return nil, fmt.Errorf("policy %s: negative max validity", name)
+	}
+	if p.requireInventory && e.inventory == nil {
+		return nil, fmt.Errorf("policy %s requires an inventory, but none is configured", name)
+	}
+	return p, nil
+}
+
+// policyFor returns the policy for a requester, falling back to the default.
+func (e *PolicyEngine) policyFor(requester string) *policy {
+	if p, ok := e.policies[requester]; ok {
+		return p
+	}
+	return e.defaultPolicy
+}
+
+// Validity returns the lifetime for a certificate: requested, or
+// CertValidityPeriod when zero, capped by the requester's policy.
+func (e *PolicyEngine) Validity(requester string, requested time.Duration) time.Duration {
+	validity := requested
+	if validity <= 0 {
+		validity = CertValidityPeriod
+	}
+	if e == nil {
+		return validity
+	}
+	if limit := e.policyFor(requester).maxValidity; limit > 0 && validity > limit {
+		validity = limit
+	}
+	return validity
+}
+
+// check verifies that requester may have the request certified.
+func (e *PolicyEngine) check(ctx context.Context, requester string, req *certificateRequest) error {
+	if e == nil {
+		return nil
+	}
+	p := e.policyFor(requester)
+
+	if req.organization != "" && len(p.organizations) > 0 && !equalsAnyFold(p.organizations, req.organization) {
+		return fmt.Errorf("%w: organization %q is not allowed", ErrPolicyViolation, req.organization)
+	}
+
+	// The common name is held to the same rules as the SANs
+	dnsNames := req.names.DNSNames
+	ips := req.names.IPAddresses
+	if req.commonName != "" {
+		if ip := net.ParseIP(req.commonName); ip != nil {
+			ips = append(ips[:len(ips):len(ips)], ip)
+		} else if name, err := normalizeDNSName(req.commonName); err == nil {
+			dnsNames = appendUnique(dnsNames[:len(dnsNames):len(dnsNames)], name)
+		} else if len(p.domains) > 0 || p.requireInventory {
+			return fmt.Errorf("%w: common name %q is not a host name", ErrPolicyViolation, req.commonName)
+		}
+	}
+
+	for _, name := range dnsNames {
+		if !p.allowsDomain(name) {
+			return fmt.Errorf("%w: DNS name %s is outside the allowed domains", ErrPolicyViolation, name)
+		}
+	}
+	for _, ip := range ips {
+		if !p.allowsIP(ip) {
+			return fmt.Errorf("%w: IP address %s is outside the allowed networks", ErrPolicyViolation, ip)
+		}
+	}
+	for _, u := range req.names.URIs {
+		if len(p.domains) > 0 && (u.Hostname() == "" || !p.allowsDomain(strings.ToLower(u.Hostname()))) {
+			return fmt.Errorf("%w: URI %s is outside the allowed domains", ErrPolicyViolation, u)
+		}
+	}
+	for _, email := range req.names.EmailAddresses {
+		if !p.allowsDomain(email[strings.LastIndex(email, "@")+1:]) {
+			return fmt.Errorf("%w: email address %s is outside the allowed domains", ErrPolicyViolation, email)
+		}
+	}
+
+	if p.requireInventory {
+		return e.checkInventory(ctx, dnsNames, ips)
+	}
+	return nil
+}
+
+// checkInventory requires every DNS name and IP address to belong to the
+// same inventory server.
+func (e *PolicyEngine) checkInventory(ctx context.Context, dnsNames []string, ips []net.IP) error {
+	names := append([]string{}, dnsNames...)
+	for _, ip := range ips {
+		names = append(names, ip.String())
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("%w: the request names no host", ErrPolicyViolation)
+	}
+
+	var server *InventoryHost
+	for _, name := range names {
+		host, err := e.inventory.FindHost(ctx, name)
+		if errors.Is(err, ErrHostNotFound) {
+			return fmt.Errorf("%w: %s does not match an inventory server", ErrPolicyViolation, name)
+		}
+		if err != nil {
+			return err
+		}
+		if server != nil && host.ID != server.ID {
+			return fmt.Errorf("%w: %s and %s belong to different inventory servers", ErrPolicyViolation, names[0], name)
+		}
+		server = host
+	}
+	return nil
+}
+
+func (p *policy) allowsDomain(name string) bool {
+	if len(p.domains) == 0 {
+		return true
+	}
+	for _, domain := range p.domains {
+		if name == domain || strings.HasSuffix(name, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *policy) allowsIP(ip net.IP) bool {
+	if len(p.networks) == 0 {
+		return true
+	}
+	for _, network := range p.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func equalsAnyFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithPolicy enforces engine on certificates issued through
+// IssueCertificateContext.
+func WithPolicy(engine *PolicyEngine) Option {
+	return func(ca *CertificateAuthority) {
+		ca.policy = engine
+	}
+}
+
+type requesterKey struct{}
+
+// ContextWithRequester records who is asking for a certificate, selecting
+// the per-API-key policy.
+func ContextWithRequester(ctx context.Context, requester string) context.Context {
+	return context.WithValue(ctx, requesterKey{}, requester)
+}
+
+// RequesterFromContext returns the requester set by ContextWithRequester.
+func RequesterFromContext(ctx context.Context) string {
+	requester, _ := ctx.Value(requesterKey{}).(string)
+	return requester
+}
@@ -0,0 +1,242 @@
+// internal/certissuer/policy_test.go
+package certissuer_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certissuer"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certissuer/certissuertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeInventory maps names to server IDs.
+type fakeInventory map[string]string
+
+func (f fakeInventory) FindHost(_ context.Context, name string) (*certissuer.InventoryHost, error) {
+	id, ok := f[strings.ToLower(name)]
+	if !ok {
+		return nil, certissuer.ErrHostNotFound
+	}
+	return &certissuer.InventoryHost{ID: id, Hostname: name}, nil
+}
+
+func newPolicyCA(t *testing.T, cfg certissuer.PolicyConfig, inventory certissuer.Inventory) *certissuer.CertificateAuthority {
+	t.Helper()
+
+	engine, err := certissuer.NewPolicyEngine(cfg, inventory)
+	require.NoError(t, err)
+	ca, err := certissuer.NewCertificateAuthority(t.TempDir(), certissuertest.OfflineRootKey(t), certissuer.WithPolicy(engine))
+	require.NoError(t, err)
+	return ca
+}
+
+func TestParseSANs(t *testing.T) {
+	names, err := certissuer.ParseSANs("Web.Example.com, 10.0.0.5, uri:spiffe://example.com/web, admin@Example.com, dns:web.example.com, ip:10.0.0.5,")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"web.example.com"}, names.DNSNames)
+	require.Len(t, names.IPAddresses, 1)
+	assert.True(t, names.IPAddresses[0].Equal(net.ParseIP("10.0.0.5")))
+	require.Len(t, names.URIs, 1)
+	assert.Equal(t, "spiffe://example.com/web", names.URIs[0].String())
+	assert.Equal(t, []string{"admin@example.com"}, names.EmailAddresses)
+
+	for _, bad := range []string{"ip:not-an-ip", "bad..name", "email:@example.com", "uri:no-scheme", "a.*.example.com"} {
+		_, err := certissuer.ParseSANs(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestIssueMultipleSANTypes(t *testing.T) {
+	ca, err := certissuer.NewCertificateAuthority(t.TempDir(), certissuertest.OfflineRootKey(t))
+	require.NoError(t, err)
+
+	csrPEM, _ := createTestCSR(t, "web.example.com")
+	certPEM, err := ca.IssueCertificateFromCSR(csrPEM, map[string]string{
+		"sans": "web.example.com,www.example.com,192.168.1.10,spiffe://example.com/web,ops@example.com",
+	})
+	require.NoError(t, err)
+
+	cert := parsePEMCertificate(t, certPEM)
+	assert.Equal(t, []string{"web.example.com", "www.example.com"}, cert.DNSNames)
+	require.Len(t, cert.IPAddresses, 1)
+	assert.Equal(t, "192.168.1.10", cert.IPAddresses[0].String())
+	require.Len(t, cert.URIs, 1)
+	assert.Equal(t, "spiffe://example.com/web", cert.URIs[0].String())
+	assert.Equal(t, []string{"ops@example.com"}, cert.EmailAddresses)
+}
+
+func TestPolicyDomainsAndNetworks(t *testing.T) {
+	ca := newPolicyCA(t, certissuer.PolicyConfig{
+		Default: certissuer.PolicyRule{
+			AllowedDomains: []string{"example.com"},
+			AllowedCIDRs:   []string{"10.0.0.0/8"},
+		},
+		APIKeys: map[string]certissuer.PolicyRule{
+			"lab": {AllowedDomains: []string{"lab.example.net"}},
+		},
+	}, nil)
+
+	tests := []struct {
+		name      string
+		requester string
+		cn        string
+		sans      string
+		allowed   bool
+	}{
+		{"matching names", "", "web.example.com", "example.com,10.1.2.3,ops@example.com", true},
+		{"foreign domain", "", "web.example.com", "web.example.org", false},
+		{"suffix is not a label boundary", "", "web.example.com", "badexample.com", false},
+		{"foreign common name", "", "web.example.org", "", false},
+		{"address outside networks", "", "web.example.com", "192.168.1.1", false},
+		{"foreign email domain", "", "web.example.com", "ops@example.org", false},
+		{"foreign URI host", "", "web.example.com", "spiffe://example.org/web", false},
+		{"per-key policy", "lab", "pxe.lab.example.net", "192.168.1.1", true},
+		{"per-key policy replaces default", "lab", "web.example.com", "", false},
+		{"unknown key uses default", "other", "pxe.lab.example.net", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := certissuer.ContextWithRequester(context.Background(), tt.requester)
+			csrPEM, _ := createTestCSR(t, tt.cn)
+			_, err := ca.IssueCertificateContext(ctx, csrPEM, map[string]string{"sans": tt.sans})
+			if tt.allowed {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, certissuer.ErrPolicyViolation)
+			}
+		})
+	}
+}
+
+func TestPolicyValidity(t *testing.T) {
+	ca := newPolicyCA(t, certissuer.PolicyConfig{
+		Default: certissuer.PolicyRule{MaxValidity: 30 * 24 * time.Hour},
+	}, nil)
+
+	issue := func(clientInfo map[string]string) time.Duration {
+		csrPEM, _ := createTestCSR(t, "web.example.com")
+		certPEM, err := ca.IssueCertificateFromCSR(csrPEM, clientInfo)
+		require.NoError(t, err)
+		cert := parsePEMCertificate(t, certPEM)
+		return cert.NotAfter.Sub(cert.NotBefore)
+	}
+
+	assert.Equal(t, 30*24*time.Hour, issue(map[string]string{}), "The default validity should be capped")
+	assert.Equal(t, 7*24*time.Hour, issue(map[string]string{"validity_days": "7"}))
+	assert.Equal(t, 30*24*time.Hour, issue(map[string]string{"validity_days": "365"}))
+
+	csrPEM, _ := createTestCSR(t, "web.example.com")
+	_, err := ca.IssueCertificateFromCSR(csrPEM, map[string]string{"validity_days": "-1"})
+	assert.Error(t, err)
+
+	// Renewal is capped as well
+	certPEM, err := ca.IssueCertificateFromCSR(csrPEM, map[string]string{})
+	require.NoError(t, err)
+	renewed := parsePEMCertificate(t, mustRenew(t, ca, certPEM))
+	assert.Equal(t, 30*24*time.Hour, renewed.NotAfter.Sub(renewed.NotBefore))
+}
+
+func mustRenew(t *testing.T, ca *certissuer.CertificateAuthority, certPEM []byte) []byte {
+	t.Helper()
+
+	renewed, err := ca.RenewCertificate(certPEM)
+	require.NoError(t, err)
+	return renewed
+}
+
+func TestPolicyOrganizations(t *testing.T) {
+	ca := newPolicyCA(t, certissuer.PolicyConfig{
+		Default: certissuer.PolicyRule{AllowedOrganizations: []string{"Lab Servers"}},
+	}, nil)
+
+	csrPEM, _ := createTestCSR(t, "web.example.com")
+	_, err := ca.IssueCertificateFromCSR(csrPEM, map[string]string{"organization": "lab servers"})
+	assert.NoError(t, err)
+	_, err = ca.IssueCertificateFromCSR(csrPEM, map[string]string{"organization": "Someone Else"})
+	assert.ErrorIs(t, err, certissuer.ErrPolicyViolation)
+}
+
+func TestPolicyInventory(t *testing.T) {
+	inventory := fakeInventory{
+		"web01.example.com": "server-1",
+		"web01":             "server-1",
+		"10.0.0.11":         "server-1",
+		"db01.example.com":  "server-2",
+	}
+	ca := newPolicyCA(t, certissuer.PolicyConfig{
+		Default: certissuer.PolicyRule{RequireInventory: true},
+	}, inventory)
+
+	tests := []struct {
+		name    string
+		cn      string
+		sans    string
+		allowed bool
+	}{
+		{"names of one server", "web01.example.com", "web01,10.0.0.11", true},
+		{"names of two servers", "web01.example.com", "db01.example.com", false},
+		{"unknown name", "web01.example.com", "mail.example.com", false},
+		{"unknown address", "web01.example.com", "10.0.0.99", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			csrPEM, _ := createTestCSR(t, tt.cn)
+			_, err := ca.IssueCertificateFromCSR(csrPEM, map[string]string{"sans": tt.sans})
+			if tt.allowed {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, certissuer.ErrPolicyViolation)
+			}
+		})
+	}
+
+	_, err := certissuer.NewPolicyEngine(certissuer.PolicyConfig{
+		Default: certissuer.PolicyRule{RequireInventory: true},
+	}, nil)
+	assert.Error(t, err, "An inventory policy needs an inventory")
+}
+
+func TestPolicyAppliesToRenewal(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := certissuer.NewCertificateAuthority(dir, certissuertest.OfflineRootKey(t))
+	require.NoError(t, err)
+	csrPEM, _ := createTestCSR(t, "web.example.com")
+	certPEM, err := ca.IssueCertificateFromCSR(csrPEM, map[string]string{"sans": "10.1.0.5"})
+	require.NoError(t, err)
+
+	// The policy was tightened after the certificate was issued
+	engine, err := certissuer.NewPolicyEngine(certissuer.PolicyConfig{
+		Default: certissuer.PolicyRule{AllowedDomains: []string{"example.com"}, AllowedCIDRs: []string{"10.0.0.0/16"}},
+		APIKeys: map[string]certissuer.PolicyRule{"lab": {AllowedCIDRs: []string{"10.1.0.0/16"}}},
+	}, nil)
+	require.NoError(t, err)
+	ca, err = certissuer.NewCertificateAuthority(dir, certissuer.WithPolicy(engine))
+	require.NoError(t, err)
+
+	_, err = ca.RenewCertificate(certPEM)
+	assert.ErrorIs(t, err, certissuer.ErrPolicyViolation)
+
+	ctx := certissuer.ContextWithRequester(context.Background(), "lab")
+	_, err = ca.RenewCertificateContext(ctx, certPEM)
+	assert.NoError(t, err, "The requester's policy applies to renewals")
+}
+
+func TestNewPolicyEngineRejectsInvalidRules(t *testing.T) {
+	_, err := certissuer.NewPolicyEngine(certissuer.PolicyConfig{
+		APIKeys: map[string]certissuer.PolicyRule{"bad": {AllowedCIDRs: []string{"10.0.0.0/33"}}},
+	}, nil)
+	assert.Error(t, err)
+
+	_, err = certissuer.NewPolicyEngine(certissuer.PolicyConfig{
+		Default: certissuer.PolicyRule{AllowedDomains: []string{"."}},
+	}, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,74 @@
+// internal/certissuer/principal.go
+package certissuer
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/mtls"
+)
+
+// WithReservedPrincipals keeps the names of the configured client
+// certificate principals out of certificates of requesters that may not
+// issue principal certificates (see ContextAllowingPrincipals).
+func WithReservedPrincipals(names ...string) Option {
+	return func(ca *CertificateAuthority) {
+		ca.principals = append(ca.principals, names...)
+	}
+}
+
+type principalsKey struct{}
+
+// ContextAllowingPrincipals lets the request in ctx bind its certificate to
+// a principal with clientInfo["principal"]. Only administrators should get
+// such a context: the principal URI turns the certificate into the
+// credential of a configured user.
+func ContextAllowingPrincipals(ctx context.Context) context.Context {
+	return context.WithValue(ctx, principalsKey{}, true)
+}
+
+func principalsAllowed(ctx context.Context) bool {
+	allowed, _ := ctx.Value(principalsKey{}).(bool)
+	return allowed
+}
+
+// checkPrincipals refuses principal URIs asked for in a CSR or in
+// clientInfo["sans"], a clientInfo["principal"] the requester may not set,
+// and names of reserved principals. The caller need not hold ca.mu.
+func (ca *CertificateAuthority) checkPrincipals(ctx context.Context, req *certificateRequest) error {
+	for _, u := range req.names.URIs {
+		if mtls.IsPrincipalURI(u) {
+			return fmt.Errorf("%w: URI %s is reserved for principal certificates", ErrPolicyViolation, u)
+		}
+	}
+	if principalsAllowed(ctx) {
+		return nil
+	}
+	if req.principal != "" {
+		return fmt.Errorf("%w: only administrators may issue certificates for principal %q", ErrPolicyViolation, req.principal)
+	}
+
+	names := append([]string{req.commonName}, req.names.DNSNames...)
+	names = append(names, req.names.EmailAddresses...)
+	for _, u := range req.names.URIs {
+		names = append(names, u.String())
+	}
+	for _, name := range names {
+		if name != "" && equalsAnyFold(ca.principals, name) {
+			return fmt.Errorf("%w: name %q is reserved for a principal", ErrPolicyViolation, name)
+		}
+	}
+	return nil
+}
+
+// withoutPrincipalURIs returns uris less those binding a principal.
+func withoutPrincipalURIs(uris []*url.URL) []*url.URL {
+	var kept []*url.URL
+	for _, u := range uris {
+		if !mtls.IsPrincipalURI(u) {
+			kept = append(kept, u)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,52 @@
+// internal/certissuer/principal_test.go
+package certissuer_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certissuer"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certissuer/certissuertest"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/mtls"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrincipalCertificates(t *testing.T) {
+	ca, err := certissuer.NewCertificateAuthority(t.TempDir(), certissuertest.OfflineRootKey(t), certissuer.WithReservedPrincipals("agent.example.com"))
+	require.NoError(t, err)
+	ctx := certissuer.ContextWithRequester(context.Background(), "agent")
+	admin := certissuer.ContextAllowingPrincipals(certissuer.ContextWithRequester(context.Background(), "admin"))
+
+	// Requesters cannot claim a principal, by URI or by its name
+	csrPEM, _ := createTestCSR(t, "web.example.com")
+	_, err = ca.IssueCertificateContext(ctx, csrPEM, map[string]string{"principal": "agent.example.com"})
+	assert.ErrorIs(t, err, certissuer.ErrPolicyViolation)
+	_, err = ca.IssueCertificateContext(ctx, csrPEM, map[string]string{"sans": "uri:" + mtls.PrincipalURI("agent.example.com").String()})
+	assert.ErrorIs(t, err, certissuer.ErrPolicyViolation)
+	_, err = ca.IssueCertificateContext(ctx, csrPEM, map[string]string{"sans": "Agent.example.com"})
+	assert.ErrorIs(t, err, certissuer.ErrPolicyViolation)
+	_, err = ca.IssueCertificateContext(ctx, csrPEM, map[string]string{"common_name": "agent.example.com"})
+	assert.ErrorIs(t, err, certissuer.ErrPolicyViolation)
+
+	// Not even administrators can request the URI directly
+	_, err = ca.IssueCertificateContext(admin, csrPEM, map[string]string{"sans": "uri:" + mtls.PrincipalURI("agent.example.com").String()})
+	assert.ErrorIs(t, err, certissuer.ErrPolicyViolation)
+
+	// An administrator binds the certificate to the principal
+	csrPEM, _ = createTestCSR(t, "agent.example.com")
+	certPEM, err := ca.IssueCertificateContext(admin, csrPEM, map[string]string{"principal": "agent.example.com"})
+	require.NoError(t, err)
+	cert := parsePEMCertificate(t, certPEM)
+	principal := mtls.PrincipalFromCertificate(cert)
+	assert.Equal(t, "agent.example.com", principal.ID)
+	user, ok := principal.User(map[string]string{"agent.example.com": "cert-agent"})
+	assert.True(t, ok)
+	assert.Equal(t, "cert-agent", user)
+
+	// Its holder can renew it, keeping the principal
+	renewedPEM, err := ca.RenewCertificateContext(ctx, certPEM)
+	require.NoError(t, err)
+	renewed := mtls.PrincipalFromCertificate(parsePEMCertificate(t, renewedPEM))
+	assert.Equal(t, "agent.example.com", renewed.ID)
+}
@@ -0,0 +1,91 @@
+// internal/certissuer/revocation.go
+package certissuer
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrCertificateNotFound is returned when no issued certificate has the given serial number.
+	ErrCertificateNotFound = errors.New("certificate not found")
+
+	// ErrCertificateAlreadyRevoked is returned when revoking a certificate that is already revoked.
+	ErrCertificateAlreadyRevoked = errors.New("certificate already revoked")
+
+	// ErrInvalidRevocationReason is returned when a revocation reason is not a known CRLReason.
+	ErrInvalidRevocationReason = errors.New("invalid revocation reason")
+)
+
+// RevocationReason is a CRLReason code as defined in RFC 5280 section 5.3.1.
+type RevocationReason int
+
+// CRLReason codes. Value 7 is unused by the RFC.
+const (
+	ReasonUnspecified          RevocationReason = 0
+	ReasonKeyCompromise        RevocationReason = 1
+	ReasonCACompromise         RevocationReason = 2
+	ReasonAffiliationChanged   RevocationReason = 3
+	ReasonSuperseded           RevocationReason = 4
+	ReasonCessationOfOperation RevocationReason = 5
+	ReasonCertificateHold      RevocationReason = 6
+	ReasonRemoveFromCRL        RevocationReason = 8
+	ReasonPrivilegeWithdrawn   RevocationReason = 9
+	ReasonAACompromise         RevocationReason = 10
+)
+
+var reasonNames = map[RevocationReason]string{
+	ReasonUnspecified:          "unspecified",
+	ReasonKeyCompromise:        "keyCompromise",
+	ReasonCACompromise:         "cACompromise",
+	ReasonAffiliationChanged:   "affiliationChanged",
+	ReasonSuperseded:           "superseded",
+	ReasonCessationOfOperation: "cessationOfOperation",
+	ReasonCertificateHold:      "certificateHold",
+	ReasonRemoveFromCRL:        "removeFromCRL",
+	ReasonPrivilegeWithdrawn:   "privilegeWithdrawn",
+	ReasonAACompromise:         "aACompromise",
+}
+
+// String returns the RFC 5280 name of the reason.
+func (r RevocationReason) String() string {
+	if name, ok := reasonNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("RevocationReason(%d)", int(r))
+}
+
+// ParseRevocationReason accepts an RFC 5280 reason name in any case and with
+// optional separators ("keyCompromise", "key_compromise", "KEY-COMPROMISE"),
+// or its numeric code. An empty string means unspecified. removeFromCRL is
+// refused: it only appears in delta CRLs, to unrevoke a certificate on hold,
+// and is never a reason to revoke one.
+func ParseRevocationReason(s string) (RevocationReason, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return ReasonUnspecified, nil
+	}
+
+	if code, err := strconv.Atoi(s); err == nil {
+		if _, ok := reasonNames[RevocationReason(code)]; ok && RevocationReason(code) != ReasonRemoveFromCRL {
+			return RevocationReason(code), nil
+		}
+		return 0, fmt.Errorf("%w: %q", ErrInvalidRevocationReason, s)
+	}
+
+	normalized := normalizeReasonName(s)
+	for reason, name := range reasonNames {
+		if reason != ReasonRemoveFromCRL && normalizeReasonName(name) == normalized {
+			return reason, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", ErrInvalidRevocationReason, s)
+}
+
+// normalizeReasonName lowercases a reason name and drops separators
+func normalizeReasonName(s string) string {
+	s = strings.ToLower(s)
+	return strings.NewReplacer("_", "", "-", "", " ", "").Replace(s)
+}
@@ -0,0 +1,64 @@
+// internal/certissuer/revocation_test.go
+package certissuer_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certissuer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRevocationReason(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    certissuer.RevocationReason
+		wantErr bool
+	}{
+		{"", certissuer.ReasonUnspecified, false},
+		{"keyCompromise", certissuer.ReasonKeyCompromise, false},
+		{"key_compromise", certissuer.ReasonKeyCompromise, false},
+		{"CESSATION-OF-OPERATION", certissuer.ReasonCessationOfOperation, false},
+		{"4", certissuer.ReasonSuperseded, false},
+		{"7", 0, true},
+		{"8", 0, true},
+		{"removeFromCRL", 0, true},
+		{"host was stolen", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := certissuer.ParseRevocationReason(tt.input)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, certissuer.ErrInvalidRevocationReason)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRevokeCertificate(t *testing.T) {
+	svc, tempDir := setupTestService(t)
+	defer os.RemoveAll(tempDir)
+	ctx := context.Background()
+
+	csrPEM, _ := createTestCSR(t, "revoke.example.com")
+	certPEM, err := svc.IssueCertificate(ctx, csrPEM, map[string]string{})
+	require.NoError(t, err)
+	serial := serialOf(t, certPEM)
+
+	err = svc.RevokeCertificate(ctx, "12345", certissuer.ReasonUnspecified)
+	assert.ErrorIs(t, err, certissuer.ErrCertificateNotFound)
+
+	require.NoError(t, svc.RevokeCertificate(ctx, serial, certissuer.ReasonSuperseded))
+
+	err = svc.RevokeCertificate(ctx, serial, certissuer.ReasonKeyCompromise)
+	assert.ErrorIs(t, err, certissuer.ErrCertificateAlreadyRevoked)
+
+	_, err = svc.RenewCertificate(ctx, certPEM)
+	assert.Error(t, err, "Revoked certificates should not be renewable")
+}
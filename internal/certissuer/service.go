@@ -11,11 +11,28 @@ import (
 // CertIssuer defines the interface for certificate management.
 type CertIssuer interface {
 	// IssueCertificate issues a certificate based on the provided CSR and client info.
+	// The PEM result is the leaf followed by the issuing intermediate.
 	IssueCertificate(ctx context.Context, csr []byte, clientInfo map[string]string) ([]byte, error)
 	// RenewCertificate renews the given certificate.
 	RenewCertificate(ctx context.Context, cert []byte) ([]byte, error)
-	// GetRootCA returns the root CA certificate.
+	// GetRootCA returns the CA chain: the issuing intermediate, then the root.
 	GetRootCA(ctx context.Context) ([]byte, error)
+	// RevokeCertificate revokes the certificate with the given serial number.
+	RevokeCertificate(ctx context.Context, serialNumber string, reason RevocationReason) error
+	// GetCRL returns the current DER-encoded certificate revocation list.
+	GetCRL(ctx context.Context) ([]byte, error)
+	// GetIssuerCRL returns the DER-encoded CRL of the current or a retired
+	// intermediate, by the ID ending its CRL distribution point.
+	GetIssuerCRL(ctx context.Context, issuerID string) ([]byte, error)
+	// GetCRLBundle returns the CRLs of all issuers as concatenated PEM.
+	GetCRLBundle(ctx context.Context) ([]byte, error)
+	// GetOCSPResponse answers a DER-encoded OCSP request. The returned bytes
+	// are a valid OCSP response even when an error is returned.
+	GetOCSPResponse(ctx context.Context, request []byte) ([]byte, error)
+	// ListCertificates returns a page of issued certificates matching filter.
+	ListCertificates(ctx context.Context, filter CertificateFilter, pageSize int, pageToken string) (*CertificatePage, error)
+	// GetCertificate returns the issued certificate with the given serial number.
+	GetCertificate(ctx context.Context, serialNumber string) (*Certificate, error)
 }
 
 // Service implements the CertIssuer interface.
@@ -24,8 +41,10 @@ type Service struct {
 	certStorage string
 }
 
-// NewService creates a new instance of the CertIssuer service.
-func NewService(certStorage string) CertIssuer {
+// NewService creates a new instance of the CertIssuer service. It fails if
+// the CA cannot be loaded or created with opts, rather than fall back to a
+// CA without its keys, policy or storage.
+func NewService(certStorage string, opts ...Option) (CertIssuer, error) {
 	// Default storage location if none provided
 	if certStorage == "" {
 		homeDir, err := os.UserHomeDir()
@@ -36,33 +55,79 @@ func NewService(certStorage string) CertIssuer {
 		}
 	}
 
-	ca, err := NewCertificateAuthority(certStorage)
+	ca, err := NewCertificateAuthority(certStorage, opts...)
 	if err != nil {
-		fmt.Printf("Error initializing certificate authority: %v\n", err)
-		// Fallback to in-memory CA
-		ca, _ = NewCertificateAuthority("")
+		return nil, fmt.Errorf("failed to initialize certificate authority: %w", err)
 	}
 
 	return &Service{
 		ca:          ca,
 		certStorage: certStorage,
-	}
+	}, nil
 }
 
 // IssueCertificate issues a certificate based on the provided CSR and client info.
 func (s *Service) IssueCertificate(ctx context.Context, csr []byte, clientInfo map[string]string) ([]byte, error) {
 	fmt.Println("Issuing certificate for client:", clientInfo)
-	return s.ca.IssueCertificateFromCSR(csr, clientInfo)
+	return s.ca.IssueCertificateContext(ctx, csr, clientInfo)
 }
 
 // RenewCertificate renews the given certificate.
 func (s *Service) RenewCertificate(ctx context.Context, cert []byte) ([]byte, error) {
 	fmt.Println("Renewing certificate")
-	return s.ca.RenewCertificate(cert)
+	return s.ca.RenewCertificateContext(ctx, cert)
 }
 
-// GetRootCA returns the root CA certificate.
+// GetRootCA returns the CA chain: the issuing intermediate, then the root.
 func (s *Service) GetRootCA(ctx context.Context) ([]byte, error) {
 	fmt.Println("Retrieving root CA certificate")
 	return s.ca.GetCACertificate(), nil
 }
+
+// RevokeCertificate revokes the certificate with the given serial number.
+func (s *Service) RevokeCertificate(ctx context.Context, serialNumber string, reason RevocationReason) error {
+	fmt.Printf("Revoking certificate %s (%s)\n", serialNumber, reason)
+	return s.ca.RevokeCertificate(serialNumber, reason)
+}
+
+// GetCRL returns the current DER-encoded certificate revocation list.
+func (s *Service) GetCRL(ctx context.Context) ([]byte, error) {
+	return s.ca.CRL()
+}
+
+// GetIssuerCRL returns the DER-encoded CRL of the issuer with the given ID.
+func (s *Service) GetIssuerCRL(ctx context.Context, issuerID string) ([]byte, error) {
+	return s.ca.IssuerCRL(issuerID)
+}
+
+// GetCRLBundle returns the CRLs of all issuers as concatenated PEM.
+func (s *Service) GetCRLBundle(ctx context.Context) ([]byte, error) {
+	crls, err := s.ca.CRLs()
+	if err != nil {
+		return nil, err
+	}
+	var bundle []byte
+	for _, crlDER := range crls {
+		bundle = append(bundle, EncodeCRLPEM(crlDER)...)
+	}
+	return bundle, nil
+}
+
+// GetOCSPResponse answers a DER-encoded OCSP request.
+func (s *Service) GetOCSPResponse(ctx context.Context, request []byte) ([]byte, error) {
+	return s.ca.OCSPResponse(request)
+}
+
+// ListCertificates returns a page of issued certificates matching filter.
+func (s *Service) ListCertificates(ctx context.Context, filter CertificateFilter, pageSize int, pageToken string) (*CertificatePage, error) {
+	return s.ca.ListCertificates(filter, pageSize, pageToken)
+}
+
+// GetCertificate returns the issued certificate with the given serial number.
+func (s *Service) GetCertificate(ctx context.Context, serialNumber string) (*Certificate, error) {
+	cert, ok := s.ca.LookupCertificate(serialNumber)
+	if !ok {
+		return nil, fmt.Errorf("%w: serial number %s", ErrCertificateNotFound, serialNumber)
+	}
+	return cert, nil
+}
@@ -10,9 +10,11 @@ import (
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certissuer"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certissuer/certissuertest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -24,12 +26,23 @@ func setupTestService(t *testing.T) (certissuer.CertIssuer, string) {
 	require.NoError(t, err, "Failed to create temp directory")
 
 	// Create the service
-	svc := certissuer.NewService(tempDir)
+	svc, err := certissuer.NewService(tempDir, certissuertest.OfflineRootKey(t))
+	require.NoError(t, err)
 	require.NotNil(t, svc, "Service should not be nil")
 
 	return svc, tempDir
 }
 
+func TestNewServiceFailsWithoutCA(t *testing.T) {
+	// A storage path that cannot hold the CA must not yield a throw-away one
+	path := filepath.Join(t.TempDir(), "not-a-directory")
+	require.NoError(t, os.WriteFile(path, []byte("x"), 0600))
+
+	svc, err := certissuer.NewService(path)
+	assert.Error(t, err)
+	assert.Nil(t, svc)
+}
+
 // Helper function to create a test CSR
 func createTestCSR(t *testing.T, commonName string) ([]byte, *rsa.PrivateKey) {
 	// Generate a private key
@@ -170,15 +183,13 @@ func TestGetRootCA(t *testing.T) {
 	files, err := os.ReadDir(tempDir)
 	require.NoError(t, err, "Should be able to read temp directory")
 
-	foundCAFile := false
+	found := make(map[string]bool)
 	for _, file := range files {
-		if file.Name() == "ca.crt" {
-			foundCAFile = true
-			break
-		}
+		found[file.Name()] = true
 	}
 
-	assert.True(t, foundCAFile, "CA certificate file should exist in storage directory")
+	assert.True(t, found["root.crt"], "Root CA certificate file should exist in storage directory")
+	assert.True(t, found["intermediate.crt"], "Intermediate CA certificate file should exist in storage directory")
 }
 
 func TestPersistenceAndReload(t *testing.T) {
@@ -191,7 +202,8 @@ func TestPersistenceAndReload(t *testing.T) {
 	require.NoError(t, err)
 
 	// Create a second service using the same directory
-	svc2 := certissuer.NewService(tempDir)
+	svc2, err := certissuer.NewService(tempDir)
+	require.NoError(t, err)
 	require.NotNil(t, svc2)
 
 	// Get the CA from the second service
@@ -0,0 +1,281 @@
+// internal/certissuer/store.go
+package certissuer
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/atomicfile"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/database"
+)
+
+// Store persists issued certificates and their metadata so that the CA can
+// rebuild its state after a restart.
+type Store interface {
+	// Save creates or replaces the stored record for cert.
+	Save(cert *Certificate) error
+	// LoadAll returns every stored certificate.
+	LoadAll() ([]*Certificate, error)
+}
+
+// certificateRecord is the on-disk metadata stored next to each certificate.
+type certificateRecord struct {
+	Serial           string            `json:"serial"`
+	IssuedTo         string            `json:"issued_to"`
+	ClientInfo       map[string]string `json:"client_info,omitempty"`
+	IssuedAt         time.Time         `json:"issued_at"`
+	ExpiresAt        time.Time         `json:"expires_at"`
+	Revoked          bool              `json:"revoked"`
+	RevocationReason RevocationReason  `json:"revocation_reason,omitempty"`
+	RevokedAt        *time.Time        `json:"revoked_at,omitempty"`
+}
+
+// FileStore keeps each certificate as <serial>.crt with its metadata in
+// <serial>.json inside a directory.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns a Store backed by dir.
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{dir: dir}
+}
+
+// Save implements Store. The metadata file is replaced atomically so a crash
+// never leaves a half-written record behind.
+func (fs *FileStore) Save(cert *Certificate) error {
+	serial := cert.Serial.String()
+
+	certPath := filepath.Join(fs.dir, serial+".crt")
+	if _, err := os.Stat(certPath); errors.Is(err, os.ErrNotExist) {
+		if err := atomicfile.WriteFile(osFs, certPath, cert.CertPEM, 0644); err != nil {
+			return fmt.Errorf("failed to save certificate %s: %w", serial, err)
+		}
+	}
+
+	record := certificateRecord{
+		Serial:           serial,
+		IssuedTo:         cert.IssuedTo,
+		ClientInfo:       cert.ClientInfo,
+		IssuedAt:         cert.IssuedAt,
+		ExpiresAt:        cert.ExpiresAt,
+		Revoked:          cert.IsRevoked,
+		RevocationReason: cert.RevocationReason,
+	}
+	if cert.IsRevoked {
+		revokedAt := cert.RevokedAt
+		record.RevokedAt = &revokedAt
+	}
+
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata for %s: %w", serial, err)
+	}
+	if err := atomicfile.WriteFile(osFs, filepath.Join(fs.dir, serial+".json"), data, 0644); err != nil {
+		return fmt.Errorf("failed to save metadata for %s: %w", serial, err)
+	}
+	return nil
+}
+
+// LoadAll implements Store. Certificates written before metadata was kept
+// are loaded with what can be recovered from the certificate itself.
+func (fs *FileStore) LoadAll() ([]*Certificate, error) {
+	entries, err := os.ReadDir(fs.dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read certificate store: %w", err)
+	}
+
+	var certs []*Certificate
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".crt") {
+			continue
+		}
+		serial := strings.TrimSuffix(name, ".crt")
+		if _, ok := new(big.Int).SetString(serial, 10); !ok {
+			// ca.crt and other non-serial files
+			continue
+		}
+
+		cert, err := fs.load(serial)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// load reads a single certificate and its metadata.
+func (fs *FileStore) load(serial string) (*Certificate, error) {
+	certPEM, err := os.ReadFile(filepath.Join(fs.dir, serial+".crt"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate %s: %w", serial, err)
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("failed to decode certificate %s", serial)
+	}
+	x509Cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate %s: %w", serial, err)
+	}
+
+	cert := &Certificate{
+		Serial:    x509Cert.SerialNumber,
+		Cert:      x509Cert,
+		CertPEM:   certPEM,
+		IssuedAt:  x509Cert.NotBefore,
+		ExpiresAt: x509Cert.NotAfter,
+		IssuedTo:  x509Cert.Subject.CommonName,
+	}
+
+	data, err := os.ReadFile(filepath.Join(fs.dir, serial+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return cert, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata for %s: %w", serial, err)
+	}
+
+	var record certificateRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("failed to decode metadata for %s: %w", serial, err)
+	}
+	cert.IssuedTo = record.IssuedTo
+	cert.ClientInfo = record.ClientInfo
+	cert.IsRevoked = record.Revoked
+	cert.RevocationReason = record.RevocationReason
+	if record.RevokedAt != nil {
+		cert.RevokedAt = *record.RevokedAt
+	}
+	return cert, nil
+}
+
+// Metadata keys of certificates kept in a DatabaseStore. Client information
+// is stored under clientInfoPrefix.
+const (
+	metadataRevocationReason = "revocation_reason"
+	metadataRevokedAt        = "revoked_at"
+	clientInfoPrefix         = "client_info."
+)
+
+// DatabaseStore keeps issued certificates in the certificates table of the
+// database. The CA only reads the store when it starts, so it is not a way
+// to share state between cert-issuer replicas: run one issuer per store.
+type DatabaseStore struct {
+	certs database.CertificateRepository
+}
+
+// NewDatabaseStore returns a Store backed by the certificate repository.
+func NewDatabaseStore(certs database.CertificateRepository) *DatabaseStore {
+	return &DatabaseStore{certs: certs}
+}
+
+// Save implements Store.
+func (ds *DatabaseStore) Save(cert *Certificate) error {
+	ctx := context.Background()
+	info := &database.CertificateInfo{
+		SerialNumber:   cert.Serial.String(),
+		IssuedTo:       cert.IssuedTo,
+		IssuedAt:       cert.IssuedAt,
+		ExpiresAt:      cert.ExpiresAt,
+		Revoked:        cert.IsRevoked,
+		CertificatePEM: string(cert.CertPEM),
+		Metadata:       make(map[string]string, len(cert.ClientInfo)+2),
+	}
+	if cert.Cert != nil {
+		info.SubjectName = cert.Cert.Subject.CommonName
+	}
+	for key, value := range cert.ClientInfo {
+		info.Metadata[clientInfoPrefix+key] = value
+	}
+	if cert.IsRevoked {
+		info.Metadata[metadataRevocationReason] = strconv.Itoa(int(cert.RevocationReason))
+		info.Metadata[metadataRevokedAt] = cert.RevokedAt.UTC().Format(time.RFC3339Nano)
+	}
+
+	if err := ds.certs.Save(ctx, info); err != nil {
+		return fmt.Errorf("failed to save certificate %s: %w", info.SerialNumber, err)
+	}
+	return nil
+}
+
+// LoadAll implements Store.
+func (ds *DatabaseStore) LoadAll() ([]*Certificate, error) {
+	ctx := context.Background()
+	var (
+		certs []*Certificate
+		opts  = database.ListOptions{PageSize: database.MaxPageSize}
+	)
+	for {
+		page, err := ds.certs.List(ctx, database.CertificateFilter{}, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read certificate store: %w", err)
+		}
+		for _, info := range page.Items {
+			cert, err := certificateFromInfo(info)
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, cert)
+		}
+		if page.NextPageToken == "" {
+			return certs, nil
+		}
+		opts.PageToken = page.NextPageToken
+	}
+}
+
+// certificateFromInfo rebuilds a certificate record from its database row.
+func certificateFromInfo(info *database.CertificateInfo) (*Certificate, error) {
+	serial := info.SerialNumber
+	block, _ := pem.Decode([]byte(info.CertificatePEM))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("failed to decode certificate %s", serial)
+	}
+	x509Cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate %s: %w", serial, err)
+	}
+
+	cert := &Certificate{
+		Serial:    x509Cert.SerialNumber,
+		Cert:      x509Cert,
+		CertPEM:   []byte(info.CertificatePEM),
+		IssuedAt:  info.IssuedAt,
+		ExpiresAt: info.ExpiresAt,
+		IssuedTo:  info.IssuedTo,
+		IsRevoked: info.Revoked,
+	}
+	for key, value := range info.Metadata {
+		if name, ok := strings.CutPrefix(key, clientInfoPrefix); ok {
+			if cert.ClientInfo == nil {
+				cert.ClientInfo = make(map[string]string)
+			}
+			cert.ClientInfo[name] = value
+		}
+	}
+	if cert.IsRevoked {
+		if reason, err := strconv.Atoi(info.Metadata[metadataRevocationReason]); err == nil {
+			cert.RevocationReason = RevocationReason(reason)
+		}
+		if revokedAt, err := time.Parse(time.RFC3339Nano, info.Metadata[metadataRevokedAt]); err == nil {
+			cert.RevokedAt = revokedAt
+		}
+	}
+	return cert, nil
+}
@@ -0,0 +1,126 @@
+// internal/certissuer/store_test.go
+package certissuer_test
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certissuer"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certissuer/certissuertest"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/configuration"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serialOf returns the decimal serial number of a PEM certificate
+func serialOf(t *testing.T, certPEM []byte) string {
+	block, _ := pem.Decode(certPEM)
+	require.NotNil(t, block, "Failed to decode certificate PEM")
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err, "Failed to parse certificate")
+	return cert.SerialNumber.String()
+}
+
+func TestCertificateStoreSurvivesRestart(t *testing.T) {
+	tempDir := t.TempDir()
+
+	ca1, err := certissuer.NewCertificateAuthority(tempDir, certissuertest.OfflineRootKey(t))
+	require.NoError(t, err)
+
+	csrPEM, _ := createTestCSR(t, "node01.example.com")
+	clientInfo := map[string]string{"common_name": "node01.example.com", "organization": "Lab"}
+	certPEM, err := ca1.IssueCertificateFromCSR(csrPEM, clientInfo)
+	require.NoError(t, err)
+	serial := serialOf(t, certPEM)
+
+	require.NoError(t, ca1.RevokeCertificate(serial, certissuer.ReasonKeyCompromise))
+	assert.FileExists(t, filepath.Join(tempDir, serial+".json"), "Metadata should be stored next to the certificate")
+
+	// A new CA over the same directory sees the same state
+	ca2, err := certissuer.NewCertificateAuthority(tempDir)
+	require.NoError(t, err)
+
+	stored, ok := ca2.LookupCertificate(serial)
+	require.True(t, ok, "Issued certificate should be reloaded")
+	assert.Equal(t, "node01.example.com", stored.IssuedTo)
+	assert.Equal(t, clientInfo, stored.ClientInfo)
+	assert.True(t, stored.IsRevoked, "Revocation should survive a restart")
+	assert.Equal(t, certissuer.ReasonKeyCompromise, stored.RevocationReason)
+	assert.False(t, stored.RevokedAt.IsZero(), "Revocation time should survive a restart")
+
+	_, err = ca2.RenewCertificate(certPEM)
+	assert.Error(t, err, "Renewing a revoked certificate should fail after restart")
+}
+
+func TestCertificateStoreLegacyFiles(t *testing.T) {
+	tempDir := t.TempDir()
+
+	ca1, err := certissuer.NewCertificateAuthority(tempDir, certissuertest.OfflineRootKey(t))
+	require.NoError(t, err)
+
+	csrPEM, _ := createTestCSR(t, "legacy.example.com")
+	certPEM, err := ca1.IssueCertificateFromCSR(csrPEM, map[string]string{})
+	require.NoError(t, err)
+	serial := serialOf(t, certPEM)
+
+	// Certificates written before metadata was kept only have the .crt file
+	require.NoError(t, os.Remove(filepath.Join(tempDir, serial+".json")))
+
+	ca2, err := certissuer.NewCertificateAuthority(tempDir)
+	require.NoError(t, err)
+
+	stored, ok := ca2.LookupCertificate(serial)
+	require.True(t, ok, "Certificates without metadata should still be loaded")
+	assert.Equal(t, "legacy.example.com", stored.IssuedTo)
+	assert.False(t, stored.IsRevoked)
+
+	renewedPEM, err := ca2.RenewCertificate(certPEM)
+	require.NoError(t, err)
+	_, ok = ca2.LookupCertificate(serialOf(t, renewedPEM))
+	assert.True(t, ok, "Renewed certificate should be stored")
+}
+
+func TestDatabaseStoreSurvivesRestart(t *testing.T) {
+	tempDir := t.TempDir()
+	ctx := context.Background()
+
+	db := database.NewServiceWithConfig(configuration.DatabaseConfig{
+		Type:   "sqlite",
+		SQLite: configuration.SQLiteConfig{Path: filepath.Join(tempDir, "certs.sqlite")},
+	})
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, db.Connect(ctx))
+	require.NoError(t, db.MigrateSchema(ctx))
+
+	caDir := filepath.Join(tempDir, "ca")
+	store := certissuer.NewDatabaseStore(db.Certificates())
+	ca1, err := certissuer.NewCertificateAuthority(caDir, certissuertest.OfflineRootKey(t), certissuer.WithStore(store))
+	require.NoError(t, err)
+
+	csrPEM, _ := createTestCSR(t, "node01.example.com")
+	clientInfo := map[string]string{"common_name": "node01.example.com", "organization": "Lab"}
+	certPEM, err := ca1.IssueCertificateFromCSR(csrPEM, clientInfo)
+	require.NoError(t, err)
+	serial := serialOf(t, certPEM)
+	require.NoError(t, ca1.RevokeCertificate(serial, certissuer.ReasonKeyCompromise))
+	assert.NoFileExists(t, filepath.Join(caDir, serial+".json"), "Metadata should only be kept in the database")
+
+	info, err := db.Certificates().Get(ctx, serial)
+	require.NoError(t, err)
+	assert.Equal(t, "node01.example.com", info.IssuedTo)
+	assert.True(t, info.Revoked)
+
+	ca2, err := certissuer.NewCertificateAuthority(caDir, certissuer.WithStore(store))
+	require.NoError(t, err)
+	stored, ok := ca2.LookupCertificate(serial)
+	require.True(t, ok, "Issued certificate should be reloaded")
+	assert.Equal(t, clientInfo, stored.ClientInfo)
+	assert.True(t, stored.IsRevoked)
+	assert.Equal(t, certissuer.ReasonKeyCompromise, stored.RevocationReason)
+	assert.False(t, stored.RevokedAt.IsZero())
+}
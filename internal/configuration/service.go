@@ -25,7 +25,8 @@ type DatabaseConfig struct {
 
 // SQLiteConfig holds configuration for the SQLite database.
 type SQLiteConfig struct {
-	Path string `mapstructure:"path"`
+	Path         string `mapstructure:"path"`
+	MaxOpenConns int    `mapstructure:"max_open_conns"`
 }
 
 // CockroachConfig holds configuration for the CockroachDB database.
@@ -35,6 +36,18 @@ type CockroachConfig struct {
 	User     string `mapstructure:"user"`
 	Password string `mapstructure:"password"`
 	Database string `mapstructure:"database"`
+
+	// TLS settings. SSLCert and SSLKey are a client certificate (typically
+	// issued by cert-issuer) and SSLRootCert is the CA used to verify the cluster.
+	SSLMode     string `mapstructure:"ssl_mode"`
+	SSLRootCert string `mapstructure:"ssl_root_cert"`
+	SSLCert     string `mapstructure:"ssl_cert"`
+	SSLKey      string `mapstructure:"ssl_key"`
+
+	// Connection pool settings
+	MaxOpenConns    int           `mapstructure:"max_open_conns"`
+	MaxIdleConns    int           `mapstructure:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `mapstructure:"conn_max_lifetime"`
 }
 
 // FileEditorConfig holds file editor-related configuration.
@@ -44,6 +57,75 @@ type FileEditorConfig struct {
 	HostnameSymlink   bool   `mapstructure:"hostname_symlink"`
 }
 
+// GRPCAuthConfig holds the TLS and client certificate settings shared by
+// the gRPC listeners.
+type GRPCAuthConfig struct {
+	// MutualTLS requires every client to present a certificate issued by
+	// the CA in ClientCAFile. Without it, client certificates are verified
+	// only when offered.
+	MutualTLS bool `mapstructure:"mutual_tls"`
+
+	// CertFile and KeyFile are the server certificate. gRPC is served in
+	// plaintext when they are empty.
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+
+	// ClientCAFile is the CA bundle client certificates are verified
+	// against. Include the intermediates so the CRL signature can be
+	// checked after an intermediate rotation.
+	ClientCAFile string `mapstructure:"client_ca_file"`
+
+	// CRLFile or CRLURL is the revocation list checked for every client
+	// certificate. A service that runs the CA checks its own CRL.
+	CRLFile string `mapstructure:"crl_file"`
+	CRLURL  string `mapstructure:"crl_url"`
+
+	// Principals maps client certificate principals to the user they act
+	// as. A certificate names its principal in a URI SAN the cert-issuer
+	// adds only for administrators (client_info "principal"); its subject
+	// and other names are ignored. Names are matched case-insensitively.
+	Principals map[string]string `mapstructure:"principals"`
+
+	// Scopes lists the permissions of each user, such as "certs:issue" or
+	// "inventory:read", for clients authenticated by certificate or by one
+	// of APIKeys. Users without an entry may only call unrestricted RPCs.
+	Scopes map[string][]string `mapstructure:"scopes"`
+
+	// APIKeys are static API keys accepted by services without their own
+	// key store, such as the webserver.
+	APIKeys []APIKeyConfig `mapstructure:"api_keys"`
+
+	// JWT accepts bearer tokens from an identity provider.
+	JWT JWTConfig `mapstructure:"jwt"`
+}
+
+// JWTConfig configures bearer token authentication. Tokens are accepted
+// when SecretFile or PublicKeyFile is set.
+type JWTConfig struct {
+	// Issuer must match the token's "iss" claim when set. Audience must
+	// match its "aud" claim and is required when tokens are accepted.
+	Issuer   string `mapstructure:"issuer"`
+	Audience string `mapstructure:"audience"`
+
+	// SecretFile holds the shared secret of HMAC signed tokens.
+	SecretFile string `mapstructure:"secret_file"`
+	// PublicKeyFile holds the PEM public keys of RSA, ECDSA or Ed25519
+	// signed tokens.
+	PublicKeyFile string `mapstructure:"public_key_file"`
+
+	// UserClaim names the user, "sub" by default.
+	UserClaim string `mapstructure:"user_claim"`
+	// ScopesClaim lists the permissions, "scope" by default, as a space
+	// separated string or an array. Without it the user's Scopes apply.
+	ScopesClaim string `mapstructure:"scopes_claim"`
+}
+
+// APIKeyConfig is an API key defined in the configuration file.
+type APIKeyConfig struct {
+	User string `mapstructure:"user"`
+	Key  string `mapstructure:"key"`
+}
+
 // ConfigService defines operations to manage configurations.
 type ConfigService interface {
 	LoadConfig() (Config, error)
@@ -0,0 +1,38 @@
+// internal/database/apikeys.go
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// APIKeyUsage records when API keys are used.
+type APIKeyUsage interface {
+	// TouchAPIKey sets the last use of the API key id to usedAt. Only
+	// last_used_at is written, so it cannot undo a concurrent update such
+	// as a revocation. It returns ErrNotFound if the key does not exist.
+	TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error
+}
+
+// TouchAPIKey implements APIKeyUsage.
+func (s *Service) TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+	db, err := s.DB()
+	if err != nil {
+		return err
+	}
+
+	query := "UPDATE api_keys SET last_used_at = ? WHERE id = ?"
+	res, err := db.ExecContext(ctx, s.dialect.rebind(query), usedAt.UTC(), id)
+	if err != nil {
+		return &QueryError{Op: "touch api key", Query: query, Err: err}
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return &QueryError{Op: "rows affected", Err: err}
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: api_keys %s", ErrNotFound, id)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+// internal/database/cockroach.go
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/configuration"
+)
+
+const (
+	// Default connection settings for CockroachDB
+	defaultCockroachPort     = 26257
+	defaultCockroachDatabase = "ubuntu_autoinstall"
+	defaultCockroachUser     = "root"
+
+	// Default pool settings for CockroachDB
+	cockroachMaxOpenConns    = 20
+	cockroachMaxIdleConns    = 5
+	cockroachConnMaxLifetime = 30 * time.Minute
+)
+
+// cockroachDSN builds a PostgreSQL connection URL for the given configuration.
+//
+// When a client certificate and key are configured (for example ones issued by
+// the cert-issuer service) they are presented to the cluster, and the server
+// certificate is verified against ssl_root_cert, normally the cert-issuer CA.
+func cockroachDSN(cfg configuration.CockroachConfig) string {
+	host := cfg.Host
+	if host == "" {
+		host = "localhost"
+	}
+	port := cfg.Port
+	if port == 0 {
+		port = defaultCockroachPort
+	}
+	database := cfg.Database
+	if database == "" {
+		database = defaultCockroachDatabase
+	}
+	user := cfg.User
+	if user == "" {
+		user = defaultCockroachUser
+	}
+
+	u := url.URL{
+		Scheme: "postgresql",
+		Host:   net.JoinHostPort(host, strconv.Itoa(port)),
+		Path:   "/" + database,
+	}
+	if cfg.Password != "" {
+		u.User = url.UserPassword(user, cfg.Password)
+	} else {
+		u.User = url.User(user)
+	}
+
+	params := url.Values{}
+	params.Set("application_name", "ubuntu-autoinstall-webhook")
+	params.Set("sslmode", cockroachSSLMode(cfg))
+	if cfg.SSLRootCert != "" {
+		params.Set("sslrootcert", cfg.SSLRootCert)
+	}
+	if cfg.SSLCert != "" && cfg.SSLKey != "" {
+		params.Set("sslcert", cfg.SSLCert)
+		params.Set("sslkey", cfg.SSLKey)
+	}
+	u.RawQuery = params.Encode()
+
+	return u.String()
+}
+
+// cockroachSSLMode returns the configured sslmode, defaulting to verify-full
+// whenever any certificate material has been supplied.
+func cockroachSSLMode(cfg configuration.CockroachConfig) string {
+	if cfg.SSLMode != "" {
+		return cfg.SSLMode
+	}
+	if cfg.SSLRootCert != "" || (cfg.SSLCert != "" && cfg.SSLKey != "") {
+		return "verify-full"
+	}
+	return "disable"
+}
+
+// openCockroach opens a connection pool to CockroachDB using the pgx driver.
+func openCockroach(cfg configuration.CockroachConfig) (*sql.DB, error) {
+	if (cfg.SSLCert == "") != (cfg.SSLKey == "") {
+		return nil, fmt.Errorf("cockroachdb ssl_cert and ssl_key must be set together")
+	}
+
+	connConfig, err := pgx.ParseConfig(cockroachDSN(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("invalid cockroachdb configuration: %w", err)
+	}
+
+	db := stdlib.OpenDB(*connConfig)
+
+	maxOpen := cfg.MaxOpenConns
+	if maxOpen <= 0 {
+		maxOpen = cockroachMaxOpenConns
+	}
+	maxIdle := cfg.MaxIdleConns
+	if maxIdle <= 0 {
+		maxIdle = cockroachMaxIdleConns
+	}
+	lifetime := cfg.ConnMaxLifetime
+	if lifetime <= 0 {
+		lifetime = cockroachConnMaxLifetime
+	}
+	db.SetMaxOpenConns(maxOpen)
+	db.SetMaxIdleConns(maxIdle)
+	db.SetConnMaxLifetime(lifetime)
+
+	return db, nil
+}
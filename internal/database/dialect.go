@@ -0,0 +1,48 @@
+// internal/database/dialect.go
+package database
+
+import (
+	"strconv"
+	"strings"
+)
+
+// dialect captures the SQL differences between the supported backends.
+type dialect struct {
+	name string
+	// numberedParams is true when the driver expects $1, $2... instead of ?.
+	numberedParams bool
+}
+
+var (
+	sqliteDialect    = dialect{name: "sqlite"}
+	cockroachDialect = dialect{name: "cockroachdb", numberedParams: true}
+)
+
+// rebind rewrites ? placeholders into the form expected by the dialect.
+// Question marks inside single-quoted string literals are left untouched.
+func (d dialect) rebind(query string) string {
+	if !d.numberedParams || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+
+	n := 0
+	inString := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'':
+			inString = !inString
+			b.WriteByte(c)
+		case c == '?' && !inString:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
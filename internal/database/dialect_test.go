@@ -0,0 +1,78 @@
+// internal/database/dialect_test.go
+package database
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/configuration"
+)
+
+func TestRebind(t *testing.T) {
+	query := "SELECT * FROM servers WHERE hostname = ? AND description <> 'why?' AND status = ?"
+
+	if got := sqliteDialect.rebind(query); got != query {
+		t.Errorf("sqlite rebind changed the query: %s", got)
+	}
+
+	want := "SELECT * FROM servers WHERE hostname = $1 AND description <> 'why?' AND status = $2"
+	if got := cockroachDialect.rebind(query); got != want {
+		t.Errorf("cockroach rebind:\n got  %s\n want %s", got, want)
+	}
+}
+
+func TestCockroachDSN(t *testing.T) {
+	testCases := []struct {
+		name     string
+		cfg      configuration.CockroachConfig
+		wantMode string
+		wantCert bool
+	}{
+		{
+			name:     "Insecure defaults",
+			cfg:      configuration.CockroachConfig{Host: "db.example.com"},
+			wantMode: "disable",
+		},
+		{
+			name: "Client certificate from cert-issuer",
+			cfg: configuration.CockroachConfig{
+				Host:        "db.example.com",
+				Port:        26258,
+				User:        "autoinstall",
+				Database:    "webhook",
+				SSLRootCert: "/etc/autoinstall/ca.crt",
+				SSLCert:     "/etc/autoinstall/client.crt",
+				SSLKey:      "/etc/autoinstall/client.key",
+			},
+			wantMode: "verify-full",
+			wantCert: true,
+		},
+		{
+			name: "Explicit sslmode wins",
+			cfg: configuration.CockroachConfig{
+				SSLMode:     "verify-ca",
+				SSLRootCert: "/etc/autoinstall/ca.crt",
+			},
+			wantMode: "verify-ca",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			u, err := url.Parse(cockroachDSN(tc.cfg))
+			if err != nil {
+				t.Fatalf("cockroachDSN produced an invalid URL: %v", err)
+			}
+			params := u.Query()
+			if got := params.Get("sslmode"); got != tc.wantMode {
+				t.Errorf("sslmode = %q, want %q", got, tc.wantMode)
+			}
+			if hasCert := params.Get("sslcert") != ""; hasCert != tc.wantCert {
+				t.Errorf("sslcert present = %v, want %v", hasCert, tc.wantCert)
+			}
+			if tc.cfg.Port != 0 && u.Port() != "26258" {
+				t.Errorf("port = %q, want 26258", u.Port())
+			}
+		})
+	}
+}
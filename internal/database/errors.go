@@ -0,0 +1,70 @@
+// internal/database/errors.go
+package database
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mattn/go-sqlite3"
+)
+
+var (
+	// ErrNotConnected is returned when an operation is attempted before Connect.
+	ErrNotConnected = errors.New("database: not connected")
+
+	// ErrUnsupportedDatabase is returned when database.type names an unknown backend.
+	ErrUnsupportedDatabase = errors.New("database: unsupported database type")
+
+	// ErrInvalidRecord is returned when a record cannot be mapped to a table row.
+	ErrInvalidRecord = errors.New("database: invalid record")
+
+	// ErrNotFound is returned when no row matches the requested key.
+	ErrNotFound = errors.New("database: not found")
+
+	// ErrAlreadyExists is returned when an insert or update violates a unique constraint.
+	ErrAlreadyExists = errors.New("database: already exists")
+
+	// ErrInvalidPageToken is returned when a List page token cannot be decoded.
+	ErrInvalidPageToken = errors.New("database: invalid page token")
+
+	// ErrLeaseHeld is returned when a lease is currently owned by another holder.
+	ErrLeaseHeld = errors.New("database: lease held by another holder")
+
+	// ErrLeaseLost is returned when a lease expired or changed hands before it
+	// could be renewed or released.
+	ErrLeaseLost = errors.New("database: lease lost")
+)
+
+// QueryError describes a failed statement together with the operation that issued it.
+type QueryError struct {
+	Op    string
+	Query string
+	Err   error
+}
+
+// Error implements the error interface.
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("database: %s failed: %v", e.Op, e.Err)
+}
+
+// Unwrap returns the underlying driver error.
+func (e *QueryError) Unwrap() error {
+	return e.Err
+}
+
+// isUniqueViolation reports whether err is a primary key or unique constraint
+// violation from either backend.
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique ||
+			sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "23505" // unique_violation
+	}
+	return false
+}
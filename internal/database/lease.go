@@ -0,0 +1,171 @@
+// internal/database/lease.go
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Lease is a time-bounded claim on a named resource. Token is a fencing token
+// that increases every time the lease changes hands, so work stamped with an
+// older token can be recognised as stale.
+type Lease struct {
+	Name      string
+	Holder    string
+	Token     int64
+	ExpiresAt time.Time
+}
+
+// Expired reports whether the lease has run out at the given time.
+func (l *Lease) Expired(now time.Time) bool {
+	return !now.Before(l.ExpiresAt)
+}
+
+// LeaseStore manages named leases shared between service replicas.
+type LeaseStore interface {
+	// AcquireLease takes the named lease for holder if it is free, expired or
+	// already held by holder. It returns ErrLeaseHeld if another holder owns it.
+	AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (*Lease, error)
+	// RenewLease extends a lease that is still held. It returns ErrLeaseLost if
+	// the lease expired or was taken over since it was acquired.
+	RenewLease(ctx context.Context, lease *Lease, ttl time.Duration) (*Lease, error)
+	// CheckLease confirms that a lease is still held with the same fencing
+	// token and has not expired. It returns ErrLeaseLost otherwise.
+	CheckLease(ctx context.Context, lease *Lease) error
+	// ReleaseLease gives up a lease so another holder can take it immediately.
+	ReleaseLease(ctx context.Context, lease *Lease) error
+}
+
+// AcquireLease implements LeaseStore.
+func (s *Service) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (*Lease, error) {
+	db, err := s.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, &QueryError{Op: "acquire lease", Err: err}
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	expires := now.Add(ttl)
+
+	var current Lease
+	var expiresAt int64
+	query := "SELECT holder, fencing_token, expires_at FROM leases WHERE name = ?"
+	err = tx.QueryRowContext(ctx, s.dialect.rebind(query), name).Scan(&current.Holder, &current.Token, &expiresAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		current.Token = 1
+		query = "INSERT INTO leases (name, holder, fencing_token, expires_at) VALUES (?, ?, ?, ?)"
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind(query), name, holder, current.Token, expires.UnixMilli()); err != nil {
+			if isUniqueViolation(err) {
+				// Another replica inserted the row first
+				return nil, fmt.Errorf("%w: %s", ErrLeaseHeld, name)
+			}
+			return nil, &QueryError{Op: "acquire lease", Query: query, Err: err}
+		}
+	case err != nil:
+		return nil, &QueryError{Op: "acquire lease", Query: query, Err: err}
+	default:
+		current.ExpiresAt = time.UnixMilli(expiresAt)
+		if current.Holder != holder && current.Holder != "" && !current.Expired(now) {
+			return nil, fmt.Errorf("%w: %s is held by %s", ErrLeaseHeld, name, current.Holder)
+		}
+		// Only a change of holder advances the fencing token; renewing
+		// our own lease keeps it.
+		if current.Holder != holder {
+			current.Token++
+		}
+		query = "UPDATE leases SET holder = ?, fencing_token = ?, expires_at = ? WHERE name = ? AND holder = ? AND expires_at = ?"
+		res, err := tx.ExecContext(ctx, s.dialect.rebind(query),
+			holder, current.Token, expires.UnixMilli(), name, current.Holder, expiresAt)
+		if err != nil {
+			return nil, &QueryError{Op: "acquire lease", Query: query, Err: err}
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrLeaseHeld, name)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, &QueryError{Op: "acquire lease", Err: err}
+	}
+	return &Lease{Name: name, Holder: holder, Token: current.Token, ExpiresAt: time.UnixMilli(expires.UnixMilli())}, nil
+}
+
+// RenewLease implements LeaseStore.
+func (s *Service) RenewLease(ctx context.Context, lease *Lease, ttl time.Duration) (*Lease, error) {
+	db, err := s.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expires := time.UnixMilli(now.Add(ttl).UnixMilli())
+	query := "UPDATE leases SET expires_at = ? WHERE name = ? AND holder = ? AND fencing_token = ? AND expires_at > ?"
+	res, err := db.ExecContext(ctx, s.dialect.rebind(query),
+		expires.UnixMilli(), lease.Name, lease.Holder, lease.Token, now.UnixMilli())
+	if err != nil {
+		return nil, &QueryError{Op: "renew lease", Query: query, Err: err}
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, &QueryError{Op: "rows affected", Err: err}
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrLeaseLost, lease.Name)
+	}
+
+	renewed := *lease
+	renewed.ExpiresAt = expires
+	return &renewed, nil
+}
+
+// CheckLease implements LeaseStore.
+func (s *Service) CheckLease(ctx context.Context, lease *Lease) error {
+	db, err := s.DB()
+	if err != nil {
+		return err
+	}
+
+	var held int
+	query := "SELECT COUNT(*) FROM leases WHERE name = ? AND holder = ? AND fencing_token = ? AND expires_at > ?"
+	err = db.QueryRowContext(ctx, s.dialect.rebind(query),
+		lease.Name, lease.Holder, lease.Token, time.Now().UnixMilli()).Scan(&held)
+	if err != nil {
+		return &QueryError{Op: "check lease", Query: query, Err: err}
+	}
+	if held == 0 {
+		return fmt.Errorf("%w: %s", ErrLeaseLost, lease.Name)
+	}
+	return nil
+}
+
+// ReleaseLease implements LeaseStore. The row is kept so the next holder
+// continues from the current fencing token.
+func (s *Service) ReleaseLease(ctx context.Context, lease *Lease) error {
+	db, err := s.DB()
+	if err != nil {
+		return err
+	}
+
+	query := "UPDATE leases SET holder = '', expires_at = 0 WHERE name = ? AND holder = ? AND fencing_token = ?"
+	res, err := db.ExecContext(ctx, s.dialect.rebind(query), lease.Name, lease.Holder, lease.Token)
+	if err != nil {
+		return &QueryError{Op: "release lease", Query: query, Err: err}
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return &QueryError{Op: "rows affected", Err: err}
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %s", ErrLeaseLost, lease.Name)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+// internal/database/lease_test.go
+package database_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/database"
+)
+
+func TestLeaseLifecycle(t *testing.T) {
+	ctx := context.Background()
+	dbService := newTestService(t)
+
+	first, err := dbService.AcquireLease(ctx, "fileeditor/leader", "replica-a", time.Minute)
+	if err != nil {
+		t.Fatalf("AcquireLease() returned an error: %v", err)
+	}
+	if first.Token != 1 {
+		t.Errorf("expected first fencing token 1, got %d", first.Token)
+	}
+
+	// A second holder is refused while the lease is live
+	if _, err := dbService.AcquireLease(ctx, "fileeditor/leader", "replica-b", time.Minute); !errors.Is(err, database.ErrLeaseHeld) {
+		t.Errorf("expected ErrLeaseHeld, got %v", err)
+	}
+
+	// Re-acquiring or renewing our own lease keeps the token
+	again, err := dbService.AcquireLease(ctx, "fileeditor/leader", "replica-a", time.Minute)
+	if err != nil {
+		t.Fatalf("re-AcquireLease() returned an error: %v", err)
+	}
+	if again.Token != first.Token {
+		t.Errorf("expected token %d to be kept, got %d", first.Token, again.Token)
+	}
+	renewed, err := dbService.RenewLease(ctx, again, time.Minute)
+	if err != nil {
+		t.Fatalf("RenewLease() returned an error: %v", err)
+	}
+	if err := dbService.CheckLease(ctx, renewed); err != nil {
+		t.Errorf("CheckLease() of a held lease returned an error: %v", err)
+	}
+
+	// After release another holder takes over with a higher token
+	if err := dbService.ReleaseLease(ctx, renewed); err != nil {
+		t.Fatalf("ReleaseLease() returned an error: %v", err)
+	}
+	second, err := dbService.AcquireLease(ctx, "fileeditor/leader", "replica-b", time.Minute)
+	if err != nil {
+		t.Fatalf("AcquireLease() after release returned an error: %v", err)
+	}
+	if second.Token <= renewed.Token {
+		t.Errorf("expected token to increase past %d, got %d", renewed.Token, second.Token)
+	}
+
+	// The old holder can no longer renew or pass a fencing check
+	if _, err := dbService.RenewLease(ctx, renewed, time.Minute); !errors.Is(err, database.ErrLeaseLost) {
+		t.Errorf("expected ErrLeaseLost, got %v", err)
+	}
+	if err := dbService.CheckLease(ctx, renewed); !errors.Is(err, database.ErrLeaseLost) {
+		t.Errorf("expected ErrLeaseLost from CheckLease, got %v", err)
+	}
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	ctx := context.Background()
+	dbService := newTestService(t)
+
+	expiring, err := dbService.AcquireLease(ctx, "leader", "replica-a", 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("AcquireLease() returned an error: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if _, err := dbService.RenewLease(ctx, expiring, time.Minute); !errors.Is(err, database.ErrLeaseLost) {
+		t.Errorf("expected ErrLeaseLost for an expired lease, got %v", err)
+	}
+
+	taken, err := dbService.AcquireLease(ctx, "leader", "replica-b", time.Minute)
+	if err != nil {
+		t.Fatalf("AcquireLease() of an expired lease returned an error: %v", err)
+	}
+	if taken.Token != expiring.Token+1 {
+		t.Errorf("expected token %d, got %d", expiring.Token+1, taken.Token)
+	}
+}
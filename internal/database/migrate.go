@@ -0,0 +1,363 @@
+// internal/database/migrate.go
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationFilePattern matches files such as 0001_initial_schema.up.sql.
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+var (
+	// ErrChecksumMismatch is returned when an applied migration differs from the embedded one.
+	ErrChecksumMismatch = errors.New("database: migration checksum mismatch")
+
+	// ErrUnknownMigration is returned when the database has a version this binary does not know.
+	ErrUnknownMigration = errors.New("database: unknown migration applied")
+)
+
+const createSchemaVersionTable = `CREATE TABLE IF NOT EXISTS schema_version (
+	version    INTEGER PRIMARY KEY,
+	name       TEXT NOT NULL,
+	checksum   TEXT NOT NULL,
+	applied_at TIMESTAMP NOT NULL
+)`
+
+// Migration is a single reversible schema change.
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// MigrationStatus reports whether a migration has been applied.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	// ChecksumOK is false when the applied checksum differs from the embedded migration.
+	ChecksumOK bool
+}
+
+// appliedMigration is a row of the schema_version table.
+type appliedMigration struct {
+	version   int
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// Migrator applies and reverts the embedded migrations.
+type Migrator struct {
+	db         *sql.DB
+	dialect    dialect
+	migrations []Migration
+}
+
+// Migrator returns a Migrator for the connected database.
+func (s *Service) Migrator() (*Migrator, error) {
+	db, err := s.DB()
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, dialect: s.dialect, migrations: migrations}, nil
+}
+
+// loadMigrations reads and orders the up/down pairs from fsys.
+func loadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		version, _ := strconv.Atoi(match[1])
+
+		content, err := fs.ReadFile(fsys, path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s must have both up and down steps", m.Version, m.Name)
+		}
+		m.Checksum = migrationChecksum(m.Up, m.Down)
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// migrationChecksum covers both steps, so an edited down script is detected
+// as well as an edited up script.
+func migrationChecksum(up, down string) string {
+	h := sha256.New()
+	h.Write([]byte(up))
+	h.Write([]byte{0})
+	h.Write([]byte(down))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Latest returns the highest known migration version.
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Up applies pending migrations up to and including target. A target of 0 means latest.
+func (m *Migrator) Up(ctx context.Context, target int) error {
+	if target <= 0 {
+		target = m.Latest()
+	}
+
+	applied, err := m.verify(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range m.migrations {
+		if migration.Version > target {
+			break
+		}
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		if err := m.apply(ctx, migration, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Down reverts up to steps of the most recently applied migrations and
+// returns how many were reverted.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	if steps <= 0 {
+		return 0, nil
+	}
+
+	applied, err := m.verify(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	reverted := 0
+	for i := len(m.migrations) - 1; i >= 0 && reverted < steps; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		if err := m.apply(ctx, migration, false); err != nil {
+			return reverted, err
+		}
+		reverted++
+	}
+	return reverted, nil
+}
+
+// Status lists every known migration and whether it has been applied.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name, ChecksumOK: true}
+		if row, ok := applied[migration.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = row.appliedAt
+			status.ChecksumOK = row.checksum == migration.Checksum
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// verify checks applied migrations against the embedded set.
+func (m *Migrator) verify(ctx context.Context) (map[int]appliedMigration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[int]Migration, len(m.migrations))
+	for _, migration := range m.migrations {
+		known[migration.Version] = migration
+	}
+
+	for version, row := range applied {
+		migration, ok := known[version]
+		if !ok {
+			return nil, fmt.Errorf("%w: version %d (%s)", ErrUnknownMigration, version, row.name)
+		}
+		if row.checksum != migration.Checksum {
+			return nil, fmt.Errorf("%w: version %d (%s)", ErrChecksumMismatch, version, migration.Name)
+		}
+	}
+	return applied, nil
+}
+
+// applied returns the rows of schema_version, creating the table if needed.
+func (m *Migrator) applied(ctx context.Context) (map[int]appliedMigration, error) {
+	if _, err := m.db.ExecContext(ctx, createSchemaVersionTable); err != nil {
+		return nil, &QueryError{Op: "migrate", Query: createSchemaVersionTable, Err: err}
+	}
+
+	query := "SELECT version, name, checksum, applied_at FROM schema_version"
+	rows, err := m.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, &QueryError{Op: "migrate", Query: query, Err: err}
+	}
+	defer rows.Close()
+
+	applied := make(map[int]appliedMigration)
+	for rows.Next() {
+		var row appliedMigration
+		if err := rows.Scan(&row.version, &row.name, &row.checksum, &row.appliedAt); err != nil {
+			return nil, &QueryError{Op: "migrate", Query: query, Err: err}
+		}
+		applied[row.version] = row
+	}
+	if err := rows.Err(); err != nil {
+		return nil, &QueryError{Op: "migrate", Query: query, Err: err}
+	}
+	return applied, nil
+}
+
+// apply runs one migration step and records it in schema_version in a single transaction.
+func (m *Migrator) apply(ctx context.Context, migration Migration, up bool) error {
+	op := fmt.Sprintf("migrate up %04d_%s", migration.Version, migration.Name)
+	script := migration.Up
+	if !up {
+		op = fmt.Sprintf("migrate down %04d_%s", migration.Version, migration.Name)
+		script = migration.Down
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return &QueryError{Op: op, Err: err}
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range splitStatements(script) {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return &QueryError{Op: op, Query: stmt, Err: err}
+		}
+	}
+
+	var (
+		query string
+		args  []interface{}
+	)
+	if up {
+		query = "INSERT INTO schema_version (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)"
+		args = []interface{}{migration.Version, migration.Name, migration.Checksum, time.Now().UTC()}
+	} else {
+		query = "DELETE FROM schema_version WHERE version = ?"
+		args = []interface{}{migration.Version}
+	}
+	if _, err := tx.ExecContext(ctx, m.dialect.rebind(query), args...); err != nil {
+		return &QueryError{Op: op, Query: query, Err: err}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return &QueryError{Op: op, Err: err}
+	}
+	return nil
+}
+
+// splitStatements splits a script on semicolons outside of string literals
+// and drops comment-only fragments.
+func splitStatements(script string) []string {
+	var (
+		statements []string
+		current    strings.Builder
+		inString   bool
+	)
+
+	flush := func() {
+		stmt := strings.TrimSpace(stripComments(current.String()))
+		if stmt != "" {
+			statements = append(statements, stmt)
+		}
+		current.Reset()
+	}
+
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		if c == '\'' {
+			inString = !inString
+		}
+		if c == ';' && !inString {
+			flush()
+			continue
+		}
+		current.WriteByte(c)
+	}
+	flush()
+
+	return statements
+}
+
+// stripComments removes full-line "--" comments.
+func stripComments(stmt string) string {
+	lines := strings.Split(stmt, "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "--") {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.Join(kept, "\n")
+}
@@ -0,0 +1,135 @@
+// internal/database/migrate_test.go
+package database_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/configuration"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/database"
+)
+
+// newMigrator returns a connected service and its migrator without applying any migrations.
+func newMigrator(t *testing.T) (*database.Service, *database.Migrator) {
+	t.Helper()
+
+	dbService := database.NewServiceWithConfig(configuration.DatabaseConfig{
+		Type:   "sqlite",
+		SQLite: configuration.SQLiteConfig{Path: filepath.Join(t.TempDir(), "migrate.sqlite")},
+	})
+	t.Cleanup(func() { dbService.Close() })
+
+	if err := dbService.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect() returned an error: %v", err)
+	}
+	migrator, err := dbService.Migrator()
+	if err != nil {
+		t.Fatalf("Migrator() returned an error: %v", err)
+	}
+	return dbService, migrator
+}
+
+func TestMigrateUpDownStatus(t *testing.T) {
+	ctx := context.Background()
+	dbService, migrator := newMigrator(t)
+
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status() returned an error: %v", err)
+	}
+	if len(statuses) == 0 {
+		t.Fatal("expected at least one embedded migration")
+	}
+	for _, status := range statuses {
+		if status.Applied {
+			t.Errorf("migration %d reported as applied before Up", status.Version)
+		}
+	}
+
+	if err := migrator.Up(ctx, 0); err != nil {
+		t.Fatalf("Up() returned an error: %v", err)
+	}
+	statuses, _ = migrator.Status(ctx)
+	for _, status := range statuses {
+		if !status.Applied || !status.ChecksumOK {
+			t.Errorf("migration %d: applied=%v checksumOK=%v", status.Version, status.Applied, status.ChecksumOK)
+		}
+	}
+	db, err := dbService.DB()
+	if err != nil {
+		t.Fatalf("DB() returned an error: %v", err)
+	}
+	if _, err := db.ExecContext(ctx, "SELECT id FROM servers"); err != nil {
+		t.Errorf("servers table should exist after Up: %v", err)
+	}
+
+	// Reverting everything drops the tables again
+	reverted, err := migrator.Down(ctx, len(statuses)+1)
+	if err != nil {
+		t.Fatalf("Down() returned an error: %v", err)
+	}
+	if reverted != len(statuses) {
+		t.Errorf("Down() reverted %d migrations, expected %d", reverted, len(statuses))
+	}
+	if _, err := db.ExecContext(ctx, "SELECT id FROM servers"); err == nil {
+		t.Error("servers table should not exist after Down")
+	}
+	var remaining int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM schema_version").Scan(&remaining); err != nil {
+		t.Fatalf("failed to count schema_version rows: %v", err)
+	}
+	if remaining != 0 {
+		t.Errorf("expected empty schema_version after Down, got %d rows", remaining)
+	}
+}
+
+func TestMigrateChecksumMismatch(t *testing.T) {
+	ctx := context.Background()
+	dbService, migrator := newMigrator(t)
+
+	if err := migrator.Up(ctx, 0); err != nil {
+		t.Fatalf("Up() returned an error: %v", err)
+	}
+
+	db, err := dbService.DB()
+	if err != nil {
+		t.Fatalf("DB() returned an error: %v", err)
+	}
+	if _, err := db.Exec("UPDATE schema_version SET checksum = 'tampered' WHERE version = 1"); err != nil {
+		t.Fatalf("failed to tamper with schema_version: %v", err)
+	}
+
+	if err := migrator.Up(ctx, 0); !errors.Is(err, database.ErrChecksumMismatch) {
+		t.Errorf("expected ErrChecksumMismatch, got %v", err)
+	}
+
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status() returned an error: %v", err)
+	}
+	if statuses[0].ChecksumOK {
+		t.Error("Status() should report the tampered checksum")
+	}
+}
+
+func TestMigrateUnknownVersion(t *testing.T) {
+	ctx := context.Background()
+	dbService, migrator := newMigrator(t)
+
+	if err := migrator.Up(ctx, 0); err != nil {
+		t.Fatalf("Up() returned an error: %v", err)
+	}
+
+	db, _ := dbService.DB()
+	if _, err := db.Exec(
+		"INSERT INTO schema_version (version, name, checksum, applied_at) VALUES (9999, 'future', 'x', CURRENT_TIMESTAMP)",
+	); err != nil {
+		t.Fatalf("failed to insert future migration: %v", err)
+	}
+
+	if err := migrator.Up(ctx, 0); !errors.Is(err, database.ErrUnknownMigration) {
+		t.Errorf("expected ErrUnknownMigration, got %v", err)
+	}
+}
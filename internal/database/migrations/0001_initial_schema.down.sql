@@ -0,0 +1,9 @@
+-- 0001_initial_schema.down.sql
+DROP TABLE IF EXISTS certificates;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS webhooks;
+DROP TABLE IF EXISTS tasks;
+DROP TABLE IF EXISTS installations;
+DROP TABLE IF EXISTS templates;
+DROP TABLE IF EXISTS servers;
@@ -0,0 +1,128 @@
+-- 0001_initial_schema.up.sql
+-- Core tables. Column types are portable between SQLite and CockroachDB;
+-- map and list fields are stored as JSON-encoded TEXT.
+
+CREATE TABLE IF NOT EXISTS servers (
+    id            TEXT PRIMARY KEY,
+    hostname      TEXT NOT NULL,
+    description   TEXT NOT NULL DEFAULT '',
+    asset_tag     TEXT NOT NULL DEFAULT '',
+    serial_number TEXT NOT NULL DEFAULT '',
+    mac_address   TEXT NOT NULL UNIQUE,
+    ip_address    TEXT NOT NULL DEFAULT '',
+    status        INTEGER NOT NULL DEFAULT 0,
+    location      TEXT NOT NULL DEFAULT '',
+    tags          TEXT NOT NULL DEFAULT '{}',
+    metadata      TEXT NOT NULL DEFAULT '{}',
+    hardware      TEXT NOT NULL DEFAULT '{}',
+    registered_at TIMESTAMP NOT NULL,
+    last_seen     TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS servers_hostname_idx ON servers (hostname);
+
+CREATE TABLE IF NOT EXISTS templates (
+    id          TEXT PRIMARY KEY,
+    name        TEXT NOT NULL UNIQUE,
+    description TEXT NOT NULL DEFAULT '',
+    content     TEXT NOT NULL DEFAULT '',
+    created_at  TIMESTAMP NOT NULL,
+    updated_at  TIMESTAMP NOT NULL,
+    created_by  TEXT NOT NULL DEFAULT '',
+    version     INTEGER NOT NULL DEFAULT 1,
+    tags        TEXT NOT NULL DEFAULT '{}',
+    parameters  TEXT NOT NULL DEFAULT '{}'
+);
+
+CREATE TABLE IF NOT EXISTS installations (
+    id              TEXT PRIMARY KEY,
+    server_id       TEXT NOT NULL REFERENCES servers (id),
+    template_id     TEXT NOT NULL DEFAULT '',
+    status          INTEGER NOT NULL DEFAULT 0,
+    created_at      TIMESTAMP NOT NULL,
+    started_at      TIMESTAMP,
+    completed_at    TIMESTAMP,
+    parameters      TEXT NOT NULL DEFAULT '{}',
+    initiated_by    TEXT NOT NULL DEFAULT '',
+    error_message   TEXT NOT NULL DEFAULT '',
+    autoinstall_url TEXT NOT NULL DEFAULT '',
+    os_version      TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS installations_server_id_idx ON installations (server_id);
+
+CREATE TABLE IF NOT EXISTS tasks (
+    id             TEXT PRIMARY KEY,
+    name           TEXT NOT NULL DEFAULT '',
+    type           INTEGER NOT NULL DEFAULT 0,
+    status         INTEGER NOT NULL DEFAULT 0,
+    created_at     TIMESTAMP NOT NULL,
+    started_at     TIMESTAMP,
+    completed_at   TIMESTAMP,
+    progress       DOUBLE PRECISION NOT NULL DEFAULT 0,
+    created_by     TEXT NOT NULL DEFAULT '',
+    status_message TEXT NOT NULL DEFAULT '',
+    error          TEXT NOT NULL DEFAULT '',
+    parameters     TEXT NOT NULL DEFAULT '{}',
+    result         TEXT NOT NULL DEFAULT '{}',
+    retry_count    INTEGER NOT NULL DEFAULT 0,
+    resource_id    TEXT NOT NULL DEFAULT '',
+    resource_type  TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+    id                  TEXT PRIMARY KEY,
+    name                TEXT NOT NULL,
+    url                 TEXT NOT NULL,
+    description         TEXT NOT NULL DEFAULT '',
+    active              BOOLEAN NOT NULL DEFAULT TRUE,
+    secret              TEXT NOT NULL DEFAULT '',
+    event_types         TEXT NOT NULL DEFAULT '[]',
+    headers             TEXT NOT NULL DEFAULT '{}',
+    auth_type           INTEGER NOT NULL DEFAULT 0,
+    auth_config         TEXT NOT NULL DEFAULT '{}',
+    timeout_seconds     INTEGER NOT NULL DEFAULT 0,
+    retry_count         INTEGER NOT NULL DEFAULT 0,
+    retry_delay_seconds INTEGER NOT NULL DEFAULT 0,
+    created_at          TIMESTAMP NOT NULL,
+    created_by          TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS users (
+    id                       TEXT PRIMARY KEY,
+    username                 TEXT NOT NULL UNIQUE,
+    email                    TEXT NOT NULL DEFAULT '',
+    full_name                TEXT NOT NULL DEFAULT '',
+    active                   BOOLEAN NOT NULL DEFAULT TRUE,
+    role                     INTEGER NOT NULL DEFAULT 0,
+    created_at               TIMESTAMP NOT NULL,
+    last_login               TIMESTAMP,
+    permissions              TEXT NOT NULL DEFAULT '[]',
+    preferences              TEXT NOT NULL DEFAULT '{}',
+    mfa_enabled              BOOLEAN NOT NULL DEFAULT FALSE,
+    password_change_required BOOLEAN NOT NULL DEFAULT FALSE,
+    password_expires_at      TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id           TEXT PRIMARY KEY,
+    name         TEXT NOT NULL,
+    key_hash     TEXT NOT NULL UNIQUE,
+    created_by   TEXT NOT NULL DEFAULT '',
+    created_at   TIMESTAMP NOT NULL,
+    last_used_at TIMESTAMP,
+    expires_at   TIMESTAMP,
+    active       BOOLEAN NOT NULL DEFAULT TRUE,
+    permissions  TEXT NOT NULL DEFAULT '[]',
+    description  TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS certificates (
+    serial_number   TEXT PRIMARY KEY,
+    subject_name    TEXT NOT NULL DEFAULT '',
+    issued_to       TEXT NOT NULL DEFAULT '',
+    issued_at       TIMESTAMP NOT NULL,
+    expires_at      TIMESTAMP NOT NULL,
+    revoked         BOOLEAN NOT NULL DEFAULT FALSE,
+    certificate_pem TEXT NOT NULL DEFAULT '',
+    metadata        TEXT NOT NULL DEFAULT '{}'
+);
+CREATE INDEX IF NOT EXISTS certificates_issued_to_idx ON certificates (issued_to);
@@ -0,0 +1,2 @@
+-- 0002_leases.down.sql
+DROP TABLE IF EXISTS leases;
@@ -0,0 +1,12 @@
+-- 0002_leases.up.sql
+-- Named leases used for leader election between service replicas. The row is
+-- kept after release so fencing tokens keep increasing across holders;
+-- expires_at is stored as Unix milliseconds to compare identically on both
+-- backends.
+
+CREATE TABLE IF NOT EXISTS leases (
+    name          TEXT PRIMARY KEY,
+    holder        TEXT NOT NULL DEFAULT '',
+    fencing_token BIGINT NOT NULL DEFAULT 0,
+    expires_at    BIGINT NOT NULL DEFAULT 0
+);
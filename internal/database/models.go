@@ -0,0 +1,142 @@
+// internal/database/models.go
+package database
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// The models below mirror the entities in pkg/proto. Enum fields hold the
+// numeric value of the corresponding proto enum, and nested messages that the
+// database never queries on are kept as JSON so the gRPC layer can round-trip
+// them with protojson. A zero time.Time is stored as NULL.
+
+// Server mirrors proto.Server.
+type Server struct {
+	ID           string
+	Hostname     string
+	Description  string
+	AssetTag     string
+	SerialNumber string
+	MACAddress   string
+	IPAddress    string
+	Status       int32 // proto.ServerStatus
+	Location     string
+	Tags         map[string]string
+	Metadata     map[string]string
+	Hardware     json.RawMessage // proto.HardwareInfo
+	RegisteredAt time.Time
+	LastSeen     time.Time
+}
+
+// Installation mirrors proto.Installation.
+type Installation struct {
+	ID             string
+	ServerID       string
+	TemplateID     string
+	Status         int32 // proto.InstallationStatus
+	CreatedAt      time.Time
+	StartedAt      time.Time
+	CompletedAt    time.Time
+	Parameters     map[string]string
+	InitiatedBy    string
+	ErrorMessage   string
+	AutoinstallURL string
+	OSVersion      string
+}
+
+// Template mirrors proto.Template.
+type Template struct {
+	ID          string
+	Name        string
+	Description string
+	Content     string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	CreatedBy   string
+	Version     int32
+	Tags        map[string]string
+	Parameters  json.RawMessage // map<string, proto.TemplateParameter>
+}
+
+// Task mirrors proto.Task.
+type Task struct {
+	ID            string
+	Name          string
+	Type          int32 // proto.TaskType
+	Status        int32 // proto.TaskStatus
+	CreatedAt     time.Time
+	StartedAt     time.Time
+	CompletedAt   time.Time
+	Progress      float64
+	CreatedBy     string
+	StatusMessage string
+	Error         string
+	Parameters    map[string]string
+	Result        map[string]string
+	RetryCount    int32
+	ResourceID    string
+	ResourceType  string
+}
+
+// Webhook mirrors proto.Webhook.
+type Webhook struct {
+	ID                string
+	Name              string
+	URL               string
+	Description       string
+	Active            bool
+	Secret            string
+	EventTypes        []int32 // proto.WebhookEventType
+	Headers           map[string]string
+	AuthType          int32           // proto.WebhookAuthType
+	AuthConfig        json.RawMessage // proto.WebhookAuthConfig
+	TimeoutSeconds    int32
+	RetryCount        int32
+	RetryDelaySeconds int32
+	CreatedAt         time.Time
+	CreatedBy         string
+}
+
+// User mirrors proto.User.
+type User struct {
+	ID                     string
+	Username               string
+	Email                  string
+	FullName               string
+	Active                 bool
+	Role                   int32 // proto.UserRole
+	CreatedAt              time.Time
+	LastLogin              time.Time
+	Permissions            []string
+	Preferences            map[string]string
+	MFAEnabled             bool
+	PasswordChangeRequired bool
+	PasswordExpiresAt      time.Time
+}
+
+// APIKey mirrors proto.APIKey. Only a hash of the secret key is ever stored.
+type APIKey struct {
+	ID          string
+	Name        string
+	KeyHash     string
+	CreatedBy   string
+	CreatedAt   time.Time
+	LastUsedAt  time.Time
+	ExpiresAt   time.Time
+	Active      bool
+	Permissions []string
+	Description string
+}
+
+// CertificateInfo mirrors proto.CertificateInfo.
+type CertificateInfo struct {
+	SerialNumber   string
+	SubjectName    string
+	IssuedTo       string
+	IssuedAt       time.Time
+	ExpiresAt      time.Time
+	Revoked        bool
+	CertificatePEM string
+	Metadata       map[string]string
+}
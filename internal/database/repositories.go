@@ -0,0 +1,432 @@
+// internal/database/repositories.go
+package database
+
+import (
+	"time"
+)
+
+// Typed repositories for each stored entity.
+type (
+	ServerRepository       = Repository[Server, ServerFilter]
+	InstallationRepository = Repository[Installation, InstallationFilter]
+	TemplateRepository     = Repository[Template, TemplateFilter]
+	TaskRepository         = Repository[Task, TaskFilter]
+	WebhookRepository      = Repository[Webhook, WebhookFilter]
+	UserRepository         = Repository[User, UserFilter]
+	APIKeyRepository       = Repository[APIKey, APIKeyFilter]
+	CertificateRepository  = Repository[CertificateInfo, CertificateFilter]
+)
+
+// ServerFilter narrows ServerRepository.List.
+type ServerFilter struct {
+	Hostname   string
+	MACAddress string
+	IPAddress  string
+	Status     int32
+	Location   string
+}
+
+func (f ServerFilter) conditions() []condition {
+	var c []condition
+	c = where(c, "hostname", f.Hostname)
+	c = where(c, "mac_address", f.MACAddress)
+	c = where(c, "ip_address", f.IPAddress)
+	c = where(c, "status", f.Status)
+	c = where(c, "location", f.Location)
+	return c
+}
+
+// InstallationFilter narrows InstallationRepository.List.
+type InstallationFilter struct {
+	ServerID    string
+	TemplateID  string
+	Status      int32
+	InitiatedBy string
+}
+
+func (f InstallationFilter) conditions() []condition {
+	var c []condition
+	c = where(c, "server_id", f.ServerID)
+	c = where(c, "template_id", f.TemplateID)
+	c = where(c, "status", f.Status)
+	c = where(c, "initiated_by", f.InitiatedBy)
+	return c
+}
+
+// TemplateFilter narrows TemplateRepository.List.
+type TemplateFilter struct {
+	Name      string
+	CreatedBy string
+}
+
+func (f TemplateFilter) conditions() []condition {
+	var c []condition
+	c = where(c, "name", f.Name)
+	c = where(c, "created_by", f.CreatedBy)
+	return c
+}
+
+// TaskFilter narrows TaskRepository.List.
+type TaskFilter struct {
+	Type         int32
+	Status       int32
+	CreatedBy    string
+	ResourceID   string
+	ResourceType string
+}
+
+func (f TaskFilter) conditions() []condition {
+	var c []condition
+	c = where(c, "type", f.Type)
+	c = where(c, "status", f.Status)
+	c = where(c, "created_by", f.CreatedBy)
+	c = where(c, "resource_id", f.ResourceID)
+	c = where(c, "resource_type", f.ResourceType)
+	return c
+}
+
+// WebhookFilter narrows WebhookRepository.List.
+type WebhookFilter struct {
+	Active    *bool
+	CreatedBy string
+}
+
+func (f WebhookFilter) conditions() []condition {
+	var c []condition
+	c = where(c, "active", f.Active)
+	c = where(c, "created_by", f.CreatedBy)
+	return c
+}
+
+// UserFilter narrows UserRepository.List.
+type UserFilter struct {
+	Username string
+	Email    string
+	Role     int32
+	Active   *bool
+}
+
+func (f UserFilter) conditions() []condition {
+	var c []condition
+	c = where(c, "username", f.Username)
+	c = where(c, "email", f.Email)
+	c = where(c, "role", f.Role)
+	c = where(c, "active", f.Active)
+	return c
+}
+
+// APIKeyFilter narrows APIKeyRepository.List.
+type APIKeyFilter struct {
+	Name      string
+	KeyHash   string
+	CreatedBy string
+	Active    *bool
+}
+
+func (f APIKeyFilter) conditions() []condition {
+	var c []condition
+	c = where(c, "name", f.Name)
+	c = where(c, "key_hash", f.KeyHash)
+	c = where(c, "created_by", f.CreatedBy)
+	c = where(c, "active", f.Active)
+	return c
+}
+
+// CertificateFilter narrows CertificateRepository.List.
+type CertificateFilter struct {
+	IssuedTo    string
+	SubjectName string
+	Revoked     *bool
+}
+
+func (f CertificateFilter) conditions() []condition {
+	var c []condition
+	c = where(c, "issued_to", f.IssuedTo)
+	c = where(c, "subject_name", f.SubjectName)
+	c = where(c, "revoked", f.Revoked)
+	return c
+}
+
+// Servers returns the repository for inventory servers.
+func (s *Service) Servers() ServerRepository {
+	return &repository[Server, ServerFilter]{svc: s, t: table[Server]{
+		name: "servers",
+		columns: []string{"id", "hostname", "description", "asset_tag", "serial_number",
+			"mac_address", "ip_address", "status", "location", "tags", "metadata", "hardware",
+			"registered_at", "last_seen"},
+		keyOf:  func(v *Server) string { return v.ID },
+		setKey: func(v *Server, id string) { v.ID = id },
+		prepare: func(v *Server) {
+			if v.RegisteredAt.IsZero() {
+				v.RegisteredAt = time.Now().UTC()
+			}
+		},
+		values: func(v *Server) ([]interface{}, error) {
+			tags, err := jsonValue(v.Tags, "{}")
+			if err != nil {
+				return nil, err
+			}
+			metadata, err := jsonValue(v.Metadata, "{}")
+			if err != nil {
+				return nil, err
+			}
+			hardware, err := jsonValue(v.Hardware, "{}")
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{v.ID, v.Hostname, v.Description, v.AssetTag, v.SerialNumber,
+				v.MACAddress, v.IPAddress, v.Status, v.Location, tags, metadata, hardware,
+				nullTime(v.RegisteredAt), nullTime(v.LastSeen)}, nil
+		},
+		scanDest: func(v *Server) []interface{} {
+			return []interface{}{&v.ID, &v.Hostname, &v.Description, &v.AssetTag, &v.SerialNumber,
+				&v.MACAddress, &v.IPAddress, &v.Status, &v.Location, jsonDest{&v.Tags},
+				jsonDest{&v.Metadata}, jsonDest{&v.Hardware}, timeDest{&v.RegisteredAt},
+				timeDest{&v.LastSeen}}
+		},
+	}}
+}
+
+// Installations returns the repository for installations.
+func (s *Service) Installations() InstallationRepository {
+	return &repository[Installation, InstallationFilter]{svc: s, t: table[Installation]{
+		name: "installations",
+		columns: []string{"id", "server_id", "template_id", "status", "created_at", "started_at",
+			"completed_at", "parameters", "initiated_by", "error_message", "autoinstall_url",
+			"os_version"},
+		keyOf:  func(v *Installation) string { return v.ID },
+		setKey: func(v *Installation, id string) { v.ID = id },
+		prepare: func(v *Installation) {
+			if v.CreatedAt.IsZero() {
+				v.CreatedAt = time.Now().UTC()
+			}
+		},
+		values: func(v *Installation) ([]interface{}, error) {
+			params, err := jsonValue(v.Parameters, "{}")
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{v.ID, v.ServerID, v.TemplateID, v.Status, nullTime(v.CreatedAt),
+				nullTime(v.StartedAt), nullTime(v.CompletedAt), params, v.InitiatedBy,
+				v.ErrorMessage, v.AutoinstallURL, v.OSVersion}, nil
+		},
+		scanDest: func(v *Installation) []interface{} {
+			return []interface{}{&v.ID, &v.ServerID, &v.TemplateID, &v.Status,
+				timeDest{&v.CreatedAt}, timeDest{&v.StartedAt}, timeDest{&v.CompletedAt},
+				jsonDest{&v.Parameters}, &v.InitiatedBy, &v.ErrorMessage, &v.AutoinstallURL,
+				&v.OSVersion}
+		},
+	}}
+}
+
+// Templates returns the repository for autoinstall templates.
+func (s *Service) Templates() TemplateRepository {
+	return &repository[Template, TemplateFilter]{svc: s, t: table[Template]{
+		name: "templates",
+		columns: []string{"id", "name", "description", "content", "created_at", "updated_at",
+			"created_by", "version", "tags", "parameters"},
+		keyOf:  func(v *Template) string { return v.ID },
+		setKey: func(v *Template, id string) { v.ID = id },
+		prepare: func(v *Template) {
+			now := time.Now().UTC()
+			if v.CreatedAt.IsZero() {
+				v.CreatedAt = now
+			}
+			if v.UpdatedAt.IsZero() {
+				v.UpdatedAt = now
+			}
+			if v.Version == 0 {
+				v.Version = 1
+			}
+		},
+		values: func(v *Template) ([]interface{}, error) {
+			tags, err := jsonValue(v.Tags, "{}")
+			if err != nil {
+				return nil, err
+			}
+			params, err := jsonValue(v.Parameters, "{}")
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{v.ID, v.Name, v.Description, v.Content, nullTime(v.CreatedAt),
+				nullTime(v.UpdatedAt), v.CreatedBy, v.Version, tags, params}, nil
+		},
+		scanDest: func(v *Template) []interface{} {
+			return []interface{}{&v.ID, &v.Name, &v.Description, &v.Content,
+				timeDest{&v.CreatedAt}, timeDest{&v.UpdatedAt}, &v.CreatedBy, &v.Version,
+				jsonDest{&v.Tags}, jsonDest{&v.Parameters}}
+		},
+	}}
+}
+
+// Tasks returns the repository for background tasks.
+func (s *Service) Tasks() TaskRepository {
+	return &repository[Task, TaskFilter]{svc: s, t: table[Task]{
+		name: "tasks",
+		columns: []string{"id", "name", "type", "status", "created_at", "started_at",
+			"completed_at", "progress", "created_by", "status_message", "error", "parameters",
+			"result", "retry_count", "resource_id", "resource_type"},
+		keyOf:  func(v *Task) string { return v.ID },
+		setKey: func(v *Task, id string) { v.ID = id },
+		prepare: func(v *Task) {
+			if v.CreatedAt.IsZero() {
+				v.CreatedAt = time.Now().UTC()
+			}
+		},
+		values: func(v *Task) ([]interface{}, error) {
+			params, err := jsonValue(v.Parameters, "{}")
+			if err != nil {
+				return nil, err
+			}
+			result, err := jsonValue(v.Result, "{}")
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{v.ID, v.Name, v.Type, v.Status, nullTime(v.CreatedAt),
+				nullTime(v.StartedAt), nullTime(v.CompletedAt), v.Progress, v.CreatedBy,
+				v.StatusMessage, v.Error, params, result, v.RetryCount, v.ResourceID,
+				v.ResourceType}, nil
+		},
+		scanDest: func(v *Task) []interface{} {
+			return []interface{}{&v.ID, &v.Name, &v.Type, &v.Status, timeDest{&v.CreatedAt},
+				timeDest{&v.StartedAt}, timeDest{&v.CompletedAt}, &v.Progress, &v.CreatedBy,
+				&v.StatusMessage, &v.Error, jsonDest{&v.Parameters}, jsonDest{&v.Result},
+				&v.RetryCount, &v.ResourceID, &v.ResourceType}
+		},
+	}}
+}
+
+// Webhooks returns the repository for webhook subscriptions.
+func (s *Service) Webhooks() WebhookRepository {
+	return &repository[Webhook, WebhookFilter]{svc: s, t: table[Webhook]{
+		name: "webhooks",
+		columns: []string{"id", "name", "url", "description", "active", "secret", "event_types",
+			"headers", "auth_type", "auth_config", "timeout_seconds", "retry_count",
+			"retry_delay_seconds", "created_at", "created_by"},
+		keyOf:  func(v *Webhook) string { return v.ID },
+		setKey: func(v *Webhook, id string) { v.ID = id },
+		prepare: func(v *Webhook) {
+			if v.CreatedAt.IsZero() {
+				v.CreatedAt = time.Now().UTC()
+			}
+		},
+		values: func(v *Webhook) ([]interface{}, error) {
+			events, err := jsonValue(v.EventTypes, "[]")
+			if err != nil {
+				return nil, err
+			}
+			headers, err := jsonValue(v.Headers, "{}")
+			if err != nil {
+				return nil, err
+			}
+			authConfig, err := jsonValue(v.AuthConfig, "{}")
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{v.ID, v.Name, v.URL, v.Description, v.Active, v.Secret, events,
+				headers, v.AuthType, authConfig, v.TimeoutSeconds, v.RetryCount,
+				v.RetryDelaySeconds, nullTime(v.CreatedAt), v.CreatedBy}, nil
+		},
+		scanDest: func(v *Webhook) []interface{} {
+			return []interface{}{&v.ID, &v.Name, &v.URL, &v.Description, &v.Active, &v.Secret,
+				jsonDest{&v.EventTypes}, jsonDest{&v.Headers}, &v.AuthType,
+				jsonDest{&v.AuthConfig}, &v.TimeoutSeconds, &v.RetryCount, &v.RetryDelaySeconds,
+				timeDest{&v.CreatedAt}, &v.CreatedBy}
+		},
+	}}
+}
+
+// Users returns the repository for users.
+func (s *Service) Users() UserRepository {
+	return &repository[User, UserFilter]{svc: s, t: table[User]{
+		name: "users",
+		columns: []string{"id", "username", "email", "full_name", "active", "role", "created_at",
+			"last_login", "permissions", "preferences", "mfa_enabled", "password_change_required",
+			"password_expires_at"},
+		keyOf:  func(v *User) string { return v.ID },
+		setKey: func(v *User, id string) { v.ID = id },
+		prepare: func(v *User) {
+			if v.CreatedAt.IsZero() {
+				v.CreatedAt = time.Now().UTC()
+			}
+		},
+		values: func(v *User) ([]interface{}, error) {
+			perms, err := jsonValue(v.Permissions, "[]")
+			if err != nil {
+				return nil, err
+			}
+			prefs, err := jsonValue(v.Preferences, "{}")
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{v.ID, v.Username, v.Email, v.FullName, v.Active, v.Role,
+				nullTime(v.CreatedAt), nullTime(v.LastLogin), perms, prefs, v.MFAEnabled,
+				v.PasswordChangeRequired, nullTime(v.PasswordExpiresAt)}, nil
+		},
+		scanDest: func(v *User) []interface{} {
+			return []interface{}{&v.ID, &v.Username, &v.Email, &v.FullName, &v.Active, &v.Role,
+				timeDest{&v.CreatedAt}, timeDest{&v.LastLogin}, jsonDest{&v.Permissions},
+				jsonDest{&v.Preferences}, &v.MFAEnabled, &v.PasswordChangeRequired,
+				timeDest{&v.PasswordExpiresAt}}
+		},
+	}}
+}
+
+// APIKeys returns the repository for API keys.
+func (s *Service) APIKeys() APIKeyRepository {
+	return &repository[APIKey, APIKeyFilter]{svc: s, t: table[APIKey]{
+		name: "api_keys",
+		columns: []string{"id", "name", "key_hash", "created_by", "created_at", "last_used_at",
+			"expires_at", "active", "permissions", "description"},
+		keyOf:  func(v *APIKey) string { return v.ID },
+		setKey: func(v *APIKey, id string) { v.ID = id },
+		prepare: func(v *APIKey) {
+			if v.CreatedAt.IsZero() {
+				v.CreatedAt = time.Now().UTC()
+			}
+		},
+		values: func(v *APIKey) ([]interface{}, error) {
+			perms, err := jsonValue(v.Permissions, "[]")
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{v.ID, v.Name, v.KeyHash, v.CreatedBy, nullTime(v.CreatedAt),
+				nullTime(v.LastUsedAt), nullTime(v.ExpiresAt), v.Active, perms,
+				v.Description}, nil
+		},
+		scanDest: func(v *APIKey) []interface{} {
+			return []interface{}{&v.ID, &v.Name, &v.KeyHash, &v.CreatedBy, timeDest{&v.CreatedAt},
+				timeDest{&v.LastUsedAt}, timeDest{&v.ExpiresAt}, &v.Active,
+				jsonDest{&v.Permissions}, &v.Description}
+		},
+	}}
+}
+
+// Certificates returns the repository for issued certificate records.
+func (s *Service) Certificates() CertificateRepository {
+	return &repository[CertificateInfo, CertificateFilter]{svc: s, t: table[CertificateInfo]{
+		name: "certificates",
+		columns: []string{"serial_number", "subject_name", "issued_to", "issued_at", "expires_at",
+			"revoked", "certificate_pem", "metadata"},
+		keyOf:  func(v *CertificateInfo) string { return v.SerialNumber },
+		setKey: func(v *CertificateInfo, id string) { v.SerialNumber = id },
+		prepare: func(v *CertificateInfo) {
+			if v.IssuedAt.IsZero() {
+				v.IssuedAt = time.Now().UTC()
+			}
+		},
+		values: func(v *CertificateInfo) ([]interface{}, error) {
+			metadata, err := jsonValue(v.Metadata, "{}")
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{v.SerialNumber, v.SubjectName, v.IssuedTo, nullTime(v.IssuedAt),
+				nullTime(v.ExpiresAt), v.Revoked, v.CertificatePEM, metadata}, nil
+		},
+		scanDest: func(v *CertificateInfo) []interface{} {
+			return []interface{}{&v.SerialNumber, &v.SubjectName, &v.IssuedTo,
+				timeDest{&v.IssuedAt}, timeDest{&v.ExpiresAt}, &v.Revoked, &v.CertificatePEM,
+				jsonDest{&v.Metadata}}
+		},
+	}}
+}
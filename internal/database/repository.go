@@ -0,0 +1,378 @@
+// internal/database/repository.go
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultPageSize is used when ListOptions.PageSize is not set.
+	DefaultPageSize = 50
+	// MaxPageSize caps ListOptions.PageSize.
+	MaxPageSize = 1000
+)
+
+// Repository provides typed CRUD operations, filtering and cursor pagination
+// for a single entity. F is the entity's filter type.
+type Repository[T any, F Filter] interface {
+	// Create inserts item, assigning an ID if it has none.
+	Create(ctx context.Context, item *T) error
+	// Get returns the item with the given ID, or ErrNotFound.
+	Get(ctx context.Context, id string) (*T, error)
+	// Update replaces the stored item with the same ID, or returns ErrNotFound.
+	Update(ctx context.Context, item *T) error
+	// Save inserts item, or replaces the stored item with the same ID, in a
+	// single statement. Unlike Create, it requires item to have an ID.
+	Save(ctx context.Context, item *T) error
+	// Delete removes the item with the given ID, or returns ErrNotFound.
+	Delete(ctx context.Context, id string) error
+	// List returns one page of items matching filter, ordered by ID.
+	List(ctx context.Context, filter F, opts ListOptions) (*Page[T], error)
+}
+
+// Filter narrows a List call. Zero-valued fields do not filter.
+type Filter interface {
+	conditions() []condition
+}
+
+// ListOptions controls pagination of List calls.
+type ListOptions struct {
+	PageSize  int
+	PageToken string
+}
+
+// Page is one page of List results. NextPageToken is empty on the last page.
+type Page[T any] struct {
+	Items         []*T
+	NextPageToken string
+}
+
+// condition is an equality predicate on a column.
+type condition struct {
+	column string
+	value  interface{}
+}
+
+// where appends an equality condition when value is set.
+func where(conds []condition, column string, value interface{}) []condition {
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return conds
+		}
+	case int32:
+		if v == 0 {
+			return conds
+		}
+	case *bool:
+		if v == nil {
+			return conds
+		}
+		value = *v
+	}
+	return append(conds, condition{column: column, value: value})
+}
+
+// rowScanner is satisfied by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// table describes how an entity maps onto a database table.
+type table[T any] struct {
+	name string
+	// columns lists every column with the primary key first.
+	columns []string
+	keyOf   func(*T) string
+	setKey  func(*T, string)
+	// values returns the column values in the order of columns.
+	values func(*T) ([]interface{}, error)
+	// scanDest returns scan destinations in the order of columns.
+	scanDest func(*T) []interface{}
+	// prepare fills in defaults (timestamps etc.) before an insert.
+	prepare func(*T)
+}
+
+// repository is the generic Repository implementation shared by all entities.
+type repository[T any, F Filter] struct {
+	svc *Service
+	t   table[T]
+}
+
+func (r *repository[T, F]) key() string {
+	return r.t.columns[0]
+}
+
+func (r *repository[T, F]) Create(ctx context.Context, item *T) error {
+	if r.t.keyOf(item) == "" {
+		r.t.setKey(item, uuid.NewString())
+	}
+	if r.t.prepare != nil {
+		r.t.prepare(item)
+	}
+
+	values, err := r.t.values(item)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRecord, err)
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(r.t.columns)), ", ")
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		r.t.name, strings.Join(r.t.columns, ", "), placeholders)
+
+	_, err = r.exec(ctx, "insert", query, values...)
+	return err
+}
+
+func (r *repository[T, F]) Get(ctx context.Context, id string) (*T, error) {
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s = ?",
+		strings.Join(r.t.columns, ", "), r.t.name, r.key())
+
+	db, err := r.svc.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	item := new(T)
+	row := db.QueryRowContext(ctx, r.svc.dialect.rebind(query), id)
+	if err := row.Scan(r.t.scanDest(item)...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s %s", ErrNotFound, r.t.name, id)
+		}
+		return nil, &QueryError{Op: "get", Query: query, Err: err}
+	}
+	return item, nil
+}
+
+func (r *repository[T, F]) Update(ctx context.Context, item *T) error {
+	values, err := r.t.values(item)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRecord, err)
+	}
+
+	assignments := make([]string, 0, len(r.t.columns)-1)
+	for _, column := range r.t.columns[1:] {
+		assignments = append(assignments, column+" = ?")
+	}
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s = ?",
+		r.t.name, strings.Join(assignments, ", "), r.key())
+
+	// Move the key from the front of values to the WHERE clause
+	args := append(values[1:len(values):len(values)], values[0])
+	res, err := r.exec(ctx, "update", query, args...)
+	if err != nil {
+		return err
+	}
+	return r.expectRow(res, r.t.keyOf(item))
+}
+
+func (r *repository[T, F]) Save(ctx context.Context, item *T) error {
+	if r.t.keyOf(item) == "" {
+		return fmt.Errorf("%w: %s has no %s", ErrInvalidRecord, r.t.name, r.key())
+	}
+	if r.t.prepare != nil {
+		r.t.prepare(item)
+	}
+
+	values, err := r.t.values(item)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRecord, err)
+	}
+
+	// ON CONFLICT ... DO UPDATE is understood by both SQLite and CockroachDB
+	assignments := make([]string, 0, len(r.t.columns)-1)
+	for _, column := range r.t.columns[1:] {
+		assignments = append(assignments, column+" = excluded."+column)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(r.t.columns)), ", ")
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) DO UPDATE SET %s",
+		r.t.name, strings.Join(r.t.columns, ", "), placeholders, r.key(), strings.Join(assignments, ", "))
+
+	_, err = r.exec(ctx, "save", query, values...)
+	return err
+}
+
+func (r *repository[T, F]) Delete(ctx context.Context, id string) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s = ?", r.t.name, r.key())
+	res, err := r.exec(ctx, "delete", query, id)
+	if err != nil {
+		return err
+	}
+	return r.expectRow(res, id)
+}
+
+func (r *repository[T, F]) List(ctx context.Context, filter F, opts ListOptions) (*Page[T], error) {
+	pageSize := opts.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	if pageSize > MaxPageSize {
+		pageSize = MaxPageSize
+	}
+
+	var (
+		clauses []string
+		args    []interface{}
+	)
+	for _, cond := range filter.conditions() {
+		clauses = append(clauses, cond.column+" = ?")
+		args = append(args, cond.value)
+	}
+	if opts.PageToken != "" {
+		after, err := decodePageToken(opts.PageToken)
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, r.key()+" > ?")
+		args = append(args, after)
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(r.t.columns, ", "), r.t.name)
+	if len(clauses) > 0 {
+		query += " WHERE " + strings.Join(clauses, " AND ")
+	}
+	// Fetch one extra row to learn whether another page exists
+	query += fmt.Sprintf(" ORDER BY %s LIMIT %d", r.key(), pageSize+1)
+
+	db, err := r.svc.DB()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, r.svc.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, &QueryError{Op: "list", Query: query, Err: err}
+	}
+	defer rows.Close()
+
+	page := &Page[T]{}
+	for rows.Next() {
+		item := new(T)
+		if err := rows.Scan(r.t.scanDest(item)...); err != nil {
+			return nil, &QueryError{Op: "list", Query: query, Err: err}
+		}
+		page.Items = append(page.Items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, &QueryError{Op: "list", Query: query, Err: err}
+	}
+
+	if len(page.Items) > pageSize {
+		page.Items = page.Items[:pageSize]
+		page.NextPageToken = encodePageToken(r.t.keyOf(page.Items[pageSize-1]))
+	}
+	return page, nil
+}
+
+// exec runs a statement, translating constraint violations into ErrAlreadyExists.
+func (r *repository[T, F]) exec(ctx context.Context, op, query string, args ...interface{}) (sql.Result, error) {
+	db, err := r.svc.DB()
+	if err != nil {
+		return nil, err
+	}
+	res, err := db.ExecContext(ctx, r.svc.dialect.rebind(query), args...)
+	if err != nil {
+		if isUniqueViolation(err) {
+			err = fmt.Errorf("%w: %v", ErrAlreadyExists, err)
+		}
+		return nil, &QueryError{Op: op, Query: query, Err: err}
+	}
+	return res, nil
+}
+
+// expectRow returns ErrNotFound when a statement affected no rows.
+func (r *repository[T, F]) expectRow(res sql.Result, id string) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return &QueryError{Op: "rows affected", Err: err}
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: %s %s", ErrNotFound, r.t.name, id)
+	}
+	return nil
+}
+
+// encodePageToken turns the last key of a page into an opaque cursor.
+func encodePageToken(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// decodePageToken reverses encodePageToken.
+func decodePageToken(token string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(key) == 0 {
+		return "", fmt.Errorf("%w: %q", ErrInvalidPageToken, token)
+	}
+	return string(key), nil
+}
+
+// nullTime converts a zero time to NULL and normalizes everything else to UTC.
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC()
+}
+
+// timeDest scans a nullable timestamp column, leaving the zero time for NULL.
+type timeDest struct {
+	dest *time.Time
+}
+
+func (d timeDest) Scan(value interface{}) error {
+	var nt sql.NullTime
+	if err := nt.Scan(value); err != nil {
+		return err
+	}
+	if nt.Valid {
+		*d.dest = nt.Time.UTC()
+	} else {
+		*d.dest = time.Time{}
+	}
+	return nil
+}
+
+// jsonValue encodes v for a JSON TEXT column, substituting empty for null.
+func jsonValue(v interface{}, empty string) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	if string(data) == "null" {
+		return empty, nil
+	}
+	return string(data), nil
+}
+
+// jsonDest scans a JSON TEXT column into dest.
+type jsonDest struct {
+	dest interface{}
+}
+
+func (d jsonDest) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = append([]byte(nil), v...)
+	default:
+		return fmt.Errorf("cannot scan %T into JSON column", value)
+	}
+
+	if raw, ok := d.dest.(*json.RawMessage); ok {
+		*raw = data
+		return nil
+	}
+	return json.Unmarshal(data, d.dest)
+}
@@ -0,0 +1,194 @@
+// internal/database/repository_test.go
+package database_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerRepositoryCRUD(t *testing.T) {
+	ctx := context.Background()
+	servers := newTestService(t).Servers()
+
+	server := &database.Server{
+		Hostname:   "node01",
+		MACAddress: "aa-bb-cc-dd-ee-01",
+		IPAddress:  "10.0.0.11",
+		Status:     2,
+		Tags:       map[string]string{"rack": "r1"},
+		Hardware:   json.RawMessage(`{"manufacturer":"Dell"}`),
+	}
+	require.NoError(t, servers.Create(ctx, server))
+	require.NotEmpty(t, server.ID, "Create should assign an ID")
+	require.False(t, server.RegisteredAt.IsZero(), "Create should set RegisteredAt")
+
+	got, err := servers.Get(ctx, server.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "node01", got.Hostname)
+	assert.Equal(t, map[string]string{"rack": "r1"}, got.Tags)
+	assert.JSONEq(t, `{"manufacturer":"Dell"}`, string(got.Hardware))
+	assert.True(t, got.LastSeen.IsZero(), "unset LastSeen should round-trip as zero")
+
+	got.LastSeen = time.Now()
+	got.Status = 4
+	require.NoError(t, servers.Update(ctx, got))
+
+	updated, err := servers.Get(ctx, server.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int32(4), updated.Status)
+	assert.WithinDuration(t, got.LastSeen, updated.LastSeen, time.Second)
+
+	// Unique MAC addresses are enforced
+	dup := &database.Server{Hostname: "node02", MACAddress: "aa-bb-cc-dd-ee-01"}
+	assert.ErrorIs(t, servers.Create(ctx, dup), database.ErrAlreadyExists)
+
+	require.NoError(t, servers.Delete(ctx, server.ID))
+	_, err = servers.Get(ctx, server.ID)
+	assert.ErrorIs(t, err, database.ErrNotFound)
+	assert.ErrorIs(t, servers.Delete(ctx, server.ID), database.ErrNotFound)
+	assert.ErrorIs(t, servers.Update(ctx, got), database.ErrNotFound)
+}
+
+func TestRepositorySave(t *testing.T) {
+	ctx := context.Background()
+	certs := newTestService(t).Certificates()
+
+	cert := &database.CertificateInfo{
+		SerialNumber:   "1001",
+		IssuedTo:       "node01",
+		ExpiresAt:      time.Now().Add(time.Hour),
+		CertificatePEM: "pem",
+	}
+	require.NoError(t, certs.Save(ctx, cert), "Save should insert a new row")
+
+	cert.Revoked = true
+	cert.Metadata = map[string]string{"revocation_reason": "1"}
+	require.NoError(t, certs.Save(ctx, cert), "Save should replace an existing row")
+
+	got, err := certs.Get(ctx, "1001")
+	require.NoError(t, err)
+	assert.True(t, got.Revoked)
+	assert.Equal(t, "node01", got.IssuedTo)
+	assert.Equal(t, map[string]string{"revocation_reason": "1"}, got.Metadata)
+
+	assert.ErrorIs(t, certs.Save(ctx, &database.CertificateInfo{}), database.ErrInvalidRecord)
+}
+
+func TestRepositoryListPagination(t *testing.T) {
+	ctx := context.Background()
+	servers := newTestService(t).Servers()
+
+	for i := 0; i < 7; i++ {
+		status := int32(2)
+		if i%2 == 1 {
+			status = 1
+		}
+		require.NoError(t, servers.Create(ctx, &database.Server{
+			ID:         fmt.Sprintf("srv-%02d", i),
+			Hostname:   fmt.Sprintf("node%02d", i),
+			MACAddress: fmt.Sprintf("aa-bb-cc-dd-ee-%02d", i),
+			Status:     status,
+		}))
+	}
+
+	// Walk all pages
+	var ids []string
+	token := ""
+	for {
+		page, err := servers.List(ctx, database.ServerFilter{}, database.ListOptions{PageSize: 3, PageToken: token})
+		require.NoError(t, err)
+		for _, s := range page.Items {
+			ids = append(ids, s.ID)
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		token = page.NextPageToken
+	}
+	assert.Equal(t, []string{"srv-00", "srv-01", "srv-02", "srv-03", "srv-04", "srv-05", "srv-06"}, ids)
+
+	// Filters combine with pagination
+	page, err := servers.List(ctx, database.ServerFilter{Status: 2}, database.ListOptions{PageSize: 10})
+	require.NoError(t, err)
+	assert.Len(t, page.Items, 4)
+	assert.Empty(t, page.NextPageToken)
+
+	_, err = servers.List(ctx, database.ServerFilter{}, database.ListOptions{PageToken: "%%%"})
+	assert.ErrorIs(t, err, database.ErrInvalidPageToken)
+}
+
+func TestRepositoriesRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	db := newTestService(t)
+
+	server := &database.Server{Hostname: "node01", MACAddress: "aa-bb-cc-dd-ee-ff"}
+	require.NoError(t, db.Servers().Create(ctx, server))
+
+	tmpl := &database.Template{Name: "base", Content: "#cloud-config\n"}
+	require.NoError(t, db.Templates().Create(ctx, tmpl))
+	assert.Equal(t, int32(1), tmpl.Version)
+
+	inst := &database.Installation{ServerID: server.ID, TemplateID: tmpl.ID, Status: 1,
+		Parameters: map[string]string{"disk": "/dev/sda"}}
+	require.NoError(t, db.Installations().Create(ctx, inst))
+	insts, err := db.Installations().List(ctx, database.InstallationFilter{ServerID: server.ID}, database.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, insts.Items, 1)
+	assert.Equal(t, "/dev/sda", insts.Items[0].Parameters["disk"])
+
+	task := &database.Task{Name: "install", Progress: 42.5, ResourceID: inst.ID}
+	require.NoError(t, db.Tasks().Create(ctx, task))
+	gotTask, err := db.Tasks().Get(ctx, task.ID)
+	require.NoError(t, err)
+	assert.InDelta(t, 42.5, gotTask.Progress, 0.001)
+
+	hook := &database.Webhook{Name: "notify", URL: "https://example.com", Active: true, EventTypes: []int32{1, 3}}
+	require.NoError(t, db.Webhooks().Create(ctx, hook))
+	active := true
+	hooks, err := db.Webhooks().List(ctx, database.WebhookFilter{Active: &active}, database.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, hooks.Items, 1)
+	assert.Equal(t, []int32{1, 3}, hooks.Items[0].EventTypes)
+
+	user := &database.User{Username: "admin", Active: true, Permissions: []string{"certs:issue"}}
+	require.NoError(t, db.Users().Create(ctx, user))
+	assert.ErrorIs(t, db.Users().Create(ctx, &database.User{Username: "admin"}), database.ErrAlreadyExists)
+
+	key := &database.APIKey{Name: "ci", KeyHash: "abc123", Active: true, ExpiresAt: time.Now().Add(time.Hour)}
+	require.NoError(t, db.APIKeys().Create(ctx, key))
+	keys, err := db.APIKeys().List(ctx, database.APIKeyFilter{KeyHash: "abc123"}, database.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, keys.Items, 1)
+	assert.Equal(t, key.ID, keys.Items[0].ID)
+
+	// Recording a use changes nothing else, e.g. a revocation made since
+	key.Active = false
+	require.NoError(t, db.APIKeys().Update(ctx, key))
+	usedAt := time.Now().Add(-time.Minute)
+	require.NoError(t, db.TouchAPIKey(ctx, key.ID, usedAt))
+	gotKey, err := db.APIKeys().Get(ctx, key.ID)
+	require.NoError(t, err)
+	assert.False(t, gotKey.Active)
+	assert.WithinDuration(t, usedAt, gotKey.LastUsedAt, time.Second)
+	assert.ErrorIs(t, db.TouchAPIKey(ctx, "missing", usedAt), database.ErrNotFound)
+
+	cert := &database.CertificateInfo{SerialNumber: "1234", IssuedTo: "node01", ExpiresAt: time.Now().Add(24 * time.Hour)}
+	require.NoError(t, db.Certificates().Create(ctx, cert))
+	revoked := false
+	certs, err := db.Certificates().List(ctx, database.CertificateFilter{Revoked: &revoked}, database.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, certs.Items, 1)
+	assert.Equal(t, "1234", certs.Items[0].SerialNumber)
+
+	var queryErr *database.QueryError
+	err = db.Installations().Create(ctx, &database.Installation{ServerID: "missing"})
+	assert.True(t, errors.As(err, &queryErr), "foreign key violations should surface as QueryError")
+}
@@ -3,49 +3,135 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/configuration"
+	"github.com/spf13/viper"
 )
 
 // Database defines the interface for database operations.
 type Database interface {
 	Connect(ctx context.Context) error
 	MigrateSchema(ctx context.Context) error
-	InsertRecord(ctx context.Context, record interface{}) error
-	Query(ctx context.Context, query string, args ...interface{}) ([]interface{}, error)
+	Close() error
+
+	// Typed repositories for the stored entities
+	Servers() ServerRepository
+	Installations() InstallationRepository
+	Templates() TemplateRepository
+	Tasks() TaskRepository
+	Webhooks() WebhookRepository
+	Users() UserRepository
+	APIKeys() APIKeyRepository
+	Certificates() CertificateRepository
+
+	// Named leases for leader election between replicas
+	LeaseStore
+
+	// Last-use tracking for API keys
+	APIKeyUsage
 }
 
 // Service implements the Database interface.
-type Service struct{}
+type Service struct {
+	cfg     configuration.DatabaseConfig
+	dialect dialect
+	db      *sql.DB
+	mu      sync.RWMutex
+}
 
-// NewService creates a new instance of the database service.
+// NewService creates a new instance of the database service using the
+// "database" section of the loaded configuration.
 func NewService() Database {
-	return &Service{}
+	return NewServiceWithConfig(ConfigFromViper())
 }
 
-// Connect simulates connecting to a database.
+// ConfigFromViper reads the "database" section of the loaded configuration.
+func ConfigFromViper() configuration.DatabaseConfig {
+	var cfg configuration.DatabaseConfig
+	if err := viper.UnmarshalKey("database", &cfg); err != nil {
+		fmt.Printf("Warning: failed to read database configuration: %v\n", err)
+	}
+	return cfg
+}
+
+// NewServiceWithConfig creates a new database service for the given configuration.
+func NewServiceWithConfig(cfg configuration.DatabaseConfig) *Service {
+	return &Service{cfg: cfg}
+}
+
+// Connect opens the configured database and verifies the connection.
 func (s *Service) Connect(ctx context.Context) error {
-	fmt.Println("Connecting to database...")
-	// TODO: Replace with actual logic for connecting to SQLite or CockroachDB.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.db != nil {
+		return nil
+	}
+
+	var (
+		db  *sql.DB
+		err error
+	)
+	switch strings.ToLower(s.cfg.Type) {
+	case "", "sqlite", "sqlite3":
+		s.dialect = sqliteDialect
+		db, err = openSQLite(s.cfg.SQLite)
+	case "cockroach", "cockroachdb", "postgres", "postgresql":
+		s.dialect = cockroachDialect
+		db, err = openCockroach(s.cfg.Cockroach)
+	default:
+		return fmt.Errorf("%w: %q", ErrUnsupportedDatabase, s.cfg.Type)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return &QueryError{Op: "connect", Err: err}
+	}
+
+	s.db = db
 	return nil
 }
 
-// MigrateSchema simulates performing a database schema migration.
-func (s *Service) MigrateSchema(ctx context.Context) error {
-	fmt.Println("Migrating database schema...")
-	// TODO: Implement schema migrations and index creation.
-	return nil
+// Close releases the connection pool.
+func (s *Service) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.db == nil {
+		return nil
+	}
+	err := s.db.Close()
+	s.db = nil
+	return err
 }
 
-// InsertRecord simulates inserting a record into the database.
-func (s *Service) InsertRecord(ctx context.Context, record interface{}) error {
-	fmt.Println("Inserting record into database:", record)
-	// TODO: Implement record insertion.
-	return nil
+// DB returns the underlying connection pool, or ErrNotConnected.
+func (s *Service) DB() (*sql.DB, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.db == nil {
+		return nil, ErrNotConnected
+	}
+	return s.db, nil
 }
 
-// Query simulates running a query against the database.
-func (s *Service) Query(ctx context.Context, query string, args ...interface{}) ([]interface{}, error) {
-	fmt.Println("Querying database with query:", query, "and args:", args)
-	// TODO: Implement query logic and return results.
-	return nil, nil
+// MigrateSchema applies all pending schema migrations.
+func (s *Service) MigrateSchema(ctx context.Context) error {
+	migrator, err := s.Migrator()
+	if err != nil {
+		return err
+	}
+	return migrator.Up(ctx, 0)
 }
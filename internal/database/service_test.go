@@ -3,43 +3,136 @@ package database_test
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
 	"testing"
 
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/configuration"
 	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/database"
 )
 
-func TestConnectAndMigrate(t *testing.T) {
-	dbService := database.NewService()
+// newTestService returns a connected, migrated service backed by a temporary SQLite file.
+func newTestService(t *testing.T) *database.Service {
+	t.Helper()
+
+	dbService := database.NewServiceWithConfig(configuration.DatabaseConfig{
+		Type:   "sqlite",
+		SQLite: configuration.SQLiteConfig{Path: filepath.Join(t.TempDir(), "test.sqlite")},
+	})
+	t.Cleanup(func() { dbService.Close() })
 
-	// Test connection
 	if err := dbService.Connect(context.Background()); err != nil {
 		t.Fatalf("Connect() returned an error: %v", err)
 	}
-
-	// Test schema migration
 	if err := dbService.MigrateSchema(context.Background()); err != nil {
 		t.Fatalf("MigrateSchema() returned an error: %v", err)
 	}
+	return dbService
+}
+
+func TestConnectAndMigrate(t *testing.T) {
+	dbService := newTestService(t)
+
+	// Migrating twice must be harmless
+	if err := dbService.MigrateSchema(context.Background()); err != nil {
+		t.Fatalf("second MigrateSchema() returned an error: %v", err)
+	}
 }
 
-func TestInsertRecord(t *testing.T) {
-	dbService := database.NewService()
+func TestTemplateCreate(t *testing.T) {
+	dbService := newTestService(t)
+	templates := dbService.Templates()
 
-	record := map[string]interface{}{
-		"id":   1,
-		"name": "Test Record",
+	if err := templates.Create(context.Background(), &database.Template{ID: "1", Name: "Test Record"}); err != nil {
+		t.Errorf("Create() returned an error: %v", err)
 	}
 
-	if err := dbService.InsertRecord(context.Background(), record); err != nil {
-		t.Errorf("InsertRecord() returned an error: %v", err)
+	// Duplicate primary keys surface as a QueryError
+	err := templates.Create(context.Background(), &database.Template{ID: "1", Name: "Other"})
+	var queryErr *database.QueryError
+	if !errors.As(err, &queryErr) || !errors.Is(err, database.ErrAlreadyExists) {
+		t.Errorf("expected *QueryError wrapping ErrAlreadyExists for duplicate insert, got %v", err)
+	}
+
+	// Values that cannot be mapped to a row are rejected
+	invalid := &database.Template{ID: "2", Name: "Invalid", Parameters: json.RawMessage("{")}
+	if err := templates.Create(context.Background(), invalid); !errors.Is(err, database.ErrInvalidRecord) {
+		t.Errorf("expected ErrInvalidRecord, got %v", err)
+	}
+}
+
+func TestTemplateList(t *testing.T) {
+	dbService := newTestService(t)
+	templates := dbService.Templates()
+
+	if err := templates.Create(context.Background(), &database.Template{ID: "1", Name: "Test Record"}); err != nil {
+		t.Fatalf("Create() returned an error: %v", err)
+	}
+	if err := templates.Create(context.Background(), &database.Template{ID: "2", Name: "Other"}); err != nil {
+		t.Fatalf("Create() returned an error: %v", err)
+	}
+
+	page, err := templates.List(context.Background(), database.TemplateFilter{Name: "Test Record"}, database.ListOptions{})
+	if err != nil {
+		t.Fatalf("List() returned an error: %v", err)
+	}
+	if len(page.Items) != 1 {
+		t.Fatalf("expected 1 row, got %d", len(page.Items))
+	}
+	if page.Items[0].ID != "1" {
+		t.Errorf("expected id %q, got %q", "1", page.Items[0].ID)
 	}
 }
 
-func TestQuery(t *testing.T) {
-	dbService := database.NewService()
+func TestPersistenceAcrossReconnect(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "persist.sqlite")
+	cfg := configuration.DatabaseConfig{Type: "sqlite3", SQLite: configuration.SQLiteConfig{Path: path}}
+	ctx := context.Background()
+
+	first := database.NewServiceWithConfig(cfg)
+	if err := first.Connect(ctx); err != nil {
+		t.Fatalf("Connect() returned an error: %v", err)
+	}
+	if err := first.MigrateSchema(ctx); err != nil {
+		t.Fatalf("MigrateSchema() returned an error: %v", err)
+	}
+	if err := first.Templates().Create(ctx, &database.Template{ID: "persisted", Name: "kept"}); err != nil {
+		t.Fatalf("Create() returned an error: %v", err)
+	}
+	first.Close()
 
-	_, err := dbService.Query(context.Background(), "SELECT * FROM records WHERE id = ?", 1)
+	second := database.NewServiceWithConfig(cfg)
+	defer second.Close()
+	if err := second.Connect(ctx); err != nil {
+		t.Fatalf("Connect() returned an error: %v", err)
+	}
+	tmpl, err := second.Templates().Get(ctx, "persisted")
 	if err != nil {
-		t.Errorf("Query() returned an error: %v", err)
+		t.Fatalf("Get() after reconnect returned an error: %v", err)
+	}
+	if tmpl.Name != "kept" {
+		t.Errorf("expected name %q, got %q", "kept", tmpl.Name)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	ctx := context.Background()
+
+	notConnected := database.NewServiceWithConfig(configuration.DatabaseConfig{Type: "sqlite"})
+	if _, err := notConnected.Servers().Get(ctx, "x"); !errors.Is(err, database.ErrNotConnected) {
+		t.Errorf("expected ErrNotConnected, got %v", err)
+	}
+
+	unsupported := database.NewServiceWithConfig(configuration.DatabaseConfig{Type: "oracle"})
+	if err := unsupported.Connect(ctx); !errors.Is(err, database.ErrUnsupportedDatabase) {
+		t.Errorf("expected ErrUnsupportedDatabase, got %v", err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	dbService := newTestService(t)
+	if _, err := dbService.Servers().Get(canceled, "x"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
 	}
 }
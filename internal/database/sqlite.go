@@ -0,0 +1,66 @@
+// internal/database/sqlite.go
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/configuration"
+	_ "github.com/mattn/go-sqlite3" // Registers the "sqlite3" driver.
+)
+
+const (
+	// sqliteBusyTimeout is how long (in ms) a connection waits on a locked database.
+	sqliteBusyTimeout = 5000
+
+	// sqliteMaxOpenConns bounds the pool; SQLite serializes writers anyway.
+	sqliteMaxOpenConns = 4
+)
+
+// defaultSQLitePath returns the database file used when none is configured.
+func defaultSQLitePath() string {
+	homeDir, err := os.UserHomeDir()
+	if err == nil {
+		return filepath.Join(homeDir, ".autoinstall-webhook", "database.sqlite")
+	}
+	return "/var/lib/autoinstall-webhook/database.sqlite"
+}
+
+// sqliteDSN builds the connection string for the sqlite3 driver.
+func sqliteDSN(path string) string {
+	params := url.Values{}
+	params.Set("_busy_timeout", fmt.Sprint(sqliteBusyTimeout))
+	params.Set("_journal_mode", "WAL")
+	params.Set("_foreign_keys", "on")
+	params.Set("_txlock", "immediate")
+	return "file:" + path + "?" + params.Encode()
+}
+
+// openSQLite opens (creating if necessary) the SQLite database described by cfg.
+func openSQLite(cfg configuration.SQLiteConfig) (*sql.DB, error) {
+	path := cfg.Path
+	if path == "" {
+		path = defaultSQLitePath()
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create database directory: %w", err)
+	}
+
+	db, err := sql.Open("sqlite3", sqliteDSN(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database %s: %w", path, err)
+	}
+
+	maxOpen := cfg.MaxOpenConns
+	if maxOpen <= 0 {
+		maxOpen = sqliteMaxOpenConns
+	}
+	db.SetMaxOpenConns(maxOpen)
+	db.SetMaxIdleConns(maxOpen)
+
+	return db, nil
+}
@@ -0,0 +1,134 @@
+// internal/fileeditor/atomic.go
+package fileeditor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/atomicfile"
+	"github.com/spf13/afero"
+)
+
+// fileTransaction replaces a set of files together. Every new file is
+// staged before any is replaced, and if one cannot be put in place those
+// already replaced are restored. It is not a crash-safe transaction: there
+// is no journal, so a crash while committing can leave the set partly
+// replaced, though each file is still whole.
+type fileTransaction struct {
+	fs     afero.Fs
+	writes []*stagedWrite
+}
+
+// stagedWrite is a file staged in a transaction, with the contents it
+// replaces.
+type stagedWrite struct {
+	path      string
+	tmpPath   string
+	existed   bool
+	original  []byte
+	mode      os.FileMode
+	committed bool
+}
+
+func newFileTransaction(fs afero.Fs) *fileTransaction {
+	return &fileTransaction{fs: fs}
+}
+
+// Stage writes content to a temporary file that Commit renames to path.
+func (t *fileTransaction) Stage(path string, content []byte, perm os.FileMode) error {
+	w := &stagedWrite{path: path, mode: perm}
+	if info, err := t.fs.Stat(path); err == nil {
+		original, err := afero.ReadFile(t.fs, path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		w.existed, w.original, w.mode = true, original, info.Mode().Perm()
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+
+	tmpPath, err := atomicfile.WriteTemp(t.fs, path, content, perm)
+	if err != nil {
+		return fmt.Errorf("failed to stage %s: %w", path, err)
+	}
+	w.tmpPath = tmpPath
+	t.writes = append(t.writes, w)
+	return nil
+}
+
+// Commit renames the staged files into place. If a rename fails the files
+// already replaced are rolled back and the error is returned. Once every
+// file is replaced the commit has happened, so a failure to sync the
+// directories afterwards is only a warning that the renames may not yet be
+// durable.
+func (t *fileTransaction) Commit() error {
+	for _, w := range t.writes {
+		if err := t.fs.Rename(w.tmpPath, w.path); err != nil {
+			err = fmt.Errorf("failed to replace %s: %w", w.path, err)
+			if rollbackErr := t.rollback(); rollbackErr != nil {
+				err = errors.Join(err, fmt.Errorf("rollback failed: %w", rollbackErr))
+			}
+			return err
+		}
+		w.committed = true
+	}
+	if err := t.syncDirs(); err != nil {
+		fmt.Printf("Warning: replaced files but %v\n", err)
+	}
+	return nil
+}
+
+// Abort discards the staged files.
+func (t *fileTransaction) Abort() {
+	for _, w := range t.writes {
+		if !w.committed {
+			_ = t.fs.Remove(w.tmpPath)
+		}
+	}
+}
+
+// rollback restores the files replaced so far, newest first, and discards
+// the rest.
+func (t *fileTransaction) rollback() error {
+	var errs []error
+	for i := len(t.writes) - 1; i >= 0; i-- {
+		w := t.writes[i]
+		if !w.committed {
+			continue
+		}
+		var err error
+		if w.existed {
+			err = atomicfile.WriteFile(t.fs, w.path, w.original, w.mode)
+		} else {
+			err = t.fs.Remove(w.path)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", w.path, err))
+			continue
+		}
+		w.committed = false
+	}
+	t.Abort()
+	if err := t.syncDirs(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// syncDirs syncs each directory the transaction writes to.
+func (t *fileTransaction) syncDirs() error {
+	synced := make(map[string]bool)
+	for _, w := range t.writes {
+		dir := filepath.Dir(w.path)
+		if synced[dir] {
+			continue
+		}
+		synced[dir] = true
+		if err := atomicfile.SyncDir(t.fs, dir); err != nil {
+			return fmt.Errorf("failed to sync %s: %w", dir, err)
+		}
+	}
+	return nil
+}
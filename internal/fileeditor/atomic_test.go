@@ -0,0 +1,135 @@
+// internal/fileeditor/atomic_test.go
+package fileeditor
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/atomicfile/atomicfiletest"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileTransactionRollback(t *testing.T) {
+	_, fs, _ := setupTestService(t)
+	dir := "/var/lib/cloud-init/00-11-22-33-44-55"
+	require.NoError(t, fs.MkdirAll(dir, 0755))
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(dir, "meta-data"), []byte("instance-id: old\n"), 0600))
+
+	failing := &atomicfiletest.FailingRenameFs{Fs: fs, FailPath: filepath.Join(dir, "user-data")}
+	tx := newFileTransaction(failing)
+	require.NoError(t, tx.Stage(filepath.Join(dir, "meta-data"), []byte("instance-id: new\n"), 0644))
+	require.NoError(t, tx.Stage(filepath.Join(dir, "network-config"), []byte("version: 2\n"), 0644))
+	require.NoError(t, tx.Stage(filepath.Join(dir, "user-data"), []byte("#cloud-config\n"), 0644))
+
+	err := tx.Commit()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "disk on fire")
+
+	// The replaced file is restored with its mode and the new one removed
+	content, err := afero.ReadFile(fs, filepath.Join(dir, "meta-data"))
+	require.NoError(t, err)
+	assert.Equal(t, "instance-id: old\n", string(content))
+	info, err := fs.Stat(filepath.Join(dir, "meta-data"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	exists, err := afero.Exists(fs, filepath.Join(dir, "network-config"))
+	require.NoError(t, err)
+	assert.False(t, exists)
+	exists, err = afero.Exists(fs, filepath.Join(dir, "user-data"))
+	require.NoError(t, err)
+	assert.False(t, exists)
+	atomicfiletest.AssertNoTempFiles(t, fs, dir)
+}
+
+func TestWriteCloudInitFiles(t *testing.T) {
+	service, fs, _ := setupTestService(t)
+	ctx := context.Background()
+	macDir := filepath.Join(service.cloudInitDir, "00-11-22-33-44-55")
+	installDir := macDir + "_install"
+
+	files := map[string][]byte{
+		"user-data":         []byte("#cloud-config\npackages:\n  - htop\n"),
+		"meta-data":         []byte("instance-id: node-01\n"),
+		"network-config":    []byte("version: 2\nethernets:\n  eno1:\n    dhcp4: true\n"),
+		"user-data_install": []byte(validUserData),
+	}
+	require.NoError(t, service.WriteCloudInitFiles(ctx, "00:11:22:33:44:55", files))
+
+	for name, path := range map[string]string{
+		"user-data":         filepath.Join(macDir, "user-data"),
+		"meta-data":         filepath.Join(macDir, "meta-data"),
+		"network-config":    filepath.Join(macDir, "network-config"),
+		"user-data_install": filepath.Join(installDir, "user-data"),
+	} {
+		content, err := afero.ReadFile(fs, path)
+		require.NoError(t, err, name)
+		assert.Equal(t, files[name], content, name)
+	}
+	atomicfiletest.AssertNoTempFiles(t, fs, macDir)
+	atomicfiletest.AssertNoTempFiles(t, fs, installDir)
+
+	// An invalid file stops the whole set from being written
+	err := service.WriteCloudInitFiles(ctx, "00:11:22:33:44:55", map[string][]byte{
+		"meta-data": []byte("instance-id: node-02\n"),
+		"user-data": []byte("packages: [htop]\n"),
+	})
+	var validationErr *ValidationError
+	require.True(t, errors.As(err, &validationErr), "%v", err)
+	content, err := afero.ReadFile(fs, filepath.Join(macDir, "meta-data"))
+	require.NoError(t, err)
+	assert.Equal(t, "instance-id: node-01\n", string(content))
+
+	// So does a failure to put one of them in place
+	service.fs = &atomicfiletest.FailingRenameFs{Fs: fs, FailPath: filepath.Join(macDir, "user-data")}
+	err = service.WriteCloudInitFiles(ctx, "00:11:22:33:44:55", map[string][]byte{
+		"meta-data": []byte("instance-id: node-02\n"),
+		"user-data": []byte("#cloud-config\n"),
+	})
+	require.Error(t, err)
+	content, err = afero.ReadFile(fs, filepath.Join(macDir, "meta-data"))
+	require.NoError(t, err)
+	assert.Equal(t, "instance-id: node-01\n", string(content))
+	content, err = afero.ReadFile(fs, filepath.Join(macDir, "user-data"))
+	require.NoError(t, err)
+	assert.Equal(t, files["user-data"], content)
+	atomicfiletest.AssertNoTempFiles(t, fs, macDir)
+}
+
+// failingOpenFs fails to open one directory, so syncing it fails.
+type failingOpenFs struct {
+	afero.Fs
+	dir string
+}
+
+func (f *failingOpenFs) Open(name string) (afero.File, error) {
+	if filepath.Clean(name) == f.dir {
+		return nil, errors.New("sync unavailable")
+	}
+	return f.Fs.Open(name)
+}
+
+func TestFileTransactionSyncFailure(t *testing.T) {
+	_, fs, _ := setupTestService(t)
+	dir := "/var/lib/cloud-init/00-11-22-33-44-55"
+	require.NoError(t, fs.MkdirAll(dir, 0755))
+
+	tx := newFileTransaction(&failingOpenFs{Fs: fs, dir: dir})
+	require.NoError(t, tx.Stage(filepath.Join(dir, "meta-data"), []byte("instance-id: new\n"), 0644))
+	require.NoError(t, tx.Stage(filepath.Join(dir, "user-data"), []byte("#cloud-config\n"), 0644))
+
+	// The files are in place, so the commit succeeds and is not rolled back
+	require.NoError(t, tx.Commit())
+	content, err := afero.ReadFile(fs, filepath.Join(dir, "meta-data"))
+	require.NoError(t, err)
+	assert.Equal(t, "instance-id: new\n", string(content))
+	content, err = afero.ReadFile(fs, filepath.Join(dir, "user-data"))
+	require.NoError(t, err)
+	assert.Equal(t, "#cloud-config\n", string(content))
+	atomicfiletest.AssertNoTempFiles(t, fs, dir)
+}
@@ -0,0 +1,63 @@
+// internal/fileeditor/cloudinit.go
+package fileeditor
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// cloudInitSchemaFiles maps the YAML cloud-init file types to the schema
+// they are checked against. user-data may hold a Subiquity autoinstall
+// section, and network configuration may be netplan v1 or v2.
+var cloudInitSchemaFiles = map[string]string{
+	"user-data":      "cloud-config.json",
+	"meta-data":      "meta-data.json",
+	"network-config": "netplan.json",
+}
+
+// LintCloudInitFile checks a user-data, meta-data or network-config file
+// against its schema. Diagnostics carry the YAML path and line of each
+// problem.
+func LintCloudInitFile(fileType string, content []byte) []Diagnostic {
+	schemaFile, ok := cloudInitSchemaFiles[fileType]
+	if !ok {
+		return []Diagnostic{{Line: 1, Severity: SeverityError, Message: fmt.Sprintf("no schema for cloud-init file type %q", fileType)}}
+	}
+	if fileType == "user-data" && !bytes.HasPrefix(content, []byte("#cloud-config")) {
+		return []Diagnostic{{Line: 1, Severity: SeverityError, Message: "user-data must start with #cloud-config"}}
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return []Diagnostic{yamlDiagnostic(err)}
+	}
+	// A document of only comments is an empty mapping
+	root := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: 1}
+	if len(doc.Content) > 0 {
+		root = doc.Content[0]
+	}
+
+	v := &schemaValidator{schemas: cloudInitSchemas}
+	v.validate(root, cloudInitSchemas[schemaFile], schemaFile, "")
+	sort.SliceStable(v.diagnostics, func(i, j int) bool {
+		return v.diagnostics[i].Line < v.diagnostics[j].Line
+	})
+	return v.diagnostics
+}
+
+var yamlErrorLine = regexp.MustCompile(`^yaml: line (\d+): `)
+
+// yamlDiagnostic turns a YAML syntax error into a diagnostic.
+func yamlDiagnostic(err error) Diagnostic {
+	d := Diagnostic{Line: 1, Severity: SeverityError, Message: err.Error()}
+	if m := yamlErrorLine.FindStringSubmatch(d.Message); m != nil {
+		d.Line, _ = strconv.Atoi(m[1])
+		d.Message = "invalid YAML: " + d.Message[len(m[0]):]
+	}
+	return d
+}
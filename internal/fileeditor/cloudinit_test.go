@@ -0,0 +1,229 @@
+// internal/fileeditor/cloudinit_test.go
+package fileeditor
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const validUserData = `#cloud-config
+autoinstall:
+  version: 1
+  locale: en_US.UTF-8
+  keyboard:
+    layout: us
+  identity:
+    hostname: node-01
+    username: ubuntu
+    password: "$6$rounds=4096$salt$hash"
+  ssh:
+    install-server: true
+    authorized-keys:
+      - ssh-ed25519 AAAA admin@example.com
+  network:
+    version: 2
+    ethernets:
+      eno1:
+        dhcp4: true
+  storage:
+    layout:
+      name: lvm
+  late-commands:
+    - curtin in-target -- systemctl enable ssh
+    - [sh, -c, "echo done > /target/root/done"]
+  user-data:
+    timezone: Etc/UTC
+    users:
+      - default
+      - name: admin
+        groups: [sudo]
+        shell: /bin/bash
+`
+
+func TestLintCloudInitFileValid(t *testing.T) {
+	files := map[string]string{
+		"user-data": validUserData,
+		"meta-data": "instance-id: node-01\nlocal-hostname: node-01\n",
+		"network-config": `network:
+  version: 2
+  ethernets:
+    id0:
+      match:
+        macaddress: "52:54:00:12:34:56"
+      addresses: [10.0.0.5/24, "fd00::5/64"]
+      routes:
+        - to: default
+          via: 10.0.0.1
+      nameservers:
+        addresses: [10.0.0.1]
+`,
+	}
+	for fileType, content := range files {
+		assert.Empty(t, LintCloudInitFile(fileType, []byte(content)), fileType)
+	}
+
+	v1 := `version: 1
+config:
+  - type: physical
+    name: eth0
+    mac_address: "52:54:00:12:34:56"
+    subnets:
+      - type: static
+        address: 10.0.0.5/24
+        gateway: 10.0.0.1
+`
+	assert.Empty(t, LintCloudInitFile("network-config", []byte(v1)))
+
+	// A header alone is valid user-data
+	assert.Empty(t, LintCloudInitFile("user-data", []byte("#cloud-config\n")))
+}
+
+type expectedDiagnostic struct {
+	line     int
+	path     string
+	severity Severity
+	message  string
+}
+
+func assertDiagnostics(t *testing.T, want []expectedDiagnostic, diagnostics []Diagnostic) {
+	t.Helper()
+	require.Len(t, diagnostics, len(want), "%v", diagnostics)
+	for i, w := range want {
+		assert.Equal(t, w.line, diagnostics[i].Line, "diagnostic %d", i)
+		assert.Equal(t, w.path, diagnostics[i].Path, "diagnostic %d", i)
+		assert.Equal(t, w.severity, diagnostics[i].Severity, "diagnostic %d", i)
+		assert.Equal(t, w.message, diagnostics[i].Message, "diagnostic %d", i)
+	}
+}
+
+func TestLintCloudInitUserData(t *testing.T) {
+	content := `#cloud-config
+pakages: [htop]
+package_update: yes please
+users:
+  - name: admin
+    sudo: ALL=(ALL) NOPASSWD:ALL
+    shel: /bin/bash
+  - groups: [sudo]
+write_files:
+  - path: /etc/motd
+    permissions: "0999"
+    encoding: base32
+power_state:
+  mode: restart
+autoinstall:
+  identity:
+    hostname: node-01
+    username: ubuntu
+  network:
+    version: 2
+    ethernets:
+      eno1:
+        dhcp4: true
+        mtu: 20
+  shutdown: reboot
+  shutdown: poweroff
+`
+	assertDiagnostics(t, []expectedDiagnostic{
+		{2, "", SeverityWarning, `unknown key "pakages" (did you mean "packages"?)`},
+		{3, "package_update", SeverityError, `must be a boolean, not a string`},
+		{7, "users[0]", SeverityWarning, `unknown key "shel" (did you mean "shell"?)`},
+		{8, "users[1]", SeverityError, `missing required key "name"`},
+		{11, "write_files[0].permissions", SeverityError, `"0999" is not in the expected format (^0?[0-7]{3,4}$)`},
+		{12, "write_files[0].encoding", SeverityError, `must be one of "gz", "gzip", "gz+base64", "gzip+base64", "gz+b64", "gzip+b64", "b64", "base64", "text/plain", not "base32"`},
+		{14, "power_state.mode", SeverityError, `must be one of "poweroff", "reboot", "halt", not "restart"`},
+		{16, "autoinstall", SeverityError, `missing required key "version"`},
+		{17, "autoinstall.identity", SeverityError, `missing required key "password"`},
+		{24, "autoinstall.network.ethernets.eno1.mtu", SeverityError, `must be at least 68, not 20`},
+		{26, "autoinstall", SeverityError, `key "shutdown" is already defined on line 25`},
+	}, LintCloudInitFile("user-data", []byte(content)))
+}
+
+func TestLintCloudInitNetworkConfig(t *testing.T) {
+	v2 := `network:
+  version: 2
+  ethernets:
+    eno1:
+      dhcp4: true
+      addresses: [10.0.0.5]
+      gateway: 10.0.0.1
+      routes:
+        - via: 10.0.0.1
+`
+	assertDiagnostics(t, []expectedDiagnostic{
+		{6, "network.ethernets.eno1.addresses[0]", SeverityError, `"10.0.0.5" is not in the expected format (^([0-9]{1,3}(\.[0-9]{1,3}){3}|[0-9a-fA-F:]*:[0-9a-fA-F:.]*)/[0-9]{1,3}$)`},
+		{7, "network.ethernets.eno1", SeverityWarning, `unknown key "gateway" (did you mean "gateway4"?)`},
+		{9, "network.ethernets.eno1.routes[0]", SeverityError, `missing required key "to"`},
+	}, LintCloudInitFile("network-config", []byte(v2)))
+
+	// The version picks the format to report against
+	v1 := `version: 1
+config:
+  - type: ethernet
+    name: eth0
+`
+	assertDiagnostics(t, []expectedDiagnostic{
+		{3, "config[0].type", SeverityError, `must be one of "physical", "bond", "bridge", "vlan", "nameserver", "route", not "ethernet"`},
+	}, LintCloudInitFile("network-config", []byte(v1)))
+
+	// Unknown versions are reported against the current one
+	assertDiagnostics(t, []expectedDiagnostic{
+		{1, "version", SeverityError, `must be 2, not 3`},
+	}, LintCloudInitFile("network-config", []byte("version: 3\n")))
+}
+
+func TestLintCloudInitMetaData(t *testing.T) {
+	assertDiagnostics(t, []expectedDiagnostic{
+		{1, "", SeverityError, `missing required key "instance-id"`},
+	}, LintCloudInitFile("meta-data", []byte("local-hostname: node-01\n")))
+
+	assertDiagnostics(t, []expectedDiagnostic{
+		{1, "instance-id", SeverityError, `must be a string, not null`},
+	}, LintCloudInitFile("meta-data", []byte("instance-id:\n")))
+
+	assertDiagnostics(t, []expectedDiagnostic{
+		{2, "", SeverityError, `invalid YAML: mapping values are not allowed in this context`},
+	}, LintCloudInitFile("meta-data", []byte("instance-id: a\n  - b: [\n")))
+}
+
+func TestLintCloudInitRecursiveAliases(t *testing.T) {
+	// Aliases back to an enclosing node must not be followed forever
+	assertDiagnostics(t, []expectedDiagnostic{
+		{1, "", SeverityError, `missing required key "instance-id"`},
+		{2, "<<", SeverityError, `recursive alias`},
+	}, LintCloudInitFile("meta-data", []byte("&x\n<<: *x\n")))
+
+	diagnostics := LintCloudInitFile("user-data", []byte("#cloud-config\npackages: &p [curl, *p]\n"))
+	require.NotEmpty(t, diagnostics)
+	assert.Contains(t, diagnostics[len(diagnostics)-1].Message, "recursive alias")
+}
+
+func TestValidateCloudInitFiles(t *testing.T) {
+	service, _, _ := setupTestService(t)
+
+	err := service.ValidateCloudInitFiles(map[string][]byte{
+		"user-data":         []byte(validUserData),
+		"meta-data":         []byte("instance-id: node-01\n"),
+		"meta-data_install": []byte("instance-id: node-01-install\n"),
+	})
+	assert.NoError(t, err)
+
+	err = service.ValidateCloudInitFiles(map[string][]byte{
+		"user-data":      []byte("#cloud-config\npackages: htop\n"),
+		"meta-data":      []byte("hostname: node-01\n"),
+		"network-config": []byte("version: 2\n"),
+	})
+	require.Error(t, err)
+	var validationErr *ValidationError
+	require.True(t, errors.As(err, &validationErr))
+	require.Len(t, validationErr.Diagnostics, 2)
+	assert.Equal(t, "meta-data", validationErr.Diagnostics[0].File)
+	assert.Equal(t, "user-data", validationErr.Diagnostics[1].File)
+	assert.Equal(t, `meta-data: line 1: missing required key "instance-id"; user-data: line 2: packages: must be a list, not a string`, err.Error())
+
+	err = service.ValidateCloudInitFiles(map[string][]byte{"vendor-data": []byte("#cloud-config\n")})
+	assert.EqualError(t, err, "vendor-data: unknown cloud-init file type: vendor-data")
+}
@@ -0,0 +1,68 @@
+// internal/fileeditor/diagnostic.go
+package fileeditor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Severity tells whether a diagnostic makes a file invalid.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Diagnostic is a problem found in a file. Path locates it in structured
+// files, e.g. "autoinstall.identity.username" or "users[0]". File is set
+// when several files are validated together.
+type Diagnostic struct {
+	File     string
+	Line     int
+	Path     string
+	Severity Severity
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s: %s", d.location(), d.Severity, d.Message)
+}
+
+// location formats where the diagnostic points to.
+func (d Diagnostic) location() string {
+	loc := fmt.Sprintf("line %d", d.Line)
+	if d.File != "" {
+		loc = d.File + ": " + loc
+	}
+	if d.Path != "" {
+		loc += ": " + d.Path
+	}
+	return loc
+}
+
+// ValidationError is returned for a file with error diagnostics. It lists
+// the warnings too.
+type ValidationError struct {
+	Diagnostics []Diagnostic
+}
+
+func (e *ValidationError) Error() string {
+	var errs []string
+	for _, d := range e.Diagnostics {
+		if d.Severity == SeverityError {
+			errs = append(errs, fmt.Sprintf("%s: %s", d.location(), d.Message))
+		}
+	}
+	return strings.Join(errs, "; ")
+}
+
+// validationError returns a *ValidationError if diagnostics has errors.
+func validationError(diagnostics []Diagnostic) error {
+	for _, d := range diagnostics {
+		if d.Severity == SeverityError {
+			return &ValidationError{Diagnostics: diagnostics}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,380 @@
+// internal/fileeditor/history.go
+package fileeditor
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/atomicfile"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/auth"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/spf13/afero"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Revision is a recorded version of a generated file. Revisions of a file
+// are numbered from 1; their contents are stored once per distinct hash.
+type Revision struct {
+	Number    int       `json:"number"`
+	Hash      string    `json:"hash"`
+	Size      int       `json:"size"`
+	Author    string    `json:"author,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// untrackedReason marks the revision recording a file written before its
+// history was kept.
+const untrackedReason = "existing file before history was kept"
+
+type changeKey struct{}
+
+type change struct {
+	author string
+	reason string
+}
+
+// ContextWithChange records who makes the writes done with ctx and why, for
+// the file history.
+func ContextWithChange(ctx context.Context, author, reason string) context.Context {
+	return context.WithValue(ctx, changeKey{}, change{author: author, reason: reason})
+}
+
+// ChangeFromContext returns the author and reason set by ContextWithChange.
+// Without an author there, the caller authenticated by the auth
+// interceptor is the author.
+func ChangeFromContext(ctx context.Context) (author, reason string) {
+	c, _ := ctx.Value(changeKey{}).(change)
+	if c.author == "" {
+		if id, ok := auth.IdentityFromContext(ctx); ok {
+			c.author = id.User
+		}
+	}
+	return c.author, c.reason
+}
+
+// historyFileTypes are the file types whose history is kept. Cloud-init
+// types may also carry the "_install" suffix.
+var historyFileTypes = map[string]bool{
+	"ipxe":           true,
+	"user-data":      true,
+	"meta-data":      true,
+	"network-config": true,
+	"variables.sh":   true,
+}
+
+// historyHost checks the file type of a history request and returns the
+// normalized MAC address naming the host's history.
+func (s *Service) historyHost(macAddress, fileType string) (string, error) {
+	baseType, install := strings.CutSuffix(fileType, "_install")
+	if !historyFileTypes[baseType] || (install && baseType == "ipxe") {
+		return "", fmt.Errorf("unknown file type: %s", fileType)
+	}
+	normalizedMac := s.normalizeMacAddress(macAddress)
+	if normalizedMac == "" || normalizedMac == "." || normalizedMac == ".." || strings.ContainsAny(normalizedMac, `/\`) {
+		return "", fmt.Errorf("invalid MAC address: %s", macAddress)
+	}
+	return normalizedMac, nil
+}
+
+// DefaultHistoryDir holds the file history unless fileeditor.history_dir
+// is set. Old revisions can contain password hashes and tokens, so the
+// history is kept away from the served cloud-init and iPXE directories.
+const DefaultHistoryDir = "/var/lib/ubuntu-autoinstall-webhook/history"
+
+// The history is readable by the service user only.
+const (
+	historyDirMode  = 0700
+	historyFileMode = 0600
+)
+
+// historyRoot returns the directory holding the file history.
+func (s *Service) historyRoot() string {
+	if s.historyDir != "" {
+		return s.historyDir
+	}
+	return DefaultHistoryDir
+}
+
+// checkHistoryDir refuses a history directory inside a directory served to
+// installing hosts, which would publish every past revision.
+func (s *Service) checkHistoryDir() error {
+	root, err := filepath.Abs(s.historyRoot())
+	if err != nil {
+		return fmt.Errorf("invalid history directory: %w", err)
+	}
+	for _, dir := range []string{s.cloudInitDir, s.ipxeDir} {
+		if dir == "" {
+			continue
+		}
+		served, err := filepath.Abs(dir)
+		if err != nil {
+			return fmt.Errorf("invalid directory %s: %w", dir, err)
+		}
+		rel, err := filepath.Rel(served, root)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("history directory %s must not be inside the served directory %s", root, served)
+		}
+	}
+	return nil
+}
+
+// objectPath returns where the content with the given hash is stored.
+func (s *Service) objectPath(hash string) string {
+	return filepath.Join(s.historyRoot(), "objects", hash[:2], hash)
+}
+
+// revisionLogPath returns the revision log of a host's file.
+func (s *Service) revisionLogPath(normalizedMac, fileType string) string {
+	return filepath.Join(s.historyRoot(), "hosts", normalizedMac, fileType+".json")
+}
+
+// readRevisions returns the revisions of a host's file, oldest first.
+func (s *Service) readRevisions(normalizedMac, fileType string) ([]Revision, error) {
+	data, err := afero.ReadFile(s.fs, s.revisionLogPath(normalizedMac, fileType))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read revision log: %w", err)
+	}
+	var revisions []Revision
+	if err := json.Unmarshal(data, &revisions); err != nil {
+		return nil, fmt.Errorf("failed to parse revision log: %w", err)
+	}
+	return revisions, nil
+}
+
+// recordRevision stores content as the newest revision of a host's file,
+// attributed to the change in ctx.
+func (s *Service) recordRevision(ctx context.Context, normalizedMac, fileType string, content []byte) (Revision, error) {
+	author, reason := ChangeFromContext(ctx)
+	return s.appendRevision(normalizedMac, fileType, content, author, reason, time.Now().UTC())
+}
+
+// recordUntracked records the file at path as the first revision if it
+// exists but has no history yet, so that the first tracked write can be
+// reverted too.
+func (s *Service) recordUntracked(normalizedMac, fileType, path string) error {
+	revisions, err := s.readRevisions(normalizedMac, fileType)
+	if err != nil || len(revisions) > 0 {
+		return err
+	}
+	info, err := s.fs.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	content, err := afero.ReadFile(s.fs, path)
+	if err != nil {
+		return err
+	}
+	_, err = s.appendRevision(normalizedMac, fileType, content, "", untrackedReason, info.ModTime().UTC())
+	return err
+}
+
+func (s *Service) appendRevision(normalizedMac, fileType string, content []byte, author, reason string, timestamp time.Time) (Revision, error) {
+	s.historyMutex.Lock()
+	defer s.historyMutex.Unlock()
+
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+
+	// Identical contents share one object
+	objectPath := s.objectPath(hash)
+	if exists, err := afero.Exists(s.fs, objectPath); err != nil {
+		return Revision{}, err
+	} else if !exists {
+		if err := s.fs.MkdirAll(filepath.Dir(objectPath), historyDirMode); err != nil {
+			return Revision{}, fmt.Errorf("failed to create object directory: %w", err)
+		}
+		if err := atomicfile.WriteFile(s.fs, objectPath, content, historyFileMode); err != nil {
+			return Revision{}, fmt.Errorf("failed to store revision: %w", err)
+		}
+	}
+
+	revisions, err := s.readRevisions(normalizedMac, fileType)
+	if err != nil {
+		return Revision{}, err
+	}
+	revision := Revision{
+		Number:    len(revisions) + 1,
+		Hash:      hash,
+		Size:      len(content),
+		Author:    author,
+		Reason:    reason,
+		Timestamp: timestamp,
+	}
+	revisions = append(revisions, revision)
+
+	data, err := json.MarshalIndent(revisions, "", "  ")
+	if err != nil {
+		return Revision{}, err
+	}
+	logPath := s.revisionLogPath(normalizedMac, fileType)
+	if err := s.fs.MkdirAll(filepath.Dir(logPath), historyDirMode); err != nil {
+		return Revision{}, fmt.Errorf("failed to create history directory: %w", err)
+	}
+	if err := atomicfile.WriteFile(s.fs, logPath, data, historyFileMode); err != nil {
+		return Revision{}, fmt.Errorf("failed to write revision log: %w", err)
+	}
+	return revision, nil
+}
+
+// revision returns a revision of a host's file and its content.
+func (s *Service) revision(normalizedMac, fileType string, number int) (Revision, []byte, error) {
+	revisions, err := s.readRevisions(normalizedMac, fileType)
+	if err != nil {
+		return Revision{}, nil, err
+	}
+	if number < 1 || number > len(revisions) {
+		return Revision{}, nil, fmt.Errorf("revision %d of %s not found", number, fileType)
+	}
+	revision := revisions[number-1]
+
+	content, err := afero.ReadFile(s.fs, s.objectPath(revision.Hash))
+	if err != nil {
+		return Revision{}, nil, fmt.Errorf("failed to read revision %d: %w", number, err)
+	}
+	if sum := sha256.Sum256(content); hex.EncodeToString(sum[:]) != revision.Hash {
+		return Revision{}, nil, fmt.Errorf("revision %d of %s is corrupt", number, fileType)
+	}
+	return revision, content, nil
+}
+
+// ListRevisions returns the recorded revisions of a host's file, oldest
+// first. fileType is "ipxe" or a cloud-init file type as passed to
+// WriteCloudInitFile.
+func (s *Service) ListRevisions(ctx context.Context, macAddress, fileType string) ([]Revision, error) {
+	_, span := s.tracer.Start(ctx, "ListRevisions")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("mac_address", macAddress),
+		attribute.String("file_type", fileType),
+	)
+
+	normalizedMac, err := s.historyHost(macAddress, fileType)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	revisions, err := s.readRevisions(normalizedMac, fileType)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("revision_count", len(revisions)))
+	return revisions, nil
+}
+
+// DiffRevisions returns a unified diff from one revision of a host's file to
+// another.
+func (s *Service) DiffRevisions(ctx context.Context, macAddress, fileType string, from, to int) (string, error) {
+	_, span := s.tracer.Start(ctx, "DiffRevisions")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("mac_address", macAddress),
+		attribute.String("file_type", fileType),
+		attribute.Int("from", from),
+		attribute.Int("to", to),
+	)
+
+	normalizedMac, err := s.historyHost(macAddress, fileType)
+	if err != nil {
+		span.RecordError(err)
+		return "", err
+	}
+
+	_, fromContent, err := s.revision(normalizedMac, fileType, from)
+	if err != nil {
+		span.RecordError(err)
+		return "", err
+	}
+	_, toContent, err := s.revision(normalizedMac, fileType, to)
+	if err != nil {
+		span.RecordError(err)
+		return "", err
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        diffLines(fromContent),
+		B:        diffLines(toContent),
+		FromFile: fmt.Sprintf("%s@%d", fileType, from),
+		ToFile:   fmt.Sprintf("%s@%d", fileType, to),
+		Context:  3,
+	})
+	if err != nil {
+		span.RecordError(err)
+		return "", fmt.Errorf("failed to diff revisions: %w", err)
+	}
+	return diff, nil
+}
+
+// RestoreRevision writes an earlier revision of a host's file back, which
+// records it as a new revision. The content is validated as for any other
+// write. Without a reason in ctx the restored revision is named as the
+// reason.
+func (s *Service) RestoreRevision(ctx context.Context, macAddress, fileType string, number int) error {
+	ctx, span := s.tracer.Start(ctx, "RestoreRevision")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("mac_address", macAddress),
+		attribute.String("file_type", fileType),
+		attribute.Int("revision", number),
+	)
+
+	normalizedMac, err := s.historyHost(macAddress, fileType)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	_, content, err := s.revision(normalizedMac, fileType, number)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	author, reason := ChangeFromContext(ctx)
+	if reason == "" {
+		reason = fmt.Sprintf("restore revision %d", number)
+	}
+	ctx = ContextWithChange(ctx, author, reason)
+
+	if fileType == "ipxe" {
+		err = s.WriteIpxeFile(ctx, macAddress, content)
+	} else {
+		err = s.WriteCloudInitFile(ctx, macAddress, fileType, content)
+	}
+	if err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("failed to restore revision %d: %w", number, err)
+	}
+	return nil
+}
+
+// diffLines splits content into lines that each end in a newline.
+// difflib.SplitLines would add an empty line after a final newline.
+func diffLines(content []byte) []string {
+	lines := strings.SplitAfter(string(content), "\n")
+	if last := len(lines) - 1; lines[last] == "" {
+		lines = lines[:last]
+	} else {
+		lines[last] += "\n"
+	}
+	return lines
+}
@@ -0,0 +1,179 @@
+// internal/fileeditor/history_test.go
+package fileeditor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/auth"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIpxeFileHistory(t *testing.T) {
+	service, fs, _ := setupTestService(t)
+	ctx := context.Background()
+	mac := "00:11:22:33:44:55"
+
+	good := []byte("#!ipxe\necho good\nboot\n")
+	bad := []byte("#!ipxe\necho bad\nboot\n")
+	require.NoError(t, service.WriteIpxeFile(ContextWithChange(ctx, "alice", "initial template"), mac, good))
+	require.NoError(t, service.WriteIpxeFile(ContextWithChange(ctx, "bob", "template push"), mac, bad))
+
+	revisions, err := service.ListRevisions(ctx, mac, "ipxe")
+	require.NoError(t, err)
+	require.Len(t, revisions, 2)
+	assert.Equal(t, 1, revisions[0].Number)
+	assert.Equal(t, "alice", revisions[0].Author)
+	assert.Equal(t, "initial template", revisions[0].Reason)
+	assert.Equal(t, len(good), revisions[0].Size)
+	assert.Equal(t, "bob", revisions[1].Author)
+	assert.NotEqual(t, revisions[0].Hash, revisions[1].Hash)
+	assert.False(t, revisions[1].Timestamp.Before(revisions[0].Timestamp))
+
+	diff, err := service.DiffRevisions(ctx, mac, "ipxe", 1, 2)
+	require.NoError(t, err)
+	assert.Equal(t, `--- ipxe@1
++++ ipxe@2
+@@ -1,3 +1,3 @@
+ #!ipxe
+-echo good
++echo bad
+ boot
+`, diff)
+
+	require.NoError(t, service.RestoreRevision(ContextWithChange(ctx, "carol", ""), mac, "ipxe", 1))
+	content, err := afero.ReadFile(fs, filepath.Join(service.ipxeDir, "mac-00-11-22-33-44-55.ipxe"))
+	require.NoError(t, err)
+	assert.Equal(t, good, content)
+
+	revisions, err = service.ListRevisions(ctx, "00-11-22-33-44-55", "ipxe")
+	require.NoError(t, err)
+	require.Len(t, revisions, 3)
+	assert.Equal(t, "carol", revisions[2].Author)
+	assert.Equal(t, "restore revision 1", revisions[2].Reason)
+	assert.Equal(t, revisions[0].Hash, revisions[2].Hash, "Restored content is stored once")
+
+	_, err = service.DiffRevisions(ctx, mac, "ipxe", 1, 4)
+	assert.Error(t, err)
+	assert.Error(t, service.RestoreRevision(ctx, mac, "ipxe", 0))
+	_, err = service.ListRevisions(ctx, mac, "../../etc/passwd")
+	assert.Error(t, err)
+	_, err = service.ListRevisions(ctx, "../../etc", "ipxe")
+	assert.Error(t, err)
+}
+
+func TestCloudInitFileHistory(t *testing.T) {
+	service, fs, _ := setupTestService(t)
+	ctx := context.Background()
+	mac := "00:11:22:33:44:55"
+	installDir := filepath.Join(service.cloudInitDir, "00-11-22-33-44-55_install")
+
+	// A file written before history was kept becomes the first revision
+	require.NoError(t, fs.MkdirAll(installDir, 0755))
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(installDir, "meta-data"), []byte("instance-id: legacy\n"), 0644))
+
+	require.NoError(t, service.WriteCloudInitFile(ctx, mac, "meta-data_install", []byte("instance-id: v1\n")))
+	require.NoError(t, service.WriteCloudInitFiles(ContextWithChange(ctx, "ci", "render templates"), mac, map[string][]byte{
+		"meta-data_install": []byte("instance-id: v2\n"),
+		"user-data_install": []byte("#cloud-config\n"),
+	}))
+
+	revisions, err := service.ListRevisions(ctx, mac, "meta-data_install")
+	require.NoError(t, err)
+	require.Len(t, revisions, 3)
+	assert.Equal(t, untrackedReason, revisions[0].Reason)
+	assert.Empty(t, revisions[1].Author)
+	assert.Equal(t, "render templates", revisions[2].Reason)
+
+	revisions, err = service.ListRevisions(ctx, mac, "user-data_install")
+	require.NoError(t, err)
+	assert.Len(t, revisions, 1)
+
+	// Other files of the host have their own history
+	revisions, err = service.ListRevisions(ctx, mac, "meta-data")
+	require.NoError(t, err)
+	assert.Empty(t, revisions)
+
+	require.NoError(t, service.RestoreRevision(ctx, mac, "meta-data_install", 1))
+	content, err := afero.ReadFile(fs, filepath.Join(installDir, "meta-data"))
+	require.NoError(t, err)
+	assert.Equal(t, "instance-id: legacy\n", string(content))
+}
+
+func TestHistoryDirOutsideServedRoot(t *testing.T) {
+	service, _, _ := setupTestService(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	assert.Equal(t, DefaultHistoryDir, service.historyRoot())
+	assert.NoError(t, service.checkHistoryDir())
+
+	for _, dir := range []string{
+		service.cloudInitDir,
+		filepath.Join(service.cloudInitDir, "file_history"),
+		filepath.Join(service.ipxeDir, "history"),
+	} {
+		service.historyDir = dir
+		assert.Error(t, service.Start(ctx), dir)
+	}
+
+	service.historyDir = service.cloudInitDir + "-history"
+	assert.NoError(t, service.checkHistoryDir())
+}
+
+func TestHistoryAuthorAndPermissions(t *testing.T) {
+	service, fs, _ := setupTestService(t)
+	ctx := auth.ContextWithIdentity(context.Background(), &auth.Identity{User: "deploy-bot"})
+	mac := "00:11:22:33:44:55"
+
+	// The authenticated caller is the author unless the change names one
+	require.NoError(t, service.WriteIpxeFile(ctx, mac, []byte("#!ipxe\nboot\n")))
+	require.NoError(t, service.WriteIpxeFile(ContextWithChange(ctx, "alice", "rebuild"), mac, []byte("#!ipxe\nreboot\n")))
+
+	revisions, err := service.ListRevisions(ctx, mac, "ipxe")
+	require.NoError(t, err)
+	require.Len(t, revisions, 2)
+	assert.Equal(t, "deploy-bot", revisions[0].Author)
+	assert.Equal(t, "alice", revisions[1].Author)
+
+	// Old revisions may hold secrets, so only the service user can read them
+	for path, mode := range map[string]os.FileMode{
+		service.revisionLogPath("00-11-22-33-44-55", "ipxe"):               historyFileMode,
+		filepath.Dir(service.revisionLogPath("00-11-22-33-44-55", "ipxe")): historyDirMode,
+		service.objectPath(revisions[0].Hash):                              historyFileMode,
+		filepath.Dir(service.objectPath(revisions[0].Hash)):                historyDirMode,
+	} {
+		info, err := fs.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, mode, info.Mode().Perm(), path)
+	}
+}
+
+func TestHistoryFailureAfterWrite(t *testing.T) {
+	service, fs, _ := setupTestService(t)
+	ctx := context.Background()
+	mac := "00:11:22:33:44:55"
+
+	// A file where the content objects should be makes recording fail
+	service.historyDir = "/history"
+	require.NoError(t, fs.MkdirAll(service.historyDir, 0700))
+	require.NoError(t, afero.WriteFile(fs, filepath.Join(service.historyDir, "objects"), nil, 0600))
+
+	// The files are already in place, so the write still succeeds
+	content := []byte("#!ipxe\nboot\n")
+	require.NoError(t, service.WriteIpxeFile(ctx, mac, content))
+	written, err := afero.ReadFile(fs, filepath.Join(service.ipxeDir, "mac-00-11-22-33-44-55.ipxe"))
+	require.NoError(t, err)
+	assert.Equal(t, content, written)
+
+	require.NoError(t, service.WriteCloudInitFiles(ctx, mac, map[string][]byte{
+		"meta-data": []byte("instance-id: v1\n"),
+	}))
+	written, err = afero.ReadFile(fs, filepath.Join(service.cloudInitDir, "00-11-22-33-44-55", "meta-data"))
+	require.NoError(t, err)
+	assert.Equal(t, "instance-id: v1\n", string(written))
+}
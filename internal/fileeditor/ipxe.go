@@ -0,0 +1,495 @@
+// internal/fileeditor/ipxe.go
+package fileeditor
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ipxeCommands are the commands of the iPXE shell. Builds may leave some
+// out, but a name not listed here is almost certainly a typo.
+var ipxeCommands = map[string]bool{
+	"autoboot": true, "boot": true, "certfree": true, "certstat": true,
+	"certstore": true, "chain": true, "choose": true, "clear": true,
+	"colour": true, "config": true, "console": true, "cpair": true,
+	"cpuid": true, "dhcp": true, "digest": true, "echo": true, "exit": true,
+	"fcels": true, "fcstat": true, "gdbstub": true, "goto": true,
+	"help": true, "ibstat": true, "ifclose": true, "ifconf": true,
+	"ifopen": true, "ifstat": true, "imgargs": true, "imgdecrypt": true,
+	"imgexec": true, "imgextract": true, "imgfetch": true, "imgfree": true,
+	"imgload": true, "imgselect": true, "imgstat": true, "imgtrust": true,
+	"imgverify": true, "inc": true, "initrd": true, "ipstat": true,
+	"iseq": true, "isset": true, "item": true, "kernel": true,
+	"login": true, "lotest": true, "md5sum": true, "menu": true,
+	"module": true, "neighbour": true, "nslookup": true, "nstat": true,
+	"ntp": true, "param": true, "params": true, "pciscan": true,
+	"ping": true, "poweroff": true, "profstat": true, "prompt": true,
+	"pxebind": true, "read": true, "reboot": true, "route": true,
+	"sanboot": true, "sanhook": true, "sanunhook": true, "set": true,
+	"sha1sum": true, "shell": true, "shim": true, "show": true,
+	"sleep": true, "sync": true, "time": true, "usbscan": true,
+	"vcreate": true, "vdestroy": true,
+}
+
+// ipxeImageCommands take an image URI, after options.
+var ipxeImageCommands = map[string]bool{
+	"chain": true, "imgexec": true, "kernel": true, "imgselect": true,
+	"initrd": true, "module": true, "imgfetch": true, "imgload": true,
+}
+
+// ipxeImageOptionsWithValue are image command options taking a value.
+var ipxeImageOptionsWithValue = map[string]bool{
+	"--name": true, "-n": true, "--timeout": true, "-t": true,
+}
+
+// ipxeURISchemes are the URI schemes iPXE can fetch from.
+var ipxeURISchemes = map[string]bool{
+	"http": true, "https": true, "tftp": true, "ftp": true, "nfs": true,
+	"file": true, "data": true, "iscsi": true, "aoe": true, "fcp": true,
+	"ib_srp": true, "slam": true,
+}
+
+// ipxeSettings are the settings iPXE provides, from DHCP or the hardware.
+var ipxeSettings = map[string]bool{
+	"ip": true, "netmask": true, "gateway": true, "dns": true,
+	"domain": true, "hostname": true, "next-server": true, "filename": true,
+	"root-path": true, "mac": true, "busid": true, "bustype": true,
+	"chip": true, "ifname": true, "mtu": true, "uuid": true, "user-class": true,
+	"manufacturer": true, "product": true, "serial": true, "asset": true,
+	"board-serial": true, "platform": true, "buildarch": true,
+	"version": true, "unixtime": true, "username": true, "password": true,
+	"initiator-iqn": true, "keymap": true, "syslog": true, "syslogs": true,
+	"base-url": true, "scriptlet": true, "dhcp-server": true, "ip6": true,
+	"gateway6": true, "dns6": true, "len6": true, "cwuri": true, "cwduri": true,
+	"ntp": true, "priority": true, "skip-san-boot": true, "memsize": true,
+	"product-name": true, "net0": true, "netX": true, "url": true,
+}
+
+var (
+	ipxeLabelPattern   = regexp.MustCompile(`^:[A-Za-z0-9_.-]+$`)
+	ipxeSettingPattern = regexp.MustCompile(`\$\{([^}]*)\}`)
+)
+
+// ipxeLine is a statement of an iPXE script: one command and its
+// arguments, on the line it starts.
+type ipxeLine struct {
+	line int
+	args []string
+}
+
+// LintIpxeScript parses an iPXE script and returns its problems: unknown
+// commands, goto targets without a label, missing or malformed image URIs
+// and settings that are never set. Settings assigned with "set" are
+// expanded before URIs are checked.
+func LintIpxeScript(content []byte) []Diagnostic {
+	var diagnostics []Diagnostic
+	report := func(line int, severity Severity, format string, args ...interface{}) {
+		diagnostics = append(diagnostics, Diagnostic{Line: line, Severity: severity, Message: fmt.Sprintf(format, args...)})
+	}
+
+	text := strings.ReplaceAll(string(content), "\r\n", "\n")
+	if strings.TrimSpace(text) == "" {
+		report(1, SeverityError, "iPXE content cannot be empty")
+		return diagnostics
+	}
+	if !strings.HasPrefix(text, "#!ipxe") {
+		report(1, SeverityError, "iPXE content must start with #!ipxe")
+		return diagnostics
+	}
+
+	labels := make(map[string]int)
+	settings := make(map[string]string)
+	assigned := make(map[string]bool)
+	var statements []ipxeLine
+	var items []ipxeLine
+	dynamicGoto := false
+
+	// First pass: labels, statements and the settings they assign
+	lines := strings.Split(text, "\n")
+	for n := 1; n < len(lines); n++ {
+		start := n + 1
+		raw := lines[n]
+		// A trailing backslash continues the line
+		for strings.HasSuffix(raw, "\\") && n+1 < len(lines) {
+			n++
+			raw = strings.TrimSuffix(raw, "\\") + " " + lines[n]
+		}
+		trimmed := strings.TrimSpace(raw)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, ":") {
+			if !ipxeLabelPattern.MatchString(trimmed) {
+				report(start, SeverityError, "invalid label %q", trimmed)
+				continue
+			}
+			name := trimmed[1:]
+			if first, ok := labels[name]; ok {
+				report(start, SeverityError, "label %q is already defined on line %d", name, first)
+				continue
+			}
+			labels[name] = start
+			continue
+		}
+
+		args, err := splitIpxeArgs(trimmed)
+		if err != nil {
+			report(start, SeverityError, "%v", err)
+			continue
+		}
+		for _, cmd := range splitIpxeCommands(args) {
+			statements = append(statements, ipxeLine{line: start, args: cmd})
+			for _, name := range ipxeAssignments(cmd) {
+				assigned[name] = true
+			}
+			if cmd[0] == "set" && len(cmd) >= 2 {
+				settings[settingName(cmd[1])] = strings.Join(cmd[2:], " ")
+			}
+			if cmd[0] == "item" {
+				items = append(items, ipxeLine{line: start, args: cmd})
+			}
+			if cmd[0] == "goto" && len(cmd) == 2 && strings.Contains(cmd[1], "${") {
+				dynamicGoto = true
+			}
+		}
+	}
+
+	// Second pass: commands, their arguments and settings
+	for _, st := range statements {
+		cmd, args := st.args[0], st.args[1:]
+		if !ipxeCommands[cmd] {
+			if suggestion := closestIpxeCommand(cmd); suggestion != "" {
+				report(st.line, SeverityError, "unknown command %q (did you mean %q?)", cmd, suggestion)
+			} else {
+				report(st.line, SeverityError, "unknown command %q", cmd)
+			}
+			continue
+		}
+
+		// isset and friends may name settings that are not set
+		if cmd != "isset" && cmd != "iseq" && cmd != "clear" {
+			for _, arg := range args {
+				for _, name := range referencedSettings(arg) {
+					if !assigned[name] && !ipxeSettings[name] {
+						report(st.line, SeverityWarning, "setting %q is never set", name)
+					}
+				}
+			}
+		}
+
+		switch {
+		case cmd == "goto":
+			if len(args) != 1 {
+				report(st.line, SeverityError, "goto takes exactly one label")
+				continue
+			}
+			if strings.Contains(args[0], "${") {
+				// The target depends on a setting, e.g. a menu choice
+				continue
+			}
+			if _, ok := labels[args[0]]; !ok {
+				report(st.line, SeverityError, "goto target %q has no label", args[0])
+			}
+		case cmd == "set":
+			if len(args) == 0 {
+				report(st.line, SeverityError, "set needs a setting name")
+			}
+		case cmd == "sleep":
+			if len(args) != 1 {
+				report(st.line, SeverityError, "sleep takes a number of seconds")
+			} else if _, err := strconv.Atoi(args[0]); err != nil && !strings.Contains(args[0], "${") {
+				report(st.line, SeverityError, "sleep takes a number of seconds, not %q", args[0])
+			}
+		case ipxeImageCommands[cmd]:
+			uri, ok := imageURI(args)
+			if !ok {
+				if cmd != "imgexec" && cmd != "imgselect" && cmd != "imgload" {
+					report(st.line, SeverityError, "%s needs an image URI", cmd)
+				}
+				continue
+			}
+			if err := checkIpxeURI(expandSettings(uri, settings)); err != nil {
+				report(st.line, SeverityError, "%s: %v", cmd, err)
+			}
+		}
+	}
+
+	// Menu items usually name the label a "goto ${choice}" jumps to
+	if dynamicGoto {
+		for _, item := range items {
+			if label, ok := menuItemLabel(item.args[1:]); ok {
+				if _, found := labels[label]; !found {
+					report(item.line, SeverityWarning, "menu item %q has no label", label)
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		return diagnostics[i].Line < diagnostics[j].Line
+	})
+	return diagnostics
+}
+
+// splitIpxeArgs splits a line into words, honouring quotes and backslash
+// escapes like the iPXE shell.
+func splitIpxeArgs(line string) ([]string, error) {
+	var args []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inWord {
+		args = append(args, word.String())
+	}
+	return args, nil
+}
+
+// splitIpxeCommands splits words at the "||", "&&" and ";" operators
+// into commands.
+func splitIpxeCommands(args []string) [][]string {
+	var commands [][]string
+	var current []string
+	for _, arg := range args {
+		switch arg {
+		case "||", "&&", ";":
+			if len(current) > 0 {
+				commands = append(commands, current)
+			}
+			current = nil
+		default:
+			current = append(current, arg)
+		}
+	}
+	if len(current) > 0 {
+		commands = append(commands, current)
+	}
+	return commands
+}
+
+// ipxeAssignments returns the settings a command assigns.
+func ipxeAssignments(cmd []string) []string {
+	var names []string
+	switch cmd[0] {
+	case "set", "read", "inc", "clear":
+		if len(cmd) >= 2 {
+			names = append(names, settingName(cmd[1]))
+		}
+	case "choose", "prompt":
+		// The setting follows the options
+		for i := 1; i < len(cmd); i++ {
+			arg := cmd[i]
+			if strings.HasPrefix(arg, "-") {
+				if cmd[0] == "prompt" && (arg == "--variable" || arg == "-v") && i+1 < len(cmd) {
+					names = append(names, settingName(cmd[i+1]))
+				}
+				if arg == "--menu" || arg == "-m" || arg == "--default" || arg == "-d" ||
+					arg == "--timeout" || arg == "-t" || arg == "--key" || arg == "-k" ||
+					arg == "--variable" || arg == "-v" {
+					i++
+				}
+				continue
+			}
+			if cmd[0] == "choose" {
+				names = append(names, settingName(arg))
+			}
+			break
+		}
+	case "login":
+		names = append(names, "username", "password")
+	}
+	return names
+}
+
+// settingName returns the setting a name refers to, without its scope
+// ("net0/ip") or type ("ip:ipv4").
+func settingName(name string) string {
+	name, _, _ = strings.Cut(name, ":")
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+// referencedSettings returns the settings expanded in arg.
+func referencedSettings(arg string) []string {
+	var names []string
+	for _, m := range ipxeSettingPattern.FindAllStringSubmatch(arg, -1) {
+		names = append(names, settingName(m[1]))
+	}
+	return names
+}
+
+// expandSettings replaces the settings in s whose value the script sets.
+// Settings only known at boot time are left in place.
+func expandSettings(s string, settings map[string]string) string {
+	// Settings may refer to each other; stop after a few rounds in case
+	// they form a cycle
+	for i := 0; i < 8 && strings.Contains(s, "${"); i++ {
+		expanded := ipxeSettingPattern.ReplaceAllStringFunc(s, func(ref string) string {
+			name := settingName(ref[2 : len(ref)-1])
+			if value, ok := settings[name]; ok {
+				return value
+			}
+			return ref
+		})
+		if expanded == s {
+			break
+		}
+		s = expanded
+	}
+	return s
+}
+
+// menuItemLabel returns the label of a menu item, which gaps have none of.
+func menuItemLabel(args []string) (string, bool) {
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--gap", "-g":
+			return "", false
+		case "--key", "-k", "--menu", "-m":
+			i++
+		case "--default", "-d":
+		default:
+			return args[i], true
+		}
+	}
+	return "", false
+}
+
+// imageURI returns the URI argument of an image command.
+func imageURI(args []string) (string, bool) {
+	for i := 0; i < len(args); i++ {
+		if ipxeImageOptionsWithValue[args[i]] {
+			i++
+			continue
+		}
+		if strings.HasPrefix(args[i], "-") {
+			continue
+		}
+		return args[i], true
+	}
+	return "", false
+}
+
+// checkIpxeURI checks an image URI after expansion. Parts that depend on
+// settings only known at boot time are not checked.
+func checkIpxeURI(uri string) error {
+	if strings.HasPrefix(uri, "${") {
+		// The scheme and host come from a setting, e.g. ${base-url}
+		return nil
+	}
+
+	// Stand in for the settings still unknown
+	unresolved := strings.Contains(uri, "${")
+	concrete := ipxeSettingPattern.ReplaceAllString(uri, "setting")
+	u, err := url.Parse(concrete)
+	if err != nil {
+		if unresolved {
+			// e.g. a port from a setting
+			return nil
+		}
+		return fmt.Errorf("invalid URI %q: %v", uri, err)
+	}
+	if u.Scheme == "" {
+		// Relative to the script's own URI
+		if strings.Contains(concrete, "://") {
+			return fmt.Errorf("invalid URI %q", uri)
+		}
+		return nil
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if !ipxeURISchemes[scheme] {
+		return fmt.Errorf("unsupported URI scheme %q in %q", u.Scheme, uri)
+	}
+	switch scheme {
+	case "http", "https", "tftp", "ftp", "nfs":
+		if u.Host == "" {
+			return fmt.Errorf("URI %q has no host", uri)
+		}
+		if port := u.Port(); port != "" && !unresolved {
+			if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+				return fmt.Errorf("URI %q has an invalid port", uri)
+			}
+		}
+	}
+	return nil
+}
+
+// closestIpxeCommand returns the known command nearest to a misspelt one,
+// if any is close enough to be the intended one.
+func closestIpxeCommand(cmd string) string {
+	best, bestDistance := "", 3
+	for known := range ipxeCommands {
+		if d := editDistance(cmd, known); d < bestDistance || (d == bestDistance && known < best) {
+			best, bestDistance = known, d
+		}
+	}
+	if bestDistance > 2 {
+		return ""
+	}
+	return best
+}
+
+// editDistance is the Damerau-Levenshtein distance between a and b, so a
+// swapped pair of letters counts as one edit.
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := 0; j <= len(b); j++ {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
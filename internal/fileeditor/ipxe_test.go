@@ -0,0 +1,114 @@
+// internal/fileeditor/ipxe_test.go
+package fileeditor
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const validIpxeScript = `#!ipxe
+# Boot menu for lab machines
+set base http://10.0.0.1:8080/ubuntu
+set release noble
+
+dhcp || goto failed
+
+menu Boot ${hostname}
+item --gap Installers
+item install Install Ubuntu ${release}
+item --key s shell iPXE shell
+choose --default install --timeout 5000 target && goto ${target}
+
+:install
+kernel ${base}/${release}/vmlinuz autoinstall \
+  ds=nocloud-net;s=http://10.0.0.1:8080/cloud-init/${net0/mac:hexhyp}/
+initrd --name initrd ${base}/${release}/initrd
+boot || goto failed
+
+:shell
+shell
+
+:failed
+echo Boot failed, retrying in 10 seconds
+sleep 10
+chain --autofree tftp://${next-server}/undionly.kpxe
+`
+
+func TestLintIpxeScriptValid(t *testing.T) {
+	diagnostics := LintIpxeScript([]byte(validIpxeScript))
+	assert.Empty(t, diagnostics)
+}
+
+func TestLintIpxeScriptErrors(t *testing.T) {
+	script := `#!ipxe
+dhcp
+chian http://server/boot.ipxe
+goto missing
+kernel
+initrd htp://server/initrd
+chain http:///boot.ipxe
+:dup
+:dup
+echo ${undefined}
+sleep soon
+echo "unterminated
+`
+	diagnostics := LintIpxeScript([]byte(script))
+
+	type expected struct {
+		line     int
+		severity Severity
+		message  string
+	}
+	want := []expected{
+		{3, SeverityError, `unknown command "chian" (did you mean "chain"?)`},
+		{4, SeverityError, `goto target "missing" has no label`},
+		{5, SeverityError, `kernel needs an image URI`},
+		{6, SeverityError, `initrd: unsupported URI scheme "htp" in "htp://server/initrd"`},
+		{7, SeverityError, `chain: URI "http:///boot.ipxe" has no host`},
+		{9, SeverityError, `label "dup" is already defined on line 8`},
+		{10, SeverityWarning, `setting "undefined" is never set`},
+		{11, SeverityError, `sleep takes a number of seconds, not "soon"`},
+		{12, SeverityError, `unterminated " quote`},
+	}
+	require.Len(t, diagnostics, len(want), "%v", diagnostics)
+	for i, w := range want {
+		assert.Equal(t, w.line, diagnostics[i].Line, "diagnostic %d", i)
+		assert.Equal(t, w.severity, diagnostics[i].Severity, "diagnostic %d", i)
+		assert.Equal(t, w.message, diagnostics[i].Message, "diagnostic %d", i)
+	}
+}
+
+func TestLintIpxeScriptMenuItems(t *testing.T) {
+	script := `#!ipxe
+menu
+item ubuntu Ubuntu
+item debain Debian
+choose os && goto ${os}
+:ubuntu
+:debian
+`
+	diagnostics := LintIpxeScript([]byte(script))
+	require.Len(t, diagnostics, 1)
+	assert.Equal(t, 4, diagnostics[0].Line)
+	assert.Equal(t, SeverityWarning, diagnostics[0].Severity)
+}
+
+func TestValidateIpxeFile(t *testing.T) {
+	service, _, _ := setupTestService(t)
+
+	assert.NoError(t, service.ValidateIpxeFile([]byte(validIpxeScript)))
+
+	// Warnings alone do not make a script invalid
+	assert.NoError(t, service.ValidateIpxeFile([]byte("#!ipxe\necho ${greeting}\n")))
+
+	err := service.ValidateIpxeFile([]byte("#!ipxe\ndhcp\nchian http://server/boot.ipxe\n"))
+	require.Error(t, err)
+	var validationErr *ValidationError
+	require.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, 3, validationErr.Diagnostics[0].Line)
+	assert.Contains(t, err.Error(), "line 3")
+}
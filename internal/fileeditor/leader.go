@@ -0,0 +1,182 @@
+// internal/fileeditor/leader.go
+package fileeditor
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/database"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// DefaultLeaseTTL is how long a leader lease is valid without renewal.
+const DefaultLeaseTTL = 15 * time.Second
+
+// Option configures a file editor Service.
+type Option func(*Service)
+
+// WithLeaseStore enables distributed leader election using the given store,
+// typically the shared database. Without it the service assumes it is the
+// only writer.
+func WithLeaseStore(store database.LeaseStore) Option {
+	return func(s *Service) {
+		s.leases = store
+	}
+}
+
+// WithLeaseTTL overrides the leader lease duration.
+func WithLeaseTTL(ttl time.Duration) Option {
+	return func(s *Service) {
+		s.leaseTTL = ttl
+	}
+}
+
+// WithInstanceID sets the identity this replica uses as lease holder.
+func WithInstanceID(id string) Option {
+	return func(s *Service) {
+		s.holderID = id
+	}
+}
+
+// defaultHolderID builds a holder identity that is unique per process.
+func defaultHolderID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), hex.EncodeToString(suffix))
+}
+
+// hasLeadership reports whether this instance may write files right now. A
+// lease that has run out locally counts as lost even before the renewal loop
+// notices.
+func (s *Service) hasLeadership() bool {
+	s.leaderMutex.Lock()
+	defer s.leaderMutex.Unlock()
+
+	if !s.isLeader {
+		return false
+	}
+	if s.lease != nil && s.lease.Expired(time.Now()) {
+		s.stepDown()
+		return false
+	}
+	return true
+}
+
+// FencingToken returns the token of the currently held leader lease, or 0
+// when this instance is not the leader or runs without a lease store.
+func (s *Service) FencingToken() int64 {
+	s.leaderMutex.Lock()
+	defer s.leaderMutex.Unlock()
+
+	if !s.isLeader || s.lease == nil {
+		return 0
+	}
+	return s.lease.Token
+}
+
+// checkFencing confirms, right before a change is committed to disk, that
+// the lease this instance leads with is still current in the lease store. A
+// leader that paused past its lease thus cannot overwrite the files of the
+// replica that took over with a newer fencing token.
+func (s *Service) checkFencing(ctx context.Context) error {
+	s.leaderMutex.Lock()
+	lease := s.lease
+	s.leaderMutex.Unlock()
+
+	if s.leases == nil {
+		return nil
+	}
+	if lease == nil {
+		return fmt.Errorf("not the leader, cannot commit changes")
+	}
+
+	err := s.leases.CheckLease(ctx, lease)
+	if errors.Is(err, database.ErrLeaseLost) {
+		s.leaderMutex.Lock()
+		if s.lease == lease {
+			s.stepDown()
+		}
+		s.leaderMutex.Unlock()
+		return fmt.Errorf("lost leadership with fencing token %d: %w", lease.Token, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check leader lease: %w", err)
+	}
+	return nil
+}
+
+// stepDown clears the leader state. The caller must hold leaderMutex.
+func (s *Service) stepDown() {
+	s.isLeader = false
+	s.lease = nil
+}
+
+// renewLeadership extends the current lease, stepping down if it was lost.
+func (s *Service) renewLeadership(ctx context.Context) error {
+	ctx, span := s.tracer.Start(ctx, "renewLeadership")
+	defer span.End()
+
+	s.leaderMutex.Lock()
+	defer s.leaderMutex.Unlock()
+
+	if s.lease == nil {
+		return nil
+	}
+
+	lease, err := s.leases.RenewLease(ctx, s.lease, s.leaseTTL)
+	if err != nil {
+		span.RecordError(err)
+		// A transient database error only costs leadership once the lease
+		// actually runs out; a lost lease means someone else took over.
+		if errors.Is(err, database.ErrLeaseLost) || s.lease.Expired(time.Now()) {
+			s.stepDown()
+		}
+		span.SetAttributes(attribute.Bool("is_leader", s.isLeader))
+		return err
+	}
+
+	s.lease = lease
+	span.SetAttributes(
+		attribute.Bool("is_leader", s.isLeader),
+		attribute.Int64("fencing_token", lease.Token),
+	)
+	return nil
+}
+
+// runLeaderElection renews the lease while leading and retries acquisition
+// otherwise, until ctx is canceled. Leadership is released on shutdown.
+func (s *Service) runLeaderElection(ctx context.Context) {
+	ticker := time.NewTicker(s.leaseTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if s.hasLeadership() {
+				if err := s.renewLeadership(ctx); err != nil {
+					fmt.Printf("Failed to renew leader lease: %v\n", err)
+				}
+				continue
+			}
+			if _, err := s.AcquireLeadership(ctx); err != nil {
+				fmt.Printf("Failed to acquire leadership: %v\n", err)
+			}
+		case <-ctx.Done():
+			releaseCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := s.ReleaseLeadership(releaseCtx); err != nil {
+				fmt.Printf("Failed to release leadership: %v\n", err)
+			}
+			cancel()
+			return
+		}
+	}
+}
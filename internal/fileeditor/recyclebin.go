@@ -0,0 +1,396 @@
+// internal/fileeditor/recyclebin.go
+package fileeditor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/atomicfile"
+	"github.com/spf13/afero"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// DefaultRecycleBinMaxAge is how long deleted directories are kept.
+	DefaultRecycleBinMaxAge = 7 * 24 * time.Hour
+
+	// DefaultRecycleBinCleanupInterval is how often expired entries are
+	// removed from the recycle bin.
+	DefaultRecycleBinCleanupInterval = 24 * time.Hour
+
+	// recycleTimeFormat is the deletion time in recycle bin entry names.
+	recycleTimeFormat = "20060102_150405"
+
+	// recycleManifestName is the file in a recycled directory recording
+	// the hostnames that linked to it.
+	recycleManifestName = ".recycle_bin.json"
+)
+
+// recycleNamePattern matches the names of directories moved to the recycle
+// bin: <mac>_delete_me_<time> and <mac>_install_delete_me_<time>.
+var recycleNamePattern = regexp.MustCompile(`^(.+?)(_install)?_delete_me_(\d{8}_\d{6})$`)
+
+// WithRecycleBinRetention sets how long deleted directories are kept and
+// the total size the recycle bin may grow to. Zero disables either limit.
+func WithRecycleBinRetention(maxAge time.Duration, maxSize int64) Option {
+	return func(s *Service) {
+		s.recycleMaxAge = maxAge
+		s.recycleMaxSize = maxSize
+	}
+}
+
+// WithCleanupInterval sets how often the recycle bin is cleaned up.
+func WithCleanupInterval(interval time.Duration) Option {
+	return func(s *Service) {
+		s.cleanupInterval = interval
+	}
+}
+
+// RecycleBinEntry is a deletion of a host's cloud-init directories.
+type RecycleBinEntry struct {
+	// Name identifies the entry to RestoreCloudInitDir.
+	Name string
+	// MacAddress is the normalized MAC address of the deleted directories,
+	// empty for entries not created by DeleteCloudInitDir.
+	MacAddress string
+	DeletedAt  time.Time
+	// Size is the total size of the entry's files in bytes.
+	Size int64
+	// Hostnames are the hostname symlinks restored with the entry.
+	Hostnames []string
+
+	paths []string
+}
+
+// recycleManifest is stored in recycled directories.
+type recycleManifest struct {
+	Hostnames []string `json:"hostnames"`
+}
+
+func (s *Service) recycleBinPath() string {
+	return filepath.Join(s.cloudInitDir, "recycle_bin")
+}
+
+// recycleBinEntries returns the entries in the recycle bin, oldest first.
+// The main and install directories of a deletion form one entry.
+func (s *Service) recycleBinEntries() ([]*RecycleBinEntry, error) {
+	recycleBinPath := s.recycleBinPath()
+	infos, err := afero.ReadDir(s.fs, recycleBinPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recycle bin: %w", err)
+	}
+
+	byName := make(map[string]*RecycleBinEntry)
+	var entries []*RecycleBinEntry
+	for _, info := range infos {
+		path := filepath.Join(recycleBinPath, info.Name())
+		name, mac, deletedAt := info.Name(), "", info.ModTime()
+		if m := recycleNamePattern.FindStringSubmatch(info.Name()); m != nil {
+			if t, err := time.ParseInLocation(recycleTimeFormat, m[3], time.Local); err == nil {
+				name, mac, deletedAt = m[1]+"_delete_me_"+m[3], m[1], t
+			}
+		}
+
+		entry, ok := byName[name]
+		if !ok {
+			entry = &RecycleBinEntry{Name: name, MacAddress: mac, DeletedAt: deletedAt}
+			byName[name] = entry
+			entries = append(entries, entry)
+		}
+		entry.paths = append(entry.paths, path)
+
+		size, err := s.treeSize(path)
+		if err != nil {
+			return nil, err
+		}
+		entry.Size += size
+
+		if manifest, err := s.readRecycleManifest(path); err != nil {
+			return nil, err
+		} else if manifest != nil {
+			entry.Hostnames = manifest.Hostnames
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].DeletedAt.Before(entries[j].DeletedAt)
+	})
+	return entries, nil
+}
+
+// treeSize returns the total size of the files under path.
+func (s *Service) treeSize(path string) (int64, error) {
+	var size int64
+	err := afero.Walk(s.fs, path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to size %s: %w", path, err)
+	}
+	return size, nil
+}
+
+func (s *Service) readRecycleManifest(dir string) (*recycleManifest, error) {
+	data, err := afero.ReadFile(s.fs, filepath.Join(dir, recycleManifestName))
+	if err != nil {
+		// Files and directories without a manifest
+		return nil, nil
+	}
+	var manifest recycleManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse recycle bin manifest in %s: %w", dir, err)
+	}
+	return &manifest, nil
+}
+
+// hostnamesLinkedTo returns the hostnames whose symlinks point to the
+// directory of a normalized MAC address.
+func (s *Service) hostnamesLinkedTo(normalizedMac string) ([]string, error) {
+	lstater, ok := s.fs.(afero.Lstater)
+	if !ok {
+		return nil, fmt.Errorf("filesystem doesn't support required operations")
+	}
+	linkReader, ok := s.fs.(afero.LinkReader)
+	if !ok {
+		return nil, fmt.Errorf("filesystem doesn't support required operations")
+	}
+
+	infos, err := afero.ReadDir(s.fs, s.cloudInitDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cloud-init directory: %w", err)
+	}
+	var hostnames []string
+	for _, info := range infos {
+		entryPath := filepath.Join(s.cloudInitDir, info.Name())
+		entryInfo, _, err := lstater.LstatIfPossible(entryPath)
+		if err != nil || entryInfo.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		target, err := linkReader.ReadlinkIfPossible(entryPath)
+		if err == nil && filepath.Base(target) == normalizedMac {
+			hostnames = append(hostnames, info.Name())
+		}
+	}
+	return hostnames, nil
+}
+
+// ListRecycleBin returns the entries in the recycle bin, oldest first.
+func (s *Service) ListRecycleBin(ctx context.Context) ([]RecycleBinEntry, error) {
+	_, span := s.tracer.Start(ctx, "ListRecycleBin")
+	defer span.End()
+
+	entries, err := s.recycleBinEntries()
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	result := make([]RecycleBinEntry, len(entries))
+	for i, entry := range entries {
+		result[i] = *entry
+		result[i].paths = nil
+	}
+	span.SetAttributes(attribute.Int("entry_count", len(result)))
+	return result, nil
+}
+
+// CleanupRecycleBin removes the recycle bin entries older than the maximum
+// age, then the oldest entries until the recycle bin fits the maximum size.
+func (s *Service) CleanupRecycleBin(ctx context.Context) error {
+	_, span := s.tracer.Start(ctx, "CleanupRecycleBin")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("max_age", s.recycleMaxAge.String()),
+		attribute.Int64("max_size", s.recycleMaxSize),
+	)
+
+	recycleBinPath := s.recycleBinPath()
+	if err := s.fs.MkdirAll(recycleBinPath, 0755); err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("failed to create recycle bin: %w", err)
+	}
+
+	entries, err := s.recycleBinEntries()
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	var total int64
+	for _, entry := range entries {
+		total += entry.Size
+	}
+
+	now := time.Now()
+	removed := 0
+	for _, entry := range entries {
+		expired := s.recycleMaxAge > 0 && now.Sub(entry.DeletedAt) > s.recycleMaxAge
+		oversized := s.recycleMaxSize > 0 && total > s.recycleMaxSize
+		if !expired && !oversized {
+			continue
+		}
+		for _, path := range entry.paths {
+			if err := s.fs.RemoveAll(path); err != nil {
+				span.RecordError(err)
+				return fmt.Errorf("failed to remove %s from recycle bin: %w", filepath.Base(path), err)
+			}
+		}
+		total -= entry.Size
+		removed++
+	}
+
+	span.SetAttributes(
+		attribute.Int("removed_count", removed),
+		attribute.Int64("remaining_size", total),
+	)
+	span.AddEvent("Recycle bin cleaned up")
+	return nil
+}
+
+// RestoreCloudInitDir moves the directories of a recycle bin entry back and
+// recreates the hostname symlinks that pointed to them. A hostname that has
+// since been linked to another directory is left alone.
+func (s *Service) RestoreCloudInitDir(ctx context.Context, name string) error {
+	ctx, span := s.tracer.Start(ctx, "RestoreCloudInitDir")
+	defer span.End()
+
+	span.SetAttributes(attribute.String("entry", name))
+
+	if !s.hasLeadership() {
+		acquired, err := s.AcquireLeadership(ctx)
+		if err != nil {
+			span.RecordError(err)
+			return fmt.Errorf("failed to acquire leadership: %w", err)
+		}
+		if !acquired {
+			return fmt.Errorf("not the leader, cannot restore directories")
+		}
+	}
+
+	entries, err := s.recycleBinEntries()
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	var entry *RecycleBinEntry
+	for _, e := range entries {
+		if e.Name == name && e.MacAddress != "" {
+			entry = e
+			break
+		}
+	}
+	if entry == nil {
+		err := fmt.Errorf("no restorable recycle bin entry %s", name)
+		span.RecordError(err)
+		return err
+	}
+
+	macDir := filepath.Join(s.cloudInitDir, entry.MacAddress)
+	macInstallDir := macDir + "_install"
+	for _, dir := range []string{macDir, macInstallDir} {
+		if exists, _ := afero.Exists(s.fs, dir); exists {
+			err := fmt.Errorf("cannot restore %s: %s already exists", name, filepath.Base(dir))
+			span.RecordError(err)
+			return err
+		}
+	}
+
+	if err := s.checkFencing(ctx); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	// Move the directories back, undoing the first move if the second fails
+	var restored []string
+	for _, path := range entry.paths {
+		dest := macDir
+		if m := recycleNamePattern.FindStringSubmatch(filepath.Base(path)); m[2] != "" {
+			dest = macInstallDir
+		}
+		if err := s.fs.Rename(path, dest); err != nil {
+			for i := len(restored) - 1; i >= 0; i -= 2 {
+				_ = s.fs.Rename(restored[i], restored[i-1])
+			}
+			span.RecordError(err)
+			return fmt.Errorf("failed to restore %s: %w", filepath.Base(path), err)
+		}
+		restored = append(restored, path, dest)
+	}
+	for i := 1; i < len(restored); i += 2 {
+		_ = s.fs.Remove(filepath.Join(restored[i], recycleManifestName))
+	}
+
+	if err := s.restoreHostnameLinks(ctx, entry.MacAddress, entry.Hostnames); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	span.AddEvent("Directories restored from recycle bin")
+	return nil
+}
+
+// restoreHostnameLinks recreates the symlinks of hostnames to the
+// directories of a normalized MAC address.
+func (s *Service) restoreHostnameLinks(ctx context.Context, normalizedMac string, hostnames []string) error {
+	_, span := s.tracer.Start(ctx, "restoreHostnameLinks")
+	defer span.End()
+
+	symlinker, ok := s.fs.(afero.Symlinker)
+	if !ok {
+		return fmt.Errorf("filesystem does not support symlinks")
+	}
+
+	macDir := filepath.Join(s.cloudInitDir, normalizedMac)
+	for _, hostname := range hostnames {
+		links := map[string]string{
+			filepath.Join(s.cloudInitDir, hostname):            macDir,
+			filepath.Join(s.cloudInitDir, hostname+"_install"): macDir + "_install",
+		}
+		for link, target := range links {
+			if exists, _ := afero.Exists(s.fs, target); !exists {
+				continue
+			}
+			if info, _, err := symlinker.LstatIfPossible(link); err == nil {
+				// A link left behind by deleting the MAC address still
+				// points here; anything else now belongs to another host
+				if info.Mode()&os.ModeSymlink != 0 {
+					if current, err := symlinker.ReadlinkIfPossible(link); err == nil && filepath.Base(current) == filepath.Base(target) {
+						continue
+					}
+				}
+				span.AddEvent(fmt.Sprintf("Not restoring %s, which is in use", filepath.Base(link)))
+				continue
+			}
+			if err := symlinker.SymlinkIfPossible(target, link); err != nil {
+				return fmt.Errorf("failed to restore hostname symlink %s: %w", filepath.Base(link), err)
+			}
+		}
+	}
+	return nil
+}
+
+// writeRecycleManifest records the hostnames linked to a deleted directory
+// in its recycle bin entry.
+func (s *Service) writeRecycleManifest(dir string, hostnames []string) error {
+	data, err := json.Marshal(recycleManifest{Hostnames: hostnames})
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(s.fs, filepath.Join(dir, recycleManifestName), data, 0644)
+}
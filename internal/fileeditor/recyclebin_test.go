@@ -0,0 +1,116 @@
+// internal/fileeditor/recyclebin_test.go
+package fileeditor
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestoreCloudInitDir(t *testing.T) {
+	service, fs, _ := setupTestService(t)
+	ctx := context.Background()
+	mac := "00:11:22:33:44:55"
+	macDir := filepath.Join(service.cloudInitDir, "00-11-22-33-44-55")
+
+	require.NoError(t, service.CreateCloudInitDirs(ctx, mac, "node-01"))
+	require.NoError(t, service.WriteCloudInitFile(ctx, mac, "meta-data", []byte("instance-id: node-01\n")))
+	require.NoError(t, service.DeleteCloudInitDir(ctx, "node-01"))
+
+	entries, err := service.ListRecycleBin(ctx)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "00-11-22-33-44-55", entries[0].MacAddress)
+	assert.Equal(t, []string{"node-01"}, entries[0].Hostnames)
+	assert.WithinDuration(t, time.Now(), entries[0].DeletedAt, 2*time.Second)
+	assert.Positive(t, entries[0].Size)
+
+	require.NoError(t, service.RestoreCloudInitDir(ctx, entries[0].Name))
+
+	content, err := afero.ReadFile(fs, filepath.Join(service.cloudInitDir, "node-01", "meta-data"))
+	require.NoError(t, err, "The hostname symlink is recreated")
+	assert.Equal(t, "instance-id: node-01\n", string(content))
+	checkSymlink(t, fs, filepath.Join(service.cloudInitDir, "node-01_install"), macDir+"_install")
+	exists, err := afero.Exists(fs, filepath.Join(macDir, recycleManifestName))
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	entries, err = service.ListRecycleBin(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	assert.Error(t, service.RestoreCloudInitDir(ctx, "00-11-22-33-44-55_delete_me_20250101_000000"))
+}
+
+func TestRestoreCloudInitDirByMac(t *testing.T) {
+	service, fs, _ := setupTestService(t)
+	ctx := context.Background()
+	mac := "00:11:22:33:44:55"
+	macDir := filepath.Join(service.cloudInitDir, "00-11-22-33-44-55")
+
+	require.NoError(t, service.CreateCloudInitDirs(ctx, mac, "node-01"))
+	require.NoError(t, service.CreateCloudInitDirs(ctx, mac, "node-01-alias"))
+	require.NoError(t, service.DeleteCloudInitDir(ctx, mac))
+
+	entries, err := service.ListRecycleBin(ctx)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.ElementsMatch(t, []string{"node-01", "node-01-alias"}, entries[0].Hostnames)
+
+	// The alias is removed and the other hostname reassigned meanwhile
+	require.NoError(t, fs.Remove(filepath.Join(service.cloudInitDir, "node-01-alias")))
+	require.NoError(t, fs.Remove(filepath.Join(service.cloudInitDir, "node-01-alias_install")))
+	require.NoError(t, service.CreateCloudInitDirs(ctx, "66:55:44:33:22:11", "node-01"))
+
+	// A directory of the same MAC address blocks the restore
+	require.NoError(t, fs.MkdirAll(macDir, 0755))
+	assert.Error(t, service.RestoreCloudInitDir(ctx, entries[0].Name))
+	require.NoError(t, fs.Remove(macDir))
+
+	require.NoError(t, service.RestoreCloudInitDir(ctx, entries[0].Name))
+	checkSymlink(t, fs, filepath.Join(service.cloudInitDir, "node-01-alias"), macDir)
+	checkSymlink(t, fs, filepath.Join(service.cloudInitDir, "node-01"), filepath.Join(service.cloudInitDir, "66-55-44-33-22-11"))
+}
+
+func TestCleanupRecycleBinSize(t *testing.T) {
+	service, fs, _ := setupTestService(t)
+	ctx := context.Background()
+	recycleBinPath := filepath.Join(service.cloudInitDir, "recycle_bin")
+
+	// Three deletions of 100 bytes, a day apart
+	now := time.Now()
+	for i, mac := range []string{"aa-aa-aa-aa-aa-aa", "bb-bb-bb-bb-bb-bb", "cc-cc-cc-cc-cc-cc"} {
+		deletedAt := now.Add(time.Duration(i-3) * 24 * time.Hour).Format(recycleTimeFormat)
+		for _, suffix := range []string{"", "_install"} {
+			dir := filepath.Join(recycleBinPath, mac+suffix+"_delete_me_"+deletedAt)
+			require.NoError(t, fs.MkdirAll(dir, 0755))
+			require.NoError(t, afero.WriteFile(fs, filepath.Join(dir, "user-data"), make([]byte, 50), 0644))
+		}
+	}
+
+	service.recycleMaxAge = 0
+	service.recycleMaxSize = 250
+	require.NoError(t, service.CleanupRecycleBin(ctx))
+	entries, err := service.ListRecycleBin(ctx)
+	require.NoError(t, err)
+	require.Len(t, entries, 2, "The oldest entry is removed with both of its directories")
+	assert.Equal(t, "bb-bb-bb-bb-bb-bb", entries[0].MacAddress)
+	assert.Equal(t, int64(100), entries[0].Size)
+
+	service.recycleMaxAge = 36 * time.Hour
+	service.recycleMaxSize = 0
+	require.NoError(t, service.CleanupRecycleBin(ctx))
+	entries, err = service.ListRecycleBin(ctx)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "cc-cc-cc-cc-cc-cc", entries[0].MacAddress)
+
+	infos, err := afero.ReadDir(fs, recycleBinPath)
+	require.NoError(t, err)
+	assert.Len(t, infos, 2)
+}
@@ -0,0 +1,566 @@
+// internal/fileeditor/schema.go
+package fileeditor
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// schemaFiles are the JSON schemas of the cloud-init files. They use the
+// subset of JSON Schema implemented by schemaValidator, plus
+// "x-unknown-keys" to report the keys rejected by "additionalProperties":
+// false with a severity other than error.
+//
+//go:embed schemas/*.json
+var schemaFiles embed.FS
+
+// cloudInitSchemas maps schema file names to their schemas.
+var cloudInitSchemas = mustLoadSchemas()
+
+// schema is a JSON schema.
+type schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 schemaTypes        `json:"type"`
+	Enum                 []interface{}      `json:"enum"`
+	AnyOf                []*schema          `json:"anyOf"`
+	Properties           map[string]*schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *additionalSchema  `json:"additionalProperties"`
+	UnknownKeys          Severity           `json:"x-unknown-keys"`
+	Items                *schema            `json:"items"`
+	Pattern              string             `json:"pattern"`
+	MinLength            *int               `json:"minLength"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	Definitions          map[string]*schema `json:"definitions"`
+
+	pattern *regexp.Regexp
+}
+
+// schemaTypes is the "type" keyword, a name or a list of names.
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*t = schemaTypes{name}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(t))
+}
+
+// additionalSchema is the "additionalProperties" keyword, a boolean or a
+// schema for the values of unlisted keys.
+type additionalSchema struct {
+	allowed bool
+	schema  *schema
+}
+
+func (a *additionalSchema) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &a.allowed); err == nil {
+		return nil
+	}
+	a.allowed = true
+	return json.Unmarshal(data, &a.schema)
+}
+
+func mustLoadSchemas() map[string]*schema {
+	entries, err := schemaFiles.ReadDir("schemas")
+	if err != nil {
+		panic(err)
+	}
+
+	schemas := make(map[string]*schema)
+	for _, entry := range entries {
+		data, err := schemaFiles.ReadFile(path.Join("schemas", entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+		var s schema
+		if err := json.Unmarshal(data, &s); err != nil {
+			panic(fmt.Sprintf("schema %s: %v", entry.Name(), err))
+		}
+		schemas[entry.Name()] = &s
+	}
+
+	// Compile the patterns and check the references up front, so that a
+	// broken schema fails the tests rather than a validation
+	for name, s := range schemas {
+		if err := prepareSchema(schemas, name, s); err != nil {
+			panic(fmt.Sprintf("schema %s: %v", name, err))
+		}
+	}
+	return schemas
+}
+
+// prepareSchema compiles the patterns in s and checks its references.
+func prepareSchema(schemas map[string]*schema, file string, s *schema) error {
+	if s == nil {
+		return nil
+	}
+	if s.Ref != "" {
+		if _, _, err := resolveRef(schemas, file, s.Ref); err != nil {
+			return err
+		}
+	}
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return err
+		}
+		s.pattern = re
+	}
+
+	children := append([]*schema{s.Items}, s.AnyOf...)
+	for _, child := range s.Properties {
+		children = append(children, child)
+	}
+	for _, child := range s.Definitions {
+		children = append(children, child)
+	}
+	if s.AdditionalProperties != nil {
+		children = append(children, s.AdditionalProperties.schema)
+	}
+	for _, child := range children {
+		if err := prepareSchema(schemas, file, child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveRef returns the schema ref points to from the schema file and the
+// file it is in. Only references to files and their definitions are
+// supported.
+func resolveRef(schemas map[string]*schema, file, ref string) (*schema, string, error) {
+	refFile, fragment, _ := strings.Cut(ref, "#")
+	if refFile != "" {
+		file = refFile
+	}
+	s, ok := schemas[file]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown schema %q", ref)
+	}
+	if fragment == "" {
+		return s, file, nil
+	}
+	name, ok := strings.CutPrefix(fragment, "/definitions/")
+	if !ok {
+		return nil, "", fmt.Errorf("unsupported reference %q", ref)
+	}
+	if s, ok = s.Definitions[name]; !ok {
+		return nil, "", fmt.Errorf("unknown definition %q", ref)
+	}
+	return s, file, nil
+}
+
+// schemaValidator checks YAML documents against the schemas, collecting
+// diagnostics that carry the path and line of each problem.
+type schemaValidator struct {
+	schemas     map[string]*schema
+	diagnostics []Diagnostic
+	// active holds the collections being validated, so that an alias
+	// back to one of them is reported instead of followed forever
+	active map[*yaml.Node]bool
+}
+
+func (v *schemaValidator) report(node *yaml.Node, severity Severity, path, format string, args ...interface{}) {
+	v.diagnostics = append(v.diagnostics, Diagnostic{
+		Line:     node.Line,
+		Path:     path,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// resolve follows the references of s, which is in the schema file named
+// file.
+func (v *schemaValidator) resolve(s *schema, file string) (*schema, string) {
+	for s.Ref != "" {
+		// References were checked when the schemas were loaded
+		s, file, _ = resolveRef(v.schemas, file, s.Ref)
+	}
+	return s, file
+}
+
+// validate checks node, found at path, against s.
+func (v *schemaValidator) validate(node *yaml.Node, s *schema, file, path string) {
+	s, file = v.resolve(s, file)
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if v.active[node] {
+		v.report(node, SeverityError, path, "recursive alias")
+		return
+	}
+
+	if len(s.AnyOf) > 0 && !v.validateAnyOf(node, s.AnyOf, file, path) {
+		return
+	}
+
+	nodeType := yamlType(node)
+	if !typeMatches(s.Type, nodeType) {
+		v.report(node, SeverityError, path, "must be %s, not %s", describeTypes(s.Type), describeType(nodeType))
+		return
+	}
+	if len(s.Enum) > 0 && !enumContains(s.Enum, node) {
+		v.report(node, SeverityError, path, "must be %s, not %s", describeEnum(s.Enum), describeValue(node))
+		return
+	}
+
+	if node.Kind == yaml.MappingNode || node.Kind == yaml.SequenceNode {
+		if v.active == nil {
+			v.active = make(map[*yaml.Node]bool)
+		}
+		v.active[node] = true
+		defer delete(v.active, node)
+	}
+
+	switch node.Kind {
+	case yaml.ScalarNode:
+		v.validateScalar(node, s, nodeType, path)
+	case yaml.MappingNode:
+		v.validateMapping(node, s, file, path)
+	case yaml.SequenceNode:
+		if s.Items != nil {
+			for i, item := range node.Content {
+				v.validate(item, s.Items, file, fmt.Sprintf("%s[%d]", path, i))
+			}
+		}
+	}
+}
+
+// validateAnyOf checks node against the alternatives and reports the
+// problems of the one it most likely meant to match. Alternatives of the
+// wrong type are not considered, and among objects those whose required
+// keys and enumerated values node has are preferred.
+func (v *schemaValidator) validateAnyOf(node *yaml.Node, alternatives []*schema, file, path string) bool {
+	nodeType := yamlType(node)
+	var types schemaTypes
+	var candidates, selected []*schema
+	var files, selectedFiles []string
+	for _, alt := range alternatives {
+		alt, altFile := v.resolve(alt, file)
+		altTypes := alt.Type
+		if len(altTypes) == 0 {
+			altTypes = enumTypes(alt.Enum)
+		}
+		types = append(types, altTypes...)
+		if !typeMatches(altTypes, nodeType) {
+			continue
+		}
+		candidates, files = append(candidates, alt), append(files, altFile)
+		if selects(alt, node) {
+			selected, selectedFiles = append(selected, alt), append(selectedFiles, altFile)
+		}
+	}
+	if len(candidates) == 0 {
+		v.report(node, SeverityError, path, "must be %s, not %s", describeTypes(types), describeType(nodeType))
+		return false
+	}
+	if len(selected) > 0 {
+		candidates, files = selected, selectedFiles
+	}
+
+	var best []Diagnostic
+	for i, alt := range candidates {
+		sub := &schemaValidator{schemas: v.schemas, active: v.active}
+		sub.validate(node, alt, files[i], path)
+		if errorCount(sub.diagnostics) == 0 {
+			v.diagnostics = append(v.diagnostics, sub.diagnostics...)
+			return true
+		}
+		if best == nil || errorCount(sub.diagnostics) < errorCount(best) {
+			best = sub.diagnostics
+		}
+	}
+	v.diagnostics = append(v.diagnostics, best...)
+	return false
+}
+
+// selects reports whether a mapping has the required keys of s and the
+// values it enumerates, e.g. the version of a netplan configuration.
+func selects(s *schema, node *yaml.Node) bool {
+	if node.Kind != yaml.MappingNode {
+		return true
+	}
+	for _, name := range s.Required {
+		if mappingValue(node, name) == nil {
+			return false
+		}
+	}
+	for name, prop := range s.Properties {
+		value := mappingValue(node, name)
+		if value != nil && len(prop.Enum) > 0 && !enumContains(prop.Enum, value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (v *schemaValidator) validateScalar(node *yaml.Node, s *schema, nodeType, path string) {
+	switch nodeType {
+	case "string":
+		if s.MinLength != nil && len(node.Value) < *s.MinLength {
+			if *s.MinLength == 1 {
+				v.report(node, SeverityError, path, "must not be empty")
+			} else {
+				v.report(node, SeverityError, path, "must be at least %d characters long", *s.MinLength)
+			}
+		}
+		if s.pattern != nil && !s.pattern.MatchString(node.Value) {
+			v.report(node, SeverityError, path, "%q is not in the expected format (%s)", node.Value, s.Pattern)
+		}
+	case "integer", "number":
+		n, err := strconv.ParseFloat(strings.ReplaceAll(node.Value, "_", ""), 64)
+		if err != nil {
+			return
+		}
+		if s.Minimum != nil && n < *s.Minimum {
+			v.report(node, SeverityError, path, "must be at least %v, not %s", *s.Minimum, node.Value)
+		}
+		if s.Maximum != nil && n > *s.Maximum {
+			v.report(node, SeverityError, path, "must be at most %v, not %s", *s.Maximum, node.Value)
+		}
+	}
+}
+
+func (v *schemaValidator) validateMapping(node *yaml.Node, s *schema, file, path string) {
+	seen := make(map[string]int)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if key.Value == "<<" {
+			// Merge keys pull in keys checked where they are defined
+			if value.Kind == yaml.AliasNode && v.active[value.Alias] {
+				v.report(value, SeverityError, joinPath(path, key.Value), "recursive alias")
+			}
+			continue
+		}
+		if line, ok := seen[key.Value]; ok {
+			v.report(key, SeverityError, path, "key %q is already defined on line %d", key.Value, line)
+			continue
+		}
+		seen[key.Value] = key.Line
+
+		keyPath := joinPath(path, key.Value)
+		if prop, ok := s.Properties[key.Value]; ok {
+			v.validate(value, prop, file, keyPath)
+			continue
+		}
+		switch additional := s.AdditionalProperties; {
+		case additional == nil:
+		case additional.schema != nil:
+			v.validate(value, additional.schema, file, keyPath)
+		case !additional.allowed:
+			severity := s.UnknownKeys
+			if severity == "" {
+				severity = SeverityError
+			}
+			if suggestion := closestKey(key.Value, s.Properties); suggestion != "" {
+				v.report(key, severity, path, "unknown key %q (did you mean %q?)", key.Value, suggestion)
+			} else {
+				v.report(key, severity, path, "unknown key %q", key.Value)
+			}
+		}
+	}
+
+	for _, name := range s.Required {
+		if _, ok := seen[name]; !ok && mappingValue(node, name) == nil {
+			v.report(node, SeverityError, path, "missing required key %q", name)
+		}
+	}
+}
+
+// closestKey returns the known key nearest to a misspelt one, if any is
+// close enough to be the intended one.
+func closestKey(key string, properties map[string]*schema) string {
+	best, bestDistance := "", 3
+	for known := range properties {
+		if d := editDistance(key, known); d < bestDistance || (d == bestDistance && known < best) {
+			best, bestDistance = known, d
+		}
+	}
+	if bestDistance > 2 || bestDistance >= len(key) {
+		return ""
+	}
+	return best
+}
+
+// mappingValue returns the value of key in a mapping, including merged
+// mappings, or nil.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	return mergedValue(node, key, make(map[*yaml.Node]bool))
+}
+
+// mergedValue looks up key in node and the mappings it merges that are not
+// in visited yet.
+func mergedValue(node *yaml.Node, key string, visited map[*yaml.Node]bool) *yaml.Node {
+	if visited[node] {
+		return nil
+	}
+	visited[node] = true
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		k, value := node.Content[i], node.Content[i+1]
+		if value.Kind == yaml.AliasNode {
+			value = value.Alias
+		}
+		if k.Value == key {
+			return value
+		}
+		if k.Value == "<<" && value.Kind == yaml.MappingNode {
+			if merged := mergedValue(value, key, visited); merged != nil {
+				return merged
+			}
+		}
+	}
+	return nil
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func errorCount(diagnostics []Diagnostic) int {
+	n := 0
+	for _, d := range diagnostics {
+		if d.Severity == SeverityError {
+			n++
+		}
+	}
+	return n
+}
+
+// yamlType returns the JSON schema type of a node.
+func yamlType(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "object"
+	case yaml.SequenceNode:
+		return "array"
+	}
+	switch node.ShortTag() {
+	case "!!int":
+		return "integer"
+	case "!!float":
+		return "number"
+	case "!!bool":
+		return "boolean"
+	case "!!null":
+		return "null"
+	}
+	return "string"
+}
+
+func typeMatches(types schemaTypes, nodeType string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	for _, t := range types {
+		if t == nodeType || (t == "number" && nodeType == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func enumContains(enum []interface{}, node *yaml.Node) bool {
+	if node.Kind != yaml.ScalarNode {
+		return false
+	}
+	nodeType := yamlType(node)
+	for _, value := range enum {
+		switch value := value.(type) {
+		case string:
+			if nodeType == "string" && node.Value == value {
+				return true
+			}
+		case float64:
+			n, err := strconv.ParseFloat(node.Value, 64)
+			if (nodeType == "integer" || nodeType == "number") && err == nil && n == value {
+				return true
+			}
+		case bool:
+			b, err := strconv.ParseBool(node.Value)
+			if nodeType == "boolean" && err == nil && b == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func enumTypes(enum []interface{}) schemaTypes {
+	var types schemaTypes
+	for _, value := range enum {
+		switch value.(type) {
+		case string:
+			types = append(types, "string")
+		case float64:
+			types = append(types, "number")
+		case bool:
+			types = append(types, "boolean")
+		}
+	}
+	return types
+}
+
+var typeDescriptions = map[string]string{
+	"object":  "a mapping",
+	"array":   "a list",
+	"string":  "a string",
+	"integer": "an integer",
+	"number":  "a number",
+	"boolean": "a boolean",
+	"null":    "null",
+}
+
+func describeType(t string) string {
+	return typeDescriptions[t]
+}
+
+func describeTypes(types schemaTypes) string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, t := range types {
+		if !seen[t] {
+			seen[t] = true
+			names = append(names, describeType(t))
+		}
+	}
+	return strings.Join(names, " or ")
+}
+
+func describeEnum(enum []interface{}) string {
+	values := make([]string, len(enum))
+	for i, value := range enum {
+		if s, ok := value.(string); ok {
+			values[i] = strconv.Quote(s)
+		} else {
+			values[i] = fmt.Sprint(value)
+		}
+	}
+	if len(values) == 1 {
+		return values[0]
+	}
+	return "one of " + strings.Join(values, ", ")
+}
+
+func describeValue(node *yaml.Node) string {
+	switch t := yamlType(node); t {
+	case "string":
+		return strconv.Quote(node.Value)
+	case "object", "array", "null":
+		return describeType(t)
+	}
+	return node.Value
+}
@@ -3,14 +3,18 @@ package fileeditor
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/atomicfile"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/database"
 	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/observability"
 	"github.com/spf13/afero"
 	"github.com/spf13/viper"
@@ -25,11 +29,17 @@ type FileEditor interface {
 	CreateCloudInitDirs(ctx context.Context, macAddress, hostname string) error
 	ValidateCloudInitFiles(files map[string][]byte) error
 	WriteCloudInitFile(ctx context.Context, macAddress string, fileType string, content []byte) error
+	WriteCloudInitFiles(ctx context.Context, macAddress string, files map[string][]byte) error
+	ListRevisions(ctx context.Context, macAddress, fileType string) ([]Revision, error)
+	DiffRevisions(ctx context.Context, macAddress, fileType string, from, to int) (string, error)
+	RestoreRevision(ctx context.Context, macAddress, fileType string, number int) error
 	ListFiles(ctx context.Context, fileType string) ([]string, error)
 	ReadFile(ctx context.Context, fileType string, filename string) ([]byte, error)
 	DeleteFile(ctx context.Context, fileType string, filename string) error
 	DeleteCloudInitDir(ctx context.Context, macOrHostname string) error
 	CleanupRecycleBin(ctx context.Context) error
+	ListRecycleBin(ctx context.Context) ([]RecycleBinEntry, error)
+	RestoreCloudInitDir(ctx context.Context, name string) error
 	Start(ctx context.Context) error
 }
 
@@ -39,14 +49,27 @@ type Service struct {
 	osFs          *afero.OsFs // Specific OS filesystem for symlink operations
 	ipxeDir       string
 	cloudInitDir  string
+	historyDir    string
+	historyMutex  sync.Mutex
 	leaderMutex   sync.Mutex
 	isLeader      bool
 	tracer        trace.Tracer
 	leaderLockKey string
+
+	// Distributed leader election; a nil lease store means single-instance mode
+	leases   database.LeaseStore
+	holderID string
+	leaseTTL time.Duration
+	lease    *database.Lease
+
+	// Recycle bin retention; zero disables a limit
+	recycleMaxAge   time.Duration
+	recycleMaxSize  int64
+	cleanupInterval time.Duration
 }
 
 // NewService creates a new instance of the file editor service.
-func NewService() FileEditor {
+func NewService(opts ...Option) FileEditor {
 	// Create a tracer specific to this service.
 	tracer := observability.GetTracer("fileeditor-service")
 	fs := afero.NewOsFs()
@@ -55,26 +78,49 @@ func NewService() FileEditor {
 	if !ok {
 		panic("failed to cast to *afero.OsFs")
 	}
-	return &Service{
+	s := &Service{
 		fs:            fs,
 		osFs:          osFs,
 		ipxeDir:       viper.GetString("fileeditor.ipxe_dir"),
 		cloudInitDir:  viper.GetString("fileeditor.cloudinit_dir"),
+		historyDir:    viper.GetString("fileeditor.history_dir"),
 		isLeader:      false,
 		tracer:        tracer,
 		leaderLockKey: "fileeditor/leader",
+		holderID:      viper.GetString("fileeditor.instance_id"),
+		leaseTTL:      viper.GetDuration("fileeditor.lease_ttl"),
+
+		recycleMaxAge:   DefaultRecycleBinMaxAge,
+		recycleMaxSize:  int64(viper.GetSizeInBytes("fileeditor.recycle_bin.max_size")),
+		cleanupInterval: viper.GetDuration("fileeditor.recycle_bin.cleanup_interval"),
+	}
+	if viper.IsSet("fileeditor.recycle_bin.max_age") {
+		s.recycleMaxAge = viper.GetDuration("fileeditor.recycle_bin.max_age")
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.holderID == "" {
+		s.holderID = defaultHolderID()
+	}
+	if s.leaseTTL <= 0 {
+		s.leaseTTL = DefaultLeaseTTL
+	}
+	if s.cleanupInterval <= 0 {
+		s.cleanupInterval = DefaultRecycleBinCleanupInterval
 	}
+	return s
 }
 
+// ValidateIpxeFile checks an iPXE script. Problems that stop it from
+// booting are returned as a *ValidationError with line-numbered
+// diagnostics; see LintIpxeScript.
 func (s *Service) ValidateIpxeFile(content []byte) error {
 	// Start a new span for the validation operation.
 	ctx, span := s.tracer.Start(context.Background(), "ValidateIpxeFile")
 	defer span.End()
 
-	fmt.Println("Validating ipxe file content")
-	// TODO: implement actual validation logic.
-	_ = ctx // Use context if needed for more advanced scenarios.
-	return nil
+	return s.validateIpxeContent(ctx, content)
 }
 
 func (s *Service) WriteIpxeFile(ctx context.Context, macAddress string, content []byte) error {
@@ -83,7 +129,7 @@ func (s *Service) WriteIpxeFile(ctx context.Context, macAddress string, content
 
 	span.SetAttributes(attribute.String("mac_address", macAddress))
 
-	if !s.isLeader {
+	if !s.hasLeadership() {
 		acquired, err := s.AcquireLeadership(ctx)
 		if err != nil {
 			span.RecordError(err)
@@ -95,6 +141,18 @@ func (s *Service) WriteIpxeFile(ctx context.Context, macAddress string, content
 		}
 	}
 
+	// Validate the content
+	if err := s.validateIpxeContent(ctx, content); err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("invalid iPXE content: %w", err)
+	}
+
+	// Confirm the lease is still current before changing anything on disk
+	if err := s.checkFencing(ctx); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
 	// Ensure the iPXE directory exists
 	if err := s.ensureDirectory(ctx, s.ipxeDir); err != nil {
 		span.RecordError(err)
@@ -106,19 +164,19 @@ func (s *Service) WriteIpxeFile(ctx context.Context, macAddress string, content
 	filename := fmt.Sprintf("mac-%s.ipxe", normalizedMac)
 	filepath := filepath.Join(s.ipxeDir, filename)
 
-	// Validate the content
-	if err := s.validateIpxeContent(ctx, content); err != nil {
+	if err := s.recordUntracked(normalizedMac, "ipxe", filepath); err != nil {
 		span.RecordError(err)
-		return fmt.Errorf("invalid iPXE content: %w", err)
+		return fmt.Errorf("failed to record file history: %w", err)
 	}
 
-	// Write the file
-	err := afero.WriteFile(s.fs, filepath, content, 0644)
-	if err != nil {
+	// Write the file atomically so that clients never fetch a partial script
+	if err := atomicfile.WriteFile(s.fs, filepath, content, 0644); err != nil {
 		span.RecordError(err)
 		return fmt.Errorf("failed to write iPXE file: %w", err)
 	}
 
+	s.recordWritten(ctx, span, normalizedMac, "ipxe", filepath, content)
+
 	span.SetAttributes(attribute.String("filepath", filepath))
 	span.AddEvent("iPXE file written successfully")
 	return nil
@@ -133,7 +191,7 @@ func (s *Service) CreateCloudInitDirs(ctx context.Context, macAddress, hostname
 		attribute.String("hostname", hostname),
 	)
 
-	if !s.isLeader {
+	if !s.hasLeadership() {
 		acquired, err := s.AcquireLeadership(ctx)
 		if err != nil {
 			span.RecordError(err)
@@ -145,6 +203,12 @@ func (s *Service) CreateCloudInitDirs(ctx context.Context, macAddress, hostname
 		}
 	}
 
+	// Confirm the lease is still current before changing anything on disk
+	if err := s.checkFencing(ctx); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
 	// Ensure the cloud-init directory exists
 	if err := s.ensureDirectory(ctx, s.cloudInitDir); err != nil {
 		span.RecordError(err)
@@ -197,18 +261,53 @@ func (s *Service) CreateCloudInitDirs(ctx context.Context, macAddress, hostname
 	return nil
 }
 
+// ValidateCloudInitFiles validates a set of cloud-init files keyed by file
+// name, e.g. "user-data" or "meta-data_install". The returned
+// *ValidationError lists the problems of all of the files.
 func (s *Service) ValidateCloudInitFiles(files map[string][]byte) error {
 	ctx, span := s.tracer.Start(context.Background(), "ValidateCloudInitFiles")
 	defer span.End()
 
-	fmt.Println("Validating cloud-init files")
-	// TODO: implement validation using cloud-init libraries.
-	_ = ctx
+	return s.validateCloudInitFiles(ctx, files)
+}
+
+func (s *Service) validateCloudInitFiles(ctx context.Context, files map[string][]byte) error {
+	ctx, span := s.tracer.Start(ctx, "validateCloudInitFiles")
+	defer span.End()
+
+	span.SetAttributes(attribute.Int("file_count", len(files)))
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Check every file so that all of their problems are reported at once
+	var diagnostics []Diagnostic
+	for _, name := range names {
+		fileDiagnostics, err := s.lintCloudInitContent(ctx, name, files[name])
+		if err != nil {
+			span.RecordError(err)
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		for _, d := range fileDiagnostics {
+			d.File = name
+			diagnostics = append(diagnostics, d)
+		}
+	}
+	span.SetAttributes(attribute.Int("diagnostic_count", len(diagnostics)))
+
+	if err := validationError(diagnostics); err != nil {
+		span.RecordError(err)
+		return err
+	}
 	return nil
 }
 
-// AcquireLeadership attempts to acquire leadership for file operations
-// In a real implementation, this would use a distributed lock mechanism
+// AcquireLeadership attempts to acquire leadership for file operations.
+// When a lease store is configured the leader lease is taken from the shared
+// database; otherwise this instance is assumed to be the only writer.
 func (s *Service) AcquireLeadership(ctx context.Context) (bool, error) {
 	ctx, span := s.tracer.Start(ctx, "AcquireLeadership")
 	defer span.End()
@@ -216,11 +315,30 @@ func (s *Service) AcquireLeadership(ctx context.Context) (bool, error) {
 	s.leaderMutex.Lock()
 	defer s.leaderMutex.Unlock()
 
-	// TODO: Implement proper distributed locking using database or k8s
-	// For now, just simulate being the leader
+	if s.leases == nil {
+		s.isLeader = true
+		span.SetAttributes(attribute.Bool("is_leader", s.isLeader))
+		return s.isLeader, nil
+	}
+
+	lease, err := s.leases.AcquireLease(ctx, s.leaderLockKey, s.holderID, s.leaseTTL)
+	if errors.Is(err, database.ErrLeaseHeld) {
+		s.stepDown()
+		span.SetAttributes(attribute.Bool("is_leader", false))
+		return false, nil
+	}
+	if err != nil {
+		span.RecordError(err)
+		return false, err
+	}
+
+	s.lease = lease
 	s.isLeader = true
 
-	span.SetAttributes(attribute.Bool("is_leader", s.isLeader))
+	span.SetAttributes(
+		attribute.Bool("is_leader", s.isLeader),
+		attribute.Int64("fencing_token", lease.Token),
+	)
 	return s.isLeader, nil
 }
 
@@ -232,10 +350,23 @@ func (s *Service) ReleaseLeadership(ctx context.Context) error {
 	s.leaderMutex.Lock()
 	defer s.leaderMutex.Unlock()
 
+	var err error
+	if s.leases != nil && s.lease != nil {
+		err = s.leases.ReleaseLease(ctx, s.lease)
+		if errors.Is(err, database.ErrLeaseLost) {
+			// Someone else already holds it; nothing left to release
+			err = nil
+		}
+	}
+
 	// Reset leader status
-	s.isLeader = false
+	s.stepDown()
 
 	span.SetAttributes(attribute.Bool("is_leader", s.isLeader))
+	if err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("failed to release leader lease: %w", err)
+	}
 	return nil
 }
 
@@ -278,25 +409,21 @@ func (s *Service) normalizeMacAddress(mac string) string {
 
 // validateIpxeContent validates the content of an iPXE file
 func (s *Service) validateIpxeContent(ctx context.Context, content []byte) error {
-	ctx, span := s.tracer.Start(ctx, "validateIpxeContent")
+	_, span := s.tracer.Start(ctx, "validateIpxeContent")
 	defer span.End()
 
-	// Check if content is empty
-	if len(content) == 0 {
-		err := fmt.Errorf("iPXE content cannot be empty")
-		span.RecordError(err)
-		return err
+	diagnostics := LintIpxeScript(content)
+	for _, d := range diagnostics {
+		if d.Severity == SeverityWarning {
+			span.AddEvent(d.String())
+		}
 	}
+	span.SetAttributes(attribute.Int("diagnostic_count", len(diagnostics)))
 
-	// Check if the content starts with #!ipxe
-	if !strings.HasPrefix(string(content), "#!ipxe") {
-		err := fmt.Errorf("iPXE content must start with #!ipxe")
+	if err := validationError(diagnostics); err != nil {
 		span.RecordError(err)
 		return err
 	}
-
-	// Additional validation logic could be added here
-
 	return nil
 }
 
@@ -310,7 +437,7 @@ func (s *Service) WriteCloudInitFile(ctx context.Context, macAddress string, fil
 		attribute.String("file_type", fileType),
 	)
 
-	if !s.isLeader {
+	if !s.hasLeadership() {
 		acquired, err := s.AcquireLeadership(ctx)
 		if err != nil {
 			span.RecordError(err)
@@ -322,44 +449,177 @@ func (s *Service) WriteCloudInitFile(ctx context.Context, macAddress string, fil
 		}
 	}
 
-	// Normalize the MAC address
-	normalizedMac := s.normalizeMacAddress(macAddress)
-
 	// Determine the directory based on file type
-	var dirPath string
-	if strings.HasSuffix(fileType, "_install") {
-		baseType := strings.TrimSuffix(fileType, "_install")
-		dirPath = filepath.Join(s.cloudInitDir, normalizedMac+"_install")
-		fileType = baseType
-	} else {
-		dirPath = filepath.Join(s.cloudInitDir, normalizedMac)
+	filePath, baseType, err := s.cloudInitFilePath(macAddress, fileType)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	// Validate the content based on file type
+	if err := s.validateCloudInitContent(ctx, baseType, content); err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("invalid cloud-init content: %w", err)
+	}
+
+	// Confirm the lease is still current before changing anything on disk
+	if err := s.checkFencing(ctx); err != nil {
+		span.RecordError(err)
+		return err
 	}
 
 	// Validate that the directory exists
-	if err := s.ensureDirectory(ctx, dirPath); err != nil {
+	if err := s.ensureDirectory(ctx, filepath.Dir(filePath)); err != nil {
 		span.RecordError(err)
 		return err
 	}
 
-	// Validate the content based on file type
-	if err := s.validateCloudInitContent(ctx, fileType, content); err != nil {
+	normalizedMac := s.normalizeMacAddress(macAddress)
+	if err := s.recordUntracked(normalizedMac, fileType, filePath); err != nil {
 		span.RecordError(err)
-		return fmt.Errorf("invalid cloud-init content: %w", err)
+		return fmt.Errorf("failed to record file history: %w", err)
 	}
 
-	// Write the file
-	filePath := filepath.Join(dirPath, fileType)
-	err := afero.WriteFile(s.fs, filePath, content, 0644)
-	if err != nil {
+	// Write the file atomically so that clients never fetch a partial file
+	if err := atomicfile.WriteFile(s.fs, filePath, content, 0644); err != nil {
 		span.RecordError(err)
 		return fmt.Errorf("failed to write cloud-init file: %w", err)
 	}
 
+	s.recordWritten(ctx, span, normalizedMac, fileType, filePath, content)
+
 	span.SetAttributes(attribute.String("filepath", filePath))
 	span.AddEvent("Cloud-init file written successfully")
 	return nil
 }
 
+// WriteCloudInitFiles writes several cloud-init files for a MAC address as
+// one transaction, keyed by file type as for WriteCloudInitFile. All of the
+// files are validated and staged before any is replaced, and if one cannot
+// be put in place the files already replaced are restored.
+func (s *Service) WriteCloudInitFiles(ctx context.Context, macAddress string, files map[string][]byte) error {
+	ctx, span := s.tracer.Start(ctx, "WriteCloudInitFiles")
+	defer span.End()
+
+	span.SetAttributes(
+		attribute.String("mac_address", macAddress),
+		attribute.Int("file_count", len(files)),
+	)
+
+	if !s.hasLeadership() {
+		acquired, err := s.AcquireLeadership(ctx)
+		if err != nil {
+			span.RecordError(err)
+			return fmt.Errorf("failed to acquire leadership: %w", err)
+		}
+
+		if !acquired {
+			return fmt.Errorf("not the leader, cannot write files")
+		}
+	}
+
+	// Validate every file before touching any of them
+	if err := s.validateCloudInitFiles(ctx, files); err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("invalid cloud-init content: %w", err)
+	}
+
+	fileTypes := make([]string, 0, len(files))
+	for fileType := range files {
+		fileTypes = append(fileTypes, fileType)
+	}
+	sort.Strings(fileTypes)
+
+	// Confirm the lease is still current before changing anything on disk
+	if err := s.checkFencing(ctx); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	normalizedMac := s.normalizeMacAddress(macAddress)
+	tx := newFileTransaction(s.fs)
+	for _, fileType := range fileTypes {
+		filePath, _, err := s.cloudInitFilePath(macAddress, fileType)
+		if err != nil {
+			tx.Abort()
+			span.RecordError(err)
+			return err
+		}
+		if err := s.ensureDirectory(ctx, filepath.Dir(filePath)); err != nil {
+			tx.Abort()
+			span.RecordError(err)
+			return err
+		}
+		if err := s.recordUntracked(normalizedMac, fileType, filePath); err != nil {
+			tx.Abort()
+			span.RecordError(err)
+			return fmt.Errorf("failed to record file history: %w", err)
+		}
+		if err := tx.Stage(filePath, files[fileType], 0644); err != nil {
+			tx.Abort()
+			span.RecordError(err)
+			return fmt.Errorf("failed to write cloud-init files: %w", err)
+		}
+	}
+
+	if err := s.checkFencing(ctx); err != nil {
+		tx.Abort()
+		span.RecordError(err)
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("failed to write cloud-init files: %w", err)
+	}
+
+	for _, fileType := range fileTypes {
+		filePath, _, _ := s.cloudInitFilePath(macAddress, fileType)
+		s.recordWritten(ctx, span, normalizedMac, fileType, filePath, files[fileType])
+	}
+
+	span.AddEvent("Cloud-init files written successfully")
+	return nil
+}
+
+// recordWritten records content, just written to path, as a new revision.
+// The write has already succeeded, so a history failure is only a warning.
+func (s *Service) recordWritten(ctx context.Context, span trace.Span, normalizedMac, fileType, path string, content []byte) {
+	revision, err := s.recordRevision(ctx, normalizedMac, fileType, content)
+	if err != nil {
+		span.RecordError(err)
+		fmt.Printf("Warning: wrote %s but failed to record file history: %v\n", path, err)
+		return
+	}
+	span.SetAttributes(attribute.Int("revision", revision.Number))
+}
+
+// cloudInitFileTypes are the files kept in a host's cloud-init directories.
+var cloudInitFileTypes = map[string]bool{
+	"user-data":      true,
+	"meta-data":      true,
+	"network-config": true,
+	"variables.sh":   true,
+}
+
+// cloudInitFilePath returns the path of a cloud-init file for a MAC address
+// and its base file type. Types with an "_install" suffix are written to
+// the install directory. Unknown types are refused, so a caller-supplied
+// type cannot name a path outside the host's directory.
+func (s *Service) cloudInitFilePath(macAddress, fileType string) (string, string, error) {
+	dir := s.normalizeMacAddress(macAddress)
+	if dir == "" || dir == "." || dir == ".." || strings.ContainsAny(dir, `/\`) {
+		return "", "", fmt.Errorf("invalid MAC address: %s", macAddress)
+	}
+	baseType, install := strings.CutSuffix(fileType, "_install")
+	if !cloudInitFileTypes[baseType] {
+		return "", "", fmt.Errorf("unknown cloud-init file type: %s", fileType)
+	}
+	if install {
+		dir += "_install"
+	}
+	return filepath.Join(s.cloudInitDir, dir, baseType), baseType, nil
+}
+
 // validateCloudInitContent validates the content of a cloud-init file based on its type
 func (s *Service) validateCloudInitContent(ctx context.Context, fileType string, content []byte) error {
 	ctx, span := s.tracer.Start(ctx, "validateCloudInitContent")
@@ -367,49 +627,59 @@ func (s *Service) validateCloudInitContent(ctx context.Context, fileType string,
 
 	span.SetAttributes(attribute.String("file_type", fileType))
 
-	// Basic validation - check if content is empty
-	if len(content) == 0 {
-		err := fmt.Errorf("cloud-init content cannot be empty")
+	diagnostics, err := s.lintCloudInitContent(ctx, fileType, content)
+	if err != nil {
 		span.RecordError(err)
 		return err
 	}
+	if err := validationError(diagnostics); err != nil {
+		span.RecordError(err)
+		return err
+	}
+	return nil
+}
 
-	switch fileType {
-	case "meta-data":
-		// Basic validation for meta-data
-		// Could add more specific validation here
-		return nil
+// lintCloudInitContent returns the diagnostics for a cloud-init file. Files
+// that cannot be checked at all, being empty or of an unknown type, are
+// returned as an error. The "_install" suffix of install-time files is
+// ignored.
+func (s *Service) lintCloudInitContent(ctx context.Context, fileType string, content []byte) ([]Diagnostic, error) {
+	_, span := s.tracer.Start(ctx, "lintCloudInitContent")
+	defer span.End()
 
-	case "user-data":
-		// Basic validation for user-data (should be valid YAML)
-		// TODO: Add more comprehensive validation using cloud-init libraries
-		if !strings.HasPrefix(string(content), "#cloud-config") {
-			err := fmt.Errorf("user-data must start with #cloud-config")
-			span.RecordError(err)
-			return err
-		}
-		return nil
+	// Basic validation - check if content is empty
+	if len(content) == 0 {
+		return nil, fmt.Errorf("cloud-init content cannot be empty")
+	}
 
-	case "network-config":
-		// Network config validation
-		return nil
+	var diagnostics []Diagnostic
+	switch fileType = strings.TrimSuffix(fileType, "_install"); fileType {
+	case "meta-data", "user-data", "network-config":
+		diagnostics = LintCloudInitFile(fileType, content)
 
 	case "variables.sh":
 		// Shell script validation
 		if !strings.HasPrefix(string(content), "#!/bin/bash") &&
 			!strings.HasPrefix(string(content), "#!/bin/sh") {
-			err := fmt.Errorf("shell scripts must start with a valid shebang (#!/bin/bash or #!/bin/sh)")
-			span.RecordError(err)
-			return err
+			diagnostics = append(diagnostics, Diagnostic{
+				Line:     1,
+				Severity: SeverityError,
+				Message:  "shell scripts must start with a valid shebang (#!/bin/bash or #!/bin/sh)",
+			})
 		}
-		return nil
 
 	default:
 		// Unknown file type
-		err := fmt.Errorf("unknown cloud-init file type: %s", fileType)
-		span.RecordError(err)
-		return err
+		return nil, fmt.Errorf("unknown cloud-init file type: %s", fileType)
+	}
+
+	for _, d := range diagnostics {
+		if d.Severity == SeverityWarning {
+			span.AddEvent(d.String())
+		}
 	}
+	span.SetAttributes(attribute.Int("diagnostic_count", len(diagnostics)))
+	return diagnostics, nil
 }
 
 // ListFiles lists all files of a specific type in the given directory
@@ -500,7 +770,7 @@ func (s *Service) DeleteFile(ctx context.Context, fileType string, filename stri
 		attribute.String("filename", filename),
 	)
 
-	if !s.isLeader {
+	if !s.hasLeadership() {
 		acquired, err := s.AcquireLeadership(ctx)
 		if err != nil {
 			span.RecordError(err)
@@ -531,6 +801,11 @@ func (s *Service) DeleteFile(ctx context.Context, fileType string, filename stri
 		return err
 	}
 
+	if err := s.checkFencing(ctx); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
 	err := s.fs.Remove(filePath)
 	if err != nil {
 		span.RecordError(err)
@@ -550,7 +825,7 @@ func (s *Service) DeleteCloudInitDir(ctx context.Context, macOrHostname string)
 	span.SetAttributes(attribute.String("mac_or_hostname", macOrHostname))
 
 	// Leadership check
-	if !s.isLeader {
+	if !s.hasLeadership() {
 		acquired, err := s.AcquireLeadership(ctx)
 		if err != nil {
 			span.RecordError(err)
@@ -561,6 +836,12 @@ func (s *Service) DeleteCloudInitDir(ctx context.Context, macOrHostname string)
 		}
 	}
 
+	// Confirm the lease is still current before changing anything on disk
+	if err := s.checkFencing(ctx); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
 	// Ensure recycle bin directory exists
 	recycleBinPath := filepath.Join(s.cloudInitDir, "recycle_bin")
 	fmt.Printf("DEBUG: Recycle bin path: %s\n", recycleBinPath)
@@ -695,8 +976,21 @@ func (s *Service) DeleteCloudInitDir(ctx context.Context, macOrHostname string)
 		fmt.Printf("DEBUG: No other symlinks found, will delete MAC directories\n")
 	}
 
+	// Remember the hostnames linked to the directories so that they can be
+	// restored with them
+	hostnames := []string{macOrHostname}
+	if !isSymlink {
+		linked, err := s.hostnamesLinkedTo(targetMacName)
+		if err != nil {
+			span.RecordError(err)
+			return err
+		}
+		hostnames = linked
+	}
+
 	// Move MAC directories to recycle bin with timestamp
-	timestamp := time.Now().Format("20060102_150405")
+	timestamp := time.Now().Format(recycleTimeFormat)
+	var recycledDir string
 	fmt.Printf("DEBUG: Moving directories to recycle bin with timestamp %s\n", timestamp)
 
 	// Move the main directory
@@ -713,6 +1007,7 @@ func (s *Service) DeleteCloudInitDir(ctx context.Context, macOrHostname string)
 			span.RecordError(err)
 			return fmt.Errorf("failed to move directory to recycle bin: %w", err)
 		}
+		recycledDir = recycleDestPath
 		fmt.Printf("DEBUG: Successfully moved directory to recycle bin\n")
 	}
 
@@ -730,9 +1025,19 @@ func (s *Service) DeleteCloudInitDir(ctx context.Context, macOrHostname string)
 			span.RecordError(err)
 			return fmt.Errorf("failed to move install directory to recycle bin: %w", err)
 		}
+		if recycledDir == "" {
+			recycledDir = recycleDestPath
+		}
 		fmt.Printf("DEBUG: Successfully moved install directory to recycle bin\n")
 	}
 
+	if recycledDir != "" && len(hostnames) > 0 {
+		if err := s.writeRecycleManifest(recycledDir, hostnames); err != nil {
+			span.RecordError(err)
+			return fmt.Errorf("failed to record hostnames in recycle bin: %w", err)
+		}
+	}
+
 	fmt.Printf("===== DELETE CLOUD-INIT DIR COMPLETE =====\n\n")
 	span.AddEvent("Directories moved to recycle bin")
 	return nil
@@ -745,49 +1050,6 @@ func isMacAddress(s string) bool {
 	return matched
 }
 
-// CleanupRecycleBin removes all files from the recycle bin directory
-func (s *Service) CleanupRecycleBin(ctx context.Context) error {
-	ctx, span := s.tracer.Start(ctx, "CleanupRecycleBin")
-	defer span.End()
-
-	recycleBinPath := filepath.Join(s.cloudInitDir, "recycle_bin")
-
-	// Check if recycle bin exists
-	exists, err := afero.Exists(s.fs, recycleBinPath)
-	if err != nil {
-		span.RecordError(err)
-		return fmt.Errorf("failed to check if recycle bin exists: %w", err)
-	}
-
-	// If it doesn't exist, create it
-	if !exists {
-		if err := s.fs.MkdirAll(recycleBinPath, 0755); err != nil {
-			span.RecordError(err)
-			return fmt.Errorf("failed to create recycle bin: %w", err)
-		}
-		return nil
-	}
-
-	// Get all entries in the recycle bin
-	entries, err := afero.ReadDir(s.fs, recycleBinPath)
-	if err != nil {
-		span.RecordError(err)
-		return fmt.Errorf("failed to read recycle bin: %w", err)
-	}
-
-	// Delete each entry
-	for _, entry := range entries {
-		entryPath := filepath.Join(recycleBinPath, entry.Name())
-		if err := s.fs.RemoveAll(entryPath); err != nil {
-			span.RecordError(err)
-			return fmt.Errorf("failed to remove %s from recycle bin: %w", entry.Name(), err)
-		}
-	}
-
-	span.AddEvent("Recycle bin cleaned up")
-	return nil
-}
-
 // Add this method to the Service struct in service.go
 func (s *Service) Start(ctx context.Context) error {
 	ctx, span := s.tracer.Start(ctx, "Start")
@@ -795,6 +1057,11 @@ func (s *Service) Start(ctx context.Context) error {
 
 	span.AddEvent("Starting file editor service")
 
+	if err := s.checkHistoryDir(); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
 	// Ensure the recycle bin exists
 	recycleBinPath := filepath.Join(s.cloudInitDir, "recycle_bin")
 	if err := s.ensureDirectory(ctx, recycleBinPath); err != nil {
@@ -802,15 +1069,24 @@ func (s *Service) Start(ctx context.Context) error {
 		return fmt.Errorf("failed to create recycle bin directory: %w", err)
 	}
 
+	// Take part in leader election and keep the lease renewed
+	if s.leases != nil {
+		if _, err := s.AcquireLeadership(ctx); err != nil {
+			span.RecordError(err)
+			fmt.Printf("Failed to acquire leadership: %v\n", err)
+		}
+		go s.runLeaderElection(ctx)
+	}
+
 	// Start a goroutine for periodic cleanup
 	go func() {
-		ticker := time.NewTicker(24 * time.Hour) // Configure cleanup period as needed
+		ticker := time.NewTicker(s.cleanupInterval)
 		defer ticker.Stop()
 
 		for {
 			select {
 			case <-ticker.C:
-				if s.isLeader {
+				if s.hasLeadership() {
 					cleanCtx := context.Background()
 					if err := s.CleanupRecycleBin(cleanCtx); err != nil {
 						// Log error but continue
@@ -8,7 +8,10 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/configuration"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/database"
 	"github.com/spf13/afero"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
@@ -372,6 +375,17 @@ func TestWriteCloudInitFile(t *testing.T) {
 			assert.Equal(t, tc.content, fileContent)
 		})
 	}
+
+	// File types naming other paths are refused
+	for _, fileType := range []string{"../../escaped/meta-data", "../meta-data", "meta-data/x", "ipxe"} {
+		err := service.WriteCloudInitFile(ctx, macAddress, fileType, []byte("instance-id: test-instance"))
+		assert.Error(t, err, fileType)
+		err = service.WriteCloudInitFiles(ctx, macAddress, map[string][]byte{fileType: []byte("instance-id: test-instance")})
+		assert.Error(t, err, fileType)
+	}
+	exists, err := afero.DirExists(fs, "/var/lib/escaped")
+	require.NoError(t, err)
+	assert.False(t, exists)
 }
 
 func TestListFiles(t *testing.T) {
@@ -785,9 +799,13 @@ func TestDeleteCloudInitDir(t *testing.T) {
 
 	// Test CleanupRecycleBin function
 	t.Run("CleanupRecycleBin", func(t *testing.T) {
+		service.recycleMaxAge = time.Hour
+		t.Cleanup(func() { service.recycleMaxAge = 0 })
+
 		// First put some test files in the recycle bin
 		testFile1 := filepath.Join(recycleBinPath, "test-mac_delete_me_20250313")
 		testFile2 := filepath.Join(recycleBinPath, "test-mac-install_delete_me_20250313")
+		recentFile := filepath.Join(recycleBinPath, "recent-mac_delete_me_"+time.Now().Format(recycleTimeFormat))
 
 		err := afero.WriteFile(fs, testFile1, []byte("test content"), 0644)
 		require.NoError(t, err)
@@ -795,26 +813,26 @@ func TestDeleteCloudInitDir(t *testing.T) {
 		err = afero.WriteFile(fs, testFile2, []byte("test content"), 0644)
 		require.NoError(t, err)
 
-		// Verify files exist
-		exists, err := afero.Exists(fs, testFile1)
+		err = afero.WriteFile(fs, recentFile, []byte("test content"), 0644)
 		require.NoError(t, err)
-		assert.True(t, exists)
 
-		exists, err = afero.Exists(fs, testFile2)
-		require.NoError(t, err)
-		assert.True(t, exists)
+		// Entries without a deletion time in their name age by modification time
+		old := time.Now().Add(-2 * time.Hour)
+		require.NoError(t, fs.Chtimes(testFile1, old, old))
+		require.NoError(t, fs.Chtimes(testFile2, old, old))
 
 		// Call CleanupRecycleBin
 		err = service.CleanupRecycleBin(ctx)
 		require.NoError(t, err)
 
-		// Verify recycle bin is empty
+		// Verify only the recent entry is left
 		entries, err := afero.ReadDir(fs, recycleBinPath)
 		require.NoError(t, err)
-		assert.Equal(t, 0, len(entries), "Recycle bin should be empty after cleanup")
+		require.Equal(t, 1, len(entries), "Expired entries should be removed by cleanup")
+		assert.Equal(t, filepath.Base(recentFile), entries[0].Name())
 
 		// Verify recycle bin directory still exists
-		exists, err = afero.DirExists(fs, recycleBinPath)
+		exists, err := afero.DirExists(fs, recycleBinPath)
 		require.NoError(t, err)
 		assert.True(t, exists, "Recycle bin directory should still exist after cleanup")
 	})
@@ -839,6 +857,78 @@ func TestLeadership(t *testing.T) {
 	assert.False(t, service.isLeader)
 }
 
+func TestDistributedLeadership(t *testing.T) {
+	ctx := context.Background()
+
+	db := database.NewServiceWithConfig(configuration.DatabaseConfig{
+		Type:   "sqlite",
+		SQLite: configuration.SQLiteConfig{Path: filepath.Join(t.TempDir(), "leases.sqlite")},
+	})
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, db.Connect(ctx))
+	require.NoError(t, db.MigrateSchema(ctx))
+
+	replicaA, fsA, _ := setupTestService(t)
+	replicaB, _, _ := setupTestService(t)
+	for i, replica := range []*Service{replicaA, replicaB} {
+		replica.isLeader = false
+		replica.leases = db
+		replica.leaseTTL = time.Minute
+		replica.holderID = fmt.Sprintf("replica-%d", i)
+		replica.leaderLockKey = "fileeditor/leader"
+	}
+
+	// Only one replica can lead at a time
+	acquired, err := replicaA.AcquireLeadership(ctx)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+	assert.Equal(t, int64(1), replicaA.FencingToken())
+
+	acquired, err = replicaB.AcquireLeadership(ctx)
+	require.NoError(t, err)
+	assert.False(t, acquired)
+	assert.Zero(t, replicaB.FencingToken())
+
+	// The follower refuses to write
+	err = replicaB.WriteIpxeFile(ctx, "00:11:22:33:44:55", []byte("#!ipxe\necho Hello"))
+	assert.ErrorContains(t, err, "not the leader")
+
+	// Renewal keeps the same fencing token
+	require.NoError(t, replicaA.renewLeadership(ctx))
+	assert.Equal(t, int64(1), replicaA.FencingToken())
+
+	// Handing over advances the token
+	require.NoError(t, replicaA.ReleaseLeadership(ctx))
+	acquired, err = replicaB.AcquireLeadership(ctx)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+	assert.Equal(t, int64(2), replicaB.FencingToken())
+
+	// A stale leader that still trusts its lease is fenced off at commit time
+	require.NoError(t, replicaB.WriteIpxeFile(ctx, "00:11:22:33:44:55", []byte("#!ipxe\necho Hello")))
+	replicaA.isLeader = true
+	replicaA.lease = &database.Lease{Name: "fileeditor/leader", Holder: "replica-0", Token: 1, ExpiresAt: time.Now().Add(time.Minute)}
+	err = replicaA.WriteIpxeFile(ctx, "00:11:22:33:44:55", []byte("#!ipxe\necho Stale"))
+	assert.ErrorIs(t, err, database.ErrLeaseLost)
+	assert.False(t, replicaA.hasLeadership())
+	// The fenced leader touched nothing, not even directories or history
+	for _, path := range []string{replicaA.ipxeDir, replicaA.historyRoot()} {
+		exists, err := afero.Exists(fsA, path)
+		require.NoError(t, err)
+		assert.False(t, exists, path)
+	}
+
+	// A stale leader steps down when renewal fails
+	replicaA.isLeader = true
+	replicaA.lease = &database.Lease{Name: "fileeditor/leader", Holder: "replica-0", Token: 1, ExpiresAt: time.Now().Add(time.Minute)}
+	assert.ErrorIs(t, replicaA.renewLeadership(ctx), database.ErrLeaseLost)
+	assert.False(t, replicaA.hasLeadership())
+
+	// A lease that ran out locally is not trusted for writes
+	replicaB.lease.ExpiresAt = time.Now().Add(-time.Second)
+	assert.False(t, replicaB.hasLeadership())
+}
+
 func TestEnsureDirectory(t *testing.T) {
 	service, fs, _ := setupTestService(t)
 	ctx := context.Background()
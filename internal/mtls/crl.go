@@ -0,0 +1,212 @@
+// internal/mtls/crl.go
+package mtls
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// CRLRefreshInterval is how long a loaded CRL is used before it is
+	// loaded again, even if its nextUpdate is later, so revocations take
+	// effect promptly.
+	CRLRefreshInterval = 5 * time.Minute
+
+	// crlFetchTimeout bounds loading a CRL during a handshake.
+	crlFetchTimeout = 10 * time.Second
+
+	// maxCRLSize bounds the CRL downloaded from a URL.
+	maxCRLSize = 16 << 20
+)
+
+// ErrRevoked is returned for a client certificate on the CRL.
+var ErrRevoked = errors.New("client certificate has been revoked")
+
+// CRLSource returns the current CRL, DER- or PEM-encoded, or a bundle of
+// PEM CRLs from several issuers.
+type CRLSource func(ctx context.Context) ([]byte, error)
+
+// CRLFromFile reads the CRL from path, e.g. the ca.crl the CA keeps in its
+// storage directory.
+func CRLFromFile(path string) CRLSource {
+	return func(ctx context.Context) ([]byte, error) {
+		return os.ReadFile(path)
+	}
+}
+
+// CRLFromURL downloads the CRL from url, e.g. the CA's /crl endpoint.
+func CRLFromURL(url string) CRLSource {
+	client := &http.Client{Timeout: crlFetchTimeout}
+	return func(ctx context.Context) ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status fetching CRL from %s: %s", url, resp.Status)
+		}
+		return io.ReadAll(io.LimitReader(resp.Body, maxCRLSize))
+	}
+}
+
+// revocationChecker checks client certificates against cached CRLs.
+type revocationChecker struct {
+	source CRLSource
+
+	mu       sync.Mutex
+	crls     []*loadedCRL
+	loadedAt time.Time
+}
+
+// loadedCRL is a parsed CRL and the serial numbers it revokes.
+type loadedCRL struct {
+	crl     *x509.RevocationList
+	revoked map[string]bool
+}
+
+func newRevocationChecker(source CRLSource) *revocationChecker {
+	return &revocationChecker{source: source}
+}
+
+// check rejects a verified chain whose leaf is revoked, or whose issuer has
+// no CRL among those loaded: its revocations could not be seen. The CRL must
+// be signed by the leaf's issuer in the verified chain.
+func (c *revocationChecker) check(chain []*x509.Certificate) error {
+	leaf, issuer := chain[0], chain[len(chain)-1]
+	if len(chain) > 1 {
+		issuer = chain[1]
+	}
+	crls, err := c.current()
+	if err != nil {
+		return fmt.Errorf("cannot check revocation of client certificate %s: %w", leaf.SerialNumber, err)
+	}
+
+	found := false
+	for _, crl := range crls {
+		if !covers(crl.crl, leaf) {
+			continue
+		}
+		if err := crl.crl.CheckSignatureFrom(issuer); err != nil {
+			return fmt.Errorf("cannot check revocation of client certificate %s: CRL is not signed by a trusted CA", leaf.SerialNumber)
+		}
+		if crl.revoked[leaf.SerialNumber.String()] {
+			return fmt.Errorf("%w: %s", ErrRevoked, leaf.SerialNumber)
+		}
+		found = true
+	}
+	if !found {
+		return fmt.Errorf("cannot check revocation of client certificate %s: no CRL of its issuer %q", leaf.SerialNumber, issuer.Subject.CommonName)
+	}
+	return nil
+}
+
+// covers reports whether crl was issued by the CA that issued leaf.
+func covers(crl *x509.RevocationList, leaf *x509.Certificate) bool {
+	if len(crl.AuthorityKeyId) > 0 && len(leaf.AuthorityKeyId) > 0 {
+		return bytes.Equal(crl.AuthorityKeyId, leaf.AuthorityKeyId)
+	}
+	return bytes.Equal(crl.RawIssuer, leaf.RawIssuer)
+}
+
+// current returns the CRLs, reloading them when they are older than
+// CRLRefreshInterval or one is past its nextUpdate. CRLs that are still
+// within their validity are kept if reloading fails; without them every
+// client certificate is rejected.
+func (c *revocationChecker) current() ([]*loadedCRL, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if c.crls != nil && now.Sub(c.loadedAt) < CRLRefreshInterval && !anyExpired(c.crls, now) {
+		return c.crls, nil
+	}
+
+	err := c.load()
+	if err == nil {
+		return c.crls, nil
+	}
+	if c.crls == nil || anyExpired(c.crls, now) {
+		return nil, err
+	}
+	fmt.Printf("Warning: failed to reload CRL, using the previous one: %v\n", err)
+	c.loadedAt = now
+	return c.crls, nil
+}
+
+// load fetches and parses the CRLs, a single one or a PEM bundle. Their
+// signatures are checked against the issuer of each client certificate.
+func (c *revocationChecker) load() error {
+	ctx, cancel := context.WithTimeout(context.Background(), crlFetchTimeout)
+	defer cancel()
+
+	data, err := c.source(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load CRL: %w", err)
+	}
+
+	var ders [][]byte
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "X509 CRL" {
+			ders = append(ders, block.Bytes)
+		}
+	}
+	if len(ders) == 0 {
+		ders = [][]byte{data}
+	}
+
+	now := time.Now()
+	crls := make([]*loadedCRL, 0, len(ders))
+	for _, der := range ders {
+		crl, err := x509.ParseRevocationList(der)
+		if err != nil {
+			return fmt.Errorf("failed to parse CRL: %w", err)
+		}
+		if expired(crl, now) {
+			return fmt.Errorf("CRL expired at %s", crl.NextUpdate.Format(time.RFC3339))
+		}
+
+		revoked := make(map[string]bool, len(crl.RevokedCertificateEntries))
+		for _, entry := range crl.RevokedCertificateEntries {
+			revoked[entry.SerialNumber.String()] = true
+		}
+		crls = append(crls, &loadedCRL{crl: crl, revoked: revoked})
+	}
+	c.crls = crls
+	c.loadedAt = now
+	return nil
+}
+
+// anyExpired reports whether one of crls is past its nextUpdate.
+func anyExpired(crls []*loadedCRL, now time.Time) bool {
+	for _, crl := range crls {
+		if expired(crl.crl, now) {
+			return true
+		}
+	}
+	return false
+}
+
+// expired reports whether crl is past its nextUpdate.
+func expired(crl *x509.RevocationList, now time.Time) bool {
+	return !crl.NextUpdate.IsZero() && now.After(crl.NextUpdate)
+}
@@ -0,0 +1,254 @@
+// internal/mtls/mtls.go
+// Package mtls builds the TLS configuration of the gRPC listeners and their
+// clients. Client certificates are verified against our own CA and checked
+// against its revocation list.
+package mtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/configuration"
+	"github.com/spf13/viper"
+)
+
+// ConfigFromViper reads the gRPC TLS settings from the auth.grpc section of
+// the loaded configuration.
+func ConfigFromViper() configuration.GRPCAuthConfig {
+	var cfg configuration.GRPCAuthConfig
+	if err := viper.UnmarshalKey("auth.grpc", &cfg); err != nil {
+		fmt.Printf("Warning: failed to read gRPC TLS configuration: %v\n", err)
+	}
+	return cfg
+}
+
+// Option customizes ServerTLSConfig.
+type Option func(*serverOptions)
+
+type serverOptions struct {
+	clientCAs []byte
+	crl       CRLSource
+}
+
+// WithClientCAs verifies client certificates against the PEM bundle caPEM
+// instead of the configured client_ca_file.
+func WithClientCAs(caPEM []byte) Option {
+	return func(o *serverOptions) {
+		o.clientCAs = caPEM
+	}
+}
+
+// WithCRLSource checks client certificates against source instead of the
+// configured crl_file or crl_url.
+func WithCRLSource(source CRLSource) Option {
+	return func(o *serverOptions) {
+		o.crl = source
+	}
+}
+
+// ServerTLSConfig returns the TLS configuration of a gRPC listener, or nil
+// if cfg has no server certificate and the listener should stay plaintext.
+// Client certificates are required with MutualTLS and otherwise verified
+// when offered. A verified client certificate is rejected if it is on its
+// issuer's CRL, or if no current CRL of its issuer can be loaded.
+func ServerTLSConfig(cfg configuration.GRPCAuthConfig, opts ...Option) (*tls.Config, error) {
+	if cfg.CertFile == "" && cfg.KeyFile == "" {
+		if cfg.MutualTLS {
+			return nil, fmt.Errorf("mutual TLS requires a server certificate (cert_file and key_file)")
+		}
+		return nil, nil
+	}
+
+	var o serverOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	keyPair, err := newKeyPairReloader(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return keyPair.certificate()
+		},
+	}
+
+	caPEM := o.clientCAs
+	if caPEM == nil && cfg.ClientCAFile != "" {
+		caPEM, err = os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %w", err)
+		}
+	}
+	if caPEM == nil {
+		if cfg.MutualTLS {
+			return nil, fmt.Errorf("mutual TLS requires a client CA (client_ca_file)")
+		}
+		return tlsConfig, nil
+	}
+
+	cas, err := parseCertificates(caPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client CA bundle: %w", err)
+	}
+	tlsConfig.ClientCAs = x509.NewCertPool()
+	for _, ca := range cas {
+		tlsConfig.ClientCAs.AddCert(ca)
+	}
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	if cfg.MutualTLS {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	source := o.crl
+	switch {
+	case source != nil:
+	case cfg.CRLFile != "":
+		source = CRLFromFile(cfg.CRLFile)
+	case cfg.CRLURL != "":
+		source = CRLFromURL(cfg.CRLURL)
+	default:
+		fmt.Println("Warning: client certificates are not checked for revocation; set crl_file or crl_url")
+		return tlsConfig, nil
+	}
+
+	checker := newRevocationChecker(source)
+	tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.VerifiedChains) == 0 {
+			return nil
+		}
+		return checker.check(cs.VerifiedChains[0])
+	}
+	return tlsConfig, nil
+}
+
+// ClientTLSConfig returns the TLS configuration for connecting to a gRPC
+// listener configured by cfg, such as the HTTP gateway dialing its own
+// server. The server is verified against client_ca_file, or the system
+// roots if it is empty, and the certificate in cert_file is presented as
+// the client certificate.
+func ClientTLSConfig(cfg configuration.GRPCAuthConfig, serverName string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: serverName}
+
+	if cfg.ClientCAFile != "" {
+		caPEM, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		cas, err := parseCertificates(caPEM)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CA bundle: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		for _, ca := range cas {
+			tlsConfig.RootCAs.AddCert(ca)
+		}
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		keyPair, err := newKeyPairReloader(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return keyPair.certificate()
+		}
+	}
+	return tlsConfig, nil
+}
+
+// parseCertificates parses every certificate in a PEM bundle.
+func parseCertificates(bundle []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, bundle = pem.Decode(bundle)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found")
+	}
+	return certs, nil
+}
+
+// keyPairReloader serves a certificate from files and reloads it when they
+// change, so a certificate renewed by cert-agent is used without a restart.
+type keyPairReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newKeyPairReloader(certFile, keyFile string) (*keyPairReloader, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("both cert_file and key_file must be set")
+	}
+	r := &keyPairReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.certificate(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// certificate returns the current key pair, reloading it if either file
+// was modified. A key pair that fails to load, e.g. halfway through a
+// renewal, is ignored in favour of the previous one.
+func (r *keyPairReloader) certificate() (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	modTime, err := latestModTime(r.certFile, r.keyFile)
+	if err == nil && r.cert != nil && modTime.Equal(r.modTime) {
+		return r.cert, nil
+	}
+	if err == nil {
+		var cert tls.Certificate
+		cert, err = tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err == nil {
+			r.cert = &cert
+			r.modTime = modTime
+			return r.cert, nil
+		}
+	}
+
+	if r.cert == nil {
+		return nil, fmt.Errorf("failed to load TLS key pair: %w", err)
+	}
+	fmt.Printf("Warning: failed to reload TLS key pair %s: %v\n", r.certFile, err)
+	r.modTime = modTime
+	return r.cert, nil
+}
+
+// latestModTime returns the most recent modification time of the files.
+func latestModTime(paths ...string) (time.Time, error) {
+	var latest time.Time
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,338 @@
+// internal/mtls/mtls_test.go
+package mtls_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certissuer"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/certissuer/certissuertest"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/configuration"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/mtls"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// testPKI is a CA with a server certificate, written to a directory the
+// way a deployment would lay it out.
+type testPKI struct {
+	ca     *certissuer.CertificateAuthority
+	dir    string
+	caFile string
+	server configuration.GRPCAuthConfig
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	dir := t.TempDir()
+	ca, err := certissuer.NewCertificateAuthority(filepath.Join(dir, "ca"), certissuertest.OfflineRootKey(t))
+	require.NoError(t, err)
+
+	pki := &testPKI{ca: ca, dir: dir, caFile: filepath.Join(dir, "ca-bundle.crt")}
+	require.NoError(t, os.WriteFile(pki.caFile, ca.GetCACertificate(), 0644))
+
+	certFile, keyFile, _ := pki.issue(t, "localhost")
+	pki.server = configuration.GRPCAuthConfig{
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		ClientCAFile: pki.caFile,
+	}
+	return pki
+}
+
+// issue creates a certificate for name and returns its files and serial.
+func (p *testPKI) issue(t *testing.T, name string) (certFile, keyFile, serial string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: name},
+		DNSNames: []string{name},
+	}, key)
+	require.NoError(t, err)
+
+	certPEM, err := p.ca.IssueCertificateFromCSR(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER}), map[string]string{})
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	certFile = filepath.Join(p.dir, name+".crt")
+	keyFile = filepath.Join(p.dir, name+".key")
+	require.NoError(t, os.WriteFile(certFile, certPEM, 0644))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	return certFile, keyFile, cert.SerialNumber.String()
+}
+
+// clientConfig returns the client settings for the certificate of name,
+// or for no client certificate if name is empty.
+func (p *testPKI) clientConfig(t *testing.T, name string) configuration.GRPCAuthConfig {
+	cfg := configuration.GRPCAuthConfig{ClientCAFile: p.caFile}
+	if name != "" {
+		cfg.CertFile, cfg.KeyFile, _ = p.issue(t, name)
+	}
+	return cfg
+}
+
+// crl returns the CA's own revocation list as a CRL source.
+func (p *testPKI) crl() mtls.CRLSource {
+	return func(ctx context.Context) ([]byte, error) {
+		return p.ca.CRL()
+	}
+}
+
+// serve starts a gRPC health server with tlsConfig and returns its address
+// and a channel receiving the principal of every request.
+func serve(t *testing.T, tlsConfig *tls.Config) (string, <-chan *mtls.Principal) {
+	t.Helper()
+	principals := make(chan *mtls.Principal, 10)
+	server := grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(tlsConfig)),
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if principal, ok := mtls.PrincipalFromContext(ctx); ok {
+				principals <- &principal
+			} else {
+				principals <- nil
+			}
+			return handler(ctx, req)
+		}),
+	)
+	healthpb.RegisterHealthServer(server, health.NewServer())
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+	return lis.Addr().String(), principals
+}
+
+// call makes a health check to addr with the client settings in cfg.
+func call(t *testing.T, addr string, cfg configuration.GRPCAuthConfig) error {
+	t.Helper()
+	tlsConfig, err := mtls.ClientTLSConfig(cfg, "localhost")
+	require.NoError(t, err)
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	return err
+}
+
+func TestMutualTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	revokedCert, revokedKey, revokedSerial := pki.issue(t, "revoked.example.com")
+	require.NoError(t, pki.ca.RevokeCertificate(revokedSerial, certissuer.ReasonKeyCompromise))
+	revokedClient := configuration.GRPCAuthConfig{ClientCAFile: pki.caFile, CertFile: revokedCert, KeyFile: revokedKey}
+
+	cfg := pki.server
+	cfg.MutualTLS = true
+	tlsConfig, err := mtls.ServerTLSConfig(cfg, mtls.WithCRLSource(pki.crl()))
+	require.NoError(t, err)
+	addr, principals := serve(t, tlsConfig)
+
+	require.NoError(t, call(t, addr, pki.clientConfig(t, "agent.example.com")))
+	principal := <-principals
+	require.NotNil(t, principal)
+	assert.Equal(t, "agent.example.com", principal.Name)
+	assert.NotEmpty(t, principal.SerialNumber)
+
+	assert.Error(t, call(t, addr, pki.clientConfig(t, "")), "A client certificate is required")
+	assert.Error(t, call(t, addr, revokedClient), "A revoked certificate is rejected")
+
+	// Certificates from another CA are not trusted
+	other := newTestPKI(t)
+	assert.Error(t, call(t, addr, configuration.GRPCAuthConfig{
+		ClientCAFile: pki.caFile,
+		CertFile:     other.server.CertFile,
+		KeyFile:      other.server.KeyFile,
+	}))
+}
+
+func TestOptionalClientCertificates(t *testing.T) {
+	pki := newTestPKI(t)
+	rootKey, err := pki.ca.RootKey()
+	require.NoError(t, err)
+	require.NoError(t, pki.ca.RotateIntermediate(rootKey))
+
+	// The CRL is now signed by an intermediate the bundle does not hold,
+	// so it is verified through the client's chain
+	tlsConfig, err := mtls.ServerTLSConfig(pki.server, mtls.WithCRLSource(pki.crl()))
+	require.NoError(t, err)
+	addr, principals := serve(t, tlsConfig)
+
+	require.NoError(t, call(t, addr, pki.clientConfig(t, "")))
+	assert.Nil(t, <-principals, "A client without a certificate has no principal")
+
+	require.NoError(t, call(t, addr, pki.clientConfig(t, "rotated.example.com")))
+	principal := <-principals
+	require.NotNil(t, principal)
+	assert.Equal(t, "rotated.example.com", principal.Name)
+}
+
+func TestRevocationFailsClosed(t *testing.T) {
+	pki := newTestPKI(t)
+	client := pki.clientConfig(t, "agent.example.com")
+
+	unavailable := func(ctx context.Context) ([]byte, error) {
+		return nil, errors.New("CRL unavailable")
+	}
+	tlsConfig, err := mtls.ServerTLSConfig(pki.server, mtls.WithCRLSource(unavailable))
+	require.NoError(t, err)
+	addr, _ := serve(t, tlsConfig)
+	assert.Error(t, call(t, addr, client))
+
+	// A CRL signed by another CA is not trusted
+	other := newTestPKI(t)
+	tlsConfig, err = mtls.ServerTLSConfig(pki.server, mtls.WithCRLSource(other.crl()))
+	require.NoError(t, err)
+	addr, _ = serve(t, tlsConfig)
+	assert.Error(t, call(t, addr, client))
+
+	// An expired CRL is not trusted
+	tlsConfig, err = mtls.ServerTLSConfig(pki.server, mtls.WithCRLSource(expiredCRL(t, pki)))
+	require.NoError(t, err)
+	addr, _ = serve(t, tlsConfig)
+	assert.Error(t, call(t, addr, client))
+}
+
+func TestCRLFromFile(t *testing.T) {
+	pki := newTestPKI(t)
+	client := pki.clientConfig(t, "agent.example.com")
+	_, err := pki.ca.CRL()
+	require.NoError(t, err)
+
+	cfg := pki.server
+	cfg.CRLFile = filepath.Join(pki.dir, "ca", "ca.crl")
+	tlsConfig, err := mtls.ServerTLSConfig(cfg)
+	require.NoError(t, err)
+	addr, _ := serve(t, tlsConfig)
+	assert.NoError(t, call(t, addr, client))
+}
+
+func TestCRLBundleAfterRotation(t *testing.T) {
+	pki := newTestPKI(t)
+	before := pki.clientConfig(t, "before.example.com")
+	revokedCert, revokedKey, revokedSerial := pki.issue(t, "revoked.example.com")
+	revoked := configuration.GRPCAuthConfig{ClientCAFile: pki.caFile, CertFile: revokedCert, KeyFile: revokedKey}
+
+	rootKey, err := pki.ca.RootKey()
+	require.NoError(t, err)
+	require.NoError(t, pki.ca.RotateIntermediate(rootKey))
+	after := pki.clientConfig(t, "after.example.com")
+
+	// Certificates of the replaced intermediate are checked against its
+	// own CRL from the bundle the CA writes
+	require.NoError(t, pki.ca.RevokeCertificate(revokedSerial, certissuer.ReasonKeyCompromise))
+	_, err = pki.ca.CRLs()
+	require.NoError(t, err)
+
+	cfg := pki.server
+	cfg.CRLFile = filepath.Join(pki.dir, "ca", "crls.pem")
+	tlsConfig, err := mtls.ServerTLSConfig(cfg)
+	require.NoError(t, err)
+	addr, _ := serve(t, tlsConfig)
+	assert.NoError(t, call(t, addr, before))
+	assert.NoError(t, call(t, addr, after))
+	assert.Error(t, call(t, addr, revoked), "A revoked certificate of the replaced intermediate is rejected")
+
+	// The current intermediate's CRL alone cannot vouch for them
+	tlsConfig, err = mtls.ServerTLSConfig(pki.server, mtls.WithCRLSource(pki.crl()))
+	require.NoError(t, err)
+	addr, _ = serve(t, tlsConfig)
+	assert.NoError(t, call(t, addr, after))
+	assert.Error(t, call(t, addr, before))
+}
+
+func TestServerTLSConfigValidation(t *testing.T) {
+	tlsConfig, err := mtls.ServerTLSConfig(configuration.GRPCAuthConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, tlsConfig, "No server certificate means plaintext")
+
+	_, err = mtls.ServerTLSConfig(configuration.GRPCAuthConfig{MutualTLS: true})
+	assert.Error(t, err)
+
+	pki := newTestPKI(t)
+	cfg := pki.server
+	cfg.ClientCAFile = ""
+	cfg.MutualTLS = true
+	_, err = mtls.ServerTLSConfig(cfg)
+	assert.Error(t, err, "Mutual TLS needs a client CA")
+
+	cfg.KeyFile = filepath.Join(pki.dir, "missing.key")
+	cfg.MutualTLS = false
+	_, err = mtls.ServerTLSConfig(cfg)
+	assert.Error(t, err)
+}
+
+func TestPrincipal(t *testing.T) {
+	principal := mtls.PrincipalFromCertificate(&x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		DNSNames:     []string{"host1.example.com"},
+		URIs:         []*url.URL{mtls.PrincipalURI("Host1")},
+	})
+	assert.Equal(t, "host1.example.com", principal.Name)
+	assert.Equal(t, "Host1", principal.ID)
+	assert.Equal(t, "42", principal.SerialNumber)
+
+	user, ok := principal.User(map[string]string{"host1": "agent"})
+	assert.True(t, ok)
+	assert.Equal(t, "agent", user)
+
+	_, ok = principal.User(map[string]string{"host2": "agent"})
+	assert.False(t, ok)
+
+	// Names the requester chose do not make a principal
+	unbound := mtls.PrincipalFromCertificate(&x509.Certificate{
+		SerialNumber: big.NewInt(43),
+		Subject:      pkix.Name{CommonName: "host1"},
+		DNSNames:     []string{"host1"},
+	})
+	assert.Equal(t, "host1", unbound.Name)
+	assert.Empty(t, unbound.ID)
+	_, ok = unbound.User(map[string]string{"host1": "agent"})
+	assert.False(t, ok)
+}
+
+// expiredCRL returns a CRL source whose CRL, signed by the CA's root, is
+// past its nextUpdate.
+func expiredCRL(t *testing.T, pki *testPKI) mtls.CRLSource {
+	key, err := pki.ca.RootKey()
+	require.NoError(t, err)
+	block, _ := pem.Decode(pki.ca.RootCertificate())
+	root, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+
+	crlDER, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-48 * time.Hour),
+		NextUpdate: time.Now().Add(-24 * time.Hour),
+	}, root, key)
+	require.NoError(t, err)
+	return func(ctx context.Context) ([]byte, error) {
+		return crlDER, nil
+	}
+}
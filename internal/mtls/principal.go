@@ -0,0 +1,105 @@
+// internal/mtls/principal.go
+package mtls
+
+import (
+	"context"
+	"crypto/x509"
+	"net/url"
+	"strings"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// PrincipalURIPrefix starts the URI SAN that binds a client certificate to
+// a configured principal. The CA only adds it for administrators and
+// refuses it in certificate requests, so unlike the subject or DNS names a
+// requester controls, it cannot be claimed by whoever can get a
+// certificate issued.
+const PrincipalURIPrefix = "spiffe://autoinstall-webhook/principal/"
+
+// PrincipalURI returns the URI SAN binding a certificate to principal name.
+func PrincipalURI(name string) *url.URL {
+	u, _ := url.Parse(PrincipalURIPrefix + url.PathEscape(name))
+	return u
+}
+
+// IsPrincipalURI reports whether u is in the namespace of PrincipalURI.
+func IsPrincipalURI(u *url.URL) bool {
+	return strings.HasPrefix(strings.ToLower(u.String()), PrincipalURIPrefix)
+}
+
+// Principal identifies the client behind a verified certificate.
+type Principal struct {
+	// Name is the subject common name, or the first DNS or URI name of a
+	// certificate without one. It is for display only.
+	Name string
+	// ID is the principal name from the certificate's PrincipalURI, or
+	// empty for a certificate that is not bound to a principal.
+	ID string
+	// SerialNumber is the certificate serial number in decimal, as used by
+	// the certificate admin API.
+	SerialNumber string
+	// Certificate is the verified client certificate.
+	Certificate *x509.Certificate
+}
+
+// PrincipalFromCertificate returns the principal a client certificate
+// identifies.
+func PrincipalFromCertificate(cert *x509.Certificate) Principal {
+	name := cert.Subject.CommonName
+	switch {
+	case name != "":
+	case len(cert.DNSNames) > 0:
+		name = cert.DNSNames[0]
+	case len(cert.URIs) > 0:
+		name = cert.URIs[0].String()
+	}
+
+	var id string
+	for _, u := range cert.URIs {
+		if IsPrincipalURI(u) {
+			if unescaped, err := url.PathUnescape(u.String()[len(PrincipalURIPrefix):]); err == nil {
+				id = unescaped
+			}
+			break
+		}
+	}
+	return Principal{
+		Name:         name,
+		ID:           id,
+		SerialNumber: cert.SerialNumber.String(),
+		Certificate:  cert,
+	}
+}
+
+// PrincipalFromContext returns the principal of a gRPC request's verified
+// client certificate. It reports false for plaintext connections and for
+// clients that presented no certificate.
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return Principal{}, false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return Principal{}, false
+	}
+	principal := PrincipalFromCertificate(info.State.VerifiedChains[0][0])
+	return principal, principal.Name != "" || principal.ID != ""
+}
+
+// User returns the user principals maps the principal's ID to. Only the
+// issuer-set ID counts, never the names a requester chose. IDs are compared
+// case-insensitively because configuration keys are lowercased.
+func (p Principal) User(principals map[string]string) (string, bool) {
+	if p.ID == "" {
+		return "", false
+	}
+	for name, user := range principals {
+		if strings.EqualFold(name, p.ID) {
+			return user, true
+		}
+	}
+	return "", false
+}
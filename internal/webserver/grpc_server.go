@@ -3,11 +3,14 @@ package webserver
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
 
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/auth"
 	pb "github.com/jdfalk/ubuntu-autoinstall-webhook/pkg/proto"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 // grpcServer implements the InstallServiceServer interface.
@@ -17,17 +20,27 @@ type grpcServer struct {
 
 // ReportStatus implements the ReportStatus RPC.
 func (s *grpcServer) ReportStatus(ctx context.Context, req *pb.StatusRequest) (*pb.StatusResponse, error) {
-	fmt.Printf("Received status update from %s: %d%% - %s\n", req.Hostname, req.Progress, req.Message)
+	if id, ok := auth.IdentityFromContext(ctx); ok {
+		fmt.Printf("Received status update from %s (%s by %s): %d%% - %s\n", req.Hostname, id.User, id.Method, req.Progress, req.Message)
+	} else {
+		fmt.Printf("Received status update from %s: %d%% - %s\n", req.Hostname, req.Progress, req.Message)
+	}
 	return &pb.StatusResponse{Acknowledged: true}, nil
 }
 
-// StartGRPCServer starts a gRPC server on the provided address.
-func StartGRPCServer(address string) error {
+// StartGRPCServer starts a gRPC server on the provided address. Callers
+// are authenticated and authorized by interceptor. It serves TLS if
+// tlsConfig is not nil; see mtls.ServerTLSConfig.
+func StartGRPCServer(address string, interceptor *auth.Interceptor, tlsConfig *tls.Config) error {
 	lis, err := net.Listen("tcp", address)
 	if err != nil {
 		return fmt.Errorf("failed to listen on %s: %w", address, err)
 	}
-	server := grpc.NewServer()
+	opts := interceptor.ServerOptions()
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	server := grpc.NewServer(opts...)
 	pb.RegisterInstallServiceServer(server, &grpcServer{})
 	fmt.Printf("gRPC server is listening on %s\n", address)
 	return server.Serve(lis)
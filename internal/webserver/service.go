@@ -3,13 +3,19 @@ package webserver
 
 import (
 	"context"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/auth"
+	"github.com/jdfalk/ubuntu-autoinstall-webhook/internal/mtls"
 	pb "github.com/jdfalk/ubuntu-autoinstall-webhook/pkg/proto"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
@@ -32,9 +38,28 @@ func NewService() Service {
 // Start starts the gRPC server and HTTP gateway.
 func (s *service) Start() error {
 	grpcAddress := ":50051"
+
+	// TLS, client certificate and permission settings from auth.grpc
+	grpcConfig := mtls.ConfigFromViper()
+	for user, scopes := range grpcConfig.Scopes {
+		if err := auth.ValidateScopes(scopes); err != nil {
+			return fmt.Errorf("invalid permissions for %s: %w", user, err)
+		}
+	}
+	serverTLS, err := mtls.ServerTLSConfig(grpcConfig)
+	if err != nil {
+		return fmt.Errorf("failed to configure gRPC TLS: %w", err)
+	}
+	// The webserver has no key store of its own; it accepts the API keys
+	// from the configuration
+	interceptor, err := auth.NewInterceptor(grpcConfig, auth.WithKeyStore(auth.StaticKeys(grpcConfig.APIKeys, grpcConfig.Scopes)))
+	if err != nil {
+		return fmt.Errorf("failed to configure gRPC authentication: %w", err)
+	}
+
 	// Start the gRPC server in a separate goroutine.
 	go func() {
-		if err := StartGRPCServer(grpcAddress); err != nil {
+		if err := StartGRPCServer(grpcAddress, interceptor, serverTLS); err != nil {
 			fmt.Println("Error starting gRPC server:", err)
 		}
 	}()
@@ -48,9 +73,23 @@ func (s *service) Start() error {
 	defer cancel()
 
 	mux := runtime.NewServeMux()
-	opts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	creds := insecure.NewCredentials()
+	if serverTLS != nil {
+		// The gateway presents the server certificate as its client
+		// certificate and verifies the server by its own name
+		serverName, err := certificateName(grpcConfig.CertFile)
+		if err != nil {
+			return err
+		}
+		clientTLS, err := mtls.ClientTLSConfig(grpcConfig, serverName)
+		if err != nil {
+			return fmt.Errorf("failed to configure gRPC gateway TLS: %w", err)
+		}
+		creds = credentials.NewTLS(clientTLS)
+	}
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
 	// Register the gRPC server endpoint with the HTTP gateway.
-	if err := pb.RegisterInstallServiceHandlerFromEndpoint(ctx, mux, grpcAddress, opts); err != nil {
+	if err := pb.RegisterInstallServiceHandlerFromEndpoint(ctx, mux, "localhost"+grpcAddress, opts); err != nil {
 		return fmt.Errorf("failed to register gRPC gateway: %w", err)
 	}
 
@@ -59,6 +98,27 @@ func (s *service) Start() error {
 	return http.ListenAndServe(httpAddress, mux)
 }
 
+// certificateName returns the name a client verifies the certificate in
+// certFile against: its first DNS name, or else its common name.
+func certificateName(certFile string) (string, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read server certificate: %w", err)
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return "", fmt.Errorf("no certificate found in %s", certFile)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse server certificate: %w", err)
+	}
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0], nil
+	}
+	return cert.Subject.CommonName, nil
+}
+
 // Stop stops the webserver gracefully (stub implementation).
 func (s *service) Stop() error {
 	fmt.Println("Stopping webserver")